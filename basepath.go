@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// ForwardedPrefixHeaderName is the header reverse proxies use to tell the
+// path prefix they have stripped from the request.
+const ForwardedPrefixHeaderName = "X-Forwarded-Prefix"
+
+// basePathRegexp matches normalized base paths. The empty string is the root.
+var basePathRegexp = regexp.MustCompile(`^(/[A-Za-z0-9._~-]+)*$`)
+
+// basePathContextKey is the key of the base path in request contexts.
+type basePathContextKey struct{}
+
+// NormalizeBasePath adds a leading and removes trailing slashes from the
+// given path. The root "/" becomes the empty string. Returns an error if the
+// path contains characters other than unreserved URL characters and slashes.
+func NormalizeBasePath(basePath string) (string, error) {
+	basePath = strings.TrimRight(strings.TrimSpace(basePath), "/")
+	if len(basePath) > 0 && !strings.HasPrefix(basePath, "/") {
+		basePath = "/" + basePath
+	}
+
+	if !basePathRegexp.MatchString(basePath) {
+		return "", fmt.Errorf("invalid base path: %q", basePath)
+	}
+
+	return basePath, nil
+}
+
+// MakeBasePathMiddleware returns a middleware that stores the base path under
+// which clients reach the server in the request context. It is the given base
+// path, prefixed with the value of the header X-Forwarded-Prefix if
+// forwardedPrefix is true and the request comes from a trusted proxy. Must be
+// used after the middleware from MakeClientMiddleware, otherwise the header
+// is never honored. Invalid header values are ignored. Retrieve it with
+// BasePath.
+func MakeBasePathMiddleware(basePath string, forwardedPrefix bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fullBasePath := basePath
+			if forwardedPrefix && FromTrustedProxy(r) {
+				prefix, err := NormalizeBasePath(r.Header.Get(ForwardedPrefixHeaderName))
+				if err == nil {
+					fullBasePath = prefix + basePath
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), basePathContextKey{}, fullBasePath)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// BasePath returns the base path stored in the request context by the
+// middleware from MakeBasePathMiddleware. Returns the empty string for the
+// root or if the middleware is not used.
+func BasePath(r *http.Request) string {
+	basePath, _ := r.Context().Value(basePathContextKey{}).(string)
+
+	return basePath
+}
+
+// MountBasePath mounts the given router under the given base path. The base
+// path is stripped from the request URL before it is passed on. Requests
+// to the base path without trailing slash are redirected to the one with, so
+// relative URLs in the web page resolve. The redirect is relative to work
+// behind proxies that strip a prefix. Returns the router unchanged if the base
+// path is empty.
+func MountBasePath(router chi.Router, basePath string) chi.Router {
+	if len(basePath) == 0 {
+		return router
+	}
+
+	root := chi.NewRouter()
+	root.Mount(basePath, http.StripPrefix(basePath, router))
+	root.Get(basePath, func(w http.ResponseWriter, r *http.Request) {
+		target := path.Base(basePath) + "/"
+		if len(r.URL.RawQuery) > 0 {
+			target += "?" + r.URL.RawQuery
+		}
+		w.Header().Set("Location", target)
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
+
+	return root
+}
+
+// swaggerServers is the servers block of the OpenAPI specification that
+// ServeSwaggerSpec replaces with the base path of the request.
+const swaggerServers = "servers:\n  - url: /\n"
+
+// ServeSwaggerSpec adds a handler to the given router that serves the OpenAPI
+// specification "swagger.yaml" from the given file system. If the base path
+// of the request is not the root, it replaces the URL of the servers block
+// "servers:\n  - url: /", so the Swagger UI sends requests to the right
+// location. Base paths are normalized and never need quoting in YAML. Falls
+// back to the embedded "static" directory if staticContent is nil.
+func ServeSwaggerSpec(router chi.Router, staticContent fs.FS) {
+	if staticContent == nil {
+		var err error
+		staticContent, err = NewUIContent("static", "")
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	spec, err := fs.ReadFile(staticContent, "swagger.yaml")
+	if err != nil {
+		panic(err)
+	}
+
+	router.Get("/swagger.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+
+		body := spec
+		if basePath := BasePath(r); len(basePath) > 0 {
+			servers := fmt.Sprintf("servers:\n  - url: %s\n", basePath)
+			body = bytes.Replace(spec, []byte(swaggerServers), []byte(servers), 1)
+		}
+
+		_, err := w.Write(body)
+		if err != nil {
+			panic(err)
+		}
+	})
+}
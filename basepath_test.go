@@ -0,0 +1,251 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeBasePath(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		basePath         string
+		expectedError    bool
+		expectedBasePath string
+	}{{
+		name:             "1_empty",
+		basePath:         "",
+		expectedBasePath: "",
+	}, {
+		name:             "2_root",
+		basePath:         "/",
+		expectedBasePath: "",
+	}, {
+		name:             "3_trailing_slash",
+		basePath:         "/token2go/",
+		expectedBasePath: "/token2go",
+	}, {
+		name:             "4_missing_leading_slash",
+		basePath:         "apps/token2go",
+		expectedBasePath: "/apps/token2go",
+	}, {
+		name:          "5_invalid_characters",
+		basePath:      "/token2go?x=y",
+		expectedError: true,
+	}, {
+		name:          "6_double_slash",
+		basePath:      "//evil.example.com",
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			basePath, err := NormalizeBasePath(tc.basePath)
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if basePath != tc.expectedBasePath {
+				t.Errorf("Wrong base path: got %q, want %q", basePath, tc.expectedBasePath)
+			}
+		})
+	}
+}
+
+func TestMakeBasePathMiddleware(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name             string
+		basePath         string
+		forwardedPrefix  bool
+		remoteAddr       string
+		headerValue      string
+		expectedBasePath string
+	}{{
+		name:             "1_root",
+		expectedBasePath: "",
+	}, {
+		name:             "2_configured",
+		basePath:         "/token2go",
+		expectedBasePath: "/token2go",
+	}, {
+		name:             "3_header_ignored",
+		basePath:         "/token2go",
+		headerValue:      "/gateway",
+		expectedBasePath: "/token2go",
+	}, {
+		name:             "4_header_honored",
+		basePath:         "/token2go",
+		forwardedPrefix:  true,
+		remoteAddr:       "10.0.0.1:1234",
+		headerValue:      "/gateway/",
+		expectedBasePath: "/gateway/token2go",
+	}, {
+		name:             "5_header_invalid",
+		forwardedPrefix:  true,
+		remoteAddr:       "10.0.0.1:1234",
+		headerValue:      "/<script>",
+		expectedBasePath: "",
+	}, {
+		name:             "6_header_untrusted_peer",
+		basePath:         "/token2go",
+		forwardedPrefix:  true,
+		remoteAddr:       "192.0.2.1:1234",
+		headerValue:      "/gateway",
+		expectedBasePath: "/token2go",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var basePath string
+			handler := MakeClientMiddleware(trustedProxies)(MakeBasePathMiddleware(tc.basePath, tc.forwardedPrefix)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					basePath = BasePath(r)
+				}),
+			))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if len(tc.remoteAddr) > 0 {
+				r.RemoteAddr = tc.remoteAddr
+			}
+			if len(tc.headerValue) > 0 {
+				r.Header.Set(ForwardedPrefixHeaderName, tc.headerValue)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if basePath != tc.expectedBasePath {
+				t.Errorf("Wrong base path: got %q, want %q", basePath, tc.expectedBasePath)
+			}
+		})
+	}
+}
+
+func TestInitRouter_BasePath(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := initRouter(RouterArgs{
+		basePath:               "/token2go",
+		forwardedPrefixEnabled: true,
+		trustedProxies:         trustedProxies,
+		tokenPipeline: TokenPipeline{
+			profiles: newTestTokenProfiles(t, []string{"Authorization"}, ""),
+		},
+	})
+
+	for _, tc := range []struct {
+		name             string
+		target           string
+		remoteAddr       string
+		headers          map[string]string
+		expectedCode     int
+		expectedLocation string
+		expectedBody     []string
+	}{{
+		name:         "1_index",
+		target:       "/token2go/",
+		expectedCode: 200,
+		expectedBody: []string{
+			`href="/token2go/css/main.css"`,
+			`src="/token2go/js/index.js"`,
+		},
+	}, {
+		name:             "2_redirect_to_trailing_slash",
+		target:           "/token2go?lang=de",
+		expectedCode:     301,
+		expectedLocation: "token2go/?lang=de",
+	}, {
+		name:         "3_token",
+		target:       "/token2go/token?format=text",
+		headers:      map[string]string{"Authorization": "a"},
+		expectedCode: 200,
+		expectedBody: []string{"a"},
+	}, {
+		name:         "4_static",
+		target:       "/token2go/js/index.js",
+		expectedCode: 200,
+	}, {
+		name:         "5_swagger_ui",
+		target:       "/token2go/swagger-ui/swagger-initializer.js",
+		expectedCode: 200,
+		expectedBody: []string{`url: "../swagger.yaml"`},
+	}, {
+		name:             "6_swagger_ui_redirect",
+		target:           "/token2go/swagger-ui",
+		expectedCode:     301,
+		expectedLocation: "swagger-ui/",
+	}, {
+		name:         "7_swagger_spec",
+		target:       "/token2go/swagger.yaml",
+		expectedCode: 200,
+		expectedBody: []string{"openapi:", "version: 1.0.0\nservers:\n  - url: /token2go\ntags:"},
+	}, {
+		name:         "8_forwarded_prefix",
+		target:       "/token2go/",
+		remoteAddr:   "10.0.0.1:1234",
+		headers:      map[string]string{"X-Forwarded-Prefix": "/gateway"},
+		expectedCode: 200,
+		expectedBody: []string{`href="/gateway/token2go/css/main.css"`},
+	}, {
+		name:         "9_forwarded_prefix_untrusted_peer",
+		target:       "/token2go/",
+		headers:      map[string]string{"X-Forwarded-Prefix": "/gateway"},
+		expectedCode: 200,
+		expectedBody: []string{`href="/token2go/css/main.css"`},
+	}, {
+		name:         "10_outside_base_path",
+		target:       "/token",
+		headers:      map[string]string{"Authorization": "a"},
+		expectedCode: 404,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			if len(tc.remoteAddr) > 0 {
+				r.RemoteAddr = tc.remoteAddr
+			}
+			for name, value := range tc.headers {
+				r.Header.Set(name, value)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if location := rr.Header().Get("Location"); location != tc.expectedLocation {
+				t.Errorf("Wrong location: got %q, want %q", location, tc.expectedLocation)
+			}
+			for _, substr := range tc.expectedBody {
+				if !strings.Contains(rr.Body.String(), substr) {
+					t.Errorf("Didn't find substr in body: want %q", substr)
+				}
+			}
+		})
+	}
+}
+
+func TestServeSwaggerSpec_Root(t *testing.T) {
+	router := initRouter(RouterArgs{})
+
+	r := httptest.NewRequest("GET", "/swagger.yaml", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 200 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 200)
+	}
+	if !strings.Contains(rr.Body.String(), swaggerServers) {
+		t.Errorf("Didn't find substr in body: want %q", swaggerServers)
+	}
+	if strings.Count(rr.Body.String(), "servers:") != 1 {
+		t.Error("Expected exactly one servers block in specification")
+	}
+}
@@ -1,7 +1,11 @@
 package main
 
 import (
+	"fmt"
+	"log"
+	"net/netip"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -13,10 +17,105 @@ type Config struct {
 	// Core configuration.
 	serverPort string
 
+	// Config file with T2G_* options that is reloaded on change.
+	configFile             string
+	configFilePollInterval int
+
 	// Token extraction.
 	tokenHeaderNames    []string
 	addTokenHeaderNames []string
 	fallbackToken       string
+	tokenCookieNames    []string
+	tokenSourceSpecs    []TokenSourceSpec
+	tokenSourcesSet     bool
+	tokenProfileSpecs   map[string][]TokenSourceSpec
+	tokenProfileTypes   map[string]string
+	tokenNotFoundStatus int
+
+	// Path prefix the server is reachable under.
+	basePath               string
+	forwardedPrefixEnabled bool
+
+	// Reverse proxies whose forwarding headers are trusted.
+	trustedProxies []netip.Prefix
+
+	// Rate limiting.
+	rateLimitIP          RateLimit
+	rateLimitFingerprint RateLimit
+
+	// Security headers.
+	contentSecurityPolicy string
+	hstsMaxAge            int
+
+	// Cross-origin requests from browsers.
+	corsOrigins        []string
+	corsRoutes         []string
+	corsAllowedHeaders []string
+	corsMaxAge         int
+
+	// OAuth2-proxy session cookie.
+	oauth2ProxyCookieName   string
+	oauth2ProxyCookieSecret string
+	oauth2ProxyCookieExpire int
+
+	// Targets of the token redirect flow and when users must approve it.
+	redirectTargets      []string
+	redirectConfirmation string
+
+	// Stream of renewed tokens.
+	streamEnabled                bool
+	streamRenewBefore            int
+	streamRenewInterval          int
+	streamHeartbeatInterval      int
+	streamMaxConnections         int
+	streamMaxConnectionsPerToken int
+
+	// Token exchange.
+	tokenExchangeURL              string
+	tokenExchangeClientID         string
+	tokenExchangeClientSecret     string
+	tokenExchangeSubjectTokenType string
+	tokenExchangeAudiences        []string
+	tokenExchangeScopes           []string
+
+	// Token introspection.
+	introspectionURL           string
+	introspectionClientID      string
+	introspectionClientSecret  string
+	introspectionTokenTypeHint string
+
+	// Single-use wrapped tokens.
+	wrapEnabled    bool
+	wrapTTL        int
+	wrapMaxEntries int
+
+	// Token refresh.
+	tokenRefreshURL          string
+	tokenRefreshClientID     string
+	tokenRefreshClientSecret string
+	refreshTokenSourceSpecs  []TokenSourceSpec
+
+	// Gateway proof.
+	gatewayProof                   string
+	gatewayProofHeaderName         string
+	gatewaySecret                  string
+	gatewayHMACHeaderNames         []string
+	gatewayHMACTimestampHeaderName string
+	gatewayHMACMaxSkew             int
+	gatewayJWTKeysFile             string
+	gatewayJWTIssuer               string
+	gatewayJWTAudience             string
+	gatewayJWTMaxLifetime          int
+
+	// Config file downloads.
+	downloadSpecs  map[string]DownloadSpec
+	downloadValues map[string]string
+
+	// Echo endpoint.
+	echoEnabled           bool
+	echoRedactHeaderNames []string
+	echoRedactQueryNames  []string
+	echoAdminToken        string
 
 	// User interface.
 	uiTarget string
@@ -24,16 +123,48 @@ type Config struct {
 	uiDesc1  string
 	uiDesc2  string
 	uiMisc   string
+
+	uiTemplateDir string
+	uiStaticDir   string
+
+	uiLocaleDir     string
+	uiDefaultLocale string
+	uiLocalized     map[string]UIStrings
+
+	uiExpiryWarning int
+	uiExpiryReload  bool
+
+	uiSnippets   []string
+	uiSnippetDir string
+	uiAPIBase    string
+
+	// Tenants in addition to the default tenant.
+	tenantSpecs map[string]TenantSpec
+
+	// Name of the tenant the Config belongs to. Empty for the default tenant.
+	tenant string
 }
 
 // NewConfig inits config struct. Values are retrieved from environments
-// variables. Includes internal defaults.
-func NewConfig() Config {
+// variables. Includes internal defaults. Returns an error if a value is
+// malformed.
+func NewConfig() (Config, error) {
 	c := Config{}
 
+	var err error
+
 	// Core configuration.
 	c.serverPort = GetEnv("SERVER_PORT", "8080")
 
+	// Config file with T2G_* options that is reloaded on change.
+	c.configFile = GetEnv("CONFIG_FILE", "")
+	c.configFilePollInterval, err = strconv.Atoi(GetEnv("CONFIG_FILE_POLL_INTERVAL", "5"))
+	if err != nil || c.configFilePollInterval < 0 {
+		return c, fmt.Errorf(
+			"invalid value for T2G_CONFIG_FILE_POLL_INTERVAL: must be non-negative number of seconds",
+		)
+	}
+
 	// Token extraction.
 	c.tokenHeaderNames = SplitToSlice(GetEnv("TOKEN_HEADER_NAMES",
 		strings.Join([]string{
@@ -46,6 +177,308 @@ func NewConfig() Config {
 	))
 	c.addTokenHeaderNames = SplitToSlice(GetEnv("ADD_TOKEN_HEADER_NAMES", ""))
 	c.fallbackToken = GetEnv("FALLBACK_TOKEN", "")
+	c.tokenCookieNames = SplitToSlice(GetEnv("TOKEN_COOKIE_NAMES", ""))
+
+	// OAuth2-proxy session cookie.
+	c.oauth2ProxyCookieName = GetEnv("OAUTH2_PROXY_COOKIE_NAME", "_oauth2_proxy")
+	c.oauth2ProxyCookieSecret = GetEnv("OAUTH2_PROXY_COOKIE_SECRET", "")
+	c.oauth2ProxyCookieExpire, err = strconv.Atoi(GetEnv("OAUTH2_PROXY_COOKIE_EXPIRE", "604800"))
+	if err != nil || c.oauth2ProxyCookieExpire < 0 {
+		return c, fmt.Errorf("invalid value for T2G_OAUTH2_PROXY_COOKIE_EXPIRE: must be non-negative number of seconds")
+	}
+
+	// Token source chain. Built from the individual options above unless
+	// specified explicitly.
+	if tokenSources := GetEnv("TOKEN_SOURCES", ""); len(tokenSources) > 0 {
+		c.tokenSourceSpecs, err = ParseTokenSourceSpecs(tokenSources)
+		if err != nil {
+			return c, fmt.Errorf("invalid value for T2G_TOKEN_SOURCES: %w", err)
+		}
+		c.completeTokenSourceSpecs(c.tokenSourceSpecs)
+		c.tokenSourcesSet = true
+	} else {
+		c.tokenSourceSpecs = c.defaultTokenSourceSpecs()
+	}
+
+	// Token profiles in addition to the default profile.
+	if tokenProfiles := GetEnv("TOKEN_PROFILES", ""); len(tokenProfiles) > 0 {
+		c.tokenProfileSpecs, err = ParseTokenProfileSpecs(tokenProfiles)
+		if err != nil {
+			return c, fmt.Errorf("invalid value for T2G_TOKEN_PROFILES: %w", err)
+		}
+		for _, specs := range c.tokenProfileSpecs {
+			c.completeTokenSourceSpecs(specs)
+		}
+	}
+	if tokenTypes := GetEnv("TOKEN_PROFILE_TOKEN_TYPES", ""); len(tokenTypes) > 0 {
+		c.tokenProfileTypes, err = ParseTokenProfileTokenTypes(tokenTypes)
+		if err != nil {
+			return c, fmt.Errorf("invalid value for T2G_TOKEN_PROFILE_TOKEN_TYPES: %w", err)
+		}
+	}
+
+	// Path prefix the server is reachable under.
+	c.basePath, err = NormalizeBasePath(GetEnv("BASE_PATH", ""))
+	if err != nil {
+		return c, fmt.Errorf("invalid value for T2G_BASE_PATH: %w", err)
+	}
+	c.forwardedPrefixEnabled, err = GetEnvBool("FORWARDED_PREFIX_ENABLED", false)
+	if err != nil {
+		return c, err
+	}
+
+	// Reverse proxies whose forwarding headers are trusted.
+	c.trustedProxies, err = ParseTrustedProxies(SplitToSlice(GetEnv("TRUSTED_PROXIES", "")))
+	if err != nil {
+		return c, fmt.Errorf("invalid value for T2G_TRUSTED_PROXIES: %w", err)
+	}
+	if c.forwardedPrefixEnabled && len(c.trustedProxies) == 0 {
+		return c, fmt.Errorf("T2G_TRUSTED_PROXIES required for T2G_FORWARDED_PREFIX_ENABLED")
+	}
+
+	// Rate limiting.
+	c.rateLimitIP, err = ParseRateLimit(GetEnv("RATE_LIMIT_IP", ""))
+	if err != nil {
+		return c, fmt.Errorf("invalid value for T2G_RATE_LIMIT_IP: %w", err)
+	}
+	if c.rateLimitIP.Requests > 0 && len(c.trustedProxies) == 0 {
+		log.Printf("T2G_RATE_LIMIT_IP is set without T2G_TRUSTED_PROXIES. " +
+			"Behind a reverse proxy all clients share the IP of the proxy")
+	}
+	c.rateLimitFingerprint, err = ParseRateLimit(GetEnv("RATE_LIMIT_FINGERPRINT", ""))
+	if err != nil {
+		return c, fmt.Errorf("invalid value for T2G_RATE_LIMIT_FINGERPRINT: %w", err)
+	}
+
+	// Security headers.
+	c.contentSecurityPolicy = GetEnv("CONTENT_SECURITY_POLICY", DefaultContentSecurityPolicy)
+	if c.contentSecurityPolicy == "none" {
+		c.contentSecurityPolicy = ""
+	}
+	err = ValidateContentSecurityPolicy(c.contentSecurityPolicy)
+	if err != nil {
+		return c, fmt.Errorf("invalid value for T2G_CONTENT_SECURITY_POLICY: %w", err)
+	}
+	c.hstsMaxAge, err = strconv.Atoi(GetEnv("HSTS_MAX_AGE", "31536000"))
+	if err != nil || c.hstsMaxAge < 0 {
+		return c, fmt.Errorf("invalid value for T2G_HSTS_MAX_AGE: must be non-negative number of seconds")
+	}
+
+	// Cross-origin requests from browsers.
+	c.corsOrigins = SplitToSlice(GetEnv("CORS_ORIGINS", ""))
+	for _, origin := range c.corsOrigins {
+		err = ValidateCORSOrigin(origin)
+		if err != nil {
+			return c, fmt.Errorf("invalid value for T2G_CORS_ORIGINS: %w", err)
+		}
+	}
+	c.corsRoutes = SplitToSlice(GetEnv("CORS_ROUTES", "/token"))
+	for _, route := range c.corsRoutes {
+		err = ValidateCORSRoute(route)
+		if err != nil {
+			return c, fmt.Errorf("invalid value for T2G_CORS_ROUTES: %w", err)
+		}
+	}
+	c.corsAllowedHeaders = SplitToSlice(GetEnv("CORS_ALLOWED_HEADERS", "Authorization"))
+	c.corsMaxAge, err = strconv.Atoi(GetEnv("CORS_MAX_AGE", "600"))
+	if err != nil || c.corsMaxAge < 0 {
+		return c, fmt.Errorf("invalid value for T2G_CORS_MAX_AGE: must be non-negative number of seconds")
+	}
+
+	// Status code of responses to requests without token.
+	c.tokenNotFoundStatus, err = strconv.Atoi(GetEnv("TOKEN_NOT_FOUND_STATUS",
+		strconv.Itoa(StatusTokenNotFound)))
+	if err != nil || c.tokenNotFoundStatus < 400 || c.tokenNotFoundStatus > 599 {
+		return c, fmt.Errorf(
+			"invalid value for T2G_TOKEN_NOT_FOUND_STATUS: must be status code between 400 and 599",
+		)
+	}
+
+	// Targets the token redirect flow may redirect to.
+	c.redirectTargets = SplitToSlice(GetEnv("REDIRECT_TARGETS", ""))
+	for _, target := range c.redirectTargets {
+		err = ValidateRedirectTargetPattern(target)
+		if err != nil {
+			return c, fmt.Errorf("invalid value for T2G_REDIRECT_TARGETS: %w", err)
+		}
+	}
+	c.redirectConfirmation = GetEnv("REDIRECT_CONFIRMATION", RedirectConfirmationNever)
+	if !contains([]string{
+		RedirectConfirmationNever, RedirectConfirmationCrossSite, RedirectConfirmationAlways,
+	}, c.redirectConfirmation) {
+		return c, fmt.Errorf("invalid value for T2G_REDIRECT_CONFIRMATION: must be never, cross-site, or always")
+	}
+
+	// Stream of renewed tokens.
+	c.streamEnabled, err = GetEnvBool("STREAM_ENABLED", false)
+	if err != nil {
+		return c, err
+	}
+	c.streamRenewBefore, err = strconv.Atoi(GetEnv("STREAM_RENEW_BEFORE", "60"))
+	if err != nil || c.streamRenewBefore < 0 {
+		return c, fmt.Errorf("invalid value for T2G_STREAM_RENEW_BEFORE: must be non-negative number of seconds")
+	}
+	c.streamRenewInterval, err = strconv.Atoi(GetEnv("STREAM_RENEW_INTERVAL", "300"))
+	if err != nil || c.streamRenewInterval <= 0 {
+		return c, fmt.Errorf("invalid value for T2G_STREAM_RENEW_INTERVAL: must be positive number of seconds")
+	}
+	c.streamHeartbeatInterval, err = strconv.Atoi(GetEnv("STREAM_HEARTBEAT_INTERVAL", "30"))
+	if err != nil || c.streamHeartbeatInterval <= 0 {
+		return c, fmt.Errorf("invalid value for T2G_STREAM_HEARTBEAT_INTERVAL: must be positive number of seconds")
+	}
+	c.streamMaxConnections, err = strconv.Atoi(GetEnv("STREAM_MAX_CONNECTIONS", "1000"))
+	if err != nil || c.streamMaxConnections <= 0 {
+		return c, fmt.Errorf("invalid value for T2G_STREAM_MAX_CONNECTIONS: must be positive number")
+	}
+	c.streamMaxConnectionsPerToken, err = strconv.Atoi(GetEnv("STREAM_MAX_CONNECTIONS_PER_TOKEN", "5"))
+	if err != nil || c.streamMaxConnectionsPerToken <= 0 {
+		return c, fmt.Errorf("invalid value for T2G_STREAM_MAX_CONNECTIONS_PER_TOKEN: must be positive number")
+	}
+
+	// Token exchange.
+	c.tokenExchangeURL = GetEnv("TOKEN_EXCHANGE_URL", "")
+	c.tokenExchangeClientID = GetEnv("TOKEN_EXCHANGE_CLIENT_ID", "")
+	if len(c.tokenExchangeURL) > 0 && len(c.tokenExchangeClientID) == 0 {
+		return c, fmt.Errorf("T2G_TOKEN_EXCHANGE_CLIENT_ID required for token exchange")
+	}
+	c.tokenExchangeClientSecret = GetEnv("TOKEN_EXCHANGE_CLIENT_SECRET", "")
+	c.tokenExchangeSubjectTokenType = GetEnv("TOKEN_EXCHANGE_SUBJECT_TOKEN_TYPE",
+		TokenTypeAccessToken)
+	c.tokenExchangeAudiences = SplitToSlice(GetEnv("TOKEN_EXCHANGE_AUDIENCES", ""))
+	c.tokenExchangeScopes = SplitToSlice(GetEnv("TOKEN_EXCHANGE_SCOPES", ""))
+
+	// Token introspection.
+	c.introspectionURL = GetEnv("INTROSPECTION_URL", "")
+	c.introspectionClientID = GetEnv("INTROSPECTION_CLIENT_ID", "")
+	if len(c.introspectionURL) > 0 && len(c.introspectionClientID) == 0 {
+		return c, fmt.Errorf("T2G_INTROSPECTION_CLIENT_ID required for introspection")
+	}
+	c.introspectionClientSecret = GetEnv("INTROSPECTION_CLIENT_SECRET", "")
+	c.introspectionTokenTypeHint = GetEnv("INTROSPECTION_TOKEN_TYPE_HINT", "access_token")
+
+	// Single-use wrapped tokens.
+	c.wrapEnabled, err = GetEnvBool("WRAP_ENABLED", false)
+	if err != nil {
+		return c, err
+	}
+	c.wrapTTL, err = strconv.Atoi(GetEnv("WRAP_TTL", "300"))
+	if err != nil || c.wrapTTL <= 0 {
+		return c, fmt.Errorf("invalid value for T2G_WRAP_TTL: must be positive number of seconds")
+	}
+	c.wrapMaxEntries, err = strconv.Atoi(GetEnv("WRAP_MAX_ENTRIES", "10000"))
+	if err != nil || c.wrapMaxEntries <= 0 {
+		return c, fmt.Errorf("invalid value for T2G_WRAP_MAX_ENTRIES: must be positive number")
+	}
+
+	// Token refresh.
+	c.tokenRefreshURL = GetEnv("TOKEN_REFRESH_URL", "")
+	c.tokenRefreshClientID = GetEnv("TOKEN_REFRESH_CLIENT_ID", "")
+	if len(c.tokenRefreshURL) > 0 && len(c.tokenRefreshClientID) == 0 {
+		return c, fmt.Errorf("T2G_TOKEN_REFRESH_CLIENT_ID required for token refresh")
+	}
+	c.tokenRefreshClientSecret = GetEnv("TOKEN_REFRESH_CLIENT_SECRET", "")
+	if refreshTokenSources := GetEnv("REFRESH_TOKEN_SOURCES", ""); len(refreshTokenSources) > 0 {
+		c.refreshTokenSourceSpecs, err = ParseTokenSourceSpecs(refreshTokenSources)
+		if err != nil {
+			return c, fmt.Errorf("invalid value for T2G_REFRESH_TOKEN_SOURCES: %w", err)
+		}
+	} else if len(c.tokenRefreshURL) > 0 {
+		c.refreshTokenSourceSpecs = []TokenSourceSpec{{
+			Type:  "header",
+			Names: []string{"X-Auth-Request-Refresh-Token"},
+		}}
+		if len(c.oauth2ProxyCookieSecret) > 0 {
+			c.refreshTokenSourceSpecs = append(c.refreshTokenSourceSpecs, TokenSourceSpec{
+				Type:  "oauth2-proxy-session",
+				Token: "refresh",
+			})
+		}
+	}
+	c.completeTokenSourceSpecs(c.refreshTokenSourceSpecs)
+
+	// Gateway proof.
+	c.gatewayProof = GetEnv("GATEWAY_PROOF", "none")
+	switch c.gatewayProof {
+	case "none":
+	case "secret":
+		c.gatewayProofHeaderName = GetEnv("GATEWAY_PROOF_HEADER_NAME",
+			"X-Token2go-Gateway-Secret")
+	case "hmac":
+		c.gatewayProofHeaderName = GetEnv("GATEWAY_PROOF_HEADER_NAME",
+			"X-Token2go-Gateway-Signature")
+	case "jwt":
+		c.gatewayProofHeaderName = GetEnv("GATEWAY_PROOF_HEADER_NAME",
+			"X-Goog-Iap-Jwt-Assertion")
+	default:
+		return c, fmt.Errorf(
+			"invalid value for T2G_GATEWAY_PROOF: %q not in none, secret, hmac, jwt",
+			c.gatewayProof,
+		)
+	}
+	c.gatewaySecret = GetEnv("GATEWAY_SECRET", "")
+	if (c.gatewayProof == "secret" || c.gatewayProof == "hmac") &&
+		len(c.gatewaySecret) == 0 {
+		return c, fmt.Errorf("T2G_GATEWAY_SECRET required for %s proof", c.gatewayProof)
+	}
+	c.gatewayHMACHeaderNames = SplitToSlice(GetEnv("GATEWAY_HMAC_HEADER_NAMES",
+		strings.Join(TokenSourceSpecsHeaderNames(c.allTokenSourceSpecs()), ","),
+	))
+	c.gatewayHMACTimestampHeaderName = GetEnv("GATEWAY_HMAC_TIMESTAMP_HEADER_NAME",
+		"X-Token2go-Gateway-Timestamp")
+	c.gatewayHMACMaxSkew, err = strconv.Atoi(GetEnv("GATEWAY_HMAC_MAX_SKEW", "300"))
+	if err != nil || c.gatewayHMACMaxSkew <= 0 {
+		return c, fmt.Errorf("invalid value for T2G_GATEWAY_HMAC_MAX_SKEW: must be positive number of seconds")
+	}
+	c.gatewayJWTKeysFile = GetEnv("GATEWAY_JWT_KEYS_FILE", "")
+	if c.gatewayProof == "jwt" && len(c.gatewayJWTKeysFile) == 0 {
+		return c, fmt.Errorf("T2G_GATEWAY_JWT_KEYS_FILE required for jwt proof")
+	}
+	c.gatewayJWTIssuer = GetEnv("GATEWAY_JWT_ISSUER", "")
+	if c.gatewayProof == "jwt" && len(c.gatewayJWTIssuer) == 0 {
+		return c, fmt.Errorf("T2G_GATEWAY_JWT_ISSUER required for jwt proof")
+	}
+	c.gatewayJWTAudience = GetEnv("GATEWAY_JWT_AUDIENCE", "")
+	if c.gatewayProof == "jwt" && len(c.gatewayJWTAudience) == 0 {
+		return c, fmt.Errorf("T2G_GATEWAY_JWT_AUDIENCE required for jwt proof")
+	}
+	c.gatewayJWTMaxLifetime, err = strconv.Atoi(GetEnv("GATEWAY_JWT_MAX_LIFETIME", "900"))
+	if err != nil || c.gatewayJWTMaxLifetime <= 0 {
+		return c, fmt.Errorf("invalid value for T2G_GATEWAY_JWT_MAX_LIFETIME: must be positive number of seconds")
+	}
+
+	// Config file downloads. Built-in downloads are enabled by default if
+	// their required values are set.
+	if downloadValues := GetEnv("DOWNLOAD_VALUES", ""); len(downloadValues) > 0 {
+		c.downloadValues, err = ParseDownloadValues(downloadValues)
+		if err != nil {
+			return c, fmt.Errorf("invalid value for T2G_DOWNLOAD_VALUES: %w", err)
+		}
+	}
+	if downloads := GetEnv("DOWNLOADS", ""); len(downloads) > 0 {
+		c.downloadSpecs, err = ParseDownloadSpecs(downloads)
+		if err != nil {
+			return c, fmt.Errorf("invalid value for T2G_DOWNLOADS: %w", err)
+		}
+	} else {
+		c.downloadSpecs = map[string]DownloadSpec{}
+		if len(c.downloadValues["kubeServer"]) > 0 {
+			c.downloadSpecs["kubeconfig"] = DownloadSpec{}
+		}
+		if len(c.downloadValues["dockerRegistry"]) > 0 {
+			c.downloadSpecs["docker-config"] = DownloadSpec{}
+		}
+	}
+
+	// Echo endpoint.
+	c.echoEnabled, err = GetEnvBool("ECHO_ENABLED", true)
+	if err != nil {
+		return c, err
+	}
+	c.echoRedactHeaderNames = SplitToSlice(GetEnv("ECHO_REDACT_HEADER_NAMES",
+		strings.Join(TokenSourceSpecsHeaderNames(c.allTokenSourceSpecs()), ","),
+	))
+	c.echoRedactQueryNames = TokenSourceSpecsQueryNames(c.allTokenSourceSpecs())
+	c.echoAdminToken = GetEnv("ECHO_ADMIN_TOKEN", "")
 
 	// User interface.
 	c.uiTarget = GetEnv("UI_TARGET", "")
@@ -53,8 +486,101 @@ func NewConfig() Config {
 	c.uiDesc1 = GetEnv("UI_DESC1", "")
 	c.uiDesc2 = GetEnv("UI_DESC2", "")
 	c.uiMisc = GetEnv("UI_MISC", "")
+	c.uiTemplateDir = GetEnv("UI_TEMPLATE_DIR", "")
+	c.uiStaticDir = GetEnv("UI_STATIC_DIR", "")
+	c.uiLocaleDir = GetEnv("UI_LOCALE_DIR", "")
+	c.uiDefaultLocale = NormalizeUILocale(GetEnv("UI_DEFAULT_LOCALE", DefaultUILocale))
+	c.uiLocalized = GetLocalizedUIStrings()
+	c.uiExpiryWarning, err = strconv.Atoi(GetEnv("UI_EXPIRY_WARNING", "60"))
+	if err != nil || c.uiExpiryWarning < 0 {
+		return c, fmt.Errorf(
+			"invalid value for T2G_UI_EXPIRY_WARNING: must be non-negative number of seconds",
+		)
+	}
+	c.uiExpiryReload, err = GetEnvBool("UI_EXPIRY_RELOAD", false)
+	if err != nil {
+		return c, err
+	}
+	c.uiSnippets = SplitToSlice(GetEnv("UI_SNIPPETS", strings.Join(DefaultSnippetNames(), ",")))
+	if len(c.uiSnippets) == 1 && c.uiSnippets[0] == "none" {
+		c.uiSnippets = nil
+	}
+	c.uiSnippetDir = GetEnv("UI_SNIPPET_DIR", "")
+	c.uiAPIBase = GetEnv("UI_API_BASE", DefaultSnippetAPIBase)
+
+	// Tenants in addition to the default tenant.
+	if tenants := GetEnv("TENANTS", ""); len(tenants) > 0 {
+		c.tenantSpecs, err = ParseTenantSpecs(tenants)
+		if err != nil {
+			return c, fmt.Errorf("invalid value for T2G_TENANTS: %w", err)
+		}
+	}
 
-	return c
+	return c, nil
+}
+
+// defaultTokenSourceSpecs builds the token source chain of the default profile
+// from the individual token extraction options. Used unless
+// T2G_TOKEN_SOURCES is set.
+func (c Config) defaultTokenSourceSpecs() []TokenSourceSpec {
+	specs := []TokenSourceSpec{{
+		Type:       "header",
+		Names:      append(append([]string{}, c.tokenHeaderNames...), c.addTokenHeaderNames...),
+		TrimPrefix: "Bearer ",
+	}, {
+		Type:       "cookie",
+		Names:      c.tokenCookieNames,
+		TrimPrefix: "Bearer ",
+	}}
+	if len(c.oauth2ProxyCookieSecret) > 0 {
+		specs = append(specs, TokenSourceSpec{
+			Type:   "oauth2-proxy-session",
+			Names:  []string{c.oauth2ProxyCookieName},
+			Secret: c.oauth2ProxyCookieSecret,
+			Expire: c.oauth2ProxyCookieExpire,
+		})
+	}
+	if len(c.fallbackToken) > 0 {
+		specs = append(specs, TokenSourceSpec{
+			Type:       "static",
+			Secret:     c.fallbackToken,
+			TrimPrefix: "Bearer ",
+		})
+	}
+
+	return specs
+}
+
+// completeTokenSourceSpecs fills in defaults from other options where specs
+// leave them out.
+func (c Config) completeTokenSourceSpecs(specs []TokenSourceSpec) {
+	for i := range specs {
+		spec := &specs[i]
+		if spec.Type == "oauth2-proxy-session" {
+			if len(spec.Names) == 0 {
+				spec.Names = []string{c.oauth2ProxyCookieName}
+			}
+			if len(spec.Secret) == 0 {
+				spec.Secret = c.oauth2ProxyCookieSecret
+			}
+			if spec.Expire == 0 {
+				spec.Expire = c.oauth2ProxyCookieExpire
+			}
+		}
+	}
+}
+
+// allTokenSourceSpecs returns the specs of the default profile followed by the
+// specs of all other profiles ordered by profile name and the specs of refresh
+// token sources.
+func (c Config) allTokenSourceSpecs() []TokenSourceSpec {
+	specs := append([]TokenSourceSpec{}, c.tokenSourceSpecs...)
+	for _, name := range sortedKeys(c.tokenProfileSpecs) {
+		specs = append(specs, c.tokenProfileSpecs[name]...)
+	}
+	specs = append(specs, c.refreshTokenSourceSpecs...)
+
+	return specs
 }
 
 // GetEnv gets environment variable value after prefixing the key. Default value
@@ -72,6 +598,20 @@ func GetEnv(key, def string) string {
 	return v
 }
 
+// GetEnvBool gets environment variable value with GetEnv and parses it as a
+// boolean. Accepts the same values as strconv.ParseBool. Default value in case
+// of absence must be provided.
+func GetEnvBool(key string, def bool) (bool, error) {
+	v := GetEnv(key, strconv.FormatBool(def))
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return def, fmt.Errorf("invalid boolean for T2G_%s: %w", key, err)
+	}
+
+	return b, nil
+}
+
 // SplitToSlice splits string by commas into a slice. Resulting items are space
 // trimmed. Empty string items are removed. Finally, the slice is returned.
 func SplitToSlice(str string) []string {
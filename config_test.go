@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"log"
 	"os"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewConfig_Default(t *testing.T) {
@@ -16,8 +20,14 @@ func TestNewConfig_Default(t *testing.T) {
 	os.Unsetenv("T2G_UI_DESC1")
 	os.Unsetenv("T2G_UI_DESC2")
 	os.Unsetenv("T2G_UI_MISC")
+	os.Unsetenv("T2G_ECHO_ENABLED")
+	os.Unsetenv("T2G_ECHO_REDACT_HEADER_NAMES")
+	os.Unsetenv("T2G_ECHO_ADMIN_TOKEN")
 
-	c := NewConfig()
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 
 	var got string
 	var want string
@@ -36,6 +46,13 @@ func TestNewConfig_Default(t *testing.T) {
 		"Access-Token,Authorization,Token,X-Auth-Request-Access-Token,X-Forwarded-Access-Token",
 	)
 	eq("addTokenHeaderNames", strings.Join(c.addTokenHeaderNames, ","), "")
+	eq("echoEnabled", strconv.FormatBool(c.echoEnabled), "true")
+	eq(
+		"echoRedactHeaderNames",
+		strings.Join(c.echoRedactHeaderNames, ","),
+		"Access-Token,Authorization,Token,X-Auth-Request-Access-Token,X-Forwarded-Access-Token",
+	)
+	eq("echoAdminToken", c.echoAdminToken, "")
 	eq("uiTarget", c.uiTarget, "")
 	eq("uiTitle", c.uiTitle, "")
 	eq("uiDesc1", c.uiDesc1, "")
@@ -53,8 +70,14 @@ func TestNewConfig_Custom(t *testing.T) {
 	t.Setenv("T2G_UI_DESC1", "x")
 	t.Setenv("T2G_UI_DESC2", "x")
 	t.Setenv("T2G_UI_MISC", "x")
+	t.Setenv("T2G_ECHO_ENABLED", "false")
+	t.Setenv("T2G_ECHO_REDACT_HEADER_NAMES", "x")
+	t.Setenv("T2G_ECHO_ADMIN_TOKEN", "x")
 
-	c := NewConfig()
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
 
 	var got string
 	var want string
@@ -69,6 +92,9 @@ func TestNewConfig_Custom(t *testing.T) {
 	eq("serverPort", c.serverPort, "x")
 	eq("tokenHeaderNames", strings.Join(c.tokenHeaderNames, ","), "x")
 	eq("addTokenHeaderNames", strings.Join(c.addTokenHeaderNames, ","), "x")
+	eq("echoEnabled", strconv.FormatBool(c.echoEnabled), "false")
+	eq("echoRedactHeaderNames", strings.Join(c.echoRedactHeaderNames, ","), "x")
+	eq("echoAdminToken", c.echoAdminToken, "x")
 	eq("uiTarget", c.uiTarget, "x")
 	eq("uiTitle", c.uiTitle, "x")
 	eq("uiDesc1", c.uiDesc1, "x")
@@ -93,6 +119,428 @@ func TestGetEnv(t *testing.T) {
 	}
 }
 
+func TestNewConfig_Malformed(t *testing.T) {
+	t.Setenv("T2G_ECHO_ENABLED", "maybe")
+
+	_, err := NewConfig()
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
+
+func TestNewConfig_TokenSources(t *testing.T) {
+	t.Setenv("T2G_TOKEN_HEADER_NAMES", "A,B")
+	t.Setenv("T2G_FALLBACK_TOKEN", "x")
+	t.Setenv("T2G_OAUTH2_PROXY_COOKIE_SECRET", "s")
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var types []string
+	for _, spec := range c.tokenSourceSpecs {
+		types = append(types, spec.Type)
+	}
+	got := strings.Join(types, ",")
+	want := "header,cookie,oauth2-proxy-session,static"
+	if got != want {
+		t.Errorf("Wrong legacy token source types: got %q, want %q", got, want)
+	}
+
+	got = strings.Join(c.echoRedactHeaderNames, ",")
+	want = "A,B,Cookie"
+	if got != want {
+		t.Errorf("Wrong echo redact header names: got %q, want %q", got, want)
+	}
+
+	t.Setenv("T2G_TOKEN_SOURCES", `[{"type": "oauth2-proxy-session"}]`)
+
+	c, err = NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(c.tokenSourceSpecs) != 1 {
+		t.Fatalf("Wrong number of token source specs: got %v, want 1", len(c.tokenSourceSpecs))
+	}
+	spec := c.tokenSourceSpecs[0]
+	if spec.Secret != "s" || strings.Join(spec.Names, ",") != "_oauth2_proxy" || spec.Expire != 604800 {
+		t.Errorf("Session spec not completed: got %+v", spec)
+	}
+
+	t.Setenv("T2G_OAUTH2_PROXY_COOKIE_EXPIRE", "-1")
+
+	_, err = NewConfig()
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+
+	t.Setenv("T2G_OAUTH2_PROXY_COOKIE_EXPIRE", "3600")
+	t.Setenv("T2G_TOKEN_SOURCES", "lol")
+
+	_, err = NewConfig()
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
+
+func TestNewConfig_TokenProfiles(t *testing.T) {
+	t.Setenv("T2G_TOKEN_HEADER_NAMES", "Authorization")
+	t.Setenv("T2G_OAUTH2_PROXY_COOKIE_SECRET", "s")
+	t.Setenv("T2G_TOKEN_PROFILES", `{
+		"id": [{"type": "header", "names": ["X-Id-Token"]}],
+		"refresh": [{"type": "oauth2-proxy-session", "token": "refresh"}]
+	}`)
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(c.tokenProfileSpecs) != 2 {
+		t.Fatalf("Wrong number of token profiles: got %v, want 2", len(c.tokenProfileSpecs))
+	}
+	spec := c.tokenProfileSpecs["refresh"][0]
+	if spec.Secret != "s" || strings.Join(spec.Names, ",") != "_oauth2_proxy" {
+		t.Errorf("Session spec not completed: got %+v", spec)
+	}
+
+	got := strings.Join(c.echoRedactHeaderNames, ",")
+	want := "Authorization,X-Id-Token,Cookie"
+	if got != want {
+		t.Errorf("Wrong echo redact header names: got %q, want %q", got, want)
+	}
+
+	t.Setenv("T2G_TOKEN_PROFILE_TOKEN_TYPES", `{"id": "urn:ietf:params:oauth:token-type:jwt"}`)
+
+	c, err = NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := c.tokenProfileTypes["id"]; got != "urn:ietf:params:oauth:token-type:jwt" {
+		t.Errorf("Wrong token type: got %q", got)
+	}
+
+	t.Setenv("T2G_TOKEN_PROFILE_TOKEN_TYPES", "[]")
+
+	_, err = NewConfig()
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+
+	t.Setenv("T2G_TOKEN_PROFILE_TOKEN_TYPES", "")
+	t.Setenv("T2G_TOKEN_PROFILES", "[]")
+
+	_, err = NewConfig()
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
+
+func TestNewConfig_TokenExchange(t *testing.T) {
+	t.Setenv("T2G_TOKEN_EXCHANGE_URL", "http://localhost/token")
+
+	_, err := NewConfig()
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+
+	t.Setenv("T2G_TOKEN_EXCHANGE_CLIENT_ID", "client")
+	t.Setenv("T2G_TOKEN_EXCHANGE_AUDIENCES", "a,b")
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := strings.Join(c.tokenExchangeAudiences, ","); got != "a,b" {
+		t.Errorf("Wrong audiences: got %q, want %q", got, "a,b")
+	}
+	if c.tokenExchangeSubjectTokenType != TokenTypeAccessToken {
+		t.Errorf(
+			"Wrong subject token type: got %q, want %q",
+			c.tokenExchangeSubjectTokenType, TokenTypeAccessToken,
+		)
+	}
+}
+
+func TestNewConfig_TokenRefresh(t *testing.T) {
+	t.Setenv("T2G_TOKEN_REFRESH_URL", "http://localhost/token")
+
+	_, err := NewConfig()
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+
+	t.Setenv("T2G_TOKEN_REFRESH_CLIENT_ID", "client")
+	t.Setenv("T2G_OAUTH2_PROXY_COOKIE_SECRET", "s")
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	var types []string
+	for _, spec := range c.refreshTokenSourceSpecs {
+		types = append(types, spec.Type)
+	}
+	if got, want := strings.Join(types, ","), "header,oauth2-proxy-session"; got != want {
+		t.Errorf("Wrong refresh token source types: got %q, want %q", got, want)
+	}
+
+	if !strings.Contains(strings.Join(c.echoRedactHeaderNames, ","), "X-Auth-Request-Refresh-Token") {
+		t.Errorf("Refresh token header not redacted: got %v", c.echoRedactHeaderNames)
+	}
+
+	t.Setenv("T2G_REFRESH_TOKEN_SOURCES", "lol")
+
+	_, err = NewConfig()
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
+
+func TestNewConfig_Introspection(t *testing.T) {
+	t.Setenv("T2G_INTROSPECTION_URL", "http://localhost/introspect")
+
+	_, err := NewConfig()
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+
+	t.Setenv("T2G_INTROSPECTION_CLIENT_ID", "client")
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if c.introspectionTokenTypeHint != "access_token" {
+		t.Errorf(
+			"Wrong token type hint: got %q, want %q",
+			c.introspectionTokenTypeHint, "access_token",
+		)
+	}
+}
+
+func TestNewConfig_TokenNotFoundStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		value          string
+		expectedError  bool
+		expectedStatus int
+	}{{
+		name:           "1_default",
+		value:          "",
+		expectedError:  false,
+		expectedStatus: 444,
+	}, {
+		name:           "2_custom",
+		value:          "401",
+		expectedError:  false,
+		expectedStatus: 401,
+	}, {
+		name:          "3_not_a_number",
+		value:         "lol",
+		expectedError: true,
+	}, {
+		name:          "4_not_an_error",
+		value:         "200",
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.value) > 0 {
+				t.Setenv("T2G_TOKEN_NOT_FOUND_STATUS", tc.value)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.tokenNotFoundStatus != tc.expectedStatus {
+				t.Errorf(
+					"Wrong status: got %v, want %v",
+					c.tokenNotFoundStatus, tc.expectedStatus,
+				)
+			}
+		})
+	}
+}
+
+func TestNewConfig_Downloads(t *testing.T) {
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(c.downloadSpecs) != 0 {
+		t.Errorf("Wrong download specs: got %v, want none", c.downloadSpecs)
+	}
+
+	t.Setenv("T2G_DOWNLOAD_VALUES", `{"kubeServer": "https://k8s.example.com"}`)
+
+	c, err = NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := c.downloadSpecs["kubeconfig"]; !ok || len(c.downloadSpecs) != 1 {
+		t.Errorf("Wrong download specs: got %v, want kubeconfig", c.downloadSpecs)
+	}
+
+	t.Setenv("T2G_DOWNLOADS", `{"aws": {"file": "/aws.tmpl"}}`)
+
+	c, err = NewConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.downloadSpecs["aws"].File != "/aws.tmpl" || len(c.downloadSpecs) != 1 {
+		t.Errorf("Wrong download specs: got %v, want aws", c.downloadSpecs)
+	}
+
+	t.Setenv("T2G_DOWNLOADS", `lol`)
+
+	_, err = NewConfig()
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
+
+func TestNewConfig_GatewayProof(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		env                map[string]string
+		expectedError      bool
+		expectedHeaderName string
+	}{{
+		name:               "1_none",
+		env:                map[string]string{},
+		expectedError:      false,
+		expectedHeaderName: "",
+	}, {
+		name:          "2_unknown",
+		env:           map[string]string{"T2G_GATEWAY_PROOF": "lol"},
+		expectedError: true,
+	}, {
+		name:          "3_secret_missing",
+		env:           map[string]string{"T2G_GATEWAY_PROOF": "secret"},
+		expectedError: true,
+	}, {
+		name: "4_hmac",
+		env: map[string]string{
+			"T2G_GATEWAY_PROOF":  "hmac",
+			"T2G_GATEWAY_SECRET": "x",
+		},
+		expectedError:      false,
+		expectedHeaderName: "X-Token2go-Gateway-Signature",
+	}, {
+		name:          "5_jwt_keys_missing",
+		env:           map[string]string{"T2G_GATEWAY_PROOF": "jwt"},
+		expectedError: true,
+	}, {
+		name: "6_jwt_custom_header",
+		env: map[string]string{
+			"T2G_GATEWAY_PROOF":             "jwt",
+			"T2G_GATEWAY_JWT_KEYS_FILE":     "x",
+			"T2G_GATEWAY_JWT_ISSUER":        "https://cloud.google.com/iap",
+			"T2G_GATEWAY_JWT_AUDIENCE":      "/projects/1/apps/x",
+			"T2G_GATEWAY_PROOF_HEADER_NAME": "X-Amzn-Oidc-Data",
+		},
+		expectedError:      false,
+		expectedHeaderName: "X-Amzn-Oidc-Data",
+	}, {
+		name: "7_hmac_invalid_max_skew",
+		env: map[string]string{
+			"T2G_GATEWAY_PROOF":         "hmac",
+			"T2G_GATEWAY_SECRET":        "x",
+			"T2G_GATEWAY_HMAC_MAX_SKEW": "0",
+		},
+		expectedError: true,
+	}, {
+		name: "8_jwt_invalid_max_lifetime",
+		env: map[string]string{
+			"T2G_GATEWAY_PROOF":            "jwt",
+			"T2G_GATEWAY_JWT_KEYS_FILE":    "x",
+			"T2G_GATEWAY_JWT_ISSUER":       "https://cloud.google.com/iap",
+			"T2G_GATEWAY_JWT_AUDIENCE":     "/projects/1/apps/x",
+			"T2G_GATEWAY_JWT_MAX_LIFETIME": "lol",
+		},
+		expectedError: true,
+	}, {
+		name: "9_jwt_issuer_missing",
+		env: map[string]string{
+			"T2G_GATEWAY_PROOF":         "jwt",
+			"T2G_GATEWAY_JWT_KEYS_FILE": "x",
+			"T2G_GATEWAY_JWT_AUDIENCE":  "/projects/1/apps/x",
+		},
+		expectedError: true,
+	}, {
+		name: "10_jwt_audience_missing",
+		env: map[string]string{
+			"T2G_GATEWAY_PROOF":         "jwt",
+			"T2G_GATEWAY_JWT_KEYS_FILE": "x",
+			"T2G_GATEWAY_JWT_ISSUER":    "https://cloud.google.com/iap",
+		},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if c.gatewayProofHeaderName != tc.expectedHeaderName {
+				t.Errorf(
+					"Wrong header name: got %q, want %q",
+					c.gatewayProofHeaderName, tc.expectedHeaderName,
+				)
+			}
+		})
+	}
+}
+
+func TestGetEnvBool(t *testing.T) {
+	t.Setenv("T2G_FOO", "false")
+
+	got, err := GetEnvBool("FOO", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != false {
+		t.Errorf("Unexpected result: got %v want %v", got, false)
+	}
+
+	os.Unsetenv("T2G_DOES_NOT_EXIST")
+	got, err = GetEnvBool("DOES_NOT_EXIST", true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got != true {
+		t.Errorf("Unexpected result: got %v want %v", got, true)
+	}
+
+	t.Setenv("T2G_FOO", "lol")
+	_, err = GetEnvBool("FOO", true)
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
+
 func TestSplitToSlice(t *testing.T) {
 	for _, tc := range []struct {
 		name  string
@@ -120,3 +568,595 @@ func TestSplitToSlice(t *testing.T) {
 		})
 	}
 }
+
+func TestNewConfig_UIExpiryWarning(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		value           string
+		expectedError   bool
+		expectedWarning int
+	}{{
+		name:            "1_default",
+		value:           "",
+		expectedError:   false,
+		expectedWarning: 60,
+	}, {
+		name:            "2_custom",
+		value:           "300",
+		expectedError:   false,
+		expectedWarning: 300,
+	}, {
+		name:          "3_not_a_number",
+		value:         "lol",
+		expectedError: true,
+	}, {
+		name:          "4_negative",
+		value:         "-1",
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.value) > 0 {
+				t.Setenv("T2G_UI_EXPIRY_WARNING", tc.value)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.uiExpiryWarning != tc.expectedWarning {
+				t.Errorf("Wrong warning: got %v, want %v", c.uiExpiryWarning, tc.expectedWarning)
+			}
+		})
+	}
+}
+
+func TestNewConfig_UISnippets(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		value            string
+		expectedSnippets []string
+	}{{
+		name:             "1_default",
+		value:            "",
+		expectedSnippets: DefaultSnippetNames(),
+	}, {
+		name:             "2_custom",
+		value:            "curl, python",
+		expectedSnippets: []string{"curl", "python"},
+	}, {
+		name:             "3_none",
+		value:            "none",
+		expectedSnippets: nil,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.value) > 0 {
+				t.Setenv("T2G_UI_SNIPPETS", tc.value)
+			}
+
+			c, err := NewConfig()
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if strings.Join(c.uiSnippets, ",") != strings.Join(tc.expectedSnippets, ",") {
+				t.Errorf("Wrong snippets: got %v, want %v", c.uiSnippets, tc.expectedSnippets)
+			}
+		})
+	}
+}
+
+func TestNewConfig_BasePath(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		value            string
+		expectedError    bool
+		expectedBasePath string
+	}{{
+		name:             "1_default",
+		value:            "",
+		expectedError:    false,
+		expectedBasePath: "",
+	}, {
+		name:             "2_custom",
+		value:            "/token2go/",
+		expectedError:    false,
+		expectedBasePath: "/token2go",
+	}, {
+		name:          "3_invalid",
+		value:         "/token 2go",
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.value) > 0 {
+				t.Setenv("T2G_BASE_PATH", tc.value)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.basePath != tc.expectedBasePath {
+				t.Errorf("Wrong base path: got %q, want %q", c.basePath, tc.expectedBasePath)
+			}
+		})
+	}
+}
+
+func TestNewConfig_RedirectTargets(t *testing.T) {
+	for _, tc := range []struct {
+		name                    string
+		value                   string
+		expectedError           bool
+		expectedRedirectTargets string
+	}{{
+		name:                    "1_default",
+		value:                   "",
+		expectedError:           false,
+		expectedRedirectTargets: "",
+	}, {
+		name:                    "2_custom",
+		value:                   "http://localhost, https://*.example.com/callback",
+		expectedError:           false,
+		expectedRedirectTargets: "http://localhost,https://*.example.com/callback",
+	}, {
+		name:          "3_relative",
+		value:         "/callback",
+		expectedError: true,
+	}, {
+		name:          "4_query",
+		value:         "https://example.com/?x=y",
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.value) > 0 {
+				t.Setenv("T2G_REDIRECT_TARGETS", tc.value)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if got := strings.Join(c.redirectTargets, ","); got != tc.expectedRedirectTargets {
+				t.Errorf("Wrong redirect targets: got %q, want %q", got, tc.expectedRedirectTargets)
+			}
+		})
+	}
+}
+
+func TestNewConfig_RedirectConfirmation(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		value         string
+		expectedError bool
+		expectedMode  string
+	}{{
+		name:         "1_default",
+		value:        "",
+		expectedMode: "never",
+	}, {
+		name:         "2_cross_site",
+		value:        "cross-site",
+		expectedMode: "cross-site",
+	}, {
+		name:          "3_unknown",
+		value:         "sometimes",
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.value) > 0 {
+				t.Setenv("T2G_REDIRECT_CONFIRMATION", tc.value)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.redirectConfirmation != tc.expectedMode {
+				t.Errorf("Wrong redirect confirmation: got %q, want %q", c.redirectConfirmation, tc.expectedMode)
+			}
+		})
+	}
+}
+
+func TestNewConfig_ConfigFilePollInterval(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		value            string
+		expectedError    bool
+		expectedInterval int
+	}{
+		{name: "1_default", value: "", expectedError: false, expectedInterval: 5},
+		{name: "2_disabled", value: "0", expectedError: false, expectedInterval: 0},
+		{name: "3_negative", value: "-1", expectedError: true},
+		{name: "4_not_a_number", value: "5s", expectedError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.value) > 0 {
+				t.Setenv("T2G_CONFIG_FILE_POLL_INTERVAL", tc.value)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.configFilePollInterval != tc.expectedInterval {
+				t.Errorf("Wrong interval: got %v, want %v", c.configFilePollInterval, tc.expectedInterval)
+			}
+		})
+	}
+}
+
+func TestNewConfig_RateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		env            map[string]string
+		expectedError  bool
+		expectedIP     RateLimit
+		expectedFinger RateLimit
+		expectedProxy  int
+		expectedWarn   bool
+	}{{
+		name: "1_default",
+	}, {
+		name: "2_custom",
+		env: map[string]string{
+			"T2G_RATE_LIMIT_IP":          "60/m",
+			"T2G_RATE_LIMIT_FINGERPRINT": "5/s",
+			"T2G_TRUSTED_PROXIES":        "10.0.0.0/8, 192.0.2.1",
+		},
+		expectedIP:     RateLimit{60, time.Minute},
+		expectedFinger: RateLimit{5, time.Second},
+		expectedProxy:  2,
+	}, {
+		name:          "3_invalid_rate_limit",
+		env:           map[string]string{"T2G_RATE_LIMIT_IP": "60"},
+		expectedError: true,
+	}, {
+		name:          "4_invalid_trusted_proxies",
+		env:           map[string]string{"T2G_TRUSTED_PROXIES": "proxy"},
+		expectedError: true,
+	}, {
+		name:          "5_forwarded_prefix_without_trusted_proxies",
+		env:           map[string]string{"T2G_FORWARDED_PREFIX_ENABLED": "true"},
+		expectedError: true,
+	}, {
+		name: "6_forwarded_prefix",
+		env: map[string]string{
+			"T2G_FORWARDED_PREFIX_ENABLED": "true",
+			"T2G_TRUSTED_PROXIES":          "10.0.0.0/8",
+		},
+		expectedProxy: 1,
+	}, {
+		name:         "7_ip_rate_limit_without_trusted_proxies",
+		env:          map[string]string{"T2G_RATE_LIMIT_IP": "60/m"},
+		expectedIP:   RateLimit{60, time.Minute},
+		expectedWarn: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			var buffer bytes.Buffer
+			log.SetOutput(&buffer)
+			defer log.SetOutput(os.Stderr)
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.rateLimitIP != tc.expectedIP {
+				t.Errorf("Wrong IP rate limit: got %v, want %v", c.rateLimitIP, tc.expectedIP)
+			}
+			if c.rateLimitFingerprint != tc.expectedFinger {
+				t.Errorf("Wrong fingerprint rate limit: got %v, want %v",
+					c.rateLimitFingerprint, tc.expectedFinger)
+			}
+			if len(c.trustedProxies) != tc.expectedProxy {
+				t.Errorf("Wrong number of trusted proxies: got %v, want %v",
+					len(c.trustedProxies), tc.expectedProxy)
+			}
+			warned := strings.Contains(buffer.String(), "T2G_TRUSTED_PROXIES")
+			if warned != tc.expectedWarn {
+				t.Errorf("Wrong warning: got %v, want %v", warned, tc.expectedWarn)
+			}
+		})
+	}
+}
+
+func TestNewConfig_SecurityHeaders(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		env           map[string]string
+		expectedError bool
+		expectedCSP   string
+		expectedHSTS  int
+	}{{
+		name:         "1_default",
+		expectedCSP:  DefaultContentSecurityPolicy,
+		expectedHSTS: 31536000,
+	}, {
+		name: "2_custom",
+		env: map[string]string{
+			"T2G_CONTENT_SECURITY_POLICY": "default-src 'self'; frame-ancestors 'none'",
+			"T2G_HSTS_MAX_AGE":            "0",
+		},
+		expectedCSP:  "default-src 'self'; frame-ancestors 'none'",
+		expectedHSTS: 0,
+	}, {
+		name:         "3_csp_disabled",
+		env:          map[string]string{"T2G_CONTENT_SECURITY_POLICY": "none"},
+		expectedCSP:  "",
+		expectedHSTS: 31536000,
+	}, {
+		name:          "4_invalid_csp",
+		env:           map[string]string{"T2G_CONTENT_SECURITY_POLICY": "default-src 'self'\nX-Injected: 1"},
+		expectedError: true,
+	}, {
+		name:          "5_invalid_hsts",
+		env:           map[string]string{"T2G_HSTS_MAX_AGE": "-1"},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.contentSecurityPolicy != tc.expectedCSP {
+				t.Errorf("Wrong policy: got %q, want %q", c.contentSecurityPolicy, tc.expectedCSP)
+			}
+			if c.hstsMaxAge != tc.expectedHSTS {
+				t.Errorf("Wrong HSTS max-age: got %v, want %v", c.hstsMaxAge, tc.expectedHSTS)
+			}
+		})
+	}
+}
+
+func TestNewConfig_CORS(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		env             map[string]string
+		expectedError   bool
+		expectedOrigins string
+		expectedRoutes  string
+		expectedHeaders string
+		expectedMaxAge  int
+	}{{
+		name:            "1_default",
+		expectedRoutes:  "/token",
+		expectedHeaders: "Authorization",
+		expectedMaxAge:  600,
+	}, {
+		name: "2_custom",
+		env: map[string]string{
+			"T2G_CORS_ORIGINS":         "https://app.example.com, https://*.apps.example.com",
+			"T2G_CORS_ROUTES":          "/token,/token/{name}",
+			"T2G_CORS_ALLOWED_HEADERS": "Authorization,X-Requested-With",
+			"T2G_CORS_MAX_AGE":         "60",
+		},
+		expectedOrigins: "https://app.example.com,https://*.apps.example.com",
+		expectedRoutes:  "/token,/token/{name}",
+		expectedHeaders: "Authorization,X-Requested-With",
+		expectedMaxAge:  60,
+	}, {
+		name:          "3_invalid_origin",
+		env:           map[string]string{"T2G_CORS_ORIGINS": "*"},
+		expectedError: true,
+	}, {
+		name:          "4_invalid_route",
+		env:           map[string]string{"T2G_CORS_ROUTES": "/health"},
+		expectedError: true,
+	}, {
+		name:          "5_invalid_max_age",
+		env:           map[string]string{"T2G_CORS_MAX_AGE": "ten"},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if got := strings.Join(c.corsOrigins, ","); got != tc.expectedOrigins {
+				t.Errorf("Wrong origins: got %q, want %q", got, tc.expectedOrigins)
+			}
+			if got := strings.Join(c.corsRoutes, ","); got != tc.expectedRoutes {
+				t.Errorf("Wrong routes: got %q, want %q", got, tc.expectedRoutes)
+			}
+			if got := strings.Join(c.corsAllowedHeaders, ","); got != tc.expectedHeaders {
+				t.Errorf("Wrong allowed headers: got %q, want %q", got, tc.expectedHeaders)
+			}
+			if c.corsMaxAge != tc.expectedMaxAge {
+				t.Errorf("Wrong max age: got %v, want %v", c.corsMaxAge, tc.expectedMaxAge)
+			}
+		})
+	}
+}
+
+func TestNewConfig_Wrap(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		env                map[string]string
+		expectedError      bool
+		expectedEnabled    bool
+		expectedTTL        int
+		expectedMaxEntries int
+	}{{
+		name:               "1_default",
+		expectedTTL:        300,
+		expectedMaxEntries: 10000,
+	}, {
+		name: "2_custom",
+		env: map[string]string{
+			"T2G_WRAP_ENABLED":     "true",
+			"T2G_WRAP_TTL":         "60",
+			"T2G_WRAP_MAX_ENTRIES": "100",
+		},
+		expectedEnabled:    true,
+		expectedTTL:        60,
+		expectedMaxEntries: 100,
+	}, {
+		name:          "3_invalid_ttl",
+		env:           map[string]string{"T2G_WRAP_TTL": "0"},
+		expectedError: true,
+	}, {
+		name:          "4_invalid_max_entries",
+		env:           map[string]string{"T2G_WRAP_MAX_ENTRIES": "many"},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.wrapEnabled != tc.expectedEnabled {
+				t.Errorf("Wrong enabled: got %v, want %v", c.wrapEnabled, tc.expectedEnabled)
+			}
+			if c.wrapTTL != tc.expectedTTL {
+				t.Errorf("Wrong TTL: got %v, want %v", c.wrapTTL, tc.expectedTTL)
+			}
+			if c.wrapMaxEntries != tc.expectedMaxEntries {
+				t.Errorf("Wrong max entries: got %v, want %v", c.wrapMaxEntries, tc.expectedMaxEntries)
+			}
+		})
+	}
+}
+
+func TestNewConfig_Stream(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		env              map[string]string
+		expectedError    bool
+		expectedEnabled  bool
+		expectedRenew    int
+		expectedPerToken int
+	}{{
+		name:             "1_default",
+		expectedRenew:    60,
+		expectedPerToken: 5,
+	}, {
+		name: "2_custom",
+		env: map[string]string{
+			"T2G_STREAM_ENABLED":                   "true",
+			"T2G_STREAM_RENEW_BEFORE":              "0",
+			"T2G_STREAM_MAX_CONNECTIONS_PER_TOKEN": "1",
+		},
+		expectedEnabled:  true,
+		expectedRenew:    0,
+		expectedPerToken: 1,
+	}, {
+		name:          "3_invalid_renew_before",
+		env:           map[string]string{"T2G_STREAM_RENEW_BEFORE": "-1"},
+		expectedError: true,
+	}, {
+		name:          "4_invalid_heartbeat",
+		env:           map[string]string{"T2G_STREAM_HEARTBEAT_INTERVAL": "0"},
+		expectedError: true,
+	}, {
+		name:          "5_invalid_max_connections",
+		env:           map[string]string{"T2G_STREAM_MAX_CONNECTIONS": "lots"},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			c, err := NewConfig()
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.streamEnabled != tc.expectedEnabled {
+				t.Errorf("Wrong enabled: got %v, want %v", c.streamEnabled, tc.expectedEnabled)
+			}
+			if c.streamRenewBefore != tc.expectedRenew {
+				t.Errorf("Wrong renew before: got %v, want %v", c.streamRenewBefore, tc.expectedRenew)
+			}
+			if c.streamMaxConnectionsPerToken != tc.expectedPerToken {
+				t.Errorf("Wrong max connections per token: got %v, want %v",
+					c.streamMaxConnectionsPerToken, tc.expectedPerToken)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Modes of T2G_REDIRECT_CONFIRMATION.
+const (
+	RedirectConfirmationNever     = "never"
+	RedirectConfirmationCrossSite = "cross-site"
+	RedirectConfirmationAlways    = "always"
+)
+
+// UIConfirmTemplate is the name of the template rendered as confirmation page
+// of the token redirect flow.
+const UIConfirmTemplate = "confirm.html"
+
+// RedirectConfirmationTTL is how long a confirmation page can be approved.
+const RedirectConfirmationTTL = 5 * time.Minute
+
+// RedirectConfirmationAppMaxLength is the number of characters of the query
+// parameter "app" shown on the confirmation page.
+const RedirectConfirmationAppMaxLength = 64
+
+var ErrConfirmationMissing = errors.New("confirmation missing")
+
+var ErrConfirmationInvalid = errors.New("confirmation invalid")
+
+var ErrConfirmationExpired = errors.New("confirmation expired")
+
+var ErrConfirmationCrossSite = errors.New("confirmation sent from other site")
+
+// RedirectConfirmer decides whether the user has to approve a token redirect
+// flow and issues and verifies the approvals. Approvals are signed with a key
+// and bound to the query of the flow and the token of the user. Use
+// NewRedirectConfirmer to construct.
+type RedirectConfirmer struct {
+	mode            string
+	redirectTargets []string
+	sources         TokenSourceChain
+	key             []byte
+	now             func() time.Time
+}
+
+// NewRedirectConfirmer creates a RedirectConfirmer for the given mode.
+// Approvals are signed with key and bound to the token extracted with sources.
+// Returns nil if mode is RedirectConfirmationNever and an error if the mode is
+// unknown.
+func NewRedirectConfirmer(
+	mode string,
+	redirectTargets []string,
+	sources TokenSourceChain,
+	key []byte,
+) (*RedirectConfirmer, error) {
+	switch mode {
+	case RedirectConfirmationNever:
+		return nil, nil
+	case RedirectConfirmationCrossSite, RedirectConfirmationAlways:
+	default:
+		return nil, fmt.Errorf("unknown redirect confirmation mode %q", mode)
+	}
+
+	return &RedirectConfirmer{
+		mode:            mode,
+		redirectTargets: redirectTargets,
+		sources:         sources,
+		key:             key,
+		now:             time.Now,
+	}, nil
+}
+
+// Required reports whether the user has to approve the flow requested with r
+// to the given target. Targets on loopback addresses are exempt if they are
+// explicitly allowed with redirect targets, as other sites can't receive
+// tokens there. In mode RedirectConfirmationCrossSite approval is required
+// unless the header Sec-Fetch-Site indicates that the user opened the flow
+// directly or from Token2go itself. Browsers that don't send the header are
+// asked.
+func (c *RedirectConfirmer) Required(r *http.Request, target string) bool {
+	if len(c.redirectTargets) > 0 && isLoopbackTarget(target) {
+		return false
+	}
+	if c.mode == RedirectConfirmationAlways {
+		return true
+	}
+
+	site := r.Header.Get("Sec-Fetch-Site")
+
+	return site != "none" && site != "same-origin"
+}
+
+// Issue returns an approval for the flow requested with r that is valid for
+// RedirectConfirmationTTL.
+func (c *RedirectConfirmer) Issue(r *http.Request) string {
+	expiry := strconv.FormatInt(c.now().Add(RedirectConfirmationTTL).Unix(), 10)
+
+	return expiry + "." + c.sign(r, expiry)
+}
+
+// Verify returns an error if the approval in the form field "confirmation" of
+// r is missing, expired, or not issued for the query and token of r. Also
+// returns an error if the header Sec-Fetch-Site is set to anything other than
+// "same-origin", so other sites can't submit approvals.
+func (c *RedirectConfirmer) Verify(r *http.Request) error {
+	site := r.Header.Get("Sec-Fetch-Site")
+	if len(site) > 0 && site != "same-origin" {
+		return ErrConfirmationCrossSite
+	}
+
+	confirmation := r.PostFormValue("confirmation")
+	if len(confirmation) == 0 {
+		return ErrConfirmationMissing
+	}
+
+	expiry, signature, ok := strings.Cut(confirmation, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(c.sign(r, expiry))) {
+		return ErrConfirmationInvalid
+	}
+
+	seconds, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		return ErrConfirmationInvalid
+	}
+	if c.now().After(time.Unix(seconds, 0)) {
+		return ErrConfirmationExpired
+	}
+
+	return nil
+}
+
+// sign computes the signature over the given expiry, the query of r, and the
+// fingerprint of the token extracted from r.
+func (c *RedirectConfirmer) sign(r *http.Request, expiry string) string {
+	var fingerprint string
+	if token, err := c.sources.Extract(r); err == nil {
+		fingerprint = token.Fingerprint
+	}
+
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(expiry + "\n" + fingerprint + "\n" + r.URL.Query().Encode()))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// isLoopbackTarget reports whether the host of the given target URL is
+// "localhost" or a loopback address.
+func isLoopbackTarget(target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" {
+		return true
+	}
+
+	addr, err := netip.ParseAddr(host)
+
+	return err == nil && addr.Unmap().IsLoopback()
+}
+
+// ConfirmTmplData is the input data for the confirm.html template.
+type ConfirmTmplData struct {
+	Lang string
+	T    Catalog
+
+	// App is the name of the application as stated by the query parameter
+	// "app". Can't be verified. Empty if not stated.
+	App string
+
+	// TargetHost is the host of the target URL including the port.
+	TargetHost string
+
+	// Target is the target URL.
+	Target string
+
+	// Fingerprint of the public key as computed by PublicKeyFingerprint.
+	Fingerprint string
+
+	// Tokens are the names of the requested token profiles.
+	Tokens []string
+
+	// Confirmation is the approval issued by RedirectConfirmer.Issue.
+	Confirmation string
+
+	// BasePath is the path prefix clients reach the server under. Empty for
+	// the root.
+	BasePath string
+
+	// Nonce allows inline scripts and styles under the
+	// Content-Security-Policy. Empty if there is no policy.
+	Nonce string
+}
+
+// MakeRedirectConfirmationMiddleware returns a middleware for the token
+// redirect flow that asks the user for approval if the given confirmer
+// requires it. The confirmation page is rendered from the UIConfirmTemplate
+// in tmpl with the messages of the locale negotiated with itd. It shows the
+// target host, the fingerprint of the public key, and the application name
+// and submits the approval with POST to the same URL. The origin of the
+// target is added to the directive form-action of the policy with
+// AllowCSPFormAction, so the browser follows the redirect. POST requests are
+// passed on if the approval is valid and answered with status code 403
+// otherwise. Falls back to the templates in the embedded "template" directory
+// if tmpl is nil.
+func MakeRedirectConfirmationMiddleware(
+	confirmer *RedirectConfirmer,
+	tmpl *template.Template,
+	itd LocalizedIndexTmplData,
+) func(http.Handler) http.Handler {
+	if tmpl == nil {
+		tmplContent, err := NewUIContent("template", "")
+		if err != nil {
+			panic(err)
+		}
+		tmpl, err = NewUITemplates(tmplContent)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				err := confirmer.Verify(r)
+				if err != nil {
+					msg := fmt.Sprintf("Forbidden. Redirect not approved: %v", err)
+					WriteProblem(w, r, http.StatusForbidden, "ForbiddenConfirmation", msg)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			queryParams := r.URL.Query()
+			target := queryParams.Get("target")
+
+			if !IsRequiredQueryParamSet(w, r,
+				"target", "state", "publicKeyType", "publicKey",
+			) {
+				return
+			}
+			if !IsRedirectTargetAllowed(w, r, target, confirmer.redirectTargets) {
+				return
+			}
+			if !confirmer.Required(r, target) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			fingerprint, err := PublicKeyFingerprint([]byte(queryParams.Get("publicKey")))
+			if !IsSucceededEncryptWithRSA(w, r, err) {
+				return
+			}
+
+			targetURL, err := url.Parse(target)
+			if err != nil || len(targetURL.Host) == 0 {
+				msg := fmt.Sprintf("Bad Request. Redirect target %q not absolute URL", target)
+				WriteProblem(w, r, http.StatusBadRequest, "ForbiddenRedirectTarget", msg)
+				return
+			}
+
+			app := queryParams.Get("app")
+			if utf8.RuneCountInString(app) > RedirectConfirmationAppMaxLength {
+				app = string([]rune(app)[:RedirectConfirmationAppMaxLength]) + "…"
+			}
+
+			locale, data := itd.Select(r)
+
+			var buffer bytes.Buffer
+			err = tmpl.ExecuteTemplate(&buffer, UIConfirmTemplate, ConfirmTmplData{
+				Lang:         data.Lang,
+				T:            data.T,
+				App:          app,
+				TargetHost:   targetURL.Host,
+				Target:       target,
+				Fingerprint:  fingerprint,
+				Tokens:       SplitToSlice(queryParams.Get("tokens")),
+				Confirmation: confirmer.Issue(r),
+				BasePath:     BasePath(r),
+				Nonce:        CSPNonce(r),
+			})
+			if err != nil {
+				msg := "Internal Server Error. Rendering page failed."
+				WriteInternalError(w, r, "PageRenderFailed", msg, err)
+				return
+			}
+
+			AllowCSPFormAction(w, targetURL.Scheme+"://"+targetURL.Host)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Language", locale)
+			w.Header().Add("Vary", "Accept-Language")
+			w.Header().Add("Vary", "Sec-Fetch-Site")
+
+			_, err = w.Write(buffer.Bytes())
+			if err != nil {
+				panic(err)
+			}
+		})
+	}
+}
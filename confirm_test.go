@@ -0,0 +1,269 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRedirectConfirmer(t *testing.T, mode string, redirectTargets []string) *RedirectConfirmer {
+	t.Helper()
+
+	confirmer, err := NewRedirectConfirmer(
+		mode, redirectTargets, newTestTokenSourceChain(t, []string{"Foo"}, ""), []byte("key"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return confirmer
+}
+
+func TestNewRedirectConfirmer(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		mode          string
+		expectedNil   bool
+		expectedError bool
+	}{
+		{name: "1_never", mode: "never", expectedNil: true},
+		{name: "2_cross_site", mode: "cross-site"},
+		{name: "3_always", mode: "always"},
+		{name: "4_unknown", mode: "sometimes", expectedNil: true, expectedError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			confirmer, err := NewRedirectConfirmer(tc.mode, nil, TokenSourceChain{}, []byte("key"))
+			if (err != nil) != tc.expectedError {
+				t.Errorf("Wrong error: got %v, want error %v", err, tc.expectedError)
+			}
+			if (confirmer == nil) != tc.expectedNil {
+				t.Errorf("Wrong confirmer: got %v, want nil %v", confirmer, tc.expectedNil)
+			}
+		})
+	}
+}
+
+func TestRedirectConfirmer_Required(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		mode            string
+		redirectTargets []string
+		target          string
+		secFetchSite    string
+		expected        bool
+	}{
+		{name: "1_cross_site", mode: "cross-site", target: "https://example.com", secFetchSite: "cross-site", expected: true},
+		{name: "2_same_site", mode: "cross-site", target: "https://example.com", secFetchSite: "same-site", expected: true},
+		{name: "3_missing", mode: "cross-site", target: "https://example.com", expected: true},
+		{name: "4_none", mode: "cross-site", target: "https://example.com", secFetchSite: "none"},
+		{name: "5_same_origin", mode: "cross-site", target: "https://example.com", secFetchSite: "same-origin"},
+		{name: "6_always", mode: "always", target: "https://example.com", secFetchSite: "none", expected: true},
+		{
+			name:            "7_loopback_allowlisted",
+			mode:            "always",
+			redirectTargets: []string{"http://localhost", "http://127.0.0.1"},
+			target:          "http://127.0.0.1:8888/callback",
+			secFetchSite:    "cross-site",
+			expected:        false,
+		},
+		{
+			name:         "8_loopback_not_allowlisted",
+			mode:         "always",
+			target:       "http://localhost:8888/callback",
+			secFetchSite: "cross-site",
+			expected:     true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			confirmer := newTestRedirectConfirmer(t, tc.mode, tc.redirectTargets)
+
+			r := httptest.NewRequest("GET", "/flow/redirect/token", nil)
+			if len(tc.secFetchSite) > 0 {
+				r.Header.Set("Sec-Fetch-Site", tc.secFetchSite)
+			}
+
+			if got := confirmer.Required(r, tc.target); got != tc.expected {
+				t.Errorf("Wrong required: got %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRedirectConfirmer_Verify(t *testing.T) {
+	confirmer := newTestRedirectConfirmer(t, "always", nil)
+	now := time.Unix(1700000000, 0)
+	confirmer.now = func() time.Time { return now }
+
+	issueRequest := httptest.NewRequest("GET", "/flow/redirect/token?target=https://a.example.com", nil)
+	issueRequest.Header.Set("Foo", "token-a")
+	confirmation := confirmer.Issue(issueRequest)
+
+	for _, tc := range []struct {
+		name          string
+		query         string
+		token         string
+		secFetchSite  string
+		confirmation  string
+		elapsed       time.Duration
+		expectedError error
+	}{{
+		name:         "1_valid",
+		query:        "target=https://a.example.com",
+		token:        "token-a",
+		secFetchSite: "same-origin",
+		confirmation: confirmation,
+	}, {
+		name:         "2_valid_without_sec_fetch_site",
+		query:        "target=https://a.example.com",
+		token:        "token-a",
+		confirmation: confirmation,
+	}, {
+		name:          "3_missing",
+		query:         "target=https://a.example.com",
+		token:         "token-a",
+		expectedError: ErrConfirmationMissing,
+	}, {
+		name:          "4_other_query",
+		query:         "target=https://evil.example.com",
+		token:         "token-a",
+		confirmation:  confirmation,
+		expectedError: ErrConfirmationInvalid,
+	}, {
+		name:          "5_other_token",
+		query:         "target=https://a.example.com",
+		token:         "token-b",
+		confirmation:  confirmation,
+		expectedError: ErrConfirmationInvalid,
+	}, {
+		name:          "6_expired",
+		query:         "target=https://a.example.com",
+		token:         "token-a",
+		confirmation:  confirmation,
+		elapsed:       RedirectConfirmationTTL + time.Second,
+		expectedError: ErrConfirmationExpired,
+	}, {
+		name:          "7_cross_site",
+		query:         "target=https://a.example.com",
+		token:         "token-a",
+		secFetchSite:  "cross-site",
+		confirmation:  confirmation,
+		expectedError: ErrConfirmationCrossSite,
+	}, {
+		name:          "8_tampered_expiry",
+		query:         "target=https://a.example.com",
+		token:         "token-a",
+		confirmation:  "9" + confirmation,
+		expectedError: ErrConfirmationInvalid,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			confirmer.now = func() time.Time { return now.Add(tc.elapsed) }
+
+			body := url.Values{"confirmation": {tc.confirmation}}.Encode()
+			r := httptest.NewRequest("POST", "/flow/redirect/token?"+tc.query, strings.NewReader(body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Foo", tc.token)
+			if len(tc.secFetchSite) > 0 {
+				r.Header.Set("Sec-Fetch-Site", tc.secFetchSite)
+			}
+
+			err := confirmer.Verify(r)
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("Wrong error: got %v, want %v", err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestInitRouter_RedirectConfirmation(t *testing.T) {
+	aPublic1, err := os.ReadFile("testdata/a-public-key-rsa2048-rfc5280-x509.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fingerprint, err := PublicKeyFingerprint(aPublic1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := initRouter(RouterArgs{
+		contentSecurityPolicy: DefaultContentSecurityPolicy,
+		tokenPipeline:         TokenPipeline{profiles: newTestTokenProfiles(t, []string{"Foo"}, "")},
+		redirectConfirmer:     newTestRedirectConfirmer(t, "cross-site", nil),
+	})
+
+	query := url.Values{
+		"target":        {"https://notebook.example.com/callback"},
+		"state":         {"state"},
+		"publicKeyType": {"rsa2048-rfc5280-x509-pem"},
+		"publicKey":     {string(aPublic1)},
+		"app":           {"Notebook <b>"},
+	}.Encode()
+
+	// Cross-site navigation gets the confirmation page.
+	r := httptest.NewRequest("GET", "/flow/redirect/token?"+query, nil)
+	r.Header.Set("Foo", "token")
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 200 {
+		t.Fatalf("Wrong code: got %v, want %v", rr.Code, 200)
+	}
+	for _, substr := range []string{"notebook.example.com", fingerprint, "Notebook &lt;b&gt;"} {
+		if !strings.Contains(rr.Body.String(), substr) {
+			t.Errorf("Didn't find substr in body: want %q", substr)
+		}
+	}
+	csp := rr.Header().Get("Content-Security-Policy")
+	if substr := "form-action 'self' https://notebook.example.com;"; !strings.Contains(csp, substr) {
+		t.Errorf("Didn't find substr in policy: got %q, want %q", csp, substr)
+	}
+
+	match := regexp.MustCompile(`name="confirmation" value="([^"]+)"`).FindStringSubmatch(rr.Body.String())
+	if match == nil {
+		t.Fatal("Didn't find confirmation in body")
+	}
+
+	// Approval from the confirmation page is redirected to the target.
+	body := url.Values{"confirmation": {match[1]}}.Encode()
+	r = httptest.NewRequest("POST", "/flow/redirect/token?"+query, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Foo", "token")
+	r.Header.Set("Sec-Fetch-Site", "same-origin")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 303 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 303)
+	}
+	if location := rr.Header().Get("Location"); !strings.HasPrefix(location, "https://notebook.example.com/callback?") {
+		t.Errorf("Wrong location: got %q", location)
+	}
+
+	// Approval submitted by another site is refused.
+	r = httptest.NewRequest("POST", "/flow/redirect/token?"+query, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Foo", "token")
+	r.Header.Set("Sec-Fetch-Site", "cross-site")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 403 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 403)
+	}
+
+	// Direct navigation skips the confirmation page.
+	r = httptest.NewRequest("GET", "/flow/redirect/token?"+query, nil)
+	r.Header.Set("Foo", "token")
+	r.Header.Set("Sec-Fetch-Site", "none")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 301 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 301)
+	}
+}
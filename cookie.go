@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// ReadChunkedCookie returns the value of the cookie with the given name. If no
+// such cookie exists, chunks named "<name>_0", "<name>_1", and so on are looked
+// for and joined in order. This is how oauth2-proxy splits large cookies. The
+// boolean is false if neither the cookie nor the first chunk exist.
+func ReadChunkedCookie(headers http.Header, name string) (string, bool) {
+	cookies := map[string]string{}
+	for _, cookie := range (&http.Request{Header: headers}).Cookies() {
+		if _, ok := cookies[cookie.Name]; !ok {
+			cookies[cookie.Name] = cookie.Value
+		}
+	}
+
+	if value, ok := cookies[name]; ok && len(value) > 0 {
+		return value, true
+	}
+
+	var value string
+	var found bool
+
+	for i := 0; ; i++ {
+		chunk, ok := cookies[name+"_"+strconv.Itoa(i)]
+		if !ok {
+			break
+		}
+		value += chunk
+		found = true
+	}
+
+	return value, found && len(value) > 0
+}
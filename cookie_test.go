@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReadChunkedCookie(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		cookie        string
+		expectedValue string
+		expectedOk    bool
+	}{{
+		name:          "1_plain",
+		cookie:        "a=x; a_0=y",
+		expectedValue: "x",
+		expectedOk:    true,
+	}, {
+		name:          "2_chunks",
+		cookie:        "a_2=z; a_0=x; a_1=y",
+		expectedValue: "xyz",
+		expectedOk:    true,
+	}, {
+		name:          "3_gap",
+		cookie:        "a_0=x; a_2=z",
+		expectedValue: "x",
+		expectedOk:    true,
+	}, {
+		name:          "4_missing",
+		cookie:        "b=x; a_1=y",
+		expectedValue: "",
+		expectedOk:    false,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			value, ok := ReadChunkedCookie(http.Header{"Cookie": {tc.cookie}}, "a")
+			if value != tc.expectedValue || ok != tc.expectedOk {
+				t.Errorf(
+					"Wrong result: got (%q, %v), want (%q, %v)",
+					value, ok, tc.expectedValue, tc.expectedOk,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// CORSRoutes are the routes cross-origin requests can be allowed for.
+func CORSRoutes() []string {
+	return []string{"/token", "/token/{name}", "/token/refresh", "/download/{kind}", "/echo"}
+}
+
+// CORS holds the settings for cross-origin requests from browsers. The zero
+// value denies all cross-origin requests.
+type CORS struct {
+	// Origins allowed to send requests with credentials. See MatchCORSOrigin.
+	Origins []string
+
+	// Routes cross-origin requests are allowed for. Subset of CORSRoutes.
+	Routes []string
+
+	// AllowedHeaders clients may send in addition to the CORS-safelisted
+	// request headers.
+	AllowedHeaders []string
+
+	// MaxAge is the number of seconds browsers may cache preflight responses.
+	MaxAge int
+}
+
+// ValidateCORSOrigin returns an error if the given pattern is not an origin
+// like "https://app.example.com". The host may start with "*." to match all
+// subdomains.
+func ValidateCORSOrigin(pattern string) error {
+	u, err := url.Parse(pattern)
+	if err != nil {
+		return fmt.Errorf("origin %q: %w", pattern, err)
+	}
+
+	if len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return fmt.Errorf("origin %q: must have scheme and host", pattern)
+	}
+	if len(u.Path) > 0 || len(u.RawQuery) > 0 || len(u.Fragment) > 0 || u.User != nil {
+		return fmt.Errorf("origin %q: must not have user info, path, query, or fragment", pattern)
+	}
+	if strings.Contains(strings.TrimPrefix(u.Hostname(), "*."), "*") {
+		return fmt.Errorf("origin %q: wildcard only allowed as first label", pattern)
+	}
+
+	return nil
+}
+
+// ValidateCORSRoute returns an error if the given route is not one of
+// CORSRoutes.
+func ValidateCORSRoute(route string) error {
+	if !contains(CORSRoutes(), route) {
+		return fmt.Errorf("route %q: must be one of %s", route, strings.Join(CORSRoutes(), ", "))
+	}
+
+	return nil
+}
+
+// MatchCORSOrigin reports whether the given origin matches one of the given
+// patterns. Scheme, host, and port must be equal. A host like
+// "*.example.com" matches all subdomains of "example.com" but not
+// "example.com" itself. The origin "null" never matches.
+func MatchCORSOrigin(patterns []string, origin string) bool {
+	o, err := url.Parse(origin)
+	if err != nil || len(o.Host) == 0 || len(o.Path) > 0 {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		p, err := url.Parse(pattern)
+		if err != nil {
+			continue
+		}
+
+		if strings.EqualFold(o.Scheme, p.Scheme) &&
+			o.Port() == p.Port() &&
+			matchRedirectTargetHost(p.Hostname(), o.Hostname()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchCORSRoute reports whether the given path matches one of the given
+// routes. A segment like "{name}" matches any non-empty segment. Paths equal
+// to one of CORSRoutes only match that route, so "/token/{name}" does not
+// cover "/token/refresh".
+func matchCORSRoute(routes []string, path string) bool {
+	if contains(CORSRoutes(), path) {
+		return contains(routes, path)
+	}
+
+	segments := strings.Split(path, "/")
+
+	for _, route := range routes {
+		routeSegments := strings.Split(route, "/")
+		if len(routeSegments) != len(segments) {
+			continue
+		}
+
+		match := true
+		for i, s := range routeSegments {
+			if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+				match = len(segments[i]) > 0
+			} else {
+				match = s == segments[i]
+			}
+			if !match {
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MakeCORSMiddleware returns a middleware that handles cross-origin requests
+// to the routes in the given CORS. Requests from allowed origins get the
+// headers Access-Control-Allow-Origin and Access-Control-Allow-Credentials,
+// also on error responses. Preflight requests are answered directly: with
+// status code 204 for allowed origins and 403 otherwise. Requests to other
+// routes and requests without the header Origin are passed on unchanged.
+func MakeCORSMiddleware(cors CORS) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(cors.Origins) == 0 || !matchCORSRoute(cors.Routes, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			if len(origin) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			preflight := r.Method == http.MethodOptions &&
+				len(r.Header.Get("Access-Control-Request-Method")) > 0
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			if !MatchCORSOrigin(cors.Origins, origin) {
+				if preflight {
+					msg := fmt.Sprintf("Forbidden. Origin %q not allowed", origin)
+					WriteProblem(w, r, http.StatusForbidden, "ForbiddenOrigin", msg)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+			if !preflight {
+				w.Header().Set("Access-Control-Expose-Headers", "Retry-After")
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Methods", "GET")
+			if len(cors.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+			}
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateCORSOrigin(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		pattern       string
+		expectedError bool
+	}{
+		{name: "1_origin", pattern: "https://app.example.com"},
+		{name: "2_port", pattern: "http://localhost:3000"},
+		{name: "3_wildcard", pattern: "https://*.example.com"},
+		{name: "4_path", pattern: "https://app.example.com/", expectedError: true},
+		{name: "5_relative", pattern: "app.example.com", expectedError: true},
+		{name: "6_inner_wildcard", pattern: "https://app.*.com", expectedError: true},
+		{name: "7_any", pattern: "*", expectedError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateCORSOrigin(tc.pattern)
+			if tc.expectedError && err == nil {
+				t.Error("Unexpected success: got nil, want error")
+			}
+			if !tc.expectedError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMatchCORSOrigin(t *testing.T) {
+	patterns := []string{"https://app.example.com", "http://localhost:3000", "https://*.apps.example.com"}
+
+	for _, tc := range []struct {
+		name     string
+		origin   string
+		expected bool
+	}{
+		{name: "1_exact", origin: "https://app.example.com", expected: true},
+		{name: "2_case", origin: "HTTPS://App.Example.com", expected: true},
+		{name: "3_wrong_scheme", origin: "http://app.example.com", expected: false},
+		{name: "4_port", origin: "http://localhost:3000", expected: true},
+		{name: "5_wrong_port", origin: "http://localhost:8080", expected: false},
+		{name: "6_missing_port", origin: "https://app.example.com:8443", expected: false},
+		{name: "7_subdomain", origin: "https://a.apps.example.com", expected: true},
+		{name: "8_wildcard_apex", origin: "https://apps.example.com", expected: false},
+		{name: "9_suffix", origin: "https://app.example.com.evil.com", expected: false},
+		{name: "10_null", origin: "null", expected: false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := MatchCORSOrigin(patterns, tc.origin); got != tc.expected {
+				t.Errorf("Wrong match: got %v, want %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMakeCORSMiddleware(t *testing.T) {
+	cors := CORS{
+		Origins:        []string{"https://app.example.com"},
+		Routes:         []string{"/token", "/token/{name}"},
+		AllowedHeaders: []string{"Authorization"},
+		MaxAge:         600,
+	}
+
+	for _, tc := range []struct {
+		name            string
+		cors            CORS
+		method          string
+		path            string
+		origin          string
+		requestMethod   string
+		expectedCode    int
+		expectedNext    bool
+		expectedOrigin  string
+		expectedMaxAge  string
+		expectedHeaders string
+	}{{
+		name:           "1_allowed",
+		cors:           cors,
+		method:         "GET",
+		path:           "/token",
+		origin:         "https://app.example.com",
+		expectedCode:   200,
+		expectedNext:   true,
+		expectedOrigin: "https://app.example.com",
+	}, {
+		name:         "2_forbidden_origin",
+		cors:         cors,
+		method:       "GET",
+		path:         "/token",
+		origin:       "https://evil.example.com",
+		expectedCode: 200,
+		expectedNext: true,
+	}, {
+		name:            "3_preflight",
+		cors:            cors,
+		method:          "OPTIONS",
+		path:            "/token/id",
+		origin:          "https://app.example.com",
+		requestMethod:   "GET",
+		expectedCode:    204,
+		expectedOrigin:  "https://app.example.com",
+		expectedMaxAge:  "600",
+		expectedHeaders: "Authorization",
+	}, {
+		name:          "4_preflight_forbidden_origin",
+		cors:          cors,
+		method:        "OPTIONS",
+		path:          "/token",
+		origin:        "https://evil.example.com",
+		requestMethod: "GET",
+		expectedCode:  403,
+	}, {
+		name:         "5_route_not_chosen",
+		cors:         cors,
+		method:       "GET",
+		path:         "/token/refresh",
+		origin:       "https://app.example.com",
+		expectedCode: 200,
+		expectedNext: true,
+	}, {
+		name:          "6_preflight_route_not_chosen",
+		cors:          cors,
+		method:        "OPTIONS",
+		path:          "/echo",
+		origin:        "https://app.example.com",
+		requestMethod: "GET",
+		expectedCode:  200,
+		expectedNext:  true,
+	}, {
+		name:          "7_deny_all_by_default",
+		cors:          CORS{Routes: []string{"/token"}},
+		method:        "OPTIONS",
+		path:          "/token",
+		origin:        "https://app.example.com",
+		requestMethod: "GET",
+		expectedCode:  200,
+		expectedNext:  true,
+	}, {
+		name:         "8_same_origin",
+		cors:         cors,
+		method:       "GET",
+		path:         "/token",
+		expectedCode: 200,
+		expectedNext: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			next := false
+			handler := MakeCORSMiddleware(tc.cors)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					next = true
+				}),
+			)
+
+			r := httptest.NewRequest(tc.method, tc.path, nil)
+			if len(tc.origin) > 0 {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if len(tc.requestMethod) > 0 {
+				r.Header.Set("Access-Control-Request-Method", tc.requestMethod)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if next != tc.expectedNext {
+				t.Errorf("Wrong call of next handler: got %v, want %v", next, tc.expectedNext)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tc.expectedOrigin {
+				t.Errorf("Wrong allowed origin: got %q, want %q", got, tc.expectedOrigin)
+			}
+			credentials := rr.Header().Get("Access-Control-Allow-Credentials")
+			if (credentials == "true") != (len(tc.expectedOrigin) > 0) {
+				t.Errorf("Wrong allow credentials: got %q", credentials)
+			}
+			if got := rr.Header().Get("Access-Control-Max-Age"); got != tc.expectedMaxAge {
+				t.Errorf("Wrong max age: got %q, want %q", got, tc.expectedMaxAge)
+			}
+			if got := rr.Header().Get("Access-Control-Allow-Headers"); got != tc.expectedHeaders {
+				t.Errorf("Wrong allowed headers: got %q, want %q", got, tc.expectedHeaders)
+			}
+		})
+	}
+}
+
+func TestInitRouter_CORS(t *testing.T) {
+	router := initRouter(RouterArgs{
+		basePath: "/token2go",
+		cors: CORS{
+			Origins: []string{"https://app.example.com"},
+			Routes:  []string{"/token"},
+		},
+	})
+
+	r := httptest.NewRequest("OPTIONS", "/token2go/token", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", "GET")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 204 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 204)
+	}
+	if got := rr.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("Wrong allowed origin: got %q, want %q", got, "https://app.example.com")
+	}
+}
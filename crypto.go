@@ -7,6 +7,7 @@ import (
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -89,21 +90,15 @@ var ErrNotRSAPublicKey = errors.New("parsed key is not of type RSA")
 
 var ErrForbiddenKeySize = errors.New("size of given key is forbidden")
 
-// EncryptWithRSA encrypts the given plaintext with the given publicKey. The
-// resulting ciphertext is returned. Ecyrption is done with RSA-OAEP.
-//
-// The public key must be PEM encoded.
-//
-// For the public key the forms RFC5280 (X.509) and RFC8017 (PKCS #1) are
-// supported. In PEM encoded blocks these can be identified with the
-// strings "PUBLIC KEY" and "RSA PUBLIC KEY".
+// ParseRSAPublicKey parses the given PEM encoded RSA public key with a size of
+// 2048 bits. For the public key the forms RFC5280 (X.509) and RFC8017
+// (PKCS #1) are supported. In PEM encoded blocks these can be identified with
+// the strings "PUBLIC KEY" and "RSA PUBLIC KEY".
 //
 // Sentinel errors: ErrPEMDecode, ErrNotPublicKey, ErrNotRSAPublicKey, ErrForbiddenKeySize.
 //
-// Custom error types: PublicKeyParseError and RSAOAEPEncryptionError.
-//
-// No other errors are bubbled up.
-func EncryptWithRSA(publicKey []byte, plaintext []byte) (ciphertext []byte, err error) {
+// Custom error types: PublicKeyParseError.
+func ParseRSAPublicKey(publicKey []byte) (*rsa.PublicKey, error) {
 	block, _ := pem.Decode(publicKey)
 	if block == nil {
 		return nil, ErrPEMDecode
@@ -117,7 +112,7 @@ func EncryptWithRSA(publicKey []byte, plaintext []byte) (ciphertext []byte, err
 		pub, parseErr = x509.ParsePKCS1PublicKey(block.Bytes)
 	}
 	if parseErr != nil {
-		return nil, &PublicKeyParseError{err}
+		return nil, &PublicKeyParseError{parseErr}
 	}
 
 	rsaKey, ok := pub.(*rsa.PublicKey)
@@ -129,6 +124,45 @@ func EncryptWithRSA(publicKey []byte, plaintext []byte) (ciphertext []byte, err
 		return nil, ErrForbiddenKeySize
 	}
 
+	return rsaKey, nil
+}
+
+// PublicKeyFingerprint returns the SHA-256 fingerprint of the given PEM
+// encoded RSA public key in the form "SHA256:<base64>" known from OpenSSH.
+// The hash is computed over the DER encoded RFC5280 (X.509) form, so both
+// supported forms of the same key have the same fingerprint. Returns the
+// errors of ParseRSAPublicKey.
+func PublicKeyFingerprint(publicKey []byte) (string, error) {
+	rsaKey, err := ParseRSAPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(rsaKey)
+	if err != nil {
+		return "", &PublicKeyParseError{err}
+	}
+	sum := sha256.Sum256(der)
+
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+// EncryptWithRSA encrypts the given plaintext with the given publicKey. The
+// resulting ciphertext is returned. Ecyrption is done with RSA-OAEP.
+//
+// The public key must be PEM encoded and is parsed with ParseRSAPublicKey.
+//
+// Sentinel errors: ErrPEMDecode, ErrNotPublicKey, ErrNotRSAPublicKey, ErrForbiddenKeySize.
+//
+// Custom error types: PublicKeyParseError and RSAOAEPEncryptionError.
+//
+// No other errors are bubbled up.
+func EncryptWithRSA(publicKey []byte, plaintext []byte) (ciphertext []byte, err error) {
+	rsaKey, err := ParseRSAPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
 	ciphertext, err = rsa.EncryptOAEP(
 		sha256.New(), rand.Reader, rsaKey, plaintext, nil,
 	)
@@ -138,3 +172,38 @@ func EncryptWithRSA(publicKey []byte, plaintext []byte) (ciphertext []byte, err
 
 	return ciphertext, nil
 }
+
+// Envelope is a payload encrypted like in the token redirect flow. The payload
+// is encrypted with AES-GCM under a random key, which in turn is encrypted
+// with RSA-OAEP. All fields are base64 encoded with padding.
+type Envelope struct {
+	Payload string `json:"payload"`
+	Key     string `json:"key"`
+	Nonce   string `json:"nonce"`
+}
+
+// SealEnvelope encrypts the given payload for the given PEM encoded public
+// key. Returns the errors of GenRandBytes, EncryptWithRSA, and
+// EncryptWithAES.
+func SealEnvelope(publicKey []byte, payload []byte) (Envelope, error) {
+	payloadKey, err := GenRandBytes(32)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	encryptedPayloadKey, err := EncryptWithRSA(publicKey, payloadKey)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	encryptedPayload, nonce, err := EncryptWithAES(payloadKey, payload)
+	if err != nil {
+		return Envelope{}, err
+	}
+
+	return Envelope{
+		Payload: base64.StdEncoding.EncodeToString(encryptedPayload),
+		Key:     base64.StdEncoding.EncodeToString(encryptedPayloadKey),
+		Nonce:   base64.StdEncoding.EncodeToString(nonce),
+	}, nil
+}
@@ -279,3 +279,35 @@ func TestAESKeySizeError(t *testing.T) {
 		t.Errorf("Must contain 5.")
 	}
 }
+
+func TestPublicKeyFingerprint(t *testing.T) {
+	x509Key, err := os.ReadFile("testdata/a-public-key-rsa2048-rfc5280-x509.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs1Key, err := os.ReadFile("testdata/a-public-key-rsa2048-rfc8017-pksc1.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x509Fingerprint, err := PublicKeyFingerprint(x509Key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	pkcs1Fingerprint, err := PublicKeyFingerprint(pkcs1Key)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if !strings.HasPrefix(x509Fingerprint, "SHA256:") || len(x509Fingerprint) != len("SHA256:")+43 {
+		t.Errorf("Wrong fingerprint format: got %q", x509Fingerprint)
+	}
+	if x509Fingerprint != pkcs1Fingerprint {
+		t.Errorf("Wrong fingerprint of same key: got %q, want %q", pkcs1Fingerprint, x509Fingerprint)
+	}
+
+	_, err = PublicKeyFingerprint([]byte("xxxx"))
+	if !errors.Is(err, ErrPEMDecode) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrPEMDecode)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// DownloadSpec specifies a config file download. Downloads are config files
+// rendered from a Go text/template with the extracted token.
+type DownloadSpec struct {
+	// File is the path to the template. If empty, the built-in template of
+	// the same kind is used.
+	File string `json:"file,omitempty"`
+
+	// Filename is suggested to clients in the Content-Disposition header.
+	// Defaults to the base name of File without ".tmpl".
+	Filename string `json:"filename,omitempty"`
+
+	// ContentType of the download. Derived from the extension of Filename if
+	// empty.
+	ContentType string `json:"contentType,omitempty"`
+
+	// Profile is the name of the token profile to render the template with.
+	// Defaults to the DefaultTokenProfile.
+	Profile string `json:"profile,omitempty"`
+}
+
+// DownloadData is the input data for download templates.
+type DownloadData struct {
+	// Token extracted from the request with the profile of the download.
+	Token Token
+
+	// Profile is the name of the token profile.
+	Profile string
+
+	// Host of the request.
+	Host string
+
+	// Values configured by the operator with T2G_DOWNLOAD_VALUES.
+	Values map[string]string
+}
+
+// Download is a config file download. Use NewDownloads to construct.
+type Download struct {
+	kind        string
+	filename    string
+	contentType string
+	profile     string
+	tmpl        *template.Template
+}
+
+// Downloads maps kinds to downloads.
+type Downloads map[string]Download
+
+var ErrDownloadUnknown = errors.New("unknown download")
+
+// BuiltinDownloadSpecs returns the specs of the built-in downloads. Their
+// templates are embedded into the server.
+//
+//   - kubeconfig: Kubeconfig with the token as user credential. Requires the
+//     value "kubeServer". Optional are "kubeClusterName" and
+//     "kubeCertificateAuthorityData".
+//   - docker-config: Docker config.json with the token as password. Requires
+//     the value "dockerRegistry". Optional is "dockerUsername".
+func BuiltinDownloadSpecs() map[string]DownloadSpec {
+	return map[string]DownloadSpec{
+		"kubeconfig": {
+			Filename:    "config",
+			ContentType: "application/yaml",
+		},
+		"docker-config": {
+			Filename:    "config.json",
+			ContentType: "application/json",
+		},
+	}
+}
+
+// ParseDownloadSpecs unmarshals a JSON object that maps download kinds to
+// DownloadSpec.
+func ParseDownloadSpecs(s string) (map[string]DownloadSpec, error) {
+	var specs map[string]DownloadSpec
+
+	err := json.Unmarshal([]byte(s), &specs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse download specs: %w", err)
+	}
+
+	return specs, nil
+}
+
+// ParseDownloadValues unmarshals a JSON object with string values.
+func ParseDownloadValues(s string) (map[string]string, error) {
+	var values map[string]string
+
+	err := json.Unmarshal([]byte(s), &values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse download values: %w", err)
+	}
+
+	return values, nil
+}
+
+// NewDownloads creates downloads from the given specs. Built-in templates are
+// read from builtins, operator templates from the file system. Templates are
+// parsed and rendered once with the given values up front. Returns an error if
+// a kind is invalid, a template can't be loaded or rendered, or a profile is
+// unknown.
+func NewDownloads(
+	builtins fs.FS,
+	specs map[string]DownloadSpec,
+	values map[string]string,
+	profiles TokenProfiles,
+) (Downloads, error) {
+	downloads := make(Downloads, len(specs))
+	builtinSpecs := BuiltinDownloadSpecs()
+
+	for _, kind := range sortedKeys(specs) {
+		spec := specs[kind]
+
+		if !tokenProfileNameRegexp.MatchString(kind) {
+			return nil, fmt.Errorf("download %q: invalid kind", kind)
+		}
+
+		var text []byte
+		var err error
+
+		if len(spec.File) > 0 {
+			text, err = os.ReadFile(spec.File)
+			if len(spec.Filename) == 0 {
+				spec.Filename = strings.TrimSuffix(filepath.Base(spec.File), ".tmpl")
+			}
+		} else if builtinSpec, ok := builtinSpecs[kind]; ok {
+			text, err = fs.ReadFile(builtins, "download/"+kind+".tmpl")
+			if len(spec.Filename) == 0 {
+				spec.Filename = builtinSpec.Filename
+			}
+			if len(spec.ContentType) == 0 {
+				spec.ContentType = builtinSpec.ContentType
+			}
+		} else {
+			return nil, fmt.Errorf("download %q: file required for custom kind", kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("download %q: failed to read template: %w", kind, err)
+		}
+
+		tmpl, err := template.New(kind).
+			Option("missingkey=error").
+			Funcs(downloadTmplFuncs()).
+			Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("download %q: failed to parse template: %w", kind, err)
+		}
+
+		if len(spec.Profile) == 0 {
+			spec.Profile = DefaultTokenProfile
+		}
+		if _, err := profiles.Get(spec.Profile); err != nil {
+			return nil, fmt.Errorf("download %q: %w", kind, err)
+		}
+
+		if len(spec.ContentType) == 0 {
+			spec.ContentType = mime.TypeByExtension(filepath.Ext(spec.Filename))
+		}
+		if len(spec.ContentType) == 0 {
+			spec.ContentType = "text/plain; charset=utf-8"
+		}
+
+		download := Download{
+			kind:        kind,
+			filename:    spec.Filename,
+			contentType: spec.ContentType,
+			profile:     spec.Profile,
+			tmpl:        tmpl,
+		}
+
+		// Render once to detect missing values before the first request.
+		_, err = download.Render(DownloadData{
+			Token:   NewToken("secret", "static"),
+			Profile: spec.Profile,
+			Host:    "localhost",
+			Values:  values,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		downloads[kind] = download
+	}
+
+	return downloads, nil
+}
+
+// Get returns the download of the given kind. Returns ErrDownloadUnknown if
+// there is no such download.
+func (d Downloads) Get(kind string) (Download, error) {
+	download, ok := d[kind]
+	if !ok {
+		return Download{}, fmt.Errorf("%w: %q", ErrDownloadUnknown, kind)
+	}
+
+	return download, nil
+}
+
+// Kinds returns the kinds of all downloads ordered by name.
+func (d Downloads) Kinds() []string {
+	return sortedKeys(d)
+}
+
+// Render executes the template of the download with the given data.
+func (d Download) Render(data DownloadData) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	err := d.tmpl.Execute(&buffer, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render download %q: %w", d.kind, err)
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// downloadTmplFuncs returns the functions available in download templates.
+func downloadTmplFuncs() template.FuncMap {
+	return template.FuncMap{
+		"base64": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"quote": strconv.Quote,
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}
+}
+
+// MakeGetDownloadHandler returns a handler that renders the download of the
+// kind given in the URL parameter "kind" with the token extracted with the
+// profile of the download. Tokens go through the given TokenPipeline like
+// with MakeGetTokenHandler. The rendered file is written as attachment.
+func MakeGetDownloadHandler(
+	downloads Downloads,
+	values map[string]string,
+	tokenPipeline TokenPipeline,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		download, err := downloads.Get(chi.URLParam(r, "kind"))
+		if err != nil {
+			msg := fmt.Sprintf("Not Found. ErrDownloadUnknown: %v", err)
+			WriteProblem(w, r, http.StatusNotFound, "DownloadUnknown", msg)
+			return
+		}
+
+		host, err := RequestHost(r)
+		if err != nil {
+			msg := fmt.Sprintf("Bad Request. ErrRequestHostInvalid: %v", err)
+			WriteProblem(w, r, http.StatusBadRequest, "RequestHostInvalid", msg)
+			return
+		}
+
+		token, ok := tokenPipeline.ExtractToken(w, r, download.profile)
+		if !ok {
+			return
+		}
+
+		b, err := download.Render(DownloadData{
+			Token:   token,
+			Profile: download.profile,
+			Host:    host,
+			Values:  values,
+		})
+		if err != nil {
+			msg := "Internal Server Error. Rendering download failed."
+			WriteInternalError(w, r, "DownloadRenderFailed", msg, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", download.contentType)
+		w.Header().Set("Content-Disposition",
+			fmt.Sprintf("attachment; filename=%q", download.filename))
+
+		_, err = w.Write(b)
+		if err != nil {
+			panic(err)
+		}
+	}
+}
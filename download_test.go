@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// newTestDownloads creates downloads with the embedded built-in templates.
+func newTestDownloads(
+	t *testing.T,
+	specs map[string]DownloadSpec,
+	values map[string]string,
+) (Downloads, error) {
+	t.Helper()
+
+	builtins, err := fs.Sub(content, "template")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewDownloads(
+		builtins, specs, values, newTestTokenProfiles(t, []string{"Authorization"}, ""),
+	)
+}
+
+// writeTestDownloadTemplate writes a template to a temporary file.
+func writeTestDownloadTemplate(t *testing.T, name string, text string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(text), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestNewDownloads(t *testing.T) {
+	custom := writeTestDownloadTemplate(t, "aws-credentials.tmpl",
+		"[default]\naws_session_token = {{ .Token.Secret }}\n")
+	missing := writeTestDownloadTemplate(t, "missing.tmpl", "{{ .Values.nope }}")
+	malformed := writeTestDownloadTemplate(t, "malformed.tmpl", "{{ .Token")
+
+	for _, tc := range []struct {
+		name                string
+		specs               map[string]DownloadSpec
+		values              map[string]string
+		expectedError       bool
+		expectedFilename    string
+		expectedContentType string
+	}{{
+		name:                "1_builtin_kubeconfig",
+		specs:               map[string]DownloadSpec{"kubeconfig": {}},
+		values:              map[string]string{"kubeServer": "https://k8s.example.com"},
+		expectedError:       false,
+		expectedFilename:    "config",
+		expectedContentType: "application/yaml",
+	}, {
+		name:          "2_builtin_value_missing",
+		specs:         map[string]DownloadSpec{"docker-config": {}},
+		values:        nil,
+		expectedError: true,
+	}, {
+		name:                "3_custom",
+		specs:               map[string]DownloadSpec{"aws": {File: custom, Profile: "id"}},
+		values:              nil,
+		expectedError:       false,
+		expectedFilename:    "aws-credentials",
+		expectedContentType: "text/plain; charset=utf-8",
+	}, {
+		name:          "4_custom_file_missing",
+		specs:         map[string]DownloadSpec{"aws": {}},
+		expectedError: true,
+	}, {
+		name:          "5_custom_value_missing",
+		specs:         map[string]DownloadSpec{"x": {File: missing}},
+		expectedError: true,
+	}, {
+		name:          "6_malformed",
+		specs:         map[string]DownloadSpec{"x": {File: malformed}},
+		expectedError: true,
+	}, {
+		name:          "7_invalid_kind",
+		specs:         map[string]DownloadSpec{"X Y": {File: custom}},
+		expectedError: true,
+	}, {
+		name:          "8_unknown_profile",
+		specs:         map[string]DownloadSpec{"x": {File: custom, Profile: "nope"}},
+		expectedError: true,
+	}, {
+		name: "9_custom_filename",
+		specs: map[string]DownloadSpec{"x": {
+			File: custom, Filename: "credentials.json",
+		}},
+		expectedError:       false,
+		expectedFilename:    "credentials.json",
+		expectedContentType: "application/json",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			downloads, err := newTestDownloads(t, tc.specs, tc.values)
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			download, err := downloads.Get(downloads.Kinds()[0])
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if download.filename != tc.expectedFilename {
+				t.Errorf("Wrong filename: got %q, want %q", download.filename, tc.expectedFilename)
+			}
+			if download.contentType != tc.expectedContentType {
+				t.Errorf(
+					"Wrong content type: got %q, want %q",
+					download.contentType, tc.expectedContentType,
+				)
+			}
+		})
+	}
+}
+
+func TestMakeGetDownloadHandler(t *testing.T) {
+	custom := writeTestDownloadTemplate(t, "api.env.tmpl",
+		"API_BASE={{ .Values.apiBase }}\nID_TOKEN={{ .Token.Secret }}\nHOST={{ .Host }}\n")
+
+	values := map[string]string{
+		"kubeServer":     "https://k8s.example.com",
+		"dockerRegistry": "ghcr.io",
+		"apiBase":        "https://api.example.com",
+	}
+	downloads, err := newTestDownloads(t, map[string]DownloadSpec{
+		"kubeconfig":    {},
+		"docker-config": {},
+		"api":           {File: custom, Profile: "id"},
+	}, values)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	router := chi.NewRouter()
+	router.Get("/download/{kind}", MakeGetDownloadHandler(downloads, values, TokenPipeline{
+		profiles: newTestTokenProfiles(t, []string{"Authorization"}, ""),
+	}))
+
+	dockerAuth := base64.StdEncoding.EncodeToString([]byte("oauth2:a"))
+
+	for _, tc := range []struct {
+		name                       string
+		target                     string
+		host                       string
+		headers                    map[string]string
+		expectedCode               int
+		expectedContentDisposition string
+		expectedBody               []string
+	}{{
+		name:                       "1_kubeconfig",
+		target:                     "/download/kubeconfig",
+		headers:                    map[string]string{"Authorization": "a"},
+		expectedCode:               200,
+		expectedContentDisposition: `attachment; filename="config"`,
+		expectedBody: []string{
+			`server: "https://k8s.example.com"`,
+			`token: "a"`,
+			`current-context: "token2go"`,
+		},
+	}, {
+		name:                       "2_docker_config",
+		target:                     "/download/docker-config",
+		headers:                    map[string]string{"Authorization": "a"},
+		expectedCode:               200,
+		expectedContentDisposition: `attachment; filename="config.json"`,
+		expectedBody:               []string{`"ghcr.io": {`, `"auth": "` + dockerAuth + `"`},
+	}, {
+		name:                       "3_custom_profile",
+		target:                     "/download/api",
+		headers:                    map[string]string{"X-Id-Token": "i"},
+		expectedCode:               200,
+		expectedContentDisposition: `attachment; filename="api.env"`,
+		expectedBody: []string{
+			"API_BASE=https://api.example.com\nID_TOKEN=i\nHOST=example.com\n",
+		},
+	}, {
+		name:         "4_unknown",
+		target:       "/download/nope",
+		headers:      map[string]string{"Authorization": "a"},
+		expectedCode: 404,
+		expectedBody: []string{"ErrDownloadUnknown"},
+	}, {
+		name:         "5_token_not_found",
+		target:       "/download/kubeconfig",
+		headers:      map[string]string{},
+		expectedCode: 444,
+		expectedBody: []string{"Token not found"},
+	}, {
+		name:         "6_host_invalid",
+		target:       "/download/api",
+		host:         "example.com\nAPI_BASE=https://evil.example.com",
+		headers:      map[string]string{"X-Id-Token": "i"},
+		expectedCode: 400,
+		expectedBody: []string{"ErrRequestHostInvalid"},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			if len(tc.host) > 0 {
+				r.Host = tc.host
+			}
+			for name, value := range tc.headers {
+				r.Header.Set(name, value)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			contentDisposition := rr.Header().Get("Content-Disposition")
+			if contentDisposition != tc.expectedContentDisposition {
+				t.Errorf(
+					"Wrong content disposition: got %q, want %q",
+					contentDisposition, tc.expectedContentDisposition,
+				)
+			}
+			for _, substr := range tc.expectedBody {
+				if !strings.Contains(rr.Body.String(), substr) {
+					t.Errorf("Didn't find substr in body: got %q, want %q", rr.Body.String(), substr)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TokenExchangeGrantType is the OAuth 2.0 grant type defined by RFC 8693.
+const TokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// RFC 8693 token type identifiers.
+const (
+	TokenTypeAccessToken  = "urn:ietf:params:oauth:token-type:access_token"
+	TokenTypeIDToken      = "urn:ietf:params:oauth:token-type:id_token"
+	TokenTypeRefreshToken = "urn:ietf:params:oauth:token-type:refresh_token"
+)
+
+var ErrTokenExchangeDisabled = errors.New("token exchange not configured")
+
+var ErrTokenExchangeForbidden = errors.New("token exchange target not allowed")
+
+var ErrTokenExchangeFailed = errors.New("token exchange failed")
+
+// TokenExchangeRequest is what the client asks for in a token exchange.
+type TokenExchangeRequest struct {
+	Audience string
+	Scopes   []string
+}
+
+// ParseTokenExchangeRequest reads the query parameters "audience" and
+// "scope". Scopes are separated by spaces or commas. Empty values are treated
+// as absent. The boolean is false if neither parameter has a value, meaning
+// that no exchange has been requested.
+func ParseTokenExchangeRequest(params url.Values) (TokenExchangeRequest, bool) {
+	req := TokenExchangeRequest{
+		Audience: strings.TrimSpace(params.Get("audience")),
+		Scopes: strings.FieldsFunc(params.Get("scope"), func(r rune) bool {
+			return r == ' ' || r == ','
+		}),
+	}
+	if len(req.Audience) == 0 && len(req.Scopes) == 0 {
+		return TokenExchangeRequest{}, false
+	}
+
+	return req, true
+}
+
+// TokenExchanger exchanges tokens at an OAuth 2.0 token endpoint according to
+// RFC 8693. Token2go authenticates with its own client credentials. Only
+// allowed audiences and scopes can be requested. The subject token type it is
+// created with applies to tokens of unknown type. Use NewTokenExchanger to
+// construct. A nil TokenExchanger refuses all exchanges.
+type TokenExchanger struct {
+	client           OAuth2Client
+	subjectTokenType string
+	audiences        []string
+	scopes           []string
+}
+
+// NewTokenExchanger creates a TokenExchanger that uses the given client.
+func NewTokenExchanger(
+	client OAuth2Client,
+	subjectTokenType string,
+	audiences []string,
+	scopes []string,
+) *TokenExchanger {
+	return &TokenExchanger{
+		client:           client,
+		subjectTokenType: subjectTokenType,
+		audiences:        audiences,
+		scopes:           scopes,
+	}
+}
+
+// Exchange exchanges the given token for a token with the requested audience
+// and scopes. The token is sent with the given subject token type. An empty
+// subjectTokenType selects the one e has been created with. The source of the
+// returned token is prefixed with "token-exchange:".
+//
+// Returns ErrTokenExchangeDisabled if e is nil, ErrTokenExchangeForbidden if
+// the request asks for something not allowed, and ErrTokenExchangeFailed
+// (possibly wrapped) if the token endpoint does not hand out a token.
+func (e *TokenExchanger) Exchange(
+	ctx context.Context,
+	token Token,
+	subjectTokenType string,
+	req TokenExchangeRequest,
+) (Token, error) {
+	if e == nil {
+		return Token{}, ErrTokenExchangeDisabled
+	}
+
+	if len(subjectTokenType) == 0 {
+		subjectTokenType = e.subjectTokenType
+	}
+
+	if len(req.Audience) > 0 && !contains(e.audiences, req.Audience) {
+		return Token{}, fmt.Errorf("%w: audience %q", ErrTokenExchangeForbidden, req.Audience)
+	}
+	for _, scope := range req.Scopes {
+		if !contains(e.scopes, scope) {
+			return Token{}, fmt.Errorf("%w: scope %q", ErrTokenExchangeForbidden, scope)
+		}
+	}
+
+	form := url.Values{
+		"grant_type":         {TokenExchangeGrantType},
+		"subject_token":      {token.Secret},
+		"subject_token_type": {subjectTokenType},
+	}
+	if len(req.Audience) > 0 {
+		form.Set("audience", req.Audience)
+	}
+	if len(req.Scopes) > 0 {
+		form.Set("scope", strings.Join(req.Scopes, " "))
+	}
+
+	response, err := e.client.RequestToken(ctx, form)
+	if err != nil {
+		return Token{}, fmt.Errorf("%w: %w", ErrTokenExchangeFailed, err)
+	}
+
+	return NewToken(response.AccessToken, "token-exchange:"+token.Source), nil
+}
+
+// contains checks if the given value is part of the slice.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newTestTokenExchangeServer starts a stub token endpoint. It accepts the
+// client "client" with secret "secret" as well as the public client "public".
+// The exchanged token is made up of the subject token, audience, and scope.
+// Exchanged ID tokens are prefixed with "id:". Subject token "deny" is
+// refused.
+func newTestTokenExchangeServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		fail := func(code int, e string) {
+			w.WriteHeader(code)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"error": e, "error_description": "stub says no",
+			})
+		}
+
+		if r.Method != http.MethodPost || r.ParseForm() != nil {
+			fail(http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		clientID, clientSecret, ok := r.BasicAuth()
+		if !ok {
+			clientID = r.PostForm.Get("client_id")
+		}
+		if !(clientID == "client" && clientSecret == "secret") && !(clientID == "public" && !ok) {
+			fail(http.StatusUnauthorized, "invalid_client")
+			return
+		}
+
+		var prefix string
+		switch r.PostForm.Get("subject_token_type") {
+		case TokenTypeAccessToken:
+		case TokenTypeIDToken:
+			prefix = "id:"
+		default:
+			fail(http.StatusBadRequest, "invalid_request")
+			return
+		}
+
+		if r.PostForm.Get("grant_type") != TokenExchangeGrantType {
+			fail(http.StatusBadRequest, "unsupported_grant_type")
+			return
+		}
+
+		subjectToken := r.PostForm.Get("subject_token")
+		if subjectToken == "deny" {
+			fail(http.StatusBadRequest, "invalid_grant")
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token": prefix + subjectToken + "|" + r.PostForm.Get("audience") +
+				"|" + r.PostForm.Get("scope"),
+			"issued_token_type": TokenTypeAccessToken,
+			"token_type":        "Bearer",
+			"expires_in":        60,
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestParseTokenExchangeRequest(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		params          url.Values
+		expectedRequest TokenExchangeRequest
+		expectedOK      bool
+	}{{
+		name:       "1_none",
+		params:     url.Values{"foo": {"bar"}},
+		expectedOK: false,
+	}, {
+		name:            "2_audience",
+		params:          url.Values{"audience": {"api"}},
+		expectedRequest: TokenExchangeRequest{Audience: "api", Scopes: []string{}},
+		expectedOK:      true,
+	}, {
+		name:   "3_scopes",
+		params: url.Values{"scope": {"read write,admin"}},
+		expectedRequest: TokenExchangeRequest{
+			Scopes: []string{"read", "write", "admin"},
+		},
+		expectedOK: true,
+	}, {
+		name:       "4_empty",
+		params:     url.Values{"audience": {""}, "scope": {" , "}},
+		expectedOK: false,
+	}, {
+		name:            "5_empty_scope",
+		params:          url.Values{"audience": {"api"}, "scope": {""}},
+		expectedRequest: TokenExchangeRequest{Audience: "api", Scopes: []string{}},
+		expectedOK:      true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request, ok := ParseTokenExchangeRequest(tc.params)
+			if ok != tc.expectedOK {
+				t.Errorf("Wrong ok: got %v, want %v", ok, tc.expectedOK)
+			}
+			if ok && !reflect.DeepEqual(request, tc.expectedRequest) {
+				t.Errorf("Wrong request: got %+v, want %+v", request, tc.expectedRequest)
+			}
+		})
+	}
+}
+
+func TestTokenExchanger_Exchange(t *testing.T) {
+	server := newTestTokenExchangeServer(t)
+
+	for _, tc := range []struct {
+		name             string
+		clientID         string
+		clientSecret     string
+		subject          string
+		subjectTokenType string
+		request          TokenExchangeRequest
+		expectedSecret   string
+		expectedError    error
+	}{{
+		name:           "1_audience",
+		clientID:       "client",
+		clientSecret:   "secret",
+		subject:        "t",
+		request:        TokenExchangeRequest{Audience: "api"},
+		expectedSecret: "t|api|",
+	}, {
+		name:           "2_scopes",
+		clientID:       "client",
+		clientSecret:   "secret",
+		subject:        "t",
+		request:        TokenExchangeRequest{Scopes: []string{"read", "write"}},
+		expectedSecret: "t||read write",
+	}, {
+		name:           "3_public_client",
+		clientID:       "public",
+		subject:        "t",
+		request:        TokenExchangeRequest{Audience: "api"},
+		expectedSecret: "t|api|",
+	}, {
+		name:          "4_audience_forbidden",
+		clientID:      "client",
+		clientSecret:  "secret",
+		subject:       "t",
+		request:       TokenExchangeRequest{Audience: "other"},
+		expectedError: ErrTokenExchangeForbidden,
+	}, {
+		name:          "5_scope_forbidden",
+		clientID:      "client",
+		clientSecret:  "secret",
+		subject:       "t",
+		request:       TokenExchangeRequest{Scopes: []string{"read", "admin"}},
+		expectedError: ErrTokenExchangeForbidden,
+	}, {
+		name:          "6_client_rejected",
+		clientID:      "client",
+		clientSecret:  "wrong",
+		subject:       "t",
+		request:       TokenExchangeRequest{Audience: "api"},
+		expectedError: ErrTokenExchangeFailed,
+	}, {
+		name:          "7_grant_rejected",
+		clientID:      "client",
+		clientSecret:  "secret",
+		subject:       "deny",
+		request:       TokenExchangeRequest{Audience: "api"},
+		expectedError: ErrTokenExchangeFailed,
+	}, {
+		name:             "8_id_token",
+		clientID:         "client",
+		clientSecret:     "secret",
+		subject:          "t",
+		subjectTokenType: TokenTypeIDToken,
+		request:          TokenExchangeRequest{Audience: "api"},
+		expectedSecret:   "id:t|api|",
+	}, {
+		name:             "9_token_type_rejected",
+		clientID:         "client",
+		clientSecret:     "secret",
+		subject:          "t",
+		subjectTokenType: TokenTypeRefreshToken,
+		request:          TokenExchangeRequest{Audience: "api"},
+		expectedError:    ErrTokenExchangeFailed,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			exchanger := NewTokenExchanger(
+				NewOAuth2Client(server.URL, tc.clientID, tc.clientSecret),
+				TokenTypeAccessToken, []string{"api"}, []string{"read", "write"},
+			)
+
+			token, err := exchanger.Exchange(
+				context.TODO(), NewToken(tc.subject, "header:Authorization"), tc.subjectTokenType, tc.request,
+			)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("Wrong error: got %v, want %v", err, tc.expectedError)
+			}
+			if err != nil {
+				return
+			}
+
+			if token.Secret != tc.expectedSecret {
+				t.Errorf("Wrong secret: got %q, want %q", token.Secret, tc.expectedSecret)
+			}
+			if want := "token-exchange:header:Authorization"; token.Source != want {
+				t.Errorf("Wrong source: got %q, want %q", token.Source, want)
+			}
+		})
+	}
+}
+
+func TestTokenExchanger_Exchange_Disabled(t *testing.T) {
+	var exchanger *TokenExchanger
+
+	_, err := exchanger.Exchange(context.TODO(), NewToken("t", "static"), "", TokenExchangeRequest{})
+	if !errors.Is(err, ErrTokenExchangeDisabled) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrTokenExchangeDisabled)
+	}
+}
+
+func TestTokenExchanger_Exchange_Unreachable(t *testing.T) {
+	server := newTestTokenExchangeServer(t)
+	server.Close()
+
+	exchanger := NewTokenExchanger(
+		NewOAuth2Client(server.URL, "client", "secret"),
+		TokenTypeAccessToken, []string{"api"}, nil,
+	)
+
+	_, err := exchanger.Exchange(
+		context.TODO(), NewToken("t", "static"), "", TokenExchangeRequest{Audience: "api"},
+	)
+	if !errors.Is(err, ErrTokenExchangeFailed) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrTokenExchangeFailed)
+	}
+	if strings.Contains(err.Error(), "secret") {
+		t.Errorf("Error discloses client secret: %v", err)
+	}
+}
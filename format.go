@@ -0,0 +1,347 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Formats tokens can be written in. Clients select them with the query
+// parameter "format". Without it, the Accept header decides between
+// TokenFormatJSON and TokenFormatText.
+const (
+	// TokenFormatJSON is the Token including metadata as JSON.
+	TokenFormatJSON = "json"
+
+	// TokenFormatText is the bare secret as plain text.
+	TokenFormatText = "text"
+
+	// TokenFormatShell is a shell export line per token.
+	TokenFormatShell = "shell"
+
+	// TokenFormatEnv is a .env file with a variable per token.
+	TokenFormatEnv = "env"
+
+	// TokenFormatHeader is a JSON object with the Authorization header.
+	TokenFormatHeader = "header"
+
+	// TokenFormatNetrc is a .netrc stanza with the secret as password.
+	TokenFormatNetrc = "netrc"
+
+	// TokenFormatExecCredential is a Kubernetes ExecCredential document as
+	// expected from client-go credential plugins.
+	TokenFormatExecCredential = "exec-credential"
+)
+
+// DefaultNetrcLogin is the login used in TokenFormatNetrc if the client does
+// not set the query parameter "login".
+const DefaultNetrcLogin = "oauth2"
+
+// ErrTokenFormatUnsupported is returned if a format can't represent a
+// TokenBundle.
+var ErrTokenFormatUnsupported = errors.New("token format not supported for token bundles")
+
+// ErrTokenFormatParamInvalid is returned if a query parameter of a format or
+// the secret itself can't be written safely.
+var ErrTokenFormatParamInvalid = errors.New("token format parameter invalid")
+
+// safeEnvValueRegexp matches values that can be written to a .env file without
+// quoting.
+var safeEnvValueRegexp = regexp.MustCompile(`^[A-Za-z0-9._~+/=-]*$`)
+
+// TokenFormats returns the names of all supported token formats.
+func TokenFormats() []string {
+	return []string{
+		TokenFormatJSON,
+		TokenFormatText,
+		TokenFormatShell,
+		TokenFormatEnv,
+		TokenFormatHeader,
+		TokenFormatNetrc,
+		TokenFormatExecCredential,
+	}
+}
+
+// NegotiateTokenFormat selects the format of the response to r. The query
+// parameter "format" takes precedence. Otherwise TokenFormatText is selected
+// if the Accept header ranks plain text higher than JSON, and TokenFormatJSON
+// in all other cases. The returned format is not validated.
+func NegotiateTokenFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); len(format) > 0 {
+		return format
+	}
+
+	qJSON, qText := acceptQualities(r)
+	if qText > qJSON {
+		return TokenFormatText
+	}
+
+	return TokenFormatJSON
+}
+
+// ExecCredential is the Kubernetes ExecCredential document written in
+// TokenFormatExecCredential.
+type ExecCredential struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Status     ExecCredentialStatus `json:"status"`
+}
+
+// ExecCredentialStatus holds the credential of an ExecCredential.
+type ExecCredentialStatus struct {
+	Token               string `json:"token"`
+	ExpirationTimestamp string `json:"expirationTimestamp,omitempty"`
+}
+
+// NewExecCredential creates an ExecCredential for the given token. If the
+// secret is a JWT with an "exp" claim, the expiration timestamp is set so that
+// clients know when to ask again.
+func NewExecCredential(token Token) ExecCredential {
+	c := ExecCredential{
+		APIVersion: "client.authentication.k8s.io/v1",
+		Kind:       "ExecCredential",
+		Status:     ExecCredentialStatus{Token: token.Secret},
+	}
+
+	if t, err := ParseJWT(token.Secret); err == nil {
+		if exp, ok := t.NumericDate("exp"); ok {
+			c.Status.ExpirationTimestamp = exp.UTC().Format(time.RFC3339)
+		}
+	}
+
+	return c
+}
+
+// FormatToken renders the token of the named profile in the given format.
+// Some formats take additional parameters from the query parameters of r.
+// Returns the rendered token and its content type.
+func FormatToken(r *http.Request, format string, name string, token Token) ([]byte, string, error) {
+	switch format {
+	case TokenFormatJSON:
+		b, err := json.Marshal(token)
+		return b, "application/json", err
+	case TokenFormatText:
+		return []byte(token.Secret), "text/plain; charset=utf-8", nil
+	case TokenFormatShell, TokenFormatEnv:
+		return FormatTokenBundle(format, TokenBundle{name: token})
+	case TokenFormatHeader:
+		b, err := json.Marshal(map[string]string{"Authorization": "Bearer " + token.Secret})
+		return b, "application/json", err
+	case TokenFormatNetrc:
+		machine := r.URL.Query().Get("machine")
+		if len(machine) == 0 {
+			machine = hostWithoutPort(r.Host)
+		}
+		login := r.URL.Query().Get("login")
+		if len(login) == 0 {
+			login = DefaultNetrcLogin
+		}
+		if !isNetrcToken(machine) {
+			return nil, "", fmt.Errorf("%w: machine %q", ErrTokenFormatParamInvalid, machine)
+		}
+		if !isNetrcToken(login) {
+			return nil, "", fmt.Errorf("%w: login %q", ErrTokenFormatParamInvalid, login)
+		}
+		if !isNetrcToken(token.Secret) {
+			return nil, "", fmt.Errorf("%w: secret contains whitespace or control characters", ErrTokenFormatParamInvalid)
+		}
+		b := fmt.Sprintf("machine %s\nlogin %s\npassword %s\n", machine, login, token.Secret)
+		return []byte(b), "text/plain; charset=utf-8", nil
+	case TokenFormatExecCredential:
+		b, err := json.Marshal(NewExecCredential(token))
+		return b, "application/json", err
+	default:
+		return nil, "", fmt.Errorf("unknown token format: %q", format)
+	}
+}
+
+// isNetrcToken reports whether s can be written to a .netrc file as a single
+// token, meaning it is not empty and contains no whitespace or control
+// characters.
+func isNetrcToken(s string) bool {
+	if len(s) == 0 {
+		return false
+	}
+	for _, c := range s {
+		if unicode.IsSpace(c) || unicode.IsControl(c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// FormatTokenBundle renders the token bundle in the given format. Only
+// TokenFormatJSON, TokenFormatShell, and TokenFormatEnv can represent
+// bundles. Returns the rendered bundle and its content type.
+func FormatTokenBundle(format string, bundle TokenBundle) ([]byte, string, error) {
+	switch format {
+	case TokenFormatJSON:
+		b, err := json.Marshal(bundle)
+		return b, "application/json", err
+	case TokenFormatShell, TokenFormatEnv:
+		var b bytes.Buffer
+
+		for _, name := range sortedKeys(bundle) {
+			variable := TokenVariableName(name)
+			secret := bundle[name].Secret
+
+			if format == TokenFormatShell {
+				fmt.Fprintf(&b, "export %s=%s\n", variable, quoteShell(secret))
+				continue
+			}
+
+			quoted, ok := quoteEnv(secret)
+			if !ok {
+				return nil, "", fmt.Errorf(
+					"%w: secret of %s can't be quoted for .env files", ErrTokenFormatParamInvalid, name,
+				)
+			}
+			fmt.Fprintf(&b, "%s=%s\n", variable, quoted)
+		}
+
+		return b.Bytes(), "text/plain; charset=utf-8", nil
+	default:
+		return nil, "", fmt.Errorf("%w: %q", ErrTokenFormatUnsupported, format)
+	}
+}
+
+// TokenFormatFilename returns the file name suggested to clients with the
+// Content-Disposition header. Empty if the format is not a file download.
+func TokenFormatFilename(format string, name string) string {
+	switch format {
+	case TokenFormatEnv:
+		return name + ".env"
+	case TokenFormatNetrc:
+		return ".netrc"
+	default:
+		return ""
+	}
+}
+
+// TokenVariableName returns the name of the environment variable for the
+// token of the named profile. For example "ACCESS_TOKEN" for "access".
+func TokenVariableName(name string) string {
+	variable := strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_TOKEN"
+	if variable[0] >= '0' && variable[0] <= '9' {
+		variable = "_" + variable
+	}
+
+	return variable
+}
+
+// quoteShell quotes s for POSIX shells with single quotes.
+func quoteShell(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteEnv quotes s for .env files. Values with only safe characters are
+// left as they are, others are put in single quotes so that dotenv parsers
+// neither expand variables nor interpret escapes. Returns false if s contains
+// single quotes or control characters, which single quotes can't represent.
+func quoteEnv(s string) (string, bool) {
+	if safeEnvValueRegexp.MatchString(s) {
+		return s, true
+	}
+
+	for _, c := range s {
+		if c == '\'' || unicode.IsControl(c) {
+			return "", false
+		}
+	}
+
+	return "'" + s + "'", true
+}
+
+// hostWithoutPort strips the port from host if present.
+func hostWithoutPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+
+	return host
+}
+
+// WriteToken writes the token of the named profile in the format negotiated
+// for r. If the query parameter "decode" is present, the token is decoded with
+// DecodeToken first. An HTTP error is written to w if the format is unknown or
+// rendering fails.
+func WriteToken(w http.ResponseWriter, r *http.Request, name string, token Token) {
+	format := NegotiateTokenFormat(r)
+	if !IsQueryParamValueAllowed(w, r, "format", format, TokenFormats()...) {
+		return
+	}
+
+	if r.URL.Query().Has("decode") {
+		token = DecodeToken(token, time.Now())
+	}
+
+	b, contentType, err := FormatToken(r, format, name, token)
+	writeFormatted(w, r, format, name, b, contentType, err)
+}
+
+// WriteTokenBundle writes the token bundle in the format negotiated for r.
+// Tokens are decoded like with WriteToken. An HTTP error is written to w if the
+// format is unknown, can't represent bundles, or rendering fails.
+func WriteTokenBundle(w http.ResponseWriter, r *http.Request, bundle TokenBundle) {
+	format := NegotiateTokenFormat(r)
+	if !IsQueryParamValueAllowed(w, r, "format", format, TokenFormats()...) {
+		return
+	}
+
+	if r.URL.Query().Has("decode") {
+		decoded := make(TokenBundle, len(bundle))
+		for name, token := range bundle {
+			decoded[name] = DecodeToken(token, time.Now())
+		}
+		bundle = decoded
+	}
+
+	b, contentType, err := FormatTokenBundle(format, bundle)
+	writeFormatted(w, r, format, "tokens", b, contentType, err)
+}
+
+// writeFormatted writes the rendered token or the error from rendering it.
+func writeFormatted(
+	w http.ResponseWriter,
+	r *http.Request,
+	format string,
+	name string,
+	b []byte,
+	contentType string,
+	err error,
+) {
+	if errors.Is(err, ErrTokenFormatUnsupported) {
+		msg := fmt.Sprintf("Not Acceptable. ErrTokenFormatUnsupported: %v", err)
+		WriteProblem(w, r, http.StatusNotAcceptable, "TokenFormatUnsupported", msg)
+		return
+	}
+	if errors.Is(err, ErrTokenFormatParamInvalid) {
+		msg := fmt.Sprintf("Bad Request. ErrTokenFormatParamInvalid: %v", err)
+		WriteProblem(w, r, http.StatusBadRequest, "TokenFormatParamInvalid", msg)
+		return
+	}
+	if err != nil {
+		msg := "Internal Server Error. Marshalling failed."
+		WriteProblem(w, r, http.StatusInternalServerError, "MarshallingFailed", msg)
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Content-Type", contentType)
+	if filename := TokenFormatFilename(format, name); len(filename) > 0 {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+
+	_, err = w.Write(b)
+	if err != nil {
+		panic(err)
+	}
+}
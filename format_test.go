@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+func TestNegotiateTokenFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		target         string
+		accept         string
+		expectedFormat string
+	}{{
+		name:           "1_default",
+		target:         "/token",
+		accept:         "",
+		expectedFormat: TokenFormatJSON,
+	}, {
+		name:           "2_wildcard",
+		target:         "/token",
+		accept:         "*/*",
+		expectedFormat: TokenFormatJSON,
+	}, {
+		name:           "3_text",
+		target:         "/token",
+		accept:         "text/plain",
+		expectedFormat: TokenFormatText,
+	}, {
+		name:           "4_json_preferred",
+		target:         "/token",
+		accept:         "text/plain;q=0.5, application/json",
+		expectedFormat: TokenFormatJSON,
+	}, {
+		name:           "5_query_param_wins",
+		target:         "/token?format=netrc",
+		accept:         "text/plain",
+		expectedFormat: TokenFormatNetrc,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			if len(tc.accept) > 0 {
+				r.Header.Set("Accept", tc.accept)
+			}
+
+			format := NegotiateTokenFormat(r)
+			if format != tc.expectedFormat {
+				t.Errorf("Wrong format: got %q, want %q", format, tc.expectedFormat)
+			}
+		})
+	}
+}
+
+func TestMakeGetTokenHandler_Formats(t *testing.T) {
+	router := chi.NewRouter()
+	handler := MakeGetTokenHandler(TokenPipeline{profiles: newTestTokenProfiles(
+		t, []string{"Authorization"}, "",
+	)})
+	router.Get("/token", handler)
+	router.Get("/token/{name}", handler)
+
+	for _, tc := range []struct {
+		name                       string
+		target                     string
+		accept                     string
+		expectedCode               int
+		expectedContentType        string
+		expectedContentDisposition string
+		expectedBody               string
+	}{{
+		name:                "1_json",
+		target:              "/token",
+		expectedCode:        200,
+		expectedContentType: "application/json",
+		expectedBody:        `"secret":"a'b"`,
+	}, {
+		name:                "2_text_accept",
+		target:              "/token",
+		accept:              "text/plain",
+		expectedCode:        200,
+		expectedContentType: "text/plain; charset=utf-8",
+		expectedBody:        "a'b",
+	}, {
+		name:                "3_shell",
+		target:              "/token?format=shell",
+		expectedCode:        200,
+		expectedContentType: "text/plain; charset=utf-8",
+		expectedBody:        "export ACCESS_TOKEN='a'\\''b'\n",
+	}, {
+		name:                       "4_env",
+		target:                     "/token/id?format=env",
+		expectedCode:               200,
+		expectedContentType:        "text/plain; charset=utf-8",
+		expectedContentDisposition: `attachment; filename="id.env"`,
+		expectedBody:               "ID_TOKEN=i\n",
+	}, {
+		name:                "5_header",
+		target:              "/token?format=header",
+		expectedCode:        200,
+		expectedContentType: "application/json",
+		expectedBody:        `{"Authorization":"Bearer a'b"}`,
+	}, {
+		name:                       "6_netrc",
+		target:                     "/token?format=netrc&machine=git.example.com",
+		expectedCode:               200,
+		expectedContentType:        "text/plain; charset=utf-8",
+		expectedContentDisposition: `attachment; filename=".netrc"`,
+		expectedBody:               "machine git.example.com\nlogin oauth2\npassword a'b\n",
+	}, {
+		name:                       "7_netrc_default_machine",
+		target:                     "/token?format=netrc&login=x",
+		expectedCode:               200,
+		expectedContentType:        "text/plain; charset=utf-8",
+		expectedContentDisposition: `attachment; filename=".netrc"`,
+		expectedBody:               "machine example.com\nlogin x\npassword a'b\n",
+	}, {
+		name:                "8_netrc_machine_newline",
+		target:              "/token?format=netrc&machine=" + url.QueryEscape("a\nmachine evil"),
+		expectedCode:        400,
+		expectedContentType: "text/plain; charset=utf-8",
+		expectedBody:        "ErrTokenFormatParamInvalid",
+	}, {
+		name:                "9_netrc_login_space",
+		target:              "/token?format=netrc&login=" + url.QueryEscape("x password y"),
+		expectedCode:        400,
+		expectedContentType: "text/plain; charset=utf-8",
+		expectedBody:        "ErrTokenFormatParamInvalid",
+	}, {
+		name:                "10_exec_credential",
+		target:              "/token?format=exec-credential",
+		expectedCode:        200,
+		expectedContentType: "application/json",
+		expectedBody:        `"kind":"ExecCredential","status":{"token":"a'b"}`,
+	}, {
+		name:                "11_bundle_env_unquotable",
+		target:              "/token?all&format=env",
+		expectedCode:        400,
+		expectedContentType: "text/plain; charset=utf-8",
+		expectedBody:        "ErrTokenFormatParamInvalid",
+	}, {
+		name:                "12_bundle_text",
+		target:              "/token?all",
+		accept:              "text/plain",
+		expectedCode:        406,
+		expectedContentType: "text/plain; charset=utf-8",
+		expectedBody:        "ErrTokenFormatUnsupported",
+	}, {
+		name:                "13_decode_opaque",
+		target:              "/token?decode",
+		expectedCode:        200,
+		expectedContentType: "application/json",
+		expectedBody:        `"source":"header:Authorization"}`,
+	}, {
+		name:                "14_unknown",
+		target:              "/token?format=yaml",
+		expectedCode:        400,
+		expectedContentType: "text/plain; charset=utf-8",
+		expectedBody:        "format",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			r.Header.Set("Authorization", "a'b")
+			r.Header.Set("X-Id-Token", "i")
+			r.Header.Set("X-Refresh-Token", "r")
+			if len(tc.accept) > 0 {
+				r.Header.Set("Accept", tc.accept)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			contentType := rr.Header().Get("Content-Type")
+			if contentType != tc.expectedContentType {
+				t.Errorf("Wrong content type: got %q, want %q", contentType, tc.expectedContentType)
+			}
+			contentDisposition := rr.Header().Get("Content-Disposition")
+			if contentDisposition != tc.expectedContentDisposition {
+				t.Errorf(
+					"Wrong content disposition: got %q, want %q",
+					contentDisposition, tc.expectedContentDisposition,
+				)
+			}
+			if !strings.Contains(rr.Body.String(), tc.expectedBody) {
+				t.Errorf("Wrong body: got %q, want %q", rr.Body.String(), tc.expectedBody)
+			}
+		})
+	}
+}
+
+func TestNewExecCredential(t *testing.T) {
+	key := readTestPrivateKey(t, "a-private-key-rsa2048-rfc5958-pksc8.pem")
+	exp := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	jwt := signTestJWT(t, key, map[string]any{"exp": exp.Unix()})
+
+	c := NewExecCredential(NewToken(jwt, "static"))
+	if c.Status.ExpirationTimestamp != "2030-01-02T03:04:05Z" {
+		t.Errorf(
+			"Wrong expiration timestamp: got %q, want %q",
+			c.Status.ExpirationTimestamp, "2030-01-02T03:04:05Z",
+		)
+	}
+
+	b, err := json.Marshal(NewExecCredential(NewToken("opaque", "static")))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{"apiVersion":"client.authentication.k8s.io/v1","kind":"ExecCredential",` +
+		`"status":{"token":"opaque"}}`
+	if string(b) != expected {
+		t.Errorf("Wrong JSON: got %s, want %s", b, expected)
+	}
+}
+
+func TestTokenVariableName(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		profile          string
+		expectedVariable string
+	}{{
+		name:             "1_simple",
+		profile:          "access",
+		expectedVariable: "ACCESS_TOKEN",
+	}, {
+		name:             "2_dash",
+		profile:          "my-api",
+		expectedVariable: "MY_API_TOKEN",
+	}, {
+		name:             "3_digit",
+		profile:          "1st",
+		expectedVariable: "_1ST_TOKEN",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			variable := TokenVariableName(tc.profile)
+			if variable != tc.expectedVariable {
+				t.Errorf("Wrong variable: got %q, want %q", variable, tc.expectedVariable)
+			}
+		})
+	}
+}
+
+func TestQuoteEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		value          string
+		expectedQuoted string
+		expectedOk     bool
+	}{{
+		name:           "1_safe",
+		value:          "eyJ.a-b_c~d+e/f=",
+		expectedQuoted: "eyJ.a-b_c~d+e/f=",
+		expectedOk:     true,
+	}, {
+		name:           "2_expansion",
+		value:          "a$HOME`id`\"b\\c",
+		expectedQuoted: "'a$HOME`id`\"b\\c'",
+		expectedOk:     true,
+	}, {
+		name:       "3_single_quote",
+		value:      "a'b",
+		expectedOk: false,
+	}, {
+		name:       "4_carriage_return",
+		value:      "a\rb",
+		expectedOk: false,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			quoted, ok := quoteEnv(tc.value)
+			if ok != tc.expectedOk {
+				t.Errorf("Wrong ok: got %v, want %v", ok, tc.expectedOk)
+			}
+			if quoted != tc.expectedQuoted {
+				t.Errorf("Wrong quoted: got %q, want %q", quoted, tc.expectedQuoted)
+			}
+		})
+	}
+}
+
+func TestFormatToken_NetrcSecret(t *testing.T) {
+	r := httptest.NewRequest("GET", "/token?format=netrc", nil)
+
+	_, _, err := FormatToken(r, TokenFormatNetrc, "access", Token{Secret: "Basic dXNlcjpwYXNz"})
+	if !errors.Is(err, ErrTokenFormatParamInvalid) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrTokenFormatParamInvalid)
+	}
+
+	b, _, err := FormatToken(r, TokenFormatNetrc, "access", Token{Secret: "dXNlcjpwYXNz"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !strings.Contains(string(b), "password dXNlcjpwYXNz\n") {
+		t.Errorf("Didn't find substr in body: want %q", "password dXNlcjpwYXNz\n")
+	}
+}
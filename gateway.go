@@ -0,0 +1,249 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrGatewayProofMissing = errors.New("gateway proof missing")
+
+var ErrGatewayProofInvalid = errors.New("gateway proof invalid")
+
+// GatewayVerifier verifies that a request has passed through the trusted
+// gateway in front of Token2go.
+type GatewayVerifier interface {
+	// Verify returns ErrGatewayProofMissing or ErrGatewayProofInvalid (possibly
+	// wrapped) if the request does not carry a valid gateway proof.
+	Verify(r *http.Request) error
+
+	// HeaderName returns the name of the header that contains the proof.
+	HeaderName() string
+}
+
+// SharedSecretGatewayVerifier expects the gateway to set a header to a static
+// shared secret. Use the function NewSharedSecretGatewayVerifier to construct.
+type SharedSecretGatewayVerifier struct {
+	headerName string
+	secret     []byte
+}
+
+// NewSharedSecretGatewayVerifier creates a verifier that compares the value of
+// the given header with the given secret.
+func NewSharedSecretGatewayVerifier(
+	headerName string,
+	secret string,
+) *SharedSecretGatewayVerifier {
+	return &SharedSecretGatewayVerifier{headerName, []byte(secret)}
+}
+
+func (v *SharedSecretGatewayVerifier) HeaderName() string {
+	return v.headerName
+}
+
+func (v *SharedSecretGatewayVerifier) Verify(r *http.Request) error {
+	proof := r.Header.Get(v.headerName)
+	if len(proof) == 0 {
+		return fmt.Errorf("%w in header %s", ErrGatewayProofMissing, v.headerName)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(proof), v.secret) != 1 {
+		return fmt.Errorf("%w in header %s", ErrGatewayProofInvalid, v.headerName)
+	}
+
+	return nil
+}
+
+// HMACGatewayVerifier expects the gateway to sign the request method and URI,
+// a timestamp, and selected headers with a shared secret. The timestamp keeps
+// captured proofs from being replayed later, method and URI keep them from
+// being replayed against other endpoints. Use the function
+// NewHMACGatewayVerifier to construct.
+type HMACGatewayVerifier struct {
+	headerName          string
+	secret              []byte
+	signedHeaderNames   []string
+	timestampHeaderName string
+	maxSkew             time.Duration
+	now                 func() time.Time
+}
+
+// NewHMACGatewayVerifier creates a verifier that expects the given header to
+// contain the hex encoded HMAC-SHA256 as computed by GatewayHMAC. The header
+// timestampHeaderName must contain the time of signing in seconds since the
+// epoch. It is the first signed header, followed by signedHeaderNames.
+// Timestamps more than maxSkew away from now are rejected.
+func NewHMACGatewayVerifier(
+	headerName string,
+	secret string,
+	signedHeaderNames []string,
+	timestampHeaderName string,
+	maxSkew time.Duration,
+) *HMACGatewayVerifier {
+	return &HMACGatewayVerifier{
+		headerName:          headerName,
+		secret:              []byte(secret),
+		signedHeaderNames:   append([]string{timestampHeaderName}, signedHeaderNames...),
+		timestampHeaderName: timestampHeaderName,
+		maxSkew:             maxSkew,
+		now:                 time.Now,
+	}
+}
+
+func (v *HMACGatewayVerifier) HeaderName() string {
+	return v.headerName
+}
+
+func (v *HMACGatewayVerifier) Verify(r *http.Request) error {
+	proof := r.Header.Get(v.headerName)
+	if len(proof) == 0 {
+		return fmt.Errorf("%w in header %s", ErrGatewayProofMissing, v.headerName)
+	}
+
+	timestamp := r.Header.Get(v.timestampHeaderName)
+	if len(timestamp) == 0 {
+		return fmt.Errorf("%w: timestamp missing in header %s", ErrGatewayProofMissing, v.timestampHeaderName)
+	}
+
+	want := GatewayHMAC(v.secret, r.Method, r.RequestURI, r.Header, v.signedHeaderNames)
+	if !hmac.Equal([]byte(strings.ToLower(proof)), []byte(want)) {
+		return fmt.Errorf("%w in header %s", ErrGatewayProofInvalid, v.headerName)
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: malformed timestamp in header %s", ErrGatewayProofInvalid, v.timestampHeaderName)
+	}
+	skew := v.now().Sub(time.Unix(seconds, 0))
+	if skew > v.maxSkew || skew < -v.maxSkew {
+		return fmt.Errorf("%w: timestamp in header %s off by %v", ErrGatewayProofInvalid, v.timestampHeaderName, skew)
+	}
+
+	return nil
+}
+
+// GatewayHMAC computes the hex encoded HMAC-SHA256 over the given request
+// method, request URI, and headers. The signed message starts with the line
+// "<method> <request URI>\n". The request URI is the unmodified request target
+// including the query as sent by the client, for example "/token?format=json".
+// It is followed by one line per header name in the given order. Each line has
+// the form "<lowercase name>:<first value>\n". Absent headers are included with
+// an empty value.
+func GatewayHMAC(
+	secret []byte,
+	method string,
+	requestURI string,
+	headers http.Header,
+	signedHeaderNames []string,
+) string {
+	mac := hmac.New(sha256.New, secret)
+
+	fmt.Fprintf(mac, "%s %s\n", method, requestURI)
+	for _, name := range signedHeaderNames {
+		fmt.Fprintf(mac, "%s:%s\n", strings.ToLower(name), headers.Get(name))
+	}
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// JWTGatewayVerifier expects the gateway to add a signed JWT assertion like
+// Google Cloud IAP (X-Goog-IAP-JWT-Assertion) or AWS ALB (X-Amzn-Oidc-Data)
+// do. Use the function NewJWTGatewayVerifier to construct.
+type JWTGatewayVerifier struct {
+	headerName  string
+	keys        map[string]crypto.PublicKey
+	issuer      string
+	audience    string
+	maxLifetime time.Duration
+}
+
+// NewJWTGatewayVerifier creates a verifier that expects the given header to
+// contain a JWT signed by the key that the "kid" header of the JWT refers to.
+// Keys are mapped by key ID, see ParseJWTKeys. The claims "iss" and "aud" must
+// match the given issuer and audience. Both are required because gateways like
+// Google Cloud IAP and AWS ALB sign the assertions of all applications with
+// the same keys. The JWT must expire within maxLifetime, see
+// ValidateJWTLifetime.
+func NewJWTGatewayVerifier(
+	headerName string,
+	keys map[string]crypto.PublicKey,
+	issuer string,
+	audience string,
+	maxLifetime time.Duration,
+) *JWTGatewayVerifier {
+	return &JWTGatewayVerifier{headerName, keys, issuer, audience, maxLifetime}
+}
+
+func (v *JWTGatewayVerifier) HeaderName() string {
+	return v.headerName
+}
+
+func (v *JWTGatewayVerifier) Verify(r *http.Request) error {
+	proof := r.Header.Get(v.headerName)
+	if len(proof) == 0 {
+		return fmt.Errorf("%w in header %s", ErrGatewayProofMissing, v.headerName)
+	}
+
+	if len(v.issuer) == 0 || len(v.audience) == 0 {
+		return fmt.Errorf("%w: issuer and audience not configured", ErrGatewayProofInvalid)
+	}
+
+	t, err := ParseJWT(proof)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrGatewayProofInvalid, err)
+	}
+
+	kid, _ := t.Header["kid"].(string)
+	key, ok := v.keys[kid]
+	if !ok {
+		return fmt.Errorf("%w: %w: %q", ErrGatewayProofInvalid, ErrJWTKeyUnknown, kid)
+	}
+
+	err = VerifyJWTSignature(t, []crypto.PublicKey{key})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrGatewayProofInvalid, err)
+	}
+
+	now := time.Now()
+
+	err = ValidateJWTClaims(t, v.issuer, v.audience, now)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrGatewayProofInvalid, err)
+	}
+
+	err = ValidateJWTLifetime(t, v.maxLifetime, now)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrGatewayProofInvalid, err)
+	}
+
+	return nil
+}
+
+// MakeGatewayProofMiddleware returns a middleware that rejects all requests
+// that fail verification by the given verifier with a client error response.
+func MakeGatewayProofMiddleware(v GatewayVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := v.Verify(r)
+			if err != nil {
+				code := "GatewayProofInvalid"
+				if errors.Is(err, ErrGatewayProofMissing) {
+					code = "GatewayProofMissing"
+				}
+				msg := fmt.Sprintf("Forbidden. Gateway proof rejected: %v", err)
+				WriteProblem(w, r, http.StatusForbidden, code, msg)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"crypto"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSharedSecretGatewayVerifier(t *testing.T) {
+	v := NewSharedSecretGatewayVerifier("X-Secret", "s3cr3t")
+
+	for _, tc := range []struct {
+		name        string
+		headers     http.Header
+		expectedErr error
+	}{{
+		name:        "1_missing",
+		headers:     http.Header{},
+		expectedErr: ErrGatewayProofMissing,
+	}, {
+		name:        "2_wrong",
+		headers:     http.Header{"X-Secret": {"lol"}},
+		expectedErr: ErrGatewayProofInvalid,
+	}, {
+		name:        "3_correct",
+		headers:     http.Header{"X-Secret": {"s3cr3t"}},
+		expectedErr: nil,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/token", nil)
+			request.Header = tc.headers
+
+			err := v.Verify(request)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("Wrong error: got %v, want %v", err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestHMACGatewayVerifier(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signed := []string{"Authorization", "X-Missing"}
+	v := NewHMACGatewayVerifier("X-Signature", "s3cr3t", signed, "X-Timestamp", 5*time.Minute)
+	v.now = func() time.Time { return now }
+
+	sign := func(timestamp time.Time) (string, string) {
+		value := strconv.FormatInt(timestamp.Unix(), 10)
+		headers := http.Header{"Authorization": {"Bearer x"}, "X-Timestamp": {value}}
+		return value, GatewayHMAC(
+			[]byte("s3cr3t"), "GET", "/token?format=json", headers,
+			append([]string{"X-Timestamp"}, signed...),
+		)
+	}
+	timestamp, signature := sign(now.Add(-time.Minute))
+	staleTimestamp, staleSignature := sign(now.Add(-10 * time.Minute))
+	futureTimestamp, futureSignature := sign(now.Add(10 * time.Minute))
+
+	for _, tc := range []struct {
+		name        string
+		method      string
+		target      string
+		headers     http.Header
+		expectedErr error
+	}{{
+		name:        "1_missing",
+		headers:     http.Header{"Authorization": {"Bearer x"}, "X-Timestamp": {timestamp}},
+		expectedErr: ErrGatewayProofMissing,
+	}, {
+		name: "2_tampered",
+		headers: http.Header{
+			"Authorization": {"Bearer y"},
+			"X-Signature":   {signature},
+			"X-Timestamp":   {timestamp},
+		},
+		expectedErr: ErrGatewayProofInvalid,
+	}, {
+		name: "3_correct",
+		headers: http.Header{
+			"Authorization": {"Bearer x"},
+			"X-Signature":   {signature},
+			"X-Timestamp":   {timestamp},
+		},
+		expectedErr: nil,
+	}, {
+		name: "4_timestamp_missing",
+		headers: http.Header{
+			"Authorization": {"Bearer x"},
+			"X-Signature":   {signature},
+		},
+		expectedErr: ErrGatewayProofMissing,
+	}, {
+		name: "5_timestamp_tampered",
+		headers: http.Header{
+			"Authorization": {"Bearer x"},
+			"X-Signature":   {staleSignature},
+			"X-Timestamp":   {timestamp},
+		},
+		expectedErr: ErrGatewayProofInvalid,
+	}, {
+		name: "6_timestamp_stale",
+		headers: http.Header{
+			"Authorization": {"Bearer x"},
+			"X-Signature":   {staleSignature},
+			"X-Timestamp":   {staleTimestamp},
+		},
+		expectedErr: ErrGatewayProofInvalid,
+	}, {
+		name: "7_timestamp_future",
+		headers: http.Header{
+			"Authorization": {"Bearer x"},
+			"X-Signature":   {futureSignature},
+			"X-Timestamp":   {futureTimestamp},
+		},
+		expectedErr: ErrGatewayProofInvalid,
+	}, {
+		name:   "8_method_other",
+		method: "POST",
+		headers: http.Header{
+			"Authorization": {"Bearer x"},
+			"X-Signature":   {signature},
+			"X-Timestamp":   {timestamp},
+		},
+		expectedErr: ErrGatewayProofInvalid,
+	}, {
+		name:   "9_path_other",
+		target: "/wrap?format=json",
+		headers: http.Header{
+			"Authorization": {"Bearer x"},
+			"X-Signature":   {signature},
+			"X-Timestamp":   {timestamp},
+		},
+		expectedErr: ErrGatewayProofInvalid,
+	}, {
+		name:   "10_query_other",
+		target: "/token?format=text",
+		headers: http.Header{
+			"Authorization": {"Bearer x"},
+			"X-Signature":   {signature},
+			"X-Timestamp":   {timestamp},
+		},
+		expectedErr: ErrGatewayProofInvalid,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			method, target := tc.method, tc.target
+			if len(method) == 0 {
+				method = "GET"
+			}
+			if len(target) == 0 {
+				target = "/token?format=json"
+			}
+
+			request := httptest.NewRequest(method, target, nil)
+			request.Header = tc.headers
+
+			err := v.Verify(request)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("Wrong error: got %v, want %v", err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestJWTGatewayVerifier(t *testing.T) {
+	gatewayKey := readTestPrivateKey(t, "b-private-key-ecdsa-prime256v1-rfc5958-pksc8.pem")
+	otherKey := readTestPrivateKey(t, "a-private-key-rsa2048-rfc5958-pksc8.pem")
+
+	v := NewJWTGatewayVerifier(
+		"X-Goog-Iap-Jwt-Assertion",
+		map[string]crypto.PublicKey{"b": gatewayKey.Public(), "a": otherKey.Public()},
+		"https://cloud.google.com/iap",
+		"/projects/1/apps/x",
+		15*time.Minute,
+	)
+
+	claims := func(iat, exp time.Duration) map[string]any {
+		c := map[string]any{
+			"iss": "https://cloud.google.com/iap",
+			"aud": "/projects/1/apps/x",
+			"iat": time.Now().Add(iat).Unix(),
+		}
+		if exp != 0 {
+			c["exp"] = time.Now().Add(exp).Unix()
+		}
+		return c
+	}
+	valid := claims(-time.Minute, 9*time.Minute)
+	expired := claims(-time.Hour, -50*time.Minute)
+	noExpiry := claims(-time.Minute, 0)
+	longExpiry := claims(0, time.Hour)
+	longLived := claims(-time.Hour, 5*time.Minute)
+	otherAudience := claims(-time.Minute, 9*time.Minute)
+	otherAudience["aud"] = "/projects/1/apps/y"
+	noAudience := claims(-time.Minute, 9*time.Minute)
+	delete(noAudience, "aud")
+	otherIssuer := claims(-time.Minute, 9*time.Minute)
+	otherIssuer["iss"] = "https://example.com"
+
+	for _, tc := range []struct {
+		name        string
+		assertion   string
+		expectedErr error
+	}{{
+		name:        "1_missing",
+		assertion:   "",
+		expectedErr: ErrGatewayProofMissing,
+	}, {
+		name:        "2_malformed",
+		assertion:   "lol",
+		expectedErr: ErrJWTMalformed,
+	}, {
+		name:        "3_wrong_key",
+		assertion:   signTestJWTWithKeyID(t, otherKey, "b", valid),
+		expectedErr: ErrJWTSignature,
+	}, {
+		name:        "4_expired",
+		assertion:   signTestJWTWithKeyID(t, gatewayKey, "b", expired),
+		expectedErr: ErrJWTExpired,
+	}, {
+		name:        "5_correct",
+		assertion:   signTestJWTWithKeyID(t, gatewayKey, "b", valid),
+		expectedErr: nil,
+	}, {
+		name:        "6_no_expiry",
+		assertion:   signTestJWTWithKeyID(t, gatewayKey, "b", noExpiry),
+		expectedErr: ErrJWTExpiryMissing,
+	}, {
+		name:        "7_expiry_too_far",
+		assertion:   signTestJWTWithKeyID(t, gatewayKey, "b", longExpiry),
+		expectedErr: ErrJWTLifetime,
+	}, {
+		name:        "8_lifetime_too_long",
+		assertion:   signTestJWTWithKeyID(t, gatewayKey, "b", longLived),
+		expectedErr: ErrJWTLifetime,
+	}, {
+		name:        "9_kid_missing",
+		assertion:   signTestJWT(t, gatewayKey, valid),
+		expectedErr: ErrJWTKeyUnknown,
+	}, {
+		name:        "10_kid_unknown",
+		assertion:   signTestJWTWithKeyID(t, gatewayKey, "c", valid),
+		expectedErr: ErrJWTKeyUnknown,
+	}, {
+		name:        "11_kid_other_key",
+		assertion:   signTestJWTWithKeyID(t, gatewayKey, "a", valid),
+		expectedErr: ErrJWTSignature,
+	}, {
+		name:        "12_audience_other",
+		assertion:   signTestJWTWithKeyID(t, gatewayKey, "b", otherAudience),
+		expectedErr: ErrJWTAudience,
+	}, {
+		name:        "13_audience_missing",
+		assertion:   signTestJWTWithKeyID(t, gatewayKey, "b", noAudience),
+		expectedErr: ErrJWTAudience,
+	}, {
+		name:        "14_issuer_other",
+		assertion:   signTestJWTWithKeyID(t, gatewayKey, "b", otherIssuer),
+		expectedErr: ErrJWTIssuer,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/token", nil)
+			if len(tc.assertion) > 0 {
+				request.Header.Set("X-Goog-Iap-Jwt-Assertion", tc.assertion)
+			}
+
+			err := v.Verify(request)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("Wrong error: got %v, want %v", err, tc.expectedErr)
+			}
+			if tc.expectedErr != nil && tc.expectedErr != ErrGatewayProofMissing &&
+				!errors.Is(err, ErrGatewayProofInvalid) {
+				t.Errorf("Wrong error: got %v, want ErrGatewayProofInvalid", err)
+			}
+		})
+	}
+}
+
+func TestInitRouter_GatewayProof(t *testing.T) {
+	t.Setenv("T2G_GATEWAY_PROOF", "secret")
+	t.Setenv("T2G_GATEWAY_SECRET", "s3cr3t")
+	t.Setenv("T2G_FALLBACK_TOKEN", "fallback")
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewRouterArgs(c, NewRouterState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initRouter(a)
+
+	for _, tc := range []struct {
+		name         string
+		path         string
+		secret       string
+		expectedCode int
+	}{{
+		name:         "1_token_rejected",
+		path:         "/token",
+		secret:       "",
+		expectedCode: 403,
+	}, {
+		name:         "2_token_accepted",
+		path:         "/token",
+		secret:       "s3cr3t",
+		expectedCode: 200,
+	}, {
+		name:         "3_echo_rejected",
+		path:         "/echo",
+		secret:       "lol",
+		expectedCode: 403,
+	}, {
+		name:         "4_health_open",
+		path:         "/health",
+		secret:       "",
+		expectedCode: 200,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", tc.path, nil)
+			if len(tc.secret) > 0 {
+				request.Header.Set("X-Token2go-Gateway-Secret", tc.secret)
+			}
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, request)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong status code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+		})
+	}
+}
+
+func TestNewGatewayVerifier_JWTKeysFile(t *testing.T) {
+	c := Config{
+		gatewayProof:           "jwt",
+		gatewayProofHeaderName: "X-Amzn-Oidc-Data",
+		gatewayJWTKeysFile:     "testdata/b-public-key-ecdsa-prime256v1-rfc5280-x509-kid.pem",
+	}
+
+	v, err := NewGatewayVerifier(c)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if v.HeaderName() != "X-Amzn-Oidc-Data" {
+		t.Errorf("Wrong header name: got %q", v.HeaderName())
+	}
+
+	c.gatewayJWTKeysFile = "testdata/does-not-exist.pem"
+	_, err = NewGatewayVerifier(c)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Wrong error: got %v, want os.ErrNotExist", err)
+	}
+}
@@ -2,4 +2,10 @@ module github.com/trallnag/token2go-server
 
 go 1.20
 
-require github.com/go-chi/chi/v5 v5.0.8
+require (
+	github.com/go-chi/chi/v5 v5.0.8
+	github.com/pierrec/lz4/v4 v4.1.18
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultUILocale is the locale of the web page if nothing else is
+// configured.
+const DefaultUILocale = "en"
+
+// Catalog maps message keys to messages of a single locale. Messages can
+// contain placeholders like "{target}".
+type Catalog map[string]string
+
+// Catalogs maps locales to catalogs. Use NewCatalogs to construct.
+type Catalogs map[string]Catalog
+
+// UIStrings holds the operator-provided T2G_UI_* strings of a locale.
+type UIStrings struct {
+	Target string `json:"target,omitempty"`
+	Title  string `json:"title,omitempty"`
+	Desc1  string `json:"desc1,omitempty"`
+	Desc2  string `json:"desc2,omitempty"`
+	Misc   string `json:"misc,omitempty"`
+}
+
+var ErrUILocaleUnknown = errors.New("unknown UI locale")
+
+var uiLocaleRegexp = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// NewCatalogs loads the embedded catalogs and the catalogs in overlayDir if
+// set. Catalogs are JSON objects in files named like "de.json". Operator
+// catalogs override single messages of embedded catalogs of the same locale
+// and can add locales. Messages missing in a catalog are taken from the
+// catalog of defaultLocale. Returns an error if a catalog is malformed or
+// defaultLocale has no catalog.
+func NewCatalogs(overlayDir string, defaultLocale string) (Catalogs, error) {
+	localeContent, err := NewUIContent("locale", overlayDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := fs.Glob(localeContent, "*.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list catalogs: %w", err)
+	}
+
+	catalogs := Catalogs{}
+	layers := []fs.FS{localeContent}
+	if overlay, ok := localeContent.(OverlayFS); ok {
+		layers = []fs.FS{overlay[1], overlay[0]}
+	}
+
+	for _, file := range files {
+		locale := NormalizeUILocale(strings.TrimSuffix(file, ".json"))
+		if !uiLocaleRegexp.MatchString(locale) {
+			return nil, fmt.Errorf("catalog %q: invalid locale", file)
+		}
+
+		catalog := Catalog{}
+		for _, layer := range layers {
+			data, err := fs.ReadFile(layer, file)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("catalog %q: %w", file, err)
+			}
+			err = json.Unmarshal(data, &catalog)
+			if err != nil {
+				return nil, fmt.Errorf("catalog %q: %w", file, err)
+			}
+		}
+		catalogs[locale] = catalog
+	}
+
+	defaultCatalog, ok := catalogs[NormalizeUILocale(defaultLocale)]
+	if !ok {
+		return nil, fmt.Errorf("%w: default %q", ErrUILocaleUnknown, defaultLocale)
+	}
+	for _, catalog := range catalogs {
+		for key, msg := range defaultCatalog {
+			if _, ok := catalog[key]; !ok {
+				catalog[key] = msg
+			}
+		}
+	}
+
+	return catalogs, nil
+}
+
+// Locales returns all locales ordered by name.
+func (c Catalogs) Locales() []string {
+	return sortedKeys(c)
+}
+
+// Format returns the message for key with placeholders replaced. Arguments
+// are pairs of placeholder names and values.
+func (c Catalog) Format(key string, args ...string) string {
+	msg := c[key]
+	for i := 0; i+1 < len(args); i += 2 {
+		msg = strings.ReplaceAll(msg, "{"+args[i]+"}", args[i+1])
+	}
+
+	return msg
+}
+
+// NormalizeUILocale lowercases the given locale and replaces underscores with
+// dashes. For example "de_AT" becomes "de-at".
+func NormalizeUILocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
+
+// NegotiateUILocale picks the locale for r from the given locales. The query
+// parameter "lang" takes precedence over the Accept-Language header. Ranges
+// match exactly or by their primary language, so "de-AT" matches "de".
+// Returns defaultLocale if nothing matches.
+func NegotiateUILocale(r *http.Request, locales []string, defaultLocale string) string {
+	type weightedRange struct {
+		locale string
+		q      float64
+	}
+
+	var ranges []weightedRange
+
+	if lang := r.URL.Query().Get("lang"); len(lang) > 0 {
+		ranges = append(ranges, weightedRange{NormalizeUILocale(lang), 2})
+	}
+
+	for _, header := range r.Header.Values("Accept-Language") {
+		for _, part := range strings.Split(header, ",") {
+			tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+			q := 1.0
+			if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+				var err error
+				q, err = strconv.ParseFloat(v, 64)
+				if err != nil {
+					continue
+				}
+			}
+
+			if len(tag) > 0 && q > 0 {
+				ranges = append(ranges, weightedRange{NormalizeUILocale(tag), q})
+			}
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, r := range ranges {
+		if contains(locales, r.locale) {
+			return r.locale
+		}
+		if primary, _, ok := strings.Cut(r.locale, "-"); ok && contains(locales, primary) {
+			return primary
+		}
+	}
+
+	return defaultLocale
+}
+
+// LocalizedIndexTmplData holds IndexTmplData per locale. Use
+// NewLocalizedIndexTmplData to construct.
+type LocalizedIndexTmplData struct {
+	defaultLocale string
+	data          map[string]IndexTmplData
+}
+
+// NewLocalizedIndexTmplData constructs IndexTmplData for every locale in the
+// given catalogs. Strings in localized are taken over those in base if set.
+// Returns an error if localized contains a locale without catalog.
+func NewLocalizedIndexTmplData(
+	catalogs Catalogs,
+	defaultLocale string,
+	base UIStrings,
+	localized map[string]UIStrings,
+) (LocalizedIndexTmplData, error) {
+	l := LocalizedIndexTmplData{
+		defaultLocale: NormalizeUILocale(defaultLocale),
+		data:          make(map[string]IndexTmplData, len(catalogs)),
+	}
+
+	for _, locale := range sortedKeys(localized) {
+		if _, ok := catalogs[locale]; !ok {
+			return l, fmt.Errorf("%w: UI strings for %q", ErrUILocaleUnknown, locale)
+		}
+	}
+
+	for locale, catalog := range catalogs {
+		s := base
+		if override, ok := localized[locale]; ok {
+			s = mergeUIStrings(s, override)
+		}
+		l.data[locale] = NewCatalogIndexTmplData(locale, catalog, s)
+	}
+
+	return l, nil
+}
+
+// Select returns the locale and the IndexTmplData negotiated for r with
+// NegotiateUILocale. Falls back to English IndexTmplData without operator
+// strings if l has no data.
+func (l LocalizedIndexTmplData) Select(r *http.Request) (string, IndexTmplData) {
+	if len(l.data) == 0 {
+		return DefaultUILocale, NewIndexTmplData("", "", "", "", "")
+	}
+
+	locale := NegotiateUILocale(r, sortedKeys(l.data), l.defaultLocale)
+
+	return locale, l.data[locale]
+}
+
+// SetProfiles sets the profile names in the IndexTmplData of all locales.
+func (l LocalizedIndexTmplData) SetProfiles(profiles []string) {
+	for locale, d := range l.data {
+		d.Profiles = profiles
+		l.data[locale] = d
+	}
+}
+
+// SetExpiry sets the expiry behavior in the IndexTmplData of all locales.
+func (l LocalizedIndexTmplData) SetExpiry(warning int, reload bool) {
+	for locale, d := range l.data {
+		d.ExpiryWarning = warning
+		d.ExpiryReload = reload
+		l.data[locale] = d
+	}
+}
+
+// All returns the IndexTmplData of all locales.
+func (l LocalizedIndexTmplData) All() map[string]IndexTmplData {
+	return l.data
+}
+
+// NewCatalogIndexTmplData constructs IndexTmplData for the given locale with
+// messages from catalog and operator-provided strings.
+func NewCatalogIndexTmplData(locale string, catalog Catalog, s UIStrings) IndexTmplData {
+	d := IndexTmplData{Lang: locale, T: catalog}
+
+	if len(s.Title) > 0 {
+		d.Title = s.Title
+	} else if len(s.Target) > 0 {
+		d.Title = catalog.Format("titleTarget", "target", s.Target)
+	} else {
+		d.Title = catalog.Format("title")
+	}
+
+	if len(s.Desc1) > 0 {
+		d.Desc1 = template.HTML( //#nosec G203 -- Accepted risk. Data is only provided on startup as config.
+			s.Desc1,
+		)
+	} else if len(s.Target) > 0 {
+		d.Desc1 = template.HTML( //#nosec G203 -- Accepted risk. Data is only provided on startup as config.
+			catalog.Format("desc1Target", "target", s.Target),
+		)
+	} else {
+		d.Desc1 = template.HTML( //#nosec G203 -- Accepted risk. Data is only provided on startup as config.
+			catalog.Format("desc1"),
+		)
+	}
+
+	d.Desc2 = template.HTML(
+		s.Desc2,
+	) //#nosec G203 -- Accepted risk. Data is only provided on startup as config.
+	d.Misc = template.HTML(
+		s.Misc,
+	) //#nosec G203 -- Accepted risk. Data is only provided on startup as config.
+
+	return d
+}
+
+// mergeUIStrings returns base with all fields replaced that are set in
+// override.
+func mergeUIStrings(base UIStrings, override UIStrings) UIStrings {
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{
+		{&base.Target, override.Target},
+		{&base.Title, override.Title},
+		{&base.Desc1, override.Desc1},
+		{&base.Desc2, override.Desc2},
+		{&base.Misc, override.Misc},
+	} {
+		if len(f.src) > 0 {
+			*f.dst = f.src
+		}
+	}
+
+	return base
+}
+
+// GetLocalizedUIStrings collects T2G_UI_TARGET, T2G_UI_TITLE, T2G_UI_DESC1,
+// T2G_UI_DESC2, and T2G_UI_MISC suffixed with a locale from the environment.
+// For example "T2G_UI_TITLE_DE" or "T2G_UI_DESC1_DE_AT". Locales are
+// normalized with NormalizeUILocale.
+func GetLocalizedUIStrings() map[string]UIStrings {
+	localized := map[string]UIStrings{}
+
+	for _, env := range os.Environ() {
+		key, value, _ := strings.Cut(env, "=")
+		if len(value) == 0 {
+			continue
+		}
+
+		for _, f := range []struct {
+			prefix string
+			set    func(s *UIStrings)
+		}{
+			{"T2G_UI_TARGET_", func(s *UIStrings) { s.Target = value }},
+			{"T2G_UI_TITLE_", func(s *UIStrings) { s.Title = value }},
+			{"T2G_UI_DESC1_", func(s *UIStrings) { s.Desc1 = value }},
+			{"T2G_UI_DESC2_", func(s *UIStrings) { s.Desc2 = value }},
+			{"T2G_UI_MISC_", func(s *UIStrings) { s.Misc = value }},
+		} {
+			if suffix, ok := strings.CutPrefix(key, f.prefix); ok && len(suffix) > 0 {
+				locale := NormalizeUILocale(suffix)
+				s := localized[locale]
+				f.set(&s)
+				localized[locale] = s
+			}
+		}
+	}
+
+	return localized
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestCatalogs writes the given catalogs to a temporary directory.
+func writeTestCatalogs(t *testing.T, catalogs map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, data := range catalogs {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func TestNegotiateUILocale(t *testing.T) {
+	locales := []string{"de", "en", "pt-br"}
+
+	for _, tc := range []struct {
+		name           string
+		target         string
+		acceptLanguage string
+		expectedLocale string
+	}{{
+		name:           "1_default",
+		target:         "/",
+		acceptLanguage: "",
+		expectedLocale: "en",
+	}, {
+		name:           "2_exact",
+		target:         "/",
+		acceptLanguage: "de",
+		expectedLocale: "de",
+	}, {
+		name:           "3_primary_language",
+		target:         "/",
+		acceptLanguage: "de-AT",
+		expectedLocale: "de",
+	}, {
+		name:           "4_quality",
+		target:         "/",
+		acceptLanguage: "fr, en;q=0.5, de;q=0.8",
+		expectedLocale: "de",
+	}, {
+		name:           "5_region",
+		target:         "/",
+		acceptLanguage: "pt-BR",
+		expectedLocale: "pt-br",
+	}, {
+		name:           "6_unknown",
+		target:         "/",
+		acceptLanguage: "fr, *;q=0.1",
+		expectedLocale: "en",
+	}, {
+		name:           "7_query_param_wins",
+		target:         "/?lang=de",
+		acceptLanguage: "en",
+		expectedLocale: "de",
+	}, {
+		name:           "8_rejected",
+		target:         "/",
+		acceptLanguage: "de;q=0",
+		expectedLocale: "en",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			if len(tc.acceptLanguage) > 0 {
+				r.Header.Set("Accept-Language", tc.acceptLanguage)
+			}
+
+			locale := NegotiateUILocale(r, locales, "en")
+			if locale != tc.expectedLocale {
+				t.Errorf("Wrong locale: got %q, want %q", locale, tc.expectedLocale)
+			}
+		})
+	}
+}
+
+func TestNewCatalogs(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		files           map[string]string
+		defaultLocale   string
+		expectedError   bool
+		expectedLocales string
+		expectedReload  map[string]string
+	}{{
+		name:            "1_embedded",
+		files:           nil,
+		defaultLocale:   "en",
+		expectedLocales: "de,en",
+		expectedReload:  map[string]string{"en": "Reload", "de": "Neu laden"},
+	}, {
+		name:            "2_override",
+		files:           map[string]string{"de.json": `{"reload": "Aktualisieren"}`},
+		defaultLocale:   "en",
+		expectedLocales: "de,en",
+		expectedReload:  map[string]string{"de": "Aktualisieren"},
+	}, {
+		name:            "3_new_locale_filled_from_default",
+		files:           map[string]string{"fr_CA.json": `{"copy": "Copier"}`},
+		defaultLocale:   "de",
+		expectedLocales: "de,en,fr-ca",
+		expectedReload:  map[string]string{"fr-ca": "Neu laden"},
+	}, {
+		name:          "4_malformed",
+		files:         map[string]string{"fr.json": `{"copy": 1}`},
+		defaultLocale: "en",
+		expectedError: true,
+	}, {
+		name:          "5_invalid_locale",
+		files:         map[string]string{"not a locale.json": `{}`},
+		defaultLocale: "en",
+		expectedError: true,
+	}, {
+		name:          "6_default_unknown",
+		files:         nil,
+		defaultLocale: "fr",
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := ""
+			if tc.files != nil {
+				dir = writeTestCatalogs(t, tc.files)
+			}
+
+			catalogs, err := NewCatalogs(dir, tc.defaultLocale)
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			locales := strings.Join(catalogs.Locales(), ",")
+			if locales != tc.expectedLocales {
+				t.Errorf("Wrong locales: got %q, want %q", locales, tc.expectedLocales)
+			}
+			for locale, expected := range tc.expectedReload {
+				if catalogs[locale]["reload"] != expected {
+					t.Errorf(
+						"Wrong message for %q: got %q, want %q",
+						locale, catalogs[locale]["reload"], expected,
+					)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbeddedCatalogsComplete(t *testing.T) {
+	catalogs, err := NewCatalogs("", "en")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for locale, catalog := range catalogs {
+		if len(catalog) != len(catalogs["en"]) {
+			t.Errorf("Wrong number of messages for %q: got %v, want %v",
+				locale, len(catalog), len(catalogs["en"]))
+		}
+	}
+}
+
+func TestNewLocalizedIndexTmplData(t *testing.T) {
+	catalogs, err := NewCatalogs("", "en")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	l, err := NewLocalizedIndexTmplData(catalogs, "en", UIStrings{
+		Target: "MyApp",
+		Misc:   "<p>Misc</p>",
+	}, map[string]UIStrings{
+		"de": {Misc: "<p>Verschiedenes</p>"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		acceptLanguage string
+		expectedLocale string
+		expectedTitle  string
+		expectedDesc1  string
+		expectedMisc   string
+	}{{
+		name:           "1_english",
+		acceptLanguage: "en",
+		expectedLocale: "en",
+		expectedTitle:  "Token2go | MyApp",
+		expectedDesc1:  "Get a token for MyApp with the Token2go service",
+		expectedMisc:   "<p>Misc</p>",
+	}, {
+		name:           "2_german",
+		acceptLanguage: "de-DE",
+		expectedLocale: "de",
+		expectedTitle:  "Token2go | MyApp",
+		expectedDesc1:  "Hol dir einen Token für MyApp mit dem Token2go-Dienst",
+		expectedMisc:   "<p>Verschiedenes</p>",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Language", tc.acceptLanguage)
+
+			locale, d := l.Select(r)
+			if locale != tc.expectedLocale {
+				t.Errorf("Wrong locale: got %q, want %q", locale, tc.expectedLocale)
+			}
+			if d.Title != tc.expectedTitle {
+				t.Errorf("Wrong Title: got %q, want %q", d.Title, tc.expectedTitle)
+			}
+			if string(d.Desc1) != tc.expectedDesc1 {
+				t.Errorf("Wrong Desc1: got %q, want %q", d.Desc1, tc.expectedDesc1)
+			}
+			if string(d.Misc) != tc.expectedMisc {
+				t.Errorf("Wrong Misc: got %q, want %q", d.Misc, tc.expectedMisc)
+			}
+		})
+	}
+
+	_, err = NewLocalizedIndexTmplData(catalogs, "en", UIStrings{}, map[string]UIStrings{
+		"fr": {Title: "Titre"},
+	})
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
+
+func TestGetLocalizedUIStrings(t *testing.T) {
+	t.Setenv("T2G_UI_TITLE_DE", "Titel")
+	t.Setenv("T2G_UI_MISC_DE_AT", "Servus")
+	t.Setenv("T2G_UI_TEMPLATE_DIR", "/tmp")
+
+	localized := GetLocalizedUIStrings()
+
+	if localized["de"].Title != "Titel" {
+		t.Errorf("Wrong Title: got %q, want %q", localized["de"].Title, "Titel")
+	}
+	if localized["de-at"].Misc != "Servus" {
+		t.Errorf("Wrong Misc: got %q, want %q", localized["de-at"].Misc, "Servus")
+	}
+	if len(localized) != 2 {
+		t.Errorf("Wrong number of locales: got %v, want %v", len(localized), 2)
+	}
+}
+
+func TestInitRouter_Localized(t *testing.T) {
+	t.Setenv("T2G_UI_TITLE_DE", "Mein Titel")
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewRouterArgs(c, NewRouterState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initRouter(a)
+
+	for _, tc := range []struct {
+		name                    string
+		acceptLanguage          string
+		expectedContentLanguage string
+		expectedBody            []string
+	}{{
+		name:                    "1_english",
+		acceptLanguage:          "en-US,en;q=0.9",
+		expectedContentLanguage: "en",
+		expectedBody: []string{
+			`lang="en"`, "Token2go", "Reload", `"copiedToken":"Copied token to clipboard"`,
+		},
+	}, {
+		name:                    "2_german",
+		acceptLanguage:          "de-DE,de;q=0.9,en;q=0.8",
+		expectedContentLanguage: "de",
+		expectedBody:            []string{`lang="de"`, "Mein Titel", "Neu laden", `"copiedToken":`},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.Header.Set("Accept-Language", tc.acceptLanguage)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != 200 {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, 200)
+			}
+			contentLanguage := rr.Header().Get("Content-Language")
+			if contentLanguage != tc.expectedContentLanguage {
+				t.Errorf(
+					"Wrong content language: got %q, want %q",
+					contentLanguage, tc.expectedContentLanguage,
+				)
+			}
+			if rr.Header().Get("Vary") != "Accept-Language" {
+				t.Errorf("Wrong vary: got %q, want %q", rr.Header().Get("Vary"), "Accept-Language")
+			}
+			for _, substr := range tc.expectedBody {
+				if !strings.Contains(rr.Body.String(), substr) {
+					t.Errorf("Didn't find substr in body: want %q", substr)
+				}
+			}
+		})
+	}
+}
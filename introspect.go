@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var ErrTokenInactive = errors.New("token inactive")
+
+var ErrTokenIntrospectionFailed = errors.New("token introspection failed")
+
+// maxIntrospectionCacheSize limits the number of cached introspection results.
+const maxIntrospectionCacheSize = 10000
+
+// introspectionCacheEntry is a cached introspection response.
+type introspectionCacheEntry struct {
+	response map[string]any
+	expires  time.Time
+}
+
+// TokenIntrospector checks tokens at an OAuth 2.0 introspection endpoint
+// according to RFC 7662. Responses for active tokens are cached by token
+// fingerprint until the token expires. Use NewTokenIntrospector to construct.
+// A nil TokenIntrospector leaves tokens untouched.
+type TokenIntrospector struct {
+	client        OAuth2Client
+	tokenTypeHint string
+
+	mu    sync.Mutex
+	cache map[string]introspectionCacheEntry
+	now   func() time.Time
+}
+
+// NewTokenIntrospector creates a TokenIntrospector that uses the given client.
+// The token type hint is sent along if not empty.
+func NewTokenIntrospector(client OAuth2Client, tokenTypeHint string) *TokenIntrospector {
+	return &TokenIntrospector{
+		client:        client,
+		tokenTypeHint: tokenTypeHint,
+		cache:         map[string]introspectionCacheEntry{},
+		now:           time.Now,
+	}
+}
+
+// Introspect checks if the given token is active. The introspection response
+// is attached to the returned token.
+//
+// Returns ErrTokenInactive if the introspection endpoint says so, and
+// ErrTokenIntrospectionFailed (possibly wrapped) if the endpoint does not give
+// a proper answer.
+func (i *TokenIntrospector) Introspect(ctx context.Context, token Token) (Token, error) {
+	if i == nil {
+		return token, nil
+	}
+
+	response, ok := i.lookup(token.Fingerprint)
+	if !ok {
+		var err error
+		response, err = i.request(ctx, token.Secret)
+		if err != nil {
+			return Token{}, err
+		}
+	}
+
+	if active, _ := response["active"].(bool); !active {
+		return Token{}, ErrTokenInactive
+	}
+
+	if !ok {
+		i.store(token.Fingerprint, response)
+	}
+
+	token.Introspection = response
+
+	return token, nil
+}
+
+// request calls the introspection endpoint.
+func (i *TokenIntrospector) request(ctx context.Context, secret string) (map[string]any, error) {
+	form := url.Values{"token": {secret}}
+	if len(i.tokenTypeHint) > 0 {
+		form.Set("token_type_hint", i.tokenTypeHint)
+	}
+
+	var response map[string]any
+
+	status, err := i.client.postForm(ctx, form, &response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenIntrospectionFailed, err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf(
+			"%w: status %d: %v: %v",
+			ErrTokenIntrospectionFailed, status, response["error"], response["error_description"],
+		)
+	}
+	if _, ok := response["active"].(bool); !ok {
+		return nil, fmt.Errorf("%w: response without active", ErrTokenIntrospectionFailed)
+	}
+
+	return response, nil
+}
+
+// lookup returns the cached response for the given fingerprint if it has not
+// expired yet.
+func (i *TokenIntrospector) lookup(fingerprint string) (map[string]any, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entry, ok := i.cache[fingerprint]
+	if !ok {
+		return nil, false
+	}
+	if !i.now().Before(entry.expires) {
+		delete(i.cache, fingerprint)
+		return nil, false
+	}
+
+	return entry.response, true
+}
+
+// store caches the response until the "exp" claim of the response. Responses
+// without "exp" are not cached. If the cache is full, expired entries are
+// dropped first. If that is not enough, the cache is cleared.
+func (i *TokenIntrospector) store(fingerprint string, response map[string]any) {
+	exp, ok := response["exp"].(float64)
+	if !ok {
+		return
+	}
+	expires := time.Unix(int64(exp), 0)
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	now := i.now()
+	if !now.Before(expires) {
+		return
+	}
+
+	if len(i.cache) >= maxIntrospectionCacheSize {
+		for k, entry := range i.cache {
+			if !now.Before(entry.expires) {
+				delete(i.cache, k)
+			}
+		}
+		if len(i.cache) >= maxIntrospectionCacheSize {
+			i.cache = map[string]introspectionCacheEntry{}
+		}
+	}
+
+	i.cache[fingerprint] = introspectionCacheEntry{response, expires}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestIntrospectionServer starts a stub introspection endpoint. It accepts
+// the client "client" with secret "secret". The token "active" is active until
+// the given exp, "noexp" is active without exp, "inactive" is inactive, and
+// all other tokens make the stub fail. Calls are counted.
+func newTestIntrospectionServer(
+	t *testing.T,
+	exp time.Time,
+	calls *atomic.Int32,
+) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+
+		clientID, clientSecret, _ := r.BasicAuth()
+		if r.ParseForm() != nil || clientID != "client" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		var response map[string]any
+		switch r.PostForm.Get("token") {
+		case "active":
+			response = map[string]any{"active": true, "scope": "read", "exp": exp.Unix()}
+		case "noexp":
+			response = map[string]any{"active": true}
+		case "inactive":
+			response = map[string]any{"active": false}
+		case "garbage":
+			response = map[string]any{"foo": "bar"}
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			response = map[string]any{"error": "server_error"}
+		}
+		_ = json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestTokenIntrospector_Introspect(t *testing.T) {
+	var calls atomic.Int32
+	server := newTestIntrospectionServer(t, time.Now().Add(time.Minute), &calls)
+	introspector := NewTokenIntrospector(
+		NewOAuth2Client(server.URL, "client", "secret"), "access_token",
+	)
+
+	for _, tc := range []struct {
+		name          string
+		secret        string
+		expectedScope any
+		expectedError error
+	}{{
+		name:          "1_active",
+		secret:        "active",
+		expectedScope: "read",
+	}, {
+		name:          "2_noexp",
+		secret:        "noexp",
+		expectedScope: nil,
+	}, {
+		name:          "3_inactive",
+		secret:        "inactive",
+		expectedError: ErrTokenInactive,
+	}, {
+		name:          "4_garbage",
+		secret:        "garbage",
+		expectedError: ErrTokenIntrospectionFailed,
+	}, {
+		name:          "5_server_error",
+		secret:        "boom",
+		expectedError: ErrTokenIntrospectionFailed,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := introspector.Introspect(context.TODO(), NewToken(tc.secret, "static"))
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("Wrong error: got %v, want %v", err, tc.expectedError)
+			}
+			if err != nil {
+				return
+			}
+
+			if token.Introspection["active"] != true {
+				t.Errorf("Wrong active: got %v, want true", token.Introspection["active"])
+			}
+			if token.Introspection["scope"] != tc.expectedScope {
+				t.Errorf("Wrong scope: got %v, want %v", token.Introspection["scope"], tc.expectedScope)
+			}
+		})
+	}
+}
+
+func TestTokenIntrospector_Introspect_Cache(t *testing.T) {
+	exp := time.Now().Add(time.Minute)
+
+	var calls atomic.Int32
+	server := newTestIntrospectionServer(t, exp, &calls)
+	introspector := NewTokenIntrospector(NewOAuth2Client(server.URL, "client", "secret"), "")
+
+	introspect := func(secret string) {
+		t.Helper()
+		_, err := introspector.Introspect(context.TODO(), NewToken(secret, "static"))
+		if err != nil && !errors.Is(err, ErrTokenInactive) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name          string
+		secret        string
+		now           time.Time
+		expectedCalls int32
+	}{{
+		name:          "1_first",
+		secret:        "active",
+		now:           time.Now(),
+		expectedCalls: 1,
+	}, {
+		name:          "2_cached",
+		secret:        "active",
+		now:           time.Now(),
+		expectedCalls: 1,
+	}, {
+		name:          "3_expired",
+		secret:        "active",
+		now:           exp.Add(time.Second),
+		expectedCalls: 2,
+	}, {
+		name:          "4_noexp_first",
+		secret:        "noexp",
+		now:           time.Now(),
+		expectedCalls: 3,
+	}, {
+		name:          "5_noexp_not_cached",
+		secret:        "noexp",
+		now:           time.Now(),
+		expectedCalls: 4,
+	}, {
+		name:          "6_inactive_first",
+		secret:        "inactive",
+		now:           time.Now(),
+		expectedCalls: 5,
+	}, {
+		name:          "7_inactive_not_cached",
+		secret:        "inactive",
+		now:           time.Now(),
+		expectedCalls: 6,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			introspector.now = func() time.Time { return tc.now }
+			introspect(tc.secret)
+
+			if got := calls.Load(); got != tc.expectedCalls {
+				t.Errorf("Wrong number of calls: got %v, want %v", got, tc.expectedCalls)
+			}
+		})
+	}
+}
+
+func TestTokenIntrospector_Introspect_Disabled(t *testing.T) {
+	var introspector *TokenIntrospector
+
+	token, err := introspector.Introspect(context.TODO(), NewToken("x", "static"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token.Introspection != nil {
+		t.Errorf("Unexpected introspection: %v", token.Introspection)
+	}
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// JWT is a decoded but not necessarily verified JSON Web Token. Use the
+// function ParseJWT to construct a new JWT.
+type JWT struct {
+	Header    map[string]any
+	Claims    map[string]any
+	Signature []byte
+
+	signingInput string
+}
+
+var ErrJWTMalformed = errors.New("malformed JWT")
+
+var ErrJWTAlgorithm = errors.New("unsupported JWT signing algorithm")
+
+var ErrJWTSignature = errors.New("invalid JWT signature")
+
+var ErrJWTExpired = errors.New("JWT expired")
+
+var ErrJWTNotYetValid = errors.New("JWT not yet valid")
+
+var ErrJWTExpiryMissing = errors.New("JWT expiry missing")
+
+var ErrJWTLifetime = errors.New("JWT lifetime too long")
+
+var ErrJWTIssuer = errors.New("unexpected JWT issuer")
+
+var ErrJWTAudience = errors.New("unexpected JWT audience")
+
+var ErrJWTKeyUnknown = errors.New("unknown JWT key ID")
+
+var ErrJWTKeyIDMissing = errors.New("JWT key ID missing")
+
+// ParseJWT decodes the given compact serialized JWT without verifying it.
+// Segments are base64url decoded. Padding is tolerated because some gateways
+// like AWS ALB add it. Returns ErrJWTMalformed if decoding fails.
+func ParseJWT(raw string) (JWT, error) {
+	segments := strings.Split(raw, ".")
+	if len(segments) != 3 {
+		return JWT{}, ErrJWTMalformed
+	}
+
+	decode := func(segment string) ([]byte, error) {
+		return base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	}
+
+	t := JWT{signingInput: segments[0] + "." + segments[1]}
+
+	headerBytes, err := decode(segments[0])
+	if err != nil {
+		return JWT{}, fmt.Errorf("%w: header: %w", ErrJWTMalformed, err)
+	}
+	if err := json.Unmarshal(headerBytes, &t.Header); err != nil {
+		return JWT{}, fmt.Errorf("%w: header: %w", ErrJWTMalformed, err)
+	}
+
+	claimsBytes, err := decode(segments[1])
+	if err != nil {
+		return JWT{}, fmt.Errorf("%w: claims: %w", ErrJWTMalformed, err)
+	}
+	if err := json.Unmarshal(claimsBytes, &t.Claims); err != nil {
+		return JWT{}, fmt.Errorf("%w: claims: %w", ErrJWTMalformed, err)
+	}
+
+	t.Signature, err = decode(segments[2])
+	if err != nil {
+		return JWT{}, fmt.Errorf("%w: signature: %w", ErrJWTMalformed, err)
+	}
+
+	return t, nil
+}
+
+// VerifyJWTSignature checks that the signature of the given JWT has been
+// created by one of the given public keys. RSA (RS*, PS*) and ECDSA (ES*)
+// algorithms are supported. Symmetric algorithms and "none" are rejected
+// with ErrJWTAlgorithm. Returns ErrJWTSignature if no key matches.
+func VerifyJWTSignature(t JWT, keys []crypto.PublicKey) error {
+	alg, _ := t.Header["alg"].(string)
+	if len(alg) != 5 {
+		return fmt.Errorf("%w: %q", ErrJWTAlgorithm, alg)
+	}
+
+	var hash crypto.Hash
+	switch alg[2:] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: %q", ErrJWTAlgorithm, alg)
+	}
+
+	family := alg[:2]
+	if family != "RS" && family != "PS" && family != "ES" {
+		return fmt.Errorf("%w: %q", ErrJWTAlgorithm, alg)
+	}
+
+	hasher := hash.New()
+	hasher.Write([]byte(t.signingInput))
+	digest := hasher.Sum(nil)
+
+	for _, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			if family == "RS" && rsa.VerifyPKCS1v15(k, hash, digest, t.Signature) == nil {
+				return nil
+			}
+			if family == "PS" && rsa.VerifyPSS(k, hash, digest, t.Signature, nil) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			if family != "ES" || len(t.Signature) != 2*size {
+				continue
+			}
+			r := new(big.Int).SetBytes(t.Signature[:size])
+			s := new(big.Int).SetBytes(t.Signature[size:])
+			if ecdsa.Verify(k, digest, r, s) {
+				return nil
+			}
+		}
+	}
+
+	return ErrJWTSignature
+}
+
+// ValidateJWTClaims checks the registered claims "exp" and "nbf" against now
+// and, if not empty, "iss" and "aud" against the given issuer and audience.
+// A leeway of one minute is applied to time based claims.
+//
+// Sentinel errors: ErrJWTExpired, ErrJWTNotYetValid, ErrJWTIssuer,
+// ErrJWTAudience.
+func ValidateJWTClaims(t JWT, issuer string, audience string, now time.Time) error {
+	leeway := time.Minute
+
+	if exp, ok := t.NumericDate("exp"); ok && now.After(exp.Add(leeway)) {
+		return ErrJWTExpired
+	}
+
+	if nbf, ok := t.NumericDate("nbf"); ok && now.Add(leeway).Before(nbf) {
+		return ErrJWTNotYetValid
+	}
+
+	if len(issuer) > 0 && t.Claims["iss"] != issuer {
+		return fmt.Errorf("%w: %v", ErrJWTIssuer, t.Claims["iss"])
+	}
+
+	if len(audience) > 0 && !t.HasAudience(audience) {
+		return fmt.Errorf("%w: %v", ErrJWTAudience, t.Claims["aud"])
+	}
+
+	return nil
+}
+
+// ValidateJWTLifetime checks that the claim "exp" is present and at most
+// maxLifetime ahead of now. If the claim "iat" is present, "exp" must also be
+// at most maxLifetime after it. Unlike ValidateJWTClaims it does not accept
+// JWTs that never expire.
+//
+// Sentinel errors: ErrJWTExpiryMissing, ErrJWTLifetime.
+func ValidateJWTLifetime(t JWT, maxLifetime time.Duration, now time.Time) error {
+	exp, ok := t.NumericDate("exp")
+	if !ok {
+		return ErrJWTExpiryMissing
+	}
+
+	if exp.Sub(now) > maxLifetime {
+		return fmt.Errorf("%w: expires in %v", ErrJWTLifetime, exp.Sub(now))
+	}
+
+	if iat, ok := t.NumericDate("iat"); ok && exp.Sub(iat) > maxLifetime {
+		return fmt.Errorf("%w: issued for %v", ErrJWTLifetime, exp.Sub(iat))
+	}
+
+	return nil
+}
+
+// NumericDate returns the claim with the given name interpreted as seconds
+// since the epoch. The boolean is false if the claim is absent or no number.
+func (t JWT) NumericDate(name string) (time.Time, bool) {
+	v, ok := t.Claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(int64(v), 0), true
+}
+
+// HasAudience checks if the "aud" claim, which can either be a single string
+// or an array of strings, contains the given audience.
+func (t JWT) HasAudience(audience string) bool {
+	switch aud := t.Claims["aud"].(type) {
+	case string:
+		return aud == audience
+	case []any:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ParsePublicKeysPEM parses all PEM encoded public keys in the given data.
+// Blocks in the forms RFC5280 (X.509) and RFC8017 (PKCS #1) are supported.
+// Other blocks are ignored. Returns an error if no key has been found.
+func ParsePublicKeysPEM(data []byte) ([]crypto.PublicKey, error) {
+	var keys []crypto.PublicKey
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		key, err := parsePublicKeyBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrPEMDecode
+	}
+
+	return keys, nil
+}
+
+// ParseJWTKeys parses public keys for verifying JWTs and maps them by key ID,
+// which is matched against the "kid" header of JWTs. The data is either a
+// JSON object that maps key IDs to PEM encoded keys like the one published by
+// Google Cloud IAP, or PEM blocks with a "Kid" header each. Returns an error if
+// no key has been found, a key has no ID, or an ID is used twice.
+func ParseJWTKeys(data []byte) (map[string]crypto.PublicKey, error) {
+	keys := map[string]crypto.PublicKey{}
+
+	add := func(kid string, key crypto.PublicKey) error {
+		if len(kid) == 0 {
+			return ErrJWTKeyIDMissing
+		}
+		if _, ok := keys[kid]; ok {
+			return fmt.Errorf("duplicate JWT key ID: %q", kid)
+		}
+		keys[kid] = key
+		return nil
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		var pems map[string]string
+		if err := json.Unmarshal(data, &pems); err != nil {
+			return nil, fmt.Errorf("failed to parse JWT keys JSON: %w", err)
+		}
+		for kid, p := range pems {
+			parsed, err := ParsePublicKeysPEM([]byte(p))
+			if err != nil {
+				return nil, fmt.Errorf("JWT key %q: %w", kid, err)
+			}
+			if len(parsed) != 1 {
+				return nil, fmt.Errorf("JWT key %q: want exactly one key, got %d", kid, len(parsed))
+			}
+			if err := add(kid, parsed[0]); err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+
+			key, err := parsePublicKeyBlock(block)
+			if err != nil {
+				return nil, err
+			}
+			if key == nil {
+				continue
+			}
+			if err := add(block.Headers["Kid"], key); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, ErrPEMDecode
+	}
+
+	return keys, nil
+}
+
+// parsePublicKeyBlock parses the given PEM block if it contains a public key
+// in the form RFC5280 (X.509) or RFC8017 (PKCS #1). Returns nil without error
+// for other blocks.
+func parsePublicKeyBlock(block *pem.Block) (crypto.PublicKey, error) {
+	var key crypto.PublicKey
+	var err error
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &PublicKeyParseError{err}
+	}
+
+	return key, nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// readTestPrivateKey reads a PKCS #8 PEM encoded private key from testdata.
+func readTestPrivateKey(t *testing.T, name string) crypto.Signer {
+	t.Helper()
+
+	data, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, _ := pem.Decode(data)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key.(crypto.Signer)
+}
+
+// signTestJWT creates a compact serialized JWT signed with RS256 or ES256.
+func signTestJWT(t *testing.T, key crypto.Signer, claims map[string]any) string {
+	t.Helper()
+
+	return signTestJWTWithKeyID(t, key, "", claims)
+}
+
+// signTestJWTWithKeyID works like signTestJWT and additionally sets the "kid"
+// header unless kid is empty.
+func signTestJWTWithKeyID(t *testing.T, key crypto.Signer, kid string, claims map[string]any) string {
+	t.Helper()
+
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+
+	header := map[string]any{"alg": alg, "typ": "JWT"}
+	if len(kid) > 0 {
+		header["kid"] = kid
+	}
+
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimsBytes, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerBytes) + "." +
+		base64.RawURLEncoding.EncodeToString(claimsBytes)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = append(
+			r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...,
+		)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestParseJWT(t *testing.T) {
+	key := readTestPrivateKey(t, "a-private-key-rsa2048-rfc5958-pksc8.pem")
+	raw := signTestJWT(t, key, map[string]any{"sub": "alice", "exp": 42})
+
+	token, err := ParseJWT(raw)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if token.Header["alg"] != "RS256" {
+		t.Errorf("Wrong alg: got %v, want RS256", token.Header["alg"])
+	}
+	if token.Claims["sub"] != "alice" {
+		t.Errorf("Wrong sub: got %v, want alice", token.Claims["sub"])
+	}
+	if exp, ok := token.NumericDate("exp"); !ok || exp.Unix() != 42 {
+		t.Errorf("Wrong exp: got %v, want 42", exp.Unix())
+	}
+
+	for _, malformed := range []string{"", "a.b", "a.b.c", "e30.e30.!!!"} {
+		_, err := ParseJWT(malformed)
+		if !errors.Is(err, ErrJWTMalformed) {
+			t.Errorf("Wrong error for %q: got %v, want ErrJWTMalformed", malformed, err)
+		}
+	}
+}
+
+func TestVerifyJWTSignature(t *testing.T) {
+	aPrivate := readTestPrivateKey(t, "a-private-key-rsa2048-rfc5958-pksc8.pem")
+	bPrivate := readTestPrivateKey(t, "b-private-key-ecdsa-prime256v1-rfc5958-pksc8.pem")
+	cPrivate := readTestPrivateKey(t, "c-private-key-rsa1024-rfc5958-pksc8.pem")
+
+	for _, tc := range []struct {
+		name        string
+		signer      crypto.Signer
+		keys        []crypto.PublicKey
+		expectedErr error
+	}{{
+		name:        "1_rsa",
+		signer:      aPrivate,
+		keys:        []crypto.PublicKey{aPrivate.Public()},
+		expectedErr: nil,
+	}, {
+		name:        "2_ecdsa",
+		signer:      bPrivate,
+		keys:        []crypto.PublicKey{aPrivate.Public(), bPrivate.Public()},
+		expectedErr: nil,
+	}, {
+		name:        "3_wrong_key",
+		signer:      cPrivate,
+		keys:        []crypto.PublicKey{aPrivate.Public(), bPrivate.Public()},
+		expectedErr: ErrJWTSignature,
+	}, {
+		name:        "4_no_keys",
+		signer:      aPrivate,
+		keys:        nil,
+		expectedErr: ErrJWTSignature,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			token, err := ParseJWT(signTestJWT(t, tc.signer, map[string]any{}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = VerifyJWTSignature(token, tc.keys)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("Wrong error: got %v, want %v", err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTSignature_Algorithm(t *testing.T) {
+	for _, alg := range []string{"none", "HS256", "RS1", ""} {
+		token := JWT{Header: map[string]any{"alg": alg}}
+		err := VerifyJWTSignature(token, nil)
+		if !errors.Is(err, ErrJWTAlgorithm) {
+			t.Errorf("Wrong error for %q: got %v, want ErrJWTAlgorithm", alg, err)
+		}
+	}
+}
+
+func TestValidateJWTClaims(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+
+	for _, tc := range []struct {
+		name        string
+		claims      map[string]any
+		issuer      string
+		audience    string
+		expectedErr error
+	}{{
+		name:        "1_empty",
+		claims:      map[string]any{},
+		expectedErr: nil,
+	}, {
+		name:        "2_expired",
+		claims:      map[string]any{"exp": float64(1_000_000 - 3600)},
+		expectedErr: ErrJWTExpired,
+	}, {
+		name:        "3_leeway",
+		claims:      map[string]any{"exp": float64(1_000_000 - 30)},
+		expectedErr: nil,
+	}, {
+		name:        "4_not_yet_valid",
+		claims:      map[string]any{"nbf": float64(1_000_000 + 3600)},
+		expectedErr: ErrJWTNotYetValid,
+	}, {
+		name:        "5_issuer",
+		claims:      map[string]any{"iss": "https://a"},
+		issuer:      "https://b",
+		expectedErr: ErrJWTIssuer,
+	}, {
+		name:        "6_audience_string",
+		claims:      map[string]any{"aud": "x"},
+		audience:    "x",
+		expectedErr: nil,
+	}, {
+		name:        "7_audience_array",
+		claims:      map[string]any{"aud": []any{"y", "x"}},
+		audience:    "x",
+		expectedErr: nil,
+	}, {
+		name:        "8_audience_wrong",
+		claims:      map[string]any{"aud": []any{"y"}},
+		audience:    "x",
+		expectedErr: ErrJWTAudience,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateJWTClaims(JWT{Claims: tc.claims}, tc.issuer, tc.audience, now)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("Wrong error: got %v, want %v", err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestValidateJWTLifetime(t *testing.T) {
+	now := time.Unix(1_000_000, 0)
+
+	for _, tc := range []struct {
+		name        string
+		claims      map[string]any
+		expectedErr error
+	}{{
+		name:        "1_missing",
+		claims:      map[string]any{},
+		expectedErr: ErrJWTExpiryMissing,
+	}, {
+		name:        "2_within",
+		claims:      map[string]any{"iat": float64(1_000_000 - 60), "exp": float64(1_000_000 + 540)},
+		expectedErr: nil,
+	}, {
+		name:        "3_exp_too_far",
+		claims:      map[string]any{"exp": float64(1_000_000 + 3600)},
+		expectedErr: ErrJWTLifetime,
+	}, {
+		name:        "4_issued_for_too_long",
+		claims:      map[string]any{"iat": float64(1_000_000 - 3600), "exp": float64(1_000_000 + 60)},
+		expectedErr: ErrJWTLifetime,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateJWTLifetime(JWT{Claims: tc.claims}, 10*time.Minute, now)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("Wrong error: got %v, want %v", err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestParsePublicKeysPEM(t *testing.T) {
+	var data []byte
+	for _, name := range []string{
+		"a-public-key-rsa2048-rfc5280-x509.pem",
+		"a-public-key-rsa2048-rfc8017-pksc1.pem",
+		"b-public-key-ecdsa-prime256v1-rfc5280-x509.pem",
+		"a-private-key-rsa2048-rfc5958-pksc8.pem",
+	} {
+		b, err := os.ReadFile("testdata/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, b...)
+	}
+
+	keys, err := ParsePublicKeysPEM(data)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(keys) != 3 {
+		t.Errorf("Wrong number of keys: got %v, want 3", len(keys))
+	}
+	if _, ok := keys[2].(*ecdsa.PublicKey); !ok {
+		t.Errorf("Wrong key type: got %T, want *ecdsa.PublicKey", keys[2])
+	}
+
+	_, err = ParsePublicKeysPEM([]byte("nothing"))
+	if !errors.Is(err, ErrPEMDecode) {
+		t.Errorf("Wrong error: got %v, want ErrPEMDecode", err)
+	}
+}
+
+func TestParseJWTKeys(t *testing.T) {
+	aPEM, err := os.ReadFile("testdata/a-public-key-rsa2048-rfc5280-x509.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bPEM, err := os.ReadFile("testdata/b-public-key-ecdsa-prime256v1-rfc5280-x509-kid.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsonKeys, err := json.Marshal(map[string]string{"a": string(aPEM), "b": string(bPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		data         []byte
+		expectedKIDs []string
+		expectedErr  error
+	}{{
+		name:         "1_pem",
+		data:         bPEM,
+		expectedKIDs: []string{"b"},
+	}, {
+		name:         "2_json",
+		data:         jsonKeys,
+		expectedKIDs: []string{"a", "b"},
+	}, {
+		name:        "3_pem_without_kid",
+		data:        append(bPEM, aPEM...),
+		expectedErr: ErrJWTKeyIDMissing,
+	}, {
+		name:        "4_duplicate_kid",
+		data:        append(bPEM, bPEM...),
+		expectedErr: nil,
+	}, {
+		name:        "5_nothing",
+		data:        []byte("nothing"),
+		expectedErr: ErrPEMDecode,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			keys, err := ParseJWTKeys(tc.data)
+			if tc.expectedKIDs == nil {
+				if err == nil {
+					t.Fatal("Unexpected success: got nil, want error")
+				}
+				if tc.expectedErr != nil && !errors.Is(err, tc.expectedErr) {
+					t.Errorf("Wrong error: got %v, want %v", err, tc.expectedErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(keys) != len(tc.expectedKIDs) {
+				t.Errorf("Wrong number of keys: got %v, want %v", len(keys), len(tc.expectedKIDs))
+			}
+			for _, kid := range tc.expectedKIDs {
+				if keys[kid] == nil {
+					t.Errorf("Didn't find key: want %q", kid)
+				}
+			}
+		})
+	}
+}
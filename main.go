@@ -2,15 +2,19 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"embed"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"html/template"
+	"io"
 	"io/fs"
+	"log"
 	"net/http"
+	"net/netip"
 	"net/url"
-	"strings"
+	"os"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -22,102 +26,514 @@ var version = ""
 //go:embed all:static
 //go:embed all:swagger-ui
 //go:embed all:template
+//go:embed all:locale
 var content embed.FS
 
 func main() {
 	fmt.Println("token2go-server", version) //nolint
 
-	c := NewConfig()
+	var configFile *EnvFile
+	if path := GetEnv("CONFIG_FILE", ""); len(path) > 0 {
+		configFile = NewEnvFile(path)
+	}
+
+	reloader, err := NewReloader(configFile)
+	if err != nil {
+		panic(err)
+	}
+
+	c := reloader.Config()
+	go reloader.Watch(time.Duration(c.configFilePollInterval) * time.Second)
 
 	server := &http.Server{
 		Addr:              ":" + c.serverPort,
 		ReadHeaderTimeout: 3 * time.Second,
-		Handler: initRouter(
-			c.fallbackToken,
-			c.tokenHeaderNames,
-			c.addTokenHeaderNames,
-			NewIndexTmplData(
-				c.uiTarget,
-				c.uiTitle,
-				c.uiDesc1,
-				c.uiDesc2,
-				c.uiMisc,
-			),
-		),
+		Handler:           reloader,
 	}
 
-	err := server.ListenAndServe()
+	err = server.ListenAndServe()
 	if err != nil {
 		panic(err)
 	}
 }
 
-func initRouter(
-	fallbackToken string,
-	tokenHeaderNames []string,
-	addTokenHeaderNames []string,
-	itd IndexTmplData,
-) chi.Router {
+// RouterArgs represents the arguments for the initRouter function. Use the
+// function NewRouterArgs to construct it from a Config.
+type RouterArgs struct {
+	basePath               string
+	forwardedPrefixEnabled bool
+
+	trustedProxies         []netip.Prefix
+	ipRateLimiter          *RateLimiter
+	fingerprintRateLimiter *RateLimiter
+
+	contentSecurityPolicy string
+	hstsMaxAge            int
+	cors                  CORS
+
+	tokenPipeline  TokenPipeline
+	tokenRefresher *TokenRefresher
+	tokenWrapper   *TokenWrapper
+
+	redirectTargets   []string
+	redirectConfirmer *RedirectConfirmer
+
+	streamSettings StreamSettings
+	streamRegistry *StreamRegistry
+
+	gatewayVerifier GatewayVerifier
+
+	downloads      Downloads
+	downloadValues map[string]string
+
+	echoEnabled           bool
+	echoRedactHeaderNames []string
+	echoRedactQueryNames  []string
+	echoAdminToken        string
+
+	uiTemplates *template.Template
+	uiStatic    fs.FS
+	itd         LocalizedIndexTmplData
+	uiSnippets  Snippets
+}
+
+// NewRouterArgs translates the given Config into RouterArgs. Stateful
+// components are taken from the given RouterState. Returns an error if
+// referenced resources like files cannot be loaded.
+func NewRouterArgs(c Config, state *RouterState) (RouterArgs, error) {
+	var gatewayVerifier GatewayVerifier
+	echoRedactHeaderNames := c.echoRedactHeaderNames
+
+	if c.gatewayProof != "none" {
+		var err error
+		gatewayVerifier, err = NewGatewayVerifier(c)
+		if err != nil {
+			return RouterArgs{}, err
+		}
+		echoRedactHeaderNames = append(
+			echoRedactHeaderNames, gatewayVerifier.HeaderName(),
+		)
+	}
+
+	tokenSources, err := NewTokenSourceChain(c.tokenSourceSpecs)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+
+	tokenProfiles, err := NewTokenProfiles(tokenSources, c.tokenProfileSpecs, c.tokenProfileTypes)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+
+	var tokenExchanger *TokenExchanger
+	if len(c.tokenExchangeURL) > 0 {
+		tokenExchanger = NewTokenExchanger(
+			NewOAuth2Client(
+				c.tokenExchangeURL,
+				c.tokenExchangeClientID,
+				c.tokenExchangeClientSecret,
+			),
+			c.tokenExchangeSubjectTokenType,
+			c.tokenExchangeAudiences,
+			c.tokenExchangeScopes,
+		)
+	}
+
+	var tokenIntrospector *TokenIntrospector
+	if len(c.introspectionURL) > 0 {
+		tokenIntrospector = state.TokenIntrospector(
+			NewOAuth2Client(
+				c.introspectionURL,
+				c.introspectionClientID,
+				c.introspectionClientSecret,
+			),
+			c.introspectionTokenTypeHint,
+		)
+	}
+
+	var tokenRefresher *TokenRefresher
+	if len(c.tokenRefreshURL) > 0 {
+		if _, err := tokenProfiles.Get("refresh"); err == nil {
+			return RouterArgs{}, fmt.Errorf(
+				"token profile \"refresh\" conflicts with token refresh endpoint",
+			)
+		}
+		refreshTokenSources, err := NewTokenSourceChain(c.refreshTokenSourceSpecs)
+		if err != nil {
+			return RouterArgs{}, fmt.Errorf("refresh %w", err)
+		}
+		refresher := NewTokenRefresher(
+			NewOAuth2Client(
+				c.tokenRefreshURL,
+				c.tokenRefreshClientID,
+				c.tokenRefreshClientSecret,
+			),
+			refreshTokenSources,
+		)
+		err = refresher.CheckProfiles(tokenProfiles)
+		if err != nil {
+			return RouterArgs{}, err
+		}
+		tokenRefresher = &refresher
+	}
+
+	var tokenWrapper *TokenWrapper
+	if c.wrapEnabled {
+		tokenWrapper = NewTokenWrapper(
+			state.WrapStore(c.tenant, c.wrapMaxEntries),
+			time.Duration(c.wrapTTL)*time.Second,
+		)
+	}
+
+	var streamRegistry *StreamRegistry
+	streamSettings := StreamSettings{
+		RenewBefore:            time.Duration(c.streamRenewBefore) * time.Second,
+		RenewInterval:          time.Duration(c.streamRenewInterval) * time.Second,
+		HeartbeatInterval:      time.Duration(c.streamHeartbeatInterval) * time.Second,
+		MaxConnections:         c.streamMaxConnections,
+		MaxConnectionsPerToken: c.streamMaxConnectionsPerToken,
+	}
+	if c.streamEnabled {
+		streamRegistry = state.StreamRegistry(streamSettings)
+	}
+
+	templateContent, err := fs.Sub(content, "template")
+	if err != nil {
+		return RouterArgs{}, fmt.Errorf("failed to access embedded templates: %w", err)
+	}
+	downloads, err := NewDownloads(
+		templateContent, c.downloadSpecs, c.downloadValues, tokenProfiles,
+	)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+
+	catalogs, err := NewCatalogs(c.uiLocaleDir, c.uiDefaultLocale)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+	itd, err := NewLocalizedIndexTmplData(catalogs, c.uiDefaultLocale, UIStrings{
+		Target: c.uiTarget,
+		Title:  c.uiTitle,
+		Desc1:  c.uiDesc1,
+		Desc2:  c.uiDesc2,
+		Misc:   c.uiMisc,
+	}, c.uiLocalized)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+	itd.SetProfiles(tokenProfiles.Names())
+	itd.SetExpiry(c.uiExpiryWarning, c.uiExpiryReload)
+
+	uiTemplateContent, err := NewUIContent("template", c.uiTemplateDir)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+	uiTemplates, err := NewUITemplates(uiTemplateContent)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+	for _, locale := range sortedKeys(itd.All()) {
+		err = uiTemplates.Execute(io.Discard, itd.All()[locale])
+		if err == nil {
+			err = uiTemplates.ExecuteTemplate(io.Discard, UIConfirmTemplate, ConfirmTmplData{
+				Lang: locale,
+				T:    itd.All()[locale].T,
+			})
+		}
+		if err != nil {
+			return RouterArgs{}, fmt.Errorf("failed to render UI templates for %q: %w", locale, err)
+		}
+	}
+
+	confirmationKey, err := state.ConfirmationKey()
+	if err != nil {
+		return RouterArgs{}, err
+	}
+	redirectConfirmer, err := NewRedirectConfirmer(
+		c.redirectConfirmation, c.redirectTargets, tokenProfiles.Default(), confirmationKey,
+	)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+
+	uiStatic, err := NewUIContent("static", c.uiStaticDir)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+
+	uiSnippetContent, err := NewUIContent("template/snippet", c.uiSnippetDir)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+	uiSnippets, err := NewSnippets(uiSnippetContent, c.uiSnippets, c.uiAPIBase)
+	if err != nil {
+		return RouterArgs{}, err
+	}
+
+	return RouterArgs{
+		basePath:               c.basePath,
+		forwardedPrefixEnabled: c.forwardedPrefixEnabled,
+
+		trustedProxies:         c.trustedProxies,
+		ipRateLimiter:          state.RateLimiter("ip", c.rateLimitIP),
+		fingerprintRateLimiter: state.RateLimiter("fingerprint", c.rateLimitFingerprint),
+
+		contentSecurityPolicy: c.contentSecurityPolicy,
+		hstsMaxAge:            c.hstsMaxAge,
+		cors: CORS{
+			Origins:        c.corsOrigins,
+			Routes:         c.corsRoutes,
+			AllowedHeaders: c.corsAllowedHeaders,
+			MaxAge:         c.corsMaxAge,
+		},
+
+		tokenPipeline: TokenPipeline{
+			profiles:     tokenProfiles,
+			introspector: tokenIntrospector,
+			exchanger:    tokenExchanger,
+
+			notFoundStatus: c.tokenNotFoundStatus,
+		},
+		tokenRefresher: tokenRefresher,
+		tokenWrapper:   tokenWrapper,
+
+		redirectTargets:   c.redirectTargets,
+		redirectConfirmer: redirectConfirmer,
+
+		streamSettings: streamSettings,
+		streamRegistry: streamRegistry,
+
+		gatewayVerifier: gatewayVerifier,
+
+		downloads:      downloads,
+		downloadValues: c.downloadValues,
+
+		echoEnabled:           c.echoEnabled,
+		echoRedactHeaderNames: echoRedactHeaderNames,
+		echoRedactQueryNames:  c.echoRedactQueryNames,
+		echoAdminToken:        c.echoAdminToken,
+
+		uiTemplates: uiTemplates,
+		uiStatic:    uiStatic,
+		itd:         itd,
+		uiSnippets:  uiSnippets,
+	}, nil
+}
+
+// NewGatewayVerifier constructs the GatewayVerifier selected in the given
+// Config. Returns an error if the selected gateway proof is unknown.
+func NewGatewayVerifier(c Config) (GatewayVerifier, error) {
+	switch c.gatewayProof {
+	case "secret":
+		return NewSharedSecretGatewayVerifier(
+			c.gatewayProofHeaderName, c.gatewaySecret,
+		), nil
+	case "hmac":
+		return NewHMACGatewayVerifier(
+			c.gatewayProofHeaderName, c.gatewaySecret, c.gatewayHMACHeaderNames,
+			c.gatewayHMACTimestampHeaderName, time.Duration(c.gatewayHMACMaxSkew)*time.Second,
+		), nil
+	case "jwt":
+		data, err := os.ReadFile(c.gatewayJWTKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read gateway JWT keys file: %w", err)
+		}
+		keys, err := ParseJWTKeys(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse gateway JWT keys file: %w", err)
+		}
+		return NewJWTGatewayVerifier(
+			c.gatewayProofHeaderName, keys, c.gatewayJWTIssuer, c.gatewayJWTAudience,
+			time.Duration(c.gatewayJWTMaxLifetime)*time.Second,
+		), nil
+	default:
+		return nil, fmt.Errorf("unknown gateway proof: %q", c.gatewayProof)
+	}
+}
+
+func initRouter(a RouterArgs) chi.Router {
 	r := chi.NewRouter()
 
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Logger)
+	r.Use(MakeClientMiddleware(a.trustedProxies))
+	r.Use(middleware.RequestLogger(ClientLogFormatter{&middleware.DefaultLogFormatter{
+		Logger: log.New(os.Stdout, "", log.LstdFlags),
+	}}))
+	r.Use(MakeSecurityHeadersMiddleware(a.contentSecurityPolicy, a.hstsMaxAge))
+	r.Use(MakeBasePathMiddleware(a.basePath, a.forwardedPrefixEnabled))
+	r.Use(MakeCORSMiddleware(a.cors))
 
 	ServeTmpl(ServeTmplArgs{
 		router:   r,
 		patterns: []string{"/", "/index.html"},
-		file:     "index.html",
-		data:     itd,
+		tmpl:     a.uiTemplates,
+		data:     a.itd,
+		snippets: a.uiSnippets,
 	})
 
 	ServeSwaggerUI(r)
 
-	ServeStatic(r)
+	ServeSwaggerSpec(r, a.uiStatic)
+
+	ServeStatic(r, a.uiStatic)
 
 	r.Group(func(r chi.Router) {
 		r.Use(middleware.NoCache)
-		r.Get("/echo", GetEchoHandler)
 		r.Get("/health", GetHealthHandler)
-		r.Get("/token", MakeGetTokenHandler(
-			append(tokenHeaderNames, addTokenHeaderNames...),
-			fallbackToken,
-		))
-		r.Get("/flow/redirect/token", MakeGetTokenRedirectFlowHandler(
-			append(tokenHeaderNames, addTokenHeaderNames...),
-			fallbackToken,
-		))
 	})
 
-	return r
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.NoCache)
+		if a.gatewayVerifier != nil {
+			r.Use(MakeGatewayProofMiddleware(a.gatewayVerifier))
+		}
+		if a.ipRateLimiter != nil || a.fingerprintRateLimiter != nil {
+			var sources TokenSourceChain
+			if a.fingerprintRateLimiter != nil {
+				sources = a.tokenPipeline.profiles.Default().RequestSources()
+			}
+			r.Use(MakeRateLimitMiddleware(a.ipRateLimiter, a.fingerprintRateLimiter, sources))
+		}
+		if a.echoEnabled {
+			r.Get("/echo", MakeGetEchoHandler(
+				a.echoRedactHeaderNames,
+				a.echoRedactQueryNames,
+				a.echoAdminToken,
+			))
+		}
+		r.Get("/token", MakeGetTokenHandler(a.tokenPipeline))
+		r.Get("/token/{name}", MakeGetTokenHandler(a.tokenPipeline))
+		if a.tokenRefresher != nil {
+			r.Get("/token/refresh", MakeGetTokenRefreshHandler(
+				*a.tokenRefresher, a.tokenPipeline,
+			))
+		}
+		if a.tokenWrapper != nil {
+			r.Post("/wrap", MakePostWrapHandler(a.tokenWrapper, a.tokenPipeline))
+			r.Post("/wrap/{name}", MakePostWrapHandler(a.tokenWrapper, a.tokenPipeline))
+			r.Post("/unwrap", MakePostUnwrapHandler(a.tokenWrapper))
+		}
+		if len(a.downloads) > 0 {
+			r.Get("/download/{kind}", MakeGetDownloadHandler(
+				a.downloads, a.downloadValues, a.tokenPipeline,
+			))
+		}
+		redirectFlow := MakeGetTokenRedirectFlowHandler(a.tokenPipeline, a.redirectTargets)
+		if a.redirectConfirmer != nil {
+			r := r.With(MakeRedirectConfirmationMiddleware(a.redirectConfirmer, a.uiTemplates, a.itd))
+			r.Get("/flow/redirect/token", redirectFlow)
+			r.Post("/flow/redirect/token", redirectFlow)
+		} else {
+			r.Get("/flow/redirect/token", redirectFlow)
+		}
+		if a.streamRegistry != nil {
+			r.Get("/flow/stream/token", MakeGetTokenStreamFlowHandler(
+				a.tokenPipeline, a.tokenRefresher, a.streamRegistry, a.streamSettings,
+			))
+		}
+	})
+
+	return MountBasePath(r, a.basePath)
 }
 
-// Echo is the representation of the GetEchoHandler's body.
+// Echo is the representation of the echo handler's body.
 type Echo struct {
 	Parameters url.Values  `json:"parameters"`
 	Headers    http.Header `json:"headers"`
 	RemoteAddr string      `json:"remoteAddr"`
+	ClientIP   string      `json:"clientIp"`
 }
 
-// GetEchoHandler writes a response with all headers, parameters, and other data
-// from from the request encoded as non-pretty JSON in the body.
-func GetEchoHandler(w http.ResponseWriter, r *http.Request) {
-	jsonEncoder := json.NewEncoder(w)
+// EchoAdminTokenHeaderName is the name of the header that must contain the
+// admin token if the echo handler is configured to require one.
+const EchoAdminTokenHeaderName = "X-Token2go-Admin-Token"
 
-	if r.URL.Query().Has("pretty") {
-		jsonEncoder.SetIndent("", "  ")
+// EchoRedacted replaces the values of redacted headers and query parameters in
+// the echo.
+const EchoRedacted = "REDACTED"
+
+// MakeGetEchoHandler returns a handler that writes a response with all headers,
+// parameters, and other data from the request encoded as non-pretty JSON in the
+// body.
+//
+// Values of headers listed in redactHeaderNames are replaced. Matching is case
+// insensitive. Values of query parameters listed in redactQueryNames are
+// replaced as well. Their names are matched exactly. If adminToken is not an
+// empty string, the request must contain it in the header
+// EchoAdminTokenHeaderName. Otherwise a client error response will be written.
+func MakeGetEchoHandler(
+	redactHeaderNames []string,
+	redactQueryNames []string,
+	adminToken string,
+) http.HandlerFunc {
+	redact := map[string]bool{
+		EchoAdminTokenHeaderName: true,
+	}
+	for _, name := range redactHeaderNames {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+	redactQuery := map[string]bool{}
+	for _, name := range redactQueryNames {
+		redactQuery[name] = true
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	err := jsonEncoder.Encode(Echo{
-		r.URL.Query(),
-		r.Header,
-		r.RemoteAddr,
-	})
-	if err != nil {
-		panic(err)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(adminToken) > 0 && subtle.ConstantTimeCompare(
+			[]byte(r.Header.Get(EchoAdminTokenHeaderName)),
+			[]byte(adminToken),
+		) != 1 {
+			msg := "Unauthorized. Missing or invalid admin token in header: "
+			WriteProblem(w, r, http.StatusUnauthorized, "AdminTokenInvalid",
+				msg+EchoAdminTokenHeaderName)
+			return
+		}
+
+		headers := make(http.Header, len(r.Header))
+		for name, values := range r.Header {
+			if redact[http.CanonicalHeaderKey(name)] {
+				values = redactValues(values)
+			}
+			headers[name] = values
+		}
+
+		parameters := r.URL.Query()
+		for name, values := range parameters {
+			if redactQuery[name] {
+				parameters[name] = redactValues(values)
+			}
+		}
+
+		jsonEncoder := json.NewEncoder(w)
+
+		if parameters.Has("pretty") {
+			jsonEncoder.SetIndent("", "  ")
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		err := jsonEncoder.Encode(Echo{
+			parameters,
+			headers,
+			r.RemoteAddr,
+			ClientIP(r),
+		})
+		if err != nil {
+			panic(err)
+		}
 	}
 }
 
+// redactValues returns a slice of the same length with every value replaced by
+// EchoRedacted.
+func redactValues(values []string) []string {
+	redacted := make([]string, len(values))
+	for i := range values {
+		redacted[i] = EchoRedacted
+	}
+
+	return redacted
+}
+
 // GetHealthHandler informs about the health of the Token2go server. Currently
 // this handler always writes a JSON response and status code 200.
 func GetHealthHandler(w http.ResponseWriter, r *http.Request) {
@@ -131,42 +547,134 @@ func GetHealthHandler(w http.ResponseWriter, r *http.Request) {
 // MakeGetTokenHandler returns a handler that extracts a token from the request
 // and returns the token including metadata encoded as non-pretty JSON.
 //
-// Handler will only look for given token header names. If the fallback token
-// is an empty string and no token has been found, a client error response
-// will be written.
-func MakeGetTokenHandler(
-	tokenHeaderNames []string,
-	fallbackToken string,
+// The profile is taken from the URL parameter "name" and defaults to the
+// DefaultTokenProfile. If the query parameter "all" is present, a TokenBundle
+// with the tokens of all profiles is returned instead. Handler will only look
+// at the sources of the given profiles. If no source offers a token, a client
+// error response will be written.
+//
+// Tokens go through the given TokenPipeline. If the query parameters
+// "audience" or "scope" are set, the token is exchanged before it is returned.
+// Token bundles can't be exchanged.
+//
+// The output format is negotiated with NegotiateTokenFormat. See TokenFormats
+// for the supported formats.
+func MakeGetTokenHandler(tokenPipeline TokenPipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Has("all") {
+			bundle, ok := tokenPipeline.ExtractBundle(w, r, nil)
+			if !ok {
+				return
+			}
+			WriteTokenBundle(w, r, bundle)
+			return
+		}
+
+		name := chi.URLParam(r, "name")
+		if len(name) == 0 {
+			name = DefaultTokenProfile
+		}
+
+		token, ok := tokenPipeline.ExtractToken(w, r, name)
+		if !ok {
+			return
+		}
+		WriteToken(w, r, name, token)
+	}
+}
+
+// MakeGetTokenRefreshHandler returns a handler that redeems the refresh token
+// found in the request for a fresh access token and returns it including
+// metadata encoded as non-pretty JSON. The refresh token itself is never part
+// of the response. The fresh token is introspected and can be exchanged with
+// the given TokenPipeline and formatted like with MakeGetTokenHandler.
+func MakeGetTokenRefreshHandler(
+	tokenRefresher TokenRefresher,
+	tokenPipeline TokenPipeline,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		token, err := ExtractToken(r.Header, tokenHeaderNames, fallbackToken)
-		if err != nil {
-			msg := "Token not found. Looking for: "
-			http.Error(w, msg+strings.Join(tokenHeaderNames, ", "), 444)
+		token, err := tokenRefresher.Refresh(r)
+		if !IsSucceededRefreshToken(w, r,
+			tokenPipeline.NotFoundStatus(), tokenRefresher.Names(), err,
+		) {
 			return
 		}
 
-		tokenJSON, err := json.Marshal(token)
-		if err != nil {
-			msg := "Internal Server Error. Marshalling failed."
-			http.Error(w, msg, http.StatusInternalServerError)
+		token, ok := tokenPipeline.Process(w, r, token)
+		if !ok {
+			return
+		}
+		WriteToken(w, r, DefaultTokenProfile, token)
+	}
+}
+
+// MakePostWrapHandler returns a handler that extracts the token of the profile
+// given by the URL parameter "name" like MakeGetTokenHandler and wraps it with
+// the given TokenWrapper. Only the TokenWrapping is written to the response,
+// encoded as non-pretty JSON.
+func MakePostWrapHandler(tokenWrapper *TokenWrapper, tokenPipeline TokenPipeline) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+		if len(name) == 0 {
+			name = DefaultTokenProfile
+		}
+
+		token, ok := tokenPipeline.ExtractToken(w, r, name)
+		if !ok {
+			return
+		}
+
+		wrapping, err := tokenWrapper.Wrap(r.Context(), name, token)
+		if !IsSucceededWrapToken(w, r, err) {
 			return
 		}
 
 		w.Header().Set("Content-Type", "application/json")
-		_, err = w.Write(tokenJSON)
+		err = json.NewEncoder(w).Encode(wrapping)
 		if err != nil {
 			panic(err)
 		}
 	}
 }
 
+// MakePostUnwrapHandler returns a handler that unwraps the token wrapped under
+// the wrapping ID in the form field "wrappingId" with the given TokenWrapper.
+// The request needs no token of its own. The token is written like with
+// MakeGetTokenHandler and can't be unwrapped again. The wrapping ID is read
+// from the body, so it does not end up in access logs.
+func MakePostUnwrapHandler(tokenWrapper *TokenWrapper) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PostFormValue("wrappingId")
+		if len(id) == 0 {
+			msg := "Bad Request. Missing form field: wrappingId"
+			WriteProblem(w, r, http.StatusBadRequest, "MissingFormField", msg)
+			return
+		}
+
+		wrapped, err := tokenWrapper.Unwrap(r.Context(), id)
+		if !IsSucceededWrapToken(w, r, err) {
+			return
+		}
+
+		WriteToken(w, r, wrapped.Profile, wrapped.Token)
+	}
+}
+
 // MakeGetTokenRedirectFlowHandler returns a handler for the token redirect
 // flow. This handler extracts the token from the request and attaches it to
 // the redirect URL as an encrypted payload.
+//
+// If the query parameter "tokens" contains a comma separated list of profile
+// names, the payload is a TokenBundle with a token for every listed profile.
+// Tokens go through the given TokenPipeline like with MakeGetTokenHandler.
+//
+// The query parameter "target" must match one of redirectTargets with
+// MatchRedirectTarget. All targets are allowed if redirectTargets is empty.
+// Requests approved with POST on the confirmation page of
+// MakeRedirectConfirmationMiddleware are redirected with status code 303.
 func MakeGetTokenRedirectFlowHandler(
-	tokenHeaderNames []string,
-	fallbackToken string,
+	tokenPipeline TokenPipeline,
+	redirectTargets []string,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		queryParams := r.URL.Query()
@@ -176,54 +684,71 @@ func MakeGetTokenRedirectFlowHandler(
 		state := queryParams.Get("state")
 		publicKeyType := queryParams.Get("publicKeyType")
 		publicKey := []byte(queryParams.Get("publicKey"))
+		tokens := SplitToSlice(queryParams.Get("tokens"))
 
 		// Ensure required query parameters are set.
-		if !IsRequiredQueryParamSet(w, queryParams,
+		if !IsRequiredQueryParamSet(w, r,
 			"target", "state", "publicKeyType", "publicKey",
 		) {
 			return
 		}
 
 		// Ensure query parameter values are allowed.
-		if !IsQueryParamValueAllowed(w, "publicKeyType", publicKeyType,
+		if !IsRedirectTargetAllowed(w, r, target, redirectTargets) {
+			return
+		}
+		if !IsQueryParamValueAllowed(w, r, "publicKeyType", publicKeyType,
 			"rsa2048-rfc5280-x509-pem", "rsa2048-rfc8017-pksc1-pem",
 		) {
 			return
 		}
+		for _, name := range tokens {
+			if !IsQueryParamValueAllowed(w, r, "tokens", name,
+				tokenPipeline.profiles.Names()...,
+			) {
+				return
+			}
+		}
 
 		// Generate key for AES encryption of payload.
 		payloadKey, err := GenRandBytes(32)
 		if err != nil {
 			msg := "Internal Server Error. Secure random number generator failure."
-			http.Error(w, msg, http.StatusInternalServerError)
+			WriteProblem(w, r, http.StatusInternalServerError, "RandomGeneratorFailure", msg)
 			return
 		}
 
 		// Encrypt payload key with public key.
 		encryptedPayloadKey, err := EncryptWithRSA(publicKey, payloadKey)
-		if !IsSucceededEncryptWithRSA(w, err) {
+		if !IsSucceededEncryptWithRSA(w, r, err) {
 			return
 		}
 
-		// Build JSON payload containing token.
-		token, err := ExtractToken(r.Header, tokenHeaderNames, fallbackToken)
-		if err != nil {
-			msg := "Token not found. Looking for: "
-			http.Error(w, msg+strings.Join(tokenHeaderNames, ", "), 444)
-			return
+		// Build JSON payload containing token or token bundle.
+		var result any
+		if len(tokens) > 0 {
+			bundle, ok := tokenPipeline.ExtractBundle(w, r, tokens)
+			if !ok {
+				return
+			}
+			result = bundle
+		} else {
+			token, ok := tokenPipeline.ExtractToken(w, r, DefaultTokenProfile)
+			if !ok {
+				return
+			}
+			result = token
 		}
-		payload, err := json.Marshal(token)
+		payload, err := json.Marshal(result)
 		if err != nil {
 			msg := "Internal Server Error. Marshalling failed."
-			http.Error(w, msg, http.StatusInternalServerError)
+			WriteProblem(w, r, http.StatusInternalServerError, "MarshallingFailed", msg)
 			return
 		}
 
 		// Encrypt payload with AES-GCM.
 		encryptedPayload, nonce, err := EncryptWithAES(payloadKey, payload)
-		if err != nil {
-			msg := "Internal Server Error. Payload encryption failed."
-			http.Error(w, msg, http.StatusInternalServerError)
+		if !IsSucceededEncryptWithAES(w, r, err) {
 			return
 		}
 
@@ -234,7 +759,11 @@ func MakeGetTokenRedirectFlowHandler(
 			"nonce":   {base64.StdEncoding.EncodeToString(nonce)},
 			"state":   {state},
 		}.Encode())
-		http.Redirect(w, r, redirectUrl, http.StatusMovedPermanently)
+		status := http.StatusMovedPermanently
+		if r.Method == http.MethodPost {
+			status = http.StatusSeeOther
+		}
+		http.Redirect(w, r, redirectUrl, status)
 	}
 }
 
@@ -246,14 +775,24 @@ func ServeSwaggerUI(router chi.Router) {
 
 	fs := http.FileServer(http.FS(swaggerContent))
 	router.Handle("/swagger-ui/*", http.StripPrefix("/swagger-ui/", fs))
+
+	// Relative redirect to keep the base path the client uses.
+	router.Get("/swagger-ui", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "swagger-ui/")
+		w.WriteHeader(http.StatusMovedPermanently)
+	})
 }
 
 // ServeStatic adds a handler to the given router that serves static content
-// from the hardcoded and embedded "static" directory using a fileserver.
-func ServeStatic(router chi.Router) {
-	staticContent, err := fs.Sub(content, "static")
-	if err != nil {
-		panic(err)
+// from the given file system using a fileserver. Falls back to the embedded
+// "static" directory if staticContent is nil.
+func ServeStatic(router chi.Router, staticContent fs.FS) {
+	if staticContent == nil {
+		var err error
+		staticContent, err = NewUIContent("static", "")
+		if err != nil {
+			panic(err)
+		}
 	}
 
 	router.Handle("/*", http.FileServer(http.FS(staticContent)))
@@ -263,32 +802,63 @@ func ServeStatic(router chi.Router) {
 type ServeTmplArgs struct {
 	router   chi.Router
 	patterns []string
-	file     string
-	data     any
+	tmpl     *template.Template
+	data     LocalizedIndexTmplData
+	snippets Snippets
 }
 
+// ServeTmpl renders the given template per request with the data of the
+// locale negotiated with LocalizedIndexTmplData.Select and the snippets
+// rendered for the base URL of the request. The result is served under the
+// given patterns. Falls back to the templates in the embedded
+// "template" directory if tmpl is nil.
 func ServeTmpl(a ServeTmplArgs) {
-	tmplContent, err := fs.Sub(content, "template")
-	if err != nil {
-		panic(err)
+	tmpl := a.tmpl
+	if tmpl == nil {
+		tmplContent, err := NewUIContent("template", "")
+		if err != nil {
+			panic(err)
+		}
+		tmpl, err = NewUITemplates(tmplContent)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	tmpl, err := template.ParseFS(tmplContent, a.file)
-	if err != nil {
-		panic(err)
-	}
+	for _, pattern := range a.patterns {
+		a.router.Get(pattern, func(w http.ResponseWriter, r *http.Request) {
+			locale, data := a.data.Select(r)
 
-	var buffer bytes.Buffer
-	err = tmpl.Execute(&buffer, a.data)
-	if err != nil {
-		panic(err)
-	}
+			baseURL, err := RequestBaseURL(r)
+			if err != nil {
+				msg := fmt.Sprintf("Bad Request. ErrRequestHostInvalid: %v", err)
+				WriteProblem(w, r, http.StatusBadRequest, "RequestHostInvalid", msg)
+				return
+			}
+
+			snippets, err := a.snippets.Render(baseURL)
+			if err != nil {
+				msg := "Internal Server Error. Rendering snippets failed."
+				WriteInternalError(w, r, "PageRenderFailed", msg, err)
+				return
+			}
+			data.Snippets = snippets
+			data.BasePath = BasePath(r)
+			data.Nonce = CSPNonce(r)
 
-	bytes := buffer.Bytes()
+			var buffer bytes.Buffer
+			err = tmpl.Execute(&buffer, data)
+			if err != nil {
+				msg := "Internal Server Error. Rendering page failed."
+				WriteInternalError(w, r, "PageRenderFailed", msg, err)
+				return
+			}
 
-	for _, pattern := range a.patterns {
-		a.router.Get(pattern, func(w http.ResponseWriter, r *http.Request) {
-			_, err = w.Write(bytes)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Header().Set("Content-Language", locale)
+			w.Header().Add("Vary", "Accept-Language")
+
+			_, err = w.Write(buffer.Bytes())
 			if err != nil {
 				panic(err)
 			}
@@ -298,14 +868,37 @@ func ServeTmpl(a ServeTmplArgs) {
 
 // IndexTmplData is the input data for the index.html template.
 type IndexTmplData struct {
-	Title string
-	Desc1 template.HTML
-	Desc2 template.HTML
-	Misc  template.HTML
+	Lang     string
+	T        Catalog
+	Title    string
+	Desc1    template.HTML
+	Desc2    template.HTML
+	Misc     template.HTML
+	Profiles []string
+
+	// ExpiryWarning is the number of seconds before the expiry of the token
+	// at which the page warns or reloads the token.
+	ExpiryWarning int
+
+	// ExpiryReload makes the page reload the token instead of only warning.
+	ExpiryReload bool
+
+	// Snippets are usage examples rendered per request. The token is
+	// represented by the SnippetTokenPlaceholder.
+	Snippets []RenderedSnippet
+
+	// BasePath is the path prefix clients reach the server under. Set per
+	// request. Empty for the root.
+	BasePath string
+
+	// Nonce allows inline scripts and styles in operator templates under the
+	// Content-Security-Policy. Set per request. Empty if there is no policy.
+	Nonce string
 }
 
 // NewIndexTmplData constructs indexTmplData after juggling around the input
 // parameters. Removes a bit of logic from the actual index.html template.
+// Messages are taken from the embedded catalog of the DefaultUILocale.
 func NewIndexTmplData(
 	uiTarget string,
 	uiTitle string,
@@ -313,31 +906,16 @@ func NewIndexTmplData(
 	uiDesc2 string,
 	uiMisc string,
 ) IndexTmplData {
-	d := IndexTmplData{}
-
-	if len(uiTitle) > 0 {
-		d.Title = uiTitle
-	} else if len(uiTarget) > 0 {
-		d.Title = "Token2go | " + uiTarget
-	} else {
-		d.Title = "Token2go"
-	}
-
-	if len(uiTarget) > 0 {
-		x := fmt.Sprintf("Get a token for %s with the Token2go service", uiTarget)
-		d.Desc1 = template.HTML(
-			x,
-		) //#nosec G203 -- Accepted risk. Data is only provided on startup as config.
-	} else {
-		d.Desc1 = template.HTML("Go ahead and grab a token with the Token2go service")
+	catalogs, err := NewCatalogs("", DefaultUILocale)
+	if err != nil {
+		panic(err)
 	}
 
-	d.Desc2 = template.HTML(
-		uiDesc2,
-	) //#nosec G203 -- Accepted risk. Data is only provided on startup as config.
-	d.Misc = template.HTML(
-		uiMisc,
-	) //#nosec G203 -- Accepted risk. Data is only provided on startup as config.
-
-	return d
+	return NewCatalogIndexTmplData(DefaultUILocale, catalogs[DefaultUILocale], UIStrings{
+		Target: uiTarget,
+		Title:  uiTitle,
+		Desc1:  uiDesc1,
+		Desc2:  uiDesc2,
+		Misc:   uiMisc,
+	})
 }
@@ -3,6 +3,12 @@ package main
 import (
 	"bytes"
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"io"
@@ -79,8 +85,8 @@ func TestUsageOfExLibs(t *testing.T) {
 	}
 }
 
-func TestGetEchoHandler(t *testing.T) {
-	handler := http.HandlerFunc(GetEchoHandler)
+func TestMakeGetEchoHandler(t *testing.T) {
+	handler := MakeGetEchoHandler(nil, nil, "")
 
 	request, err := http.NewRequestWithContext(
 		context.TODO(),
@@ -151,6 +157,106 @@ func TestGetEchoHandler(t *testing.T) {
 	}
 }
 
+func TestMakeGetEchoHandler_Redact(t *testing.T) {
+	handler := MakeGetEchoHandler([]string{"authorization", "X-Foo"}, []string{"token"}, "")
+
+	request, err := http.NewRequestWithContext(context.TODO(), "GET", "/echo?token=secret&Token=visible", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request.Header.Set("Authorization", "Bearer secret")
+	request.Header.Add("X-Foo", "secret")
+	request.Header.Add("X-Foo", "secret")
+	request.Header.Set("X-Bar", "visible")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, request)
+	rrr := rr.Result()
+	defer rrr.Body.Close()
+
+	if rrr.StatusCode != 200 {
+		t.Errorf("Wrong status code: got %v, want 200", rrr.StatusCode)
+	}
+
+	b, err := io.ReadAll(rrr.Body)
+	if err != nil {
+		t.Fatalf("Unexpected error while reading body: %v", err)
+	}
+	body := string(b)
+
+	if strings.Contains(body, "secret") {
+		t.Errorf("Found redacted value in '%v'", body)
+	}
+
+	for _, want := range []string{
+		`"Authorization":["REDACTED"]`,
+		`"X-Foo":["REDACTED","REDACTED"]`,
+		`"X-Bar":["visible"]`,
+		`"token":["REDACTED"]`,
+		`"Token":["visible"]`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Did not find '%v' in '%v'", want, body)
+		}
+	}
+}
+
+func TestMakeGetEchoHandler_AdminToken(t *testing.T) {
+	handler := MakeGetEchoHandler(nil, nil, "s3cr3t")
+
+	for _, tc := range []struct {
+		name         string
+		adminToken   string
+		expectedCode int
+	}{{
+		name:         "1_missing",
+		adminToken:   "",
+		expectedCode: 401,
+	}, {
+		name:         "2_wrong",
+		adminToken:   "nimda",
+		expectedCode: 401,
+	}, {
+		name:         "3_correct",
+		adminToken:   "s3cr3t",
+		expectedCode: 200,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequestWithContext(
+				context.TODO(), "GET", "/echo", nil,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(tc.adminToken) > 0 {
+				request.Header.Set(EchoAdminTokenHeaderName, tc.adminToken)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, request)
+			rrr := rr.Result()
+			defer rrr.Body.Close()
+
+			if rrr.StatusCode != tc.expectedCode {
+				t.Errorf(
+					"Wrong status code: got %v, want %v",
+					rrr.StatusCode, tc.expectedCode,
+				)
+			}
+
+			b, err := io.ReadAll(rrr.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(b), "s3cr3t") {
+				t.Errorf("Found admin token in '%v'", string(b))
+			}
+		})
+	}
+}
+
 func TestGetHealthHandler(t *testing.T) {
 	handler := http.HandlerFunc(GetHealthHandler)
 
@@ -213,7 +319,9 @@ func TestMakeGetTokenHandler(t *testing.T) {
 		expectedSecret:   "lol",
 	}} {
 		t.Run(tc.name, func(t *testing.T) {
-			handler := MakeGetTokenHandler(tc.tokenHeaderNames, tc.fallbackToken)
+			handler := MakeGetTokenHandler(TokenPipeline{profiles: newTestTokenProfiles(
+				t, tc.tokenHeaderNames, tc.fallbackToken,
+			)})
 
 			request, err := http.NewRequestWithContext(
 				context.TODO(),
@@ -268,6 +376,7 @@ func TestMakeGetTokenRedirectFlowHandler(t *testing.T) {
 		headers          http.Header
 		tokenHeaderNames []string
 		fallbackToken    string
+		redirectTargets  []string
 		expectedCode     int
 	}{{
 		name: "1_success_x509",
@@ -281,11 +390,35 @@ func TestMakeGetTokenRedirectFlowHandler(t *testing.T) {
 		tokenHeaderNames: []string{"Foo"},
 		fallbackToken:    "",
 		expectedCode:     301,
+	}, {
+		name: "2_target_allowed",
+		queryParams: url.Values{
+			"target":        {"http://localhost:8888/callback"},
+			"state":         {"state"},
+			"publicKeyType": {"rsa2048-rfc5280-x509-pem"},
+			"publicKey":     {string(aPublic1)},
+		},
+		headers:          http.Header{"Foo": []string{"x"}},
+		tokenHeaderNames: []string{"Foo"},
+		redirectTargets:  []string{"https://example.com", "http://localhost/callback"},
+		expectedCode:     301,
+	}, {
+		name: "3_target_forbidden",
+		queryParams: url.Values{
+			"target":        {"https://evil.example.org"},
+			"state":         {"state"},
+			"publicKeyType": {"rsa2048-rfc5280-x509-pem"},
+			"publicKey":     {string(aPublic1)},
+		},
+		headers:          http.Header{"Foo": []string{"x"}},
+		tokenHeaderNames: []string{"Foo"},
+		redirectTargets:  []string{"https://example.com", "http://localhost/callback"},
+		expectedCode:     403,
 	}} {
 		t.Run(tc.name, func(t *testing.T) {
-			handler := MakeGetTokenRedirectFlowHandler(
-				tc.tokenHeaderNames, tc.fallbackToken,
-			)
+			handler := MakeGetTokenRedirectFlowHandler(TokenPipeline{profiles: newTestTokenProfiles(
+				t, tc.tokenHeaderNames, tc.fallbackToken,
+			)}, tc.redirectTargets)
 
 			request, err := http.NewRequestWithContext(context.TODO(),
 				"GET", "/flows/redirect/token?"+tc.queryParams.Encode(), nil,
@@ -310,6 +443,9 @@ func TestMakeGetTokenRedirectFlowHandler(t *testing.T) {
 
 			gotTarget := strings.Split(rrr.Header.Get("Location"), "?")[0]
 			wantTarget := tc.queryParams.Get("target")
+			if tc.expectedCode != 301 {
+				wantTarget = ""
+			}
 			if gotTarget != wantTarget {
 				t.Errorf("Wrong target: got %v, want %v", gotTarget, wantTarget)
 			}
@@ -317,9 +453,263 @@ func TestMakeGetTokenRedirectFlowHandler(t *testing.T) {
 	}
 }
 
+func TestMakeGetTokenHandler_Profiles(t *testing.T) {
+	router := chi.NewRouter()
+	handler := MakeGetTokenHandler(TokenPipeline{
+		profiles: newTestTokenProfiles(t, []string{"Authorization"}, ""),
+	})
+	router.Get("/token", handler)
+	router.Get("/token/{name}", handler)
+
+	headers := http.Header{
+		"Authorization": {"Bearer a"},
+		"X-Id-Token":    {"i"},
+	}
+
+	for _, tc := range []struct {
+		name         string
+		target       string
+		expectedCode int
+		expectedBody []string
+	}{{
+		name:         "1_default",
+		target:       "/token",
+		expectedCode: 200,
+		expectedBody: []string{`"secret":"a"`, `"source":"header:Authorization"`},
+	}, {
+		name:         "2_named",
+		target:       "/token/id",
+		expectedCode: 200,
+		expectedBody: []string{`"secret":"i"`, `"source":"header:X-Id-Token"`},
+	}, {
+		name:         "3_named_missing",
+		target:       "/token/refresh",
+		expectedCode: 444,
+		expectedBody: []string{"header:X-Refresh-Token"},
+	}, {
+		name:         "4_named_unknown",
+		target:       "/token/nope",
+		expectedCode: 404,
+		expectedBody: []string{"ErrTokenProfileUnknown"},
+	}, {
+		name:         "5_all",
+		target:       "/token?all",
+		expectedCode: 200,
+		expectedBody: []string{`"access":{`, `"id":{`, `"secret":"i"`},
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", tc.target, nil)
+			request.Header = headers
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, request)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong status code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+
+			body := rr.Body.String()
+			for _, e := range tc.expectedBody {
+				if !strings.Contains(body, e) {
+					t.Errorf("Did not find '%v' in '%v'", e, body)
+				}
+			}
+		})
+	}
+}
+
+func TestMakeGetTokenHandler_Exchange(t *testing.T) {
+	server := newTestTokenExchangeServer(t)
+
+	handler := MakeGetTokenHandler(TokenPipeline{
+		profiles: newTestTokenProfiles(t, []string{"Authorization"}, ""),
+		exchanger: NewTokenExchanger(
+			NewOAuth2Client(server.URL, "client", "secret"),
+			TokenTypeAccessToken, []string{"api"}, nil,
+		),
+	})
+	router := chi.NewRouter()
+	router.Get("/token", handler)
+	router.Get("/token/{name}", handler)
+
+	for _, tc := range []struct {
+		name         string
+		target       string
+		expectedCode int
+		expectedBody string
+	}{{
+		name:         "1_no_exchange",
+		target:       "/token",
+		expectedCode: 200,
+		expectedBody: `"secret":"a"`,
+	}, {
+		name:         "2_exchange",
+		target:       "/token?audience=api",
+		expectedCode: 200,
+		expectedBody: `"secret":"a|api|"`,
+	}, {
+		name:         "3_forbidden",
+		target:       "/token?audience=other",
+		expectedCode: 400,
+		expectedBody: "ErrTokenExchangeForbidden",
+	}, {
+		name:         "4_bundle",
+		target:       "/token?all&audience=api",
+		expectedCode: 400,
+		expectedBody: "token bundles",
+	}, {
+		name:         "5_empty_audience",
+		target:       "/token?audience=",
+		expectedCode: 200,
+		expectedBody: `"secret":"a"`,
+	}, {
+		name:         "6_id_token",
+		target:       "/token/id?audience=api",
+		expectedCode: 200,
+		expectedBody: `"secret":"id:i|api|"`,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", tc.target, nil)
+			request.Header.Set("Authorization", "Bearer a")
+			request.Header.Set("X-Id-Token", "i")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, request)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong status code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if !strings.Contains(rr.Body.String(), tc.expectedBody) {
+				t.Errorf("Did not find '%v' in '%v'", tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestMakeGetTokenRedirectFlowHandler_Tokens(t *testing.T) {
+	aPublic1, err := os.ReadFile("testdata/a-public-key-rsa2048-rfc5280-x509.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := MakeGetTokenRedirectFlowHandler(TokenPipeline{
+		profiles: newTestTokenProfiles(t, []string{"Authorization"}, ""),
+	}, nil)
+
+	for _, tc := range []struct {
+		name           string
+		tokens         string
+		expectedCode   int
+		expectedBundle []string
+	}{{
+		name:           "1_bundle",
+		tokens:         "access,id",
+		expectedCode:   301,
+		expectedBundle: []string{"access", "id"},
+	}, {
+		name:         "2_missing",
+		tokens:       "refresh",
+		expectedCode: 444,
+	}, {
+		name:         "3_unknown",
+		tokens:       "access,nope",
+		expectedCode: 400,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			queryParams := url.Values{
+				"target":        {"https://example.com"},
+				"state":         {"state"},
+				"publicKeyType": {"rsa2048-rfc5280-x509-pem"},
+				"publicKey":     {string(aPublic1)},
+				"tokens":        {tc.tokens},
+			}
+
+			request := httptest.NewRequest(
+				"GET", "/flows/redirect/token?"+queryParams.Encode(), nil,
+			)
+			request.Header = http.Header{
+				"Authorization": {"Bearer a"},
+				"X-Id-Token":    {"i"},
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, request)
+
+			if rr.Code != tc.expectedCode {
+				t.Fatalf("Wrong status code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+
+			if rr.Code != 301 {
+				return
+			}
+
+			var bundle TokenBundle
+			payload := decryptTestRedirectPayload(t, rr.Header().Get("Location"))
+			if err := json.Unmarshal(payload, &bundle); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			for _, name := range tc.expectedBundle {
+				if _, ok := bundle[name]; !ok {
+					t.Errorf("Did not find '%v' in bundle %v", name, bundle)
+				}
+			}
+			if len(bundle) != len(tc.expectedBundle) {
+				t.Errorf("Wrong bundle size: got %v, want %v", len(bundle), len(tc.expectedBundle))
+			}
+		})
+	}
+}
+
+// decryptTestRedirectPayload decrypts the payload attached to the given
+// redirect location with the private key of testdata key pair "a".
+func decryptTestRedirectPayload(t *testing.T, location string) []byte {
+	t.Helper()
+
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+
+	decode := func(name string) []byte {
+		b, err := base64.StdEncoding.DecodeString(query.Get(name))
+		if err != nil {
+			t.Fatalf("Failed to decode %s: %v", name, err)
+		}
+		return b
+	}
+
+	privateKey, ok := readTestPrivateKey(t, "a-private-key-rsa2048-rfc5958-pksc8.pem").(*rsa.PrivateKey)
+	if !ok {
+		t.Fatal("Expected RSA private key")
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), nil, privateKey, decode("key"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := aesgcm.Open(nil, decode("nonce"), decode("payload"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return payload
+}
+
 func TestServeStatic(t *testing.T) {
 	router := chi.NewRouter()
-	ServeStatic(router)
+	ServeStatic(router, nil)
 	server := httptest.NewServer(router)
 	defer server.Close()
 	client := server.Client()
@@ -352,7 +742,15 @@ func TestServeStatic(t *testing.T) {
 }
 
 func TestIndexTmplData(t *testing.T) {
-	d := IndexTmplData{"TITLE", "DESC1", "DESC2", "<p>MISC</p>"}
+	d := IndexTmplData{
+		Lang:     "en",
+		T:        Catalog{"reload": "RELOAD"},
+		Title:    "TITLE",
+		Desc1:    "DESC1",
+		Desc2:    "DESC2",
+		Misc:     "<p>MISC</p>",
+		Profiles: []string{"access", "id"},
+	}
 
 	tmplContent, err := fs.Sub(content, "template")
 	if err != nil {
@@ -372,7 +770,7 @@ func TestIndexTmplData(t *testing.T) {
 
 	renderedStr := buffer.String()
 	for _, e := range []string{
-		d.Title, string(d.Desc1), string(d.Desc2), string(d.Misc),
+		d.Title, string(d.Desc1), string(d.Desc2), string(d.Misc), `lang="en"`, "RELOAD",
 	} {
 		if !strings.Contains(renderedStr, e) {
 			t.Errorf("Expected render to contain %q", e)
@@ -432,17 +830,86 @@ func TestNewIndexTmplData_Misc(t *testing.T) {
 }
 
 func TestInitRouter(t *testing.T) {
-	c := NewConfig()
-	initRouter(
-		c.fallbackToken,
-		c.tokenHeaderNames,
-		c.addTokenHeaderNames,
-		NewIndexTmplData(
-			c.uiTarget,
-			c.uiTitle,
-			c.uiDesc1,
-			c.uiDesc2,
-			c.uiMisc,
-		),
-	)
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewRouterArgs(c, NewRouterState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	initRouter(a)
+}
+
+func TestInitRouter_EchoDisabled(t *testing.T) {
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		echoEnabled  bool
+		expectedCode int
+	}{{
+		name:         "1_enabled",
+		echoEnabled:  true,
+		expectedCode: 200,
+	}, {
+		name:         "2_disabled",
+		echoEnabled:  false,
+		expectedCode: 404,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, err := NewRouterArgs(c, NewRouterState())
+			if err != nil {
+				t.Fatal(err)
+			}
+			a.echoEnabled = tc.echoEnabled
+
+			request := httptest.NewRequest("GET", "/echo", nil)
+			rr := httptest.NewRecorder()
+			initRouter(a).ServeHTTP(rr, request)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong status code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+		})
+	}
+}
+
+func TestInitRouter_TokenRefresh(t *testing.T) {
+	t.Setenv("T2G_TOKEN_REFRESH_URL", newTestTokenRefreshServer(t).URL)
+	t.Setenv("T2G_TOKEN_REFRESH_CLIENT_ID", "client")
+	t.Setenv("T2G_TOKEN_REFRESH_CLIENT_SECRET", "secret")
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewRouterArgs(c, NewRouterState())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest("GET", "/token/refresh", nil)
+	request.Header.Set("X-Auth-Request-Refresh-Token", "rt-1")
+	rr := httptest.NewRecorder()
+	initRouter(a).ServeHTTP(rr, request)
+
+	if rr.Code != 200 {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, 200)
+	}
+
+	for _, specs := range []map[string][]TokenSourceSpec{
+		{"refresh": {{Type: "header", Names: []string{"X-Refresh-Token"}}}},
+		{"rt": {{Type: "header", Names: []string{"X-Auth-Request-Refresh-Token"}}}},
+		{"rt": {{Type: "oauth2-proxy-session", Names: []string{"_o"}, Secret: "s", Token: "refresh"}}},
+	} {
+		c.tokenProfileSpecs = specs
+		_, err = NewRouterArgs(c, NewRouterState())
+		if err == nil {
+			t.Errorf("Unexpected success for %v: got nil, want error", specs)
+		}
+	}
 }
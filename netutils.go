@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
 	"strings"
 )
 
@@ -13,11 +12,12 @@ import (
 // caller is to return if the function returns false.
 func IsRequiredQueryParamSet(
 	w http.ResponseWriter,
-	params url.Values,
+	r *http.Request,
 	requiredParams ...string,
 ) bool {
 	var missingParams []string
 
+	params := r.URL.Query()
 	for _, requiredParam := range requiredParams {
 		if !params.Has(requiredParam) {
 			missingParams = append(missingParams, requiredParam)
@@ -29,7 +29,7 @@ func IsRequiredQueryParamSet(
 			"Bad Request. Missing query parameters: %s",
 			strings.Join(missingParams, ", "),
 		)
-		http.Error(w, msg, http.StatusBadRequest)
+		WriteProblem(w, r, http.StatusBadRequest, "MissingQueryParameters", msg)
 		return false
 	}
 
@@ -41,6 +41,7 @@ func IsRequiredQueryParamSet(
 // function returns false.
 func IsQueryParamValueAllowed(
 	w http.ResponseWriter,
+	r *http.Request,
 	name string,
 	value string,
 	allowedValues ...string,
@@ -55,14 +56,34 @@ func IsQueryParamValueAllowed(
 		"Bad Request. Value of query parameter %s forbidden. Allowed: %s",
 		name, strings.Join(allowedValues, ","),
 	)
-	http.Error(w, msg, http.StatusBadRequest)
+	WriteProblem(w, r, http.StatusBadRequest, "ForbiddenQueryParameterValue", msg)
+	return false
+}
+
+// IsRedirectTargetAllowed checks if the given target matches one of the
+// given patterns with MatchRedirectTarget. An HTTP error is written to w if
+// it does not. Left for the function caller is to return if the function
+// returns false.
+func IsRedirectTargetAllowed(
+	w http.ResponseWriter,
+	r *http.Request,
+	target string,
+	patterns []string,
+) bool {
+	if MatchRedirectTarget(patterns, target) {
+		return true
+	}
+
+	msg := fmt.Sprintf("Forbidden. Redirect target %q not allowed", target)
+	WriteProblem(w, r, http.StatusForbidden, "ForbiddenRedirectTarget", msg)
+
 	return false
 }
 
 // IsSucceededEncryptWithRSA checks and handles errors coming from the
 // EncryptWithRSA function. An HTTP error is written to w if given err not nil.
 // Left for the function caller is to return if the function returns false.
-func IsSucceededEncryptWithRSA(w http.ResponseWriter, err error) bool {
+func IsSucceededEncryptWithRSA(w http.ResponseWriter, r *http.Request, err error) bool {
 	if err == nil {
 		return true
 	}
@@ -71,32 +92,223 @@ func IsSucceededEncryptWithRSA(w http.ResponseWriter, err error) bool {
 	var rsaoaepEncryptionError *RSAOAEPEncryptionError
 
 	var msg string
-	var code int
+	var status int
+	var code string
 
 	if errors.Is(err, ErrPEMDecode) {
+		code = "PEMDecodeFailed"
 		msg = fmt.Sprintf("Bad Request. ErrPEMDecode: %v", err)
-		code = http.StatusBadRequest
+		status = http.StatusBadRequest
 	} else if errors.Is(err, ErrNotPublicKey) {
+		code = "NotPublicKey"
 		msg = fmt.Sprintf("Bad Request. ErrNotPublicKey: %v", err)
-		code = http.StatusBadRequest
+		status = http.StatusBadRequest
 	} else if errors.As(err, &publicKeyParseError) {
+		code = "PublicKeyParseError"
 		msg = fmt.Sprintf("Bad Request. PublicKeyParseError: %v", err)
-		code = http.StatusBadRequest
+		status = http.StatusBadRequest
 	} else if errors.Is(err, ErrNotRSAPublicKey) {
+		code = "NotRSAPublicKey"
 		msg = fmt.Sprintf("Bad Request. ErrNotRSAPublicKey: %v", err)
-		code = http.StatusBadRequest
+		status = http.StatusBadRequest
 	} else if errors.Is(err, ErrForbiddenKeySize) {
+		code = "ForbiddenKeySize"
 		msg = fmt.Sprintf("Bad Request. ErrForbiddenKeySize: %v", err)
-		code = http.StatusBadRequest
+		status = http.StatusBadRequest
 	} else if errors.As(err, &rsaoaepEncryptionError) {
-		msg = fmt.Sprintf("Internal Server Error. RSAOAEPEncryptionError: %v", err)
-		code = http.StatusInternalServerError
+		WriteInternalError(w, r, "RSAOAEPEncryptionError", "Internal Server Error. RSAOAEPEncryptionError.", err)
+		return false
 	} else {
-		msg = fmt.Sprintf("Internal Server Error: %v", err)
-		code = http.StatusInternalServerError
+		WriteInternalError(w, r, "InternalError", "Internal Server Error.", err)
+		return false
+	}
+
+	WriteProblem(w, r, status, code, msg)
+
+	return false
+}
+
+// IsSucceededEncryptWithAES checks and handles errors coming from the
+// EncryptWithAES function. An HTTP error is written to w if given err not nil.
+// Left for the function caller is to return if the function returns false.
+func IsSucceededEncryptWithAES(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var aesKeySizeError *AESKeySizeError
+
+	if errors.As(err, &aesKeySizeError) {
+		msg := "Internal Server Error. AESKeySizeError."
+		WriteInternalError(w, r, "AESKeySizeError", msg, err)
+		return false
+	}
+
+	msg := "Internal Server Error. Payload encryption failed."
+	WriteInternalError(w, r, "PayloadEncryptionFailed", msg, err)
+
+	return false
+}
+
+// IsSucceededExtractToken checks and handles errors coming from token
+// extraction with TokenSourceChain or TokenProfiles. An HTTP error is written
+// to w if given err not nil. Missing tokens are answered with the given
+// notFoundStatus. The names of the sources looked at are part of the error
+// message. Left for the function caller is to return if the function returns
+// false.
+func IsSucceededExtractToken(
+	w http.ResponseWriter,
+	r *http.Request,
+	notFoundStatus int,
+	sourceNames []string,
+	err error,
+) bool {
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, ErrTokenProfileUnknown) {
+		msg := fmt.Sprintf("Not Found. ErrTokenProfileUnknown: %v", err)
+		WriteProblem(w, r, http.StatusNotFound, "TokenProfileUnknown", msg)
+		return false
+	}
+
+	if errors.Is(err, ErrTokenNotFound) {
+		msg := "Token not found. Looking for: " + strings.Join(sourceNames, ", ")
+		WriteProblem(w, r, notFoundStatus, "TokenNotFound", msg)
+		return false
+	}
+
+	msg := "Internal Server Error. Token extraction failed."
+	WriteInternalError(w, r, "TokenExtractionFailed", msg, err)
+
+	return false
+}
+
+// IsSucceededExchangeToken checks and handles errors coming from
+// TokenExchanger. An HTTP error is written to w if given err not nil. Left for
+// the function caller is to return if the function returns false.
+func IsSucceededExchangeToken(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var msg string
+	var status int
+	var code string
+
+	if errors.Is(err, ErrTokenExchangeDisabled) {
+		code = "TokenExchangeDisabled"
+		msg = fmt.Sprintf("Bad Request. ErrTokenExchangeDisabled: %v", err)
+		status = http.StatusBadRequest
+	} else if errors.Is(err, ErrTokenExchangeForbidden) {
+		code = "TokenExchangeForbidden"
+		msg = fmt.Sprintf("Bad Request. ErrTokenExchangeForbidden: %v", err)
+		status = http.StatusBadRequest
+	} else if errors.Is(err, ErrTokenExchangeFailed) {
+		code = "TokenExchangeFailed"
+		msg = fmt.Sprintf("Bad Gateway. ErrTokenExchangeFailed: %v", err)
+		status = http.StatusBadGateway
+	} else {
+		WriteInternalError(w, r, "InternalError", "Internal Server Error.", err)
+		return false
+	}
+
+	WriteProblem(w, r, status, code, msg)
+
+	return false
+}
+
+// IsSucceededRefreshToken checks and handles errors coming from
+// TokenRefresher. An HTTP error is written to w if given err not nil. Missing
+// refresh tokens are answered with the given notFoundStatus. The names of the
+// refresh token sources looked at are part of the error message. Left for the
+// function caller is to return if the function returns false.
+func IsSucceededRefreshToken(
+	w http.ResponseWriter,
+	r *http.Request,
+	notFoundStatus int,
+	sourceNames []string,
+	err error,
+) bool {
+	if err == nil {
+		return true
+	}
+
+	if errors.Is(err, ErrTokenNotFound) {
+		msg := "Refresh token not found. Looking for: " + strings.Join(sourceNames, ", ")
+		WriteProblem(w, r, notFoundStatus, "RefreshTokenNotFound", msg)
+		return false
+	}
+
+	if errors.Is(err, ErrTokenRefreshFailed) {
+		msg := fmt.Sprintf("Bad Gateway. ErrTokenRefreshFailed: %v", err)
+		WriteProblem(w, r, http.StatusBadGateway, "TokenRefreshFailed", msg)
+		return false
+	}
+
+	msg := "Internal Server Error. Token refresh failed."
+	WriteInternalError(w, r, "InternalError", msg, err)
+
+	return false
+}
+
+// IsSucceededIntrospectToken checks and handles errors coming from
+// TokenIntrospector. An HTTP error is written to w if given err not nil. Left
+// for the function caller is to return if the function returns false.
+func IsSucceededIntrospectToken(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var msg string
+	var status int
+	var code string
+
+	if errors.Is(err, ErrTokenInactive) {
+		code = "TokenInactive"
+		msg = fmt.Sprintf("Forbidden. ErrTokenInactive: %v", err)
+		status = http.StatusForbidden
+	} else if errors.Is(err, ErrTokenIntrospectionFailed) {
+		code = "TokenIntrospectionFailed"
+		msg = fmt.Sprintf("Bad Gateway. ErrTokenIntrospectionFailed: %v", err)
+		status = http.StatusBadGateway
+	} else {
+		WriteInternalError(w, r, "InternalError", "Internal Server Error.", err)
+		return false
+	}
+
+	WriteProblem(w, r, status, code, msg)
+
+	return false
+}
+
+// IsSucceededWrapToken checks and handles errors coming from TokenWrapper. An
+// HTTP error is written to w if given err not nil. Left for the function
+// caller is to return if the function returns false.
+func IsSucceededWrapToken(w http.ResponseWriter, r *http.Request, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	var msg string
+	var status int
+	var code string
+
+	if errors.Is(err, ErrWrappedTokenNotFound) {
+		code = "WrappedTokenNotFound"
+		msg = "Not Found. Wrapping ID unknown, expired, or already used."
+		status = http.StatusNotFound
+	} else if errors.Is(err, ErrWrapStoreFull) {
+		code = "WrapStoreFull"
+		msg = "Service Unavailable. Too many wrapped tokens. Try again later."
+		status = http.StatusServiceUnavailable
+	} else {
+		WriteInternalError(w, r, "InternalError", "Internal Server Error.", err)
+		return false
 	}
 
-	http.Error(w, msg, code)
+	WriteProblem(w, r, status, code, msg)
 
 	return false
 }
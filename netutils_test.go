@@ -52,8 +52,9 @@ func TestIsRequiredQueryParamSet(t *testing.T) {
 				params.Add(param, "x")
 			}
 
+			r := httptest.NewRequest("GET", "/?"+params.Encode(), nil)
 			rr := httptest.NewRecorder()
-			result := IsRequiredQueryParamSet(rr, params, tc.requiredParams...)
+			result := IsRequiredQueryParamSet(rr, r, tc.requiredParams...)
 			if result != tc.expectedResult {
 				t.Errorf("Wrong result: got %v, want %v", result, tc.expectedResult)
 			}
@@ -100,8 +101,9 @@ func TestIsQueryParamValueAllowed(t *testing.T) {
 		expectedResult: false,
 	}} {
 		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
 			rr := httptest.NewRecorder()
-			result := IsQueryParamValueAllowed(rr, "X", tc.value, tc.allowedValues...)
+			result := IsQueryParamValueAllowed(rr, r, "X", tc.value, tc.allowedValues...)
 			if result != tc.expectedResult {
 				t.Errorf("Wrong result: got %v, want %v", result, tc.expectedResult)
 			}
@@ -175,8 +177,9 @@ func TestIsSucceededEncryptWithRSA(t *testing.T) {
 		expectedResult: false,
 	}} {
 		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
 			rr := httptest.NewRecorder()
-			result := IsSucceededEncryptWithRSA(rr, tc.err)
+			result := IsSucceededEncryptWithRSA(rr, r, tc.err)
 			rrr := rr.Result()
 			defer rrr.Body.Close()
 
@@ -201,3 +204,289 @@ func TestIsSucceededEncryptWithRSA(t *testing.T) {
 		})
 	}
 }
+
+func TestIsSucceededEncryptWithAES(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		substr         string
+		err            error
+		expectedCode   int
+		expectedResult bool
+	}{{
+		name:           "1_no_error",
+		substr:         "",
+		err:            nil,
+		expectedCode:   200,
+		expectedResult: true,
+	}, {
+		name:           "2_AESKeySizeError",
+		substr:         "AESKeySizeError",
+		err:            &AESKeySizeError{},
+		expectedCode:   500,
+		expectedResult: false,
+	}, {
+		name:           "3_unknown_error",
+		substr:         "Payload encryption failed",
+		err:            errors.New("foobar"),
+		expectedCode:   500,
+		expectedResult: false,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			rr := httptest.NewRecorder()
+			result := IsSucceededEncryptWithAES(rr, r, tc.err)
+
+			if result != tc.expectedResult {
+				t.Errorf("Wrong result: got %v, want %v", result, tc.expectedResult)
+			}
+			if !tc.expectedResult && rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if !strings.Contains(rr.Body.String(), tc.substr) {
+				t.Errorf(
+					"Didn't find substr in body: got %q, want %q",
+					rr.Body.String(),
+					tc.substr,
+				)
+			}
+		})
+	}
+}
+
+func TestIsSucceededExtractToken(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		substr         string
+		err            error
+		notFoundStatus int
+		expectedCode   int
+		expectedResult bool
+	}{{
+		name:           "1_no_error",
+		substr:         "",
+		err:            nil,
+		notFoundStatus: 444,
+		expectedCode:   200,
+		expectedResult: true,
+	}, {
+		name:           "2_ErrTokenNotFound",
+		substr:         "header:Authorization",
+		err:            ErrTokenNotFound,
+		notFoundStatus: 444,
+		expectedCode:   444,
+		expectedResult: false,
+	}, {
+		name:           "3_ErrTokenNotFound_custom_status",
+		substr:         "header:Authorization",
+		err:            ErrTokenNotFound,
+		notFoundStatus: 401,
+		expectedCode:   401,
+		expectedResult: false,
+	}, {
+		name:           "4_ErrTokenProfileUnknown",
+		substr:         "ErrTokenProfileUnknown",
+		err:            ErrTokenProfileUnknown,
+		notFoundStatus: 401,
+		expectedCode:   404,
+		expectedResult: false,
+	}, {
+		name:           "5_unknown_error",
+		substr:         "Token extraction failed.",
+		err:            errors.New("foobar"),
+		notFoundStatus: 444,
+		expectedCode:   500,
+		expectedResult: false,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			result := IsSucceededExtractToken(
+				rr, httptest.NewRequest("GET", "/", nil), tc.notFoundStatus,
+				[]string{"header:Authorization"}, tc.err,
+			)
+
+			if result != tc.expectedResult {
+				t.Errorf("Wrong result: got %v, want %v", result, tc.expectedResult)
+			}
+			if !tc.expectedResult && rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if !strings.Contains(rr.Body.String(), tc.substr) {
+				t.Errorf(
+					"Didn't find substr in body: got %q, want %q",
+					rr.Body.String(),
+					tc.substr,
+				)
+			}
+		})
+	}
+}
+
+func TestIsSucceededExchangeToken(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		substr         string
+		err            error
+		expectedCode   int
+		expectedResult bool
+	}{{
+		name:           "1_no_error",
+		substr:         "",
+		err:            nil,
+		expectedCode:   200,
+		expectedResult: true,
+	}, {
+		name:           "2_ErrTokenExchangeDisabled",
+		substr:         "ErrTokenExchangeDisabled",
+		err:            ErrTokenExchangeDisabled,
+		expectedCode:   400,
+		expectedResult: false,
+	}, {
+		name:           "3_ErrTokenExchangeForbidden",
+		substr:         "ErrTokenExchangeForbidden",
+		err:            ErrTokenExchangeForbidden,
+		expectedCode:   400,
+		expectedResult: false,
+	}, {
+		name:           "4_ErrTokenExchangeFailed",
+		substr:         "ErrTokenExchangeFailed",
+		err:            ErrTokenExchangeFailed,
+		expectedCode:   502,
+		expectedResult: false,
+	}, {
+		name:           "5_unknown_error",
+		substr:         "",
+		err:            errors.New("foobar"),
+		expectedCode:   500,
+		expectedResult: false,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			rr := httptest.NewRecorder()
+			result := IsSucceededExchangeToken(rr, r, tc.err)
+
+			if result != tc.expectedResult {
+				t.Errorf("Wrong result: got %v, want %v", result, tc.expectedResult)
+			}
+			if !tc.expectedResult && rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if !strings.Contains(rr.Body.String(), tc.substr) {
+				t.Errorf(
+					"Didn't find substr in body: got %q, want %q",
+					rr.Body.String(),
+					tc.substr,
+				)
+			}
+		})
+	}
+}
+
+func TestIsSucceededRefreshToken(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		substr         string
+		err            error
+		expectedCode   int
+		expectedResult bool
+	}{{
+		name:           "1_no_error",
+		substr:         "",
+		err:            nil,
+		expectedCode:   200,
+		expectedResult: true,
+	}, {
+		name:           "2_ErrTokenNotFound",
+		substr:         "header:X-Auth-Request-Refresh-Token",
+		err:            ErrTokenNotFound,
+		expectedCode:   444,
+		expectedResult: false,
+	}, {
+		name:           "3_ErrTokenRefreshFailed",
+		substr:         "ErrTokenRefreshFailed",
+		err:            ErrTokenRefreshFailed,
+		expectedCode:   502,
+		expectedResult: false,
+	}, {
+		name:           "4_unknown_error",
+		substr:         "Token refresh failed.",
+		err:            errors.New("foobar"),
+		expectedCode:   500,
+		expectedResult: false,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			result := IsSucceededRefreshToken(
+				rr, httptest.NewRequest("GET", "/", nil), StatusTokenNotFound,
+				[]string{"header:X-Auth-Request-Refresh-Token"}, tc.err,
+			)
+
+			if result != tc.expectedResult {
+				t.Errorf("Wrong result: got %v, want %v", result, tc.expectedResult)
+			}
+			if !tc.expectedResult && rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if !strings.Contains(rr.Body.String(), tc.substr) {
+				t.Errorf(
+					"Didn't find substr in body: got %q, want %q",
+					rr.Body.String(),
+					tc.substr,
+				)
+			}
+		})
+	}
+}
+
+func TestIsSucceededIntrospectToken(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		substr         string
+		err            error
+		expectedCode   int
+		expectedResult bool
+	}{{
+		name:           "1_no_error",
+		substr:         "",
+		err:            nil,
+		expectedCode:   200,
+		expectedResult: true,
+	}, {
+		name:           "2_ErrTokenInactive",
+		substr:         "ErrTokenInactive",
+		err:            ErrTokenInactive,
+		expectedCode:   403,
+		expectedResult: false,
+	}, {
+		name:           "3_ErrTokenIntrospectionFailed",
+		substr:         "ErrTokenIntrospectionFailed",
+		err:            ErrTokenIntrospectionFailed,
+		expectedCode:   502,
+		expectedResult: false,
+	}, {
+		name:           "4_unknown_error",
+		substr:         "Internal Server Error.",
+		err:            errors.New("foobar"),
+		expectedCode:   500,
+		expectedResult: false,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			rr := httptest.NewRecorder()
+			result := IsSucceededIntrospectToken(rr, r, tc.err)
+
+			if result != tc.expectedResult {
+				t.Errorf("Wrong result: got %v, want %v", result, tc.expectedResult)
+			}
+			if !tc.expectedResult && rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if !strings.Contains(rr.Body.String(), tc.substr) {
+				t.Errorf(
+					"Didn't find substr in body: got %q, want %q",
+					rr.Body.String(),
+					tc.substr,
+				)
+			}
+		})
+	}
+}
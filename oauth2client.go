@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var ErrTokenRequestFailed = errors.New("token request failed")
+
+// OAuth2Client requests tokens from an OAuth 2.0 token endpoint with its own
+// client credentials. Use NewOAuth2Client to construct.
+type OAuth2Client struct {
+	endpoint     string
+	clientID     string
+	clientSecret string
+	client       *http.Client
+}
+
+// NewOAuth2Client creates an OAuth2Client for the given token endpoint. If
+// clientSecret is empty, the client is a public client and only sends its
+// client ID.
+func NewOAuth2Client(endpoint, clientID, clientSecret string) OAuth2Client {
+	return OAuth2Client{
+		endpoint:     endpoint,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// tokenResponse is the relevant subset of a successful response or an error
+// response from the token endpoint.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`  //nolint:tagliatelle
+	RefreshToken     string `json:"refresh_token"` //nolint:tagliatelle
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"` //nolint:tagliatelle
+}
+
+// TokenResponse holds the tokens handed out by the token endpoint.
+type TokenResponse struct {
+	AccessToken string
+
+	// RefreshToken is empty unless the response contains a refresh token,
+	// for example a rotated one in response to the refresh token grant.
+	RefreshToken string
+}
+
+// RequestToken sends the given grant to the token endpoint and returns the
+// tokens from the response. Returns ErrTokenRequestFailed (possibly wrapped)
+// if the token endpoint does not hand out an access token.
+func (c OAuth2Client) RequestToken(ctx context.Context, form url.Values) (TokenResponse, error) {
+	var body tokenResponse
+
+	status, err := c.postForm(ctx, form, &body)
+	if err != nil {
+		return TokenResponse{}, fmt.Errorf("%w: %w", ErrTokenRequestFailed, err)
+	}
+
+	if status != http.StatusOK || len(body.Error) > 0 {
+		return TokenResponse{}, fmt.Errorf(
+			"%w: status %d: %s: %s",
+			ErrTokenRequestFailed, status, body.Error, body.ErrorDescription,
+		)
+	}
+	if len(body.AccessToken) == 0 {
+		return TokenResponse{}, fmt.Errorf("%w: response without access_token", ErrTokenRequestFailed)
+	}
+
+	return TokenResponse{
+		AccessToken:  body.AccessToken,
+		RefreshToken: body.RefreshToken,
+	}, nil
+}
+
+// postForm sends the form to the endpoint with client authentication and
+// decodes the JSON response into v. Returns the status code of the response.
+func (c OAuth2Client) postForm(ctx context.Context, form url.Values, v any) (int, error) {
+	if len(c.clientSecret) == 0 {
+		form.Set("client_id", c.clientID)
+	}
+
+	request, err := http.NewRequestWithContext(
+		ctx, http.MethodPost, c.endpoint, strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if len(c.clientSecret) > 0 {
+		// RFC 6749 requires credentials to be form-encoded before Basic auth.
+		request.SetBasicAuth(
+			url.QueryEscape(c.clientID), url.QueryEscape(c.clientSecret),
+		)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer response.Body.Close()
+
+	err = json.NewDecoder(io.LimitReader(response.Body, 1<<20)).Decode(v)
+	if err != nil {
+		return response.StatusCode, fmt.Errorf(
+			"status %d: undecodable response: %w", response.StatusCode, err,
+		)
+	}
+
+	return response.StatusCode, nil
+}
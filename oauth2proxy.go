@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// OAuth2ProxySession contains the parts of an oauth2-proxy session that are
+// relevant for Token2go.
+type OAuth2ProxySession struct {
+	AccessToken  string
+	IDToken      string
+	RefreshToken string
+	ExpiresOn    time.Time
+	Email        string
+	User         string
+}
+
+// OAuth2ProxySessionCookie decodes encrypted session cookies written by the
+// cookie session store of oauth2-proxy v7 and later. Use the function
+// NewOAuth2ProxySessionCookie to construct.
+type OAuth2ProxySessionCookie struct {
+	name      string
+	seed      []byte
+	cipherKey []byte
+	expire    time.Duration
+	now       func() time.Time
+}
+
+var ErrSessionCookieMissing = errors.New("session cookie missing")
+
+var ErrSessionCookieSignature = errors.New("session cookie signature invalid")
+
+var ErrSessionCookieExpired = errors.New("session cookie expired")
+
+var ErrSessionCookieDecode = errors.New("failed to decode session cookie")
+
+// oauth2ProxyCookieClockSkew is how far the timestamp of a session cookie may
+// lie in the future. Same as in oauth2-proxy.
+const oauth2ProxyCookieClockSkew = 5 * time.Minute
+
+// NewOAuth2ProxySessionCookie creates a decoder for the session cookie with the
+// given name. The secret must match oauth2-proxy's cookie secret. Like
+// oauth2-proxy, the secret is base64 decoded if that results in a valid AES key
+// length. Otherwise it is used as is. Cookies written more than expire ago are
+// rejected like oauth2-proxy does with its option "--cookie-expire". An expire
+// of 0 disables the check.
+func NewOAuth2ProxySessionCookie(name string, secret string, expire time.Duration) *OAuth2ProxySessionCookie {
+	cipherKey := []byte(secret)
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(secret, "="))
+	if err == nil && (len(decoded) == 16 || len(decoded) == 24 || len(decoded) == 32) {
+		cipherKey = decoded
+	}
+
+	return &OAuth2ProxySessionCookie{
+		name:      name,
+		seed:      []byte(secret),
+		cipherKey: cipherKey,
+		expire:    expire,
+		now:       time.Now,
+	}
+}
+
+// Decode reads the session cookie from the given headers, reassembles it if
+// split, verifies its signature and age, decrypts, decompresses, and finally
+// unmarshals it.
+//
+// Sentinel errors: ErrSessionCookieMissing, ErrSessionCookieSignature,
+// ErrSessionCookieExpired, ErrSessionCookieDecode.
+func (c *OAuth2ProxySessionCookie) Decode(headers http.Header) (OAuth2ProxySession, error) {
+	value, ok := ReadChunkedCookie(headers, c.name)
+	if !ok {
+		return OAuth2ProxySession{}, ErrSessionCookieMissing
+	}
+
+	parts := strings.Split(value, "|")
+	if len(parts) != 3 {
+		return OAuth2ProxySession{}, ErrSessionCookieSignature
+	}
+
+	mac := hmac.New(sha256.New, c.seed)
+	mac.Write([]byte(c.name))
+	mac.Write([]byte(parts[0]))
+	mac.Write([]byte(parts[1]))
+	signature, err := base64.URLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return OAuth2ProxySession{}, ErrSessionCookieSignature
+	}
+
+	timestamp, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return OAuth2ProxySession{}, fmt.Errorf("%w: invalid timestamp", ErrSessionCookieDecode)
+	}
+	if c.expire > 0 {
+		written := time.Unix(timestamp, 0)
+		now := c.now()
+		if !written.After(now.Add(-c.expire)) || !written.Before(now.Add(oauth2ProxyCookieClockSkew)) {
+			return OAuth2ProxySession{}, ErrSessionCookieExpired
+		}
+	}
+
+	ciphertext, err := base64.URLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return OAuth2ProxySession{}, fmt.Errorf("%w: %w", ErrSessionCookieDecode, err)
+	}
+
+	packed, err := decryptWithAESCFB(c.cipherKey, ciphertext)
+	if err != nil {
+		return OAuth2ProxySession{}, fmt.Errorf("%w: %w", ErrSessionCookieDecode, err)
+	}
+
+	s, err := decodeOAuth2ProxySessionState(packed)
+	if err != nil {
+		return OAuth2ProxySession{}, fmt.Errorf("%w: %w", ErrSessionCookieDecode, err)
+	}
+
+	return s, nil
+}
+
+// decryptWithAESCFB decrypts ciphertext that is prefixed with the IV like
+// oauth2-proxy's CFB cipher produces it.
+func decryptWithAESCFB(key []byte, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new cipher: %w", err)
+	}
+
+	if len(ciphertext) < aes.BlockSize {
+		return nil, errors.New("ciphertext shorter than block size")
+	}
+
+	iv := ciphertext[:aes.BlockSize]
+	plaintext := make([]byte, len(ciphertext)-aes.BlockSize)
+	//nolint:staticcheck // CFB is what oauth2-proxy uses for cookies.
+	cipher.NewCFBDecrypter(block, iv).XORKeyStream(
+		plaintext, ciphertext[aes.BlockSize:],
+	)
+
+	return plaintext, nil
+}
+
+// lz4FrameMagic is the magic number LZ4 frames start with.
+const lz4FrameMagic = 0x184D2204
+
+// maxOAuth2ProxySessionSize limits the size of decompressed session states.
+const maxOAuth2ProxySessionSize = 1 << 20
+
+// oauth2ProxySessionState is the msgpack encoding of oauth2-proxy's
+// SessionState. Fields Token2go doesn't need are skipped when decoding.
+type oauth2ProxySessionState struct {
+	ExpiresOn    *time.Time `msgpack:"eo,omitempty"`
+	AccessToken  string     `msgpack:"at,omitempty"`
+	IDToken      string     `msgpack:"it,omitempty"`
+	RefreshToken string     `msgpack:"rt,omitempty"`
+	Email        string     `msgpack:"e,omitempty"`
+	User         string     `msgpack:"u,omitempty"`
+}
+
+// decodeOAuth2ProxySessionState decompresses and unmarshals a decrypted
+// session state. Compression is optional and detected by the LZ4 frame magic
+// number.
+func decodeOAuth2ProxySessionState(packed []byte) (OAuth2ProxySession, error) {
+	if len(packed) >= 4 && binary.LittleEndian.Uint32(packed) == lz4FrameMagic {
+		decompressed, err := io.ReadAll(io.LimitReader(
+			lz4.NewReader(bytes.NewReader(packed)), maxOAuth2ProxySessionSize+1,
+		))
+		if err != nil {
+			return OAuth2ProxySession{}, fmt.Errorf("failed to decompress: %w", err)
+		}
+		if len(decompressed) > maxOAuth2ProxySessionSize {
+			return OAuth2ProxySession{}, errors.New("session state too large")
+		}
+		packed = decompressed
+	}
+
+	var state oauth2ProxySessionState
+	err := msgpack.Unmarshal(packed, &state)
+	if err != nil {
+		return OAuth2ProxySession{}, fmt.Errorf("failed to unmarshal: %w", err)
+	}
+
+	s := OAuth2ProxySession{
+		AccessToken:  state.AccessToken,
+		IDToken:      state.IDToken,
+		RefreshToken: state.RefreshToken,
+		Email:        state.Email,
+		User:         state.User,
+	}
+	if state.ExpiresOn != nil {
+		s.ExpiresOn = *state.ExpiresOn
+	}
+
+	return s, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pierrec/lz4/v4"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// testCookieSecret is a base64 encoded 32 byte secret as generated for
+// oauth2-proxy with "openssl rand -base64 32 | tr -- '+/' '-_'".
+const testCookieSecret = "T6xVVhOdTqK2lSsI3i0vJjPfZGcJZcwEBzyuWTvf6oo="
+
+// encodeTestSessionCookie encodes a session like the cookie session store of
+// oauth2-proxy does at the given time.
+func encodeTestSessionCookie(
+	t *testing.T,
+	name string,
+	secret string,
+	written time.Time,
+	session map[string]any,
+) string {
+	t.Helper()
+
+	packed, err := msgpack.Marshal(session)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var frame bytes.Buffer
+	zw := lz4.NewWriter(&frame)
+	if _, err := zw.Write(packed); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	key := NewOAuth2ProxySessionCookie(name, secret, 0).cipherKey
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := make([]byte, aes.BlockSize+frame.Len())
+	if _, err := rand.Read(ciphertext[:aes.BlockSize]); err != nil {
+		t.Fatal(err)
+	}
+	//nolint:staticcheck // CFB is what oauth2-proxy uses for cookies.
+	cipher.NewCFBEncrypter(block, ciphertext[:aes.BlockSize]).XORKeyStream(
+		ciphertext[aes.BlockSize:], frame.Bytes(),
+	)
+
+	value := base64.URLEncoding.EncodeToString(ciphertext)
+	timestamp := strconv.FormatInt(written.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(name + value + timestamp))
+	signature := base64.URLEncoding.EncodeToString(mac.Sum(nil))
+
+	return fmt.Sprintf("%s|%s|%s", value, timestamp, signature)
+}
+
+func TestOAuth2ProxySessionCookie_Decode(t *testing.T) {
+	expiresOn := time.Unix(1_700_000_000, 0)
+	value := encodeTestSessionCookie(t, "_oauth2_proxy", testCookieSecret, time.Now(), map[string]any{
+		"at": "access",
+		"it": "id",
+		"rt": "refresh",
+		"e":  "alice@example.com",
+		"eo": expiresOn,
+		"u":  "alice",
+	})
+
+	// Split cookie into chunks like oauth2-proxy does for large cookies.
+	headers := http.Header{"Cookie": {fmt.Sprintf(
+		"_oauth2_proxy_0=%s; _oauth2_proxy_1=%s", value[:20], value[20:],
+	)}}
+
+	session, err := NewOAuth2ProxySessionCookie(
+		"_oauth2_proxy", testCookieSecret, 168*time.Hour,
+	).Decode(headers)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := OAuth2ProxySession{
+		AccessToken:  "access",
+		IDToken:      "id",
+		RefreshToken: "refresh",
+		ExpiresOn:    expiresOn,
+		Email:        "alice@example.com",
+		User:         "alice",
+	}
+	if session != want {
+		t.Errorf("Wrong session: got %+v, want %+v", session, want)
+	}
+}
+
+func TestOAuth2ProxySessionCookie_DecodeErrors(t *testing.T) {
+	value := encodeTestSessionCookie(
+		t, "_oauth2_proxy", testCookieSecret, time.Now(), map[string]any{"at": "x"},
+	)
+	expired := encodeTestSessionCookie(
+		t, "_oauth2_proxy", testCookieSecret, time.Now().Add(-169*time.Hour), map[string]any{"at": "x"},
+	)
+	future := encodeTestSessionCookie(
+		t, "_oauth2_proxy", testCookieSecret, time.Now().Add(10*time.Minute), map[string]any{"at": "x"},
+	)
+
+	for _, tc := range []struct {
+		name        string
+		cookieName  string
+		secret      string
+		cookie      string
+		expectedErr error
+	}{{
+		name:        "1_missing",
+		cookieName:  "_oauth2_proxy",
+		secret:      testCookieSecret,
+		cookie:      "other=x",
+		expectedErr: ErrSessionCookieMissing,
+	}, {
+		name:        "2_wrong_secret",
+		cookieName:  "_oauth2_proxy",
+		secret:      "lolololololololololololololololo",
+		cookie:      "_oauth2_proxy=" + value,
+		expectedErr: ErrSessionCookieSignature,
+	}, {
+		name:        "3_wrong_name",
+		cookieName:  "_other",
+		secret:      testCookieSecret,
+		cookie:      "_other=" + value,
+		expectedErr: ErrSessionCookieSignature,
+	}, {
+		name:        "4_not_signed",
+		cookieName:  "_oauth2_proxy",
+		secret:      testCookieSecret,
+		cookie:      "_oauth2_proxy=abc",
+		expectedErr: ErrSessionCookieSignature,
+	}, {
+		name:        "5_expired",
+		cookieName:  "_oauth2_proxy",
+		secret:      testCookieSecret,
+		cookie:      "_oauth2_proxy=" + expired,
+		expectedErr: ErrSessionCookieExpired,
+	}, {
+		name:        "6_future",
+		cookieName:  "_oauth2_proxy",
+		secret:      testCookieSecret,
+		cookie:      "_oauth2_proxy=" + future,
+		expectedErr: ErrSessionCookieExpired,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewOAuth2ProxySessionCookie(tc.cookieName, tc.secret, 168*time.Hour).Decode(
+				http.Header{"Cookie": {tc.cookie}},
+			)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Errorf("Wrong error: got %v, want %v", err, tc.expectedErr)
+			}
+		})
+	}
+}
+
+func TestOAuth2ProxySessionCookie_DecodeFixture(t *testing.T) {
+	// Written by the cookie session store of oauth2-proxy v7.5.1 with the
+	// secret testCookieSecret at the Unix time 1700000000. Token values are
+	// long enough to be compressed by LZ4.
+	cookie, err := os.ReadFile("testdata/oauth2-proxy-v7.5.1-session-cookie.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewOAuth2ProxySessionCookie("_oauth2_proxy", testCookieSecret, 168*time.Hour)
+	decoder.now = func() time.Time { return time.Unix(1_700_000_060, 0) }
+
+	session, err := decoder.Decode(http.Header{"Cookie": {strings.TrimSpace(string(cookie))}})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	filler := strings.Repeat("abcdefgh", 16)
+	want := OAuth2ProxySession{
+		AccessToken:  "access." + filler,
+		IDToken:      "id." + filler,
+		RefreshToken: "refresh." + filler,
+		ExpiresOn:    time.Unix(1_700_003_600, 0),
+		Email:        "alice@example.com",
+		User:         "alice",
+	}
+	if !session.ExpiresOn.Equal(want.ExpiresOn) {
+		t.Errorf("Wrong expiry: got %v, want %v", session.ExpiresOn, want.ExpiresOn)
+	}
+	session.ExpiresOn = want.ExpiresOn
+	if session != want {
+		t.Errorf("Wrong session: got %+v, want %+v", session, want)
+	}
+
+	decoder.now = func() time.Time { return time.Unix(1_700_000_000, 0).Add(169 * time.Hour) }
+
+	_, err = decoder.Decode(http.Header{"Cookie": {strings.TrimSpace(string(cookie))}})
+	if !errors.Is(err, ErrSessionCookieExpired) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrSessionCookieExpired)
+	}
+}
+
+func FuzzDecodeOAuth2ProxySessionState(f *testing.F) {
+	packed, err := msgpack.Marshal(map[string]any{"at": "access", "eo": time.Unix(1, 0)})
+	if err != nil {
+		f.Fatal(err)
+	}
+	var frame bytes.Buffer
+	zw := lz4.NewWriter(&frame)
+	_, _ = zw.Write(packed)
+	_ = zw.Close()
+
+	f.Add(packed)
+	f.Add(frame.Bytes())
+	f.Add([]byte{0x04, 0x22, 0x4d, 0x18})
+	f.Add([]byte{0xdf, 0xff, 0xff, 0xff, 0xff})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Must not panic or hang. Errors are fine.
+		_, _ = decodeOAuth2ProxySessionState(data)
+	})
+}
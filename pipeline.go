@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+)
+
+// TokenPipeline bundles the steps a token goes through before it is handed
+// out: extraction with the TokenProfiles, introspection of tokens of profiles
+// with the token type TokenTypeAccessToken, and exchange if requested by the
+// client. Optional steps are skipped if their component is nil.
+//
+// The methods write an HTTP error to w if a step fails. Left for the caller is
+// to return if a method returns false.
+type TokenPipeline struct {
+	profiles     TokenProfiles
+	introspector *TokenIntrospector
+	exchanger    *TokenExchanger
+
+	notFoundStatus int
+}
+
+// NotFoundStatus returns the status code of responses to requests without
+// token.
+func (p TokenPipeline) NotFoundStatus() int {
+	if p.notFoundStatus == 0 {
+		return StatusTokenNotFound
+	}
+
+	return p.notFoundStatus
+}
+
+// ExtractToken runs the token of the named profile through the pipeline. An
+// empty name selects the DefaultTokenProfile. The token is exchanged if the
+// query parameters of r request it. The subject token type of the exchange is
+// the token type of the profile, except for the DefaultTokenProfile, which
+// uses the one the TokenExchanger has been created with.
+func (p TokenPipeline) ExtractToken(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+) (Token, bool) {
+	token, _, ok := p.ExtractTokenAndSource(w, r, name)
+	return token, ok
+}
+
+// ExtractTokenAndSource works like ExtractToken and additionally returns the
+// token as extracted from the sources, before introspection and exchange.
+func (p TokenPipeline) ExtractTokenAndSource(
+	w http.ResponseWriter,
+	r *http.Request,
+	name string,
+) (Token, Token, bool) {
+	if len(name) == 0 {
+		name = DefaultTokenProfile
+	}
+
+	sources, err := p.profiles.Get(name)
+	if !IsSucceededExtractToken(w, r, p.NotFoundStatus(), nil, err) {
+		return Token{}, Token{}, false
+	}
+
+	source, err := sources.Extract(r)
+	if !IsSucceededExtractToken(w, r, p.NotFoundStatus(), sources.Names(), err) {
+		return Token{}, Token{}, false
+	}
+
+	token := source
+	if p.profiles.TokenType(name) == TokenTypeAccessToken {
+		token, err = p.introspector.Introspect(r.Context(), token)
+		if !IsSucceededIntrospectToken(w, r, err) {
+			return Token{}, Token{}, false
+		}
+	}
+
+	var subjectTokenType string
+	if name != DefaultTokenProfile {
+		subjectTokenType = p.profiles.TokenType(name)
+	}
+
+	token, ok := p.Exchange(w, r, token, subjectTokenType)
+	return token, source, ok
+}
+
+// ExtractBundle runs the tokens of the named profiles through the pipeline.
+// See TokenProfiles.ExtractBundle for the meaning of names. Token bundles
+// can't be exchanged, so the request is refused if its query parameters ask
+// for an exchange.
+func (p TokenPipeline) ExtractBundle(
+	w http.ResponseWriter,
+	r *http.Request,
+	names []string,
+) (TokenBundle, bool) {
+	if _, exchange := ParseTokenExchangeRequest(r.URL.Query()); exchange {
+		msg := "Bad Request. Token exchange not supported for token bundles."
+		WriteProblem(w, r, http.StatusBadRequest, "TokenExchangeNotSupported", msg)
+		return nil, false
+	}
+
+	bundle, err := p.profiles.ExtractBundle(r, names)
+	if !IsSucceededExtractToken(w, r, p.NotFoundStatus(), p.profiles.AllNames(), err) {
+		return nil, false
+	}
+
+	for _, name := range sortedKeys(bundle) {
+		if p.profiles.TokenType(name) != TokenTypeAccessToken {
+			continue
+		}
+		bundle[name], err = p.introspector.Introspect(r.Context(), bundle[name])
+		if !IsSucceededIntrospectToken(w, r, err) {
+			return nil, false
+		}
+	}
+
+	return bundle, true
+}
+
+// Process runs an access token that has not been extracted with the
+// TokenProfiles, like a refreshed one, through introspection and, if the query
+// parameters of r request it, exchange.
+func (p TokenPipeline) Process(
+	w http.ResponseWriter,
+	r *http.Request,
+	token Token,
+) (Token, bool) {
+	token, err := p.introspector.Introspect(r.Context(), token)
+	if !IsSucceededIntrospectToken(w, r, err) {
+		return Token{}, false
+	}
+
+	return p.Exchange(w, r, token, TokenTypeAccessToken)
+}
+
+// Exchange exchanges the given token of the given subject token type if the
+// query parameters of r request it. Otherwise the token is returned as it is.
+// See TokenExchanger.Exchange for the meaning of an empty subjectTokenType.
+func (p TokenPipeline) Exchange(
+	w http.ResponseWriter,
+	r *http.Request,
+	token Token,
+	subjectTokenType string,
+) (Token, bool) {
+	exchangeRequest, exchange := ParseTokenExchangeRequest(r.URL.Query())
+	if !exchange {
+		return token, true
+	}
+
+	token, err := p.exchanger.Exchange(r.Context(), token, subjectTokenType, exchangeRequest)
+	if !IsSucceededExchangeToken(w, r, err) {
+		return Token{}, false
+	}
+
+	return token, true
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestTokenPipeline(t *testing.T) TokenPipeline {
+	t.Helper()
+
+	var calls atomic.Int32
+	server := newTestIntrospectionServer(t, time.Now().Add(time.Minute), &calls)
+
+	return TokenPipeline{
+		profiles: newTestTokenProfiles(t, []string{"Authorization"}, ""),
+		introspector: NewTokenIntrospector(
+			NewOAuth2Client(server.URL, "client", "secret"), "access_token",
+		),
+	}
+}
+
+func TestTokenPipeline_Introspection(t *testing.T) {
+	router := initRouter(RouterArgs{tokenPipeline: newTestTokenPipeline(t)})
+
+	aPublic1, err := os.ReadFile("testdata/a-public-key-rsa2048-rfc5280-x509.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect := "/flow/redirect/token?" + url.Values{
+		"target":        {"https://example.com"},
+		"state":         {"state"},
+		"publicKeyType": {"rsa2048-rfc5280-x509-pem"},
+		"publicKey":     {string(aPublic1)},
+	}.Encode()
+
+	for _, tc := range []struct {
+		name         string
+		target       string
+		secret       string
+		expectedCode int
+		expectedBody string
+	}{{
+		name:         "1_active",
+		target:       "/token",
+		secret:       "active",
+		expectedCode: 200,
+		expectedBody: `"introspection":{"active":true`,
+	}, {
+		name:         "2_inactive",
+		target:       "/token",
+		secret:       "inactive",
+		expectedCode: 403,
+		expectedBody: "ErrTokenInactive",
+	}, {
+		name:         "3_failed",
+		target:       "/token",
+		secret:       "boom",
+		expectedCode: 502,
+		expectedBody: "ErrTokenIntrospectionFailed",
+	}, {
+		name:         "4_bundle_inactive",
+		target:       "/token?all",
+		secret:       "inactive",
+		expectedCode: 403,
+		expectedBody: "ErrTokenInactive",
+	}, {
+		name:         "5_other_profile_skipped",
+		target:       "/token/id",
+		secret:       "inactive",
+		expectedCode: 200,
+		expectedBody: `"secret":"i"`,
+	}, {
+		name:         "6_redirect_active",
+		target:       redirect,
+		secret:       "active",
+		expectedCode: 301,
+		expectedBody: "",
+	}, {
+		name:         "7_redirect_inactive",
+		target:       redirect,
+		secret:       "inactive",
+		expectedCode: 403,
+		expectedBody: "ErrTokenInactive",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", tc.target, nil)
+			request.Header.Set("Authorization", "Bearer "+tc.secret)
+			request.Header.Set("X-Id-Token", "i")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, request)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong status code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if !strings.Contains(rr.Body.String(), tc.expectedBody) {
+				t.Errorf("Did not find '%v' in '%v'", tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestTokenPipeline_IntrospectionNamedProfile(t *testing.T) {
+	var calls atomic.Int32
+	server := newTestIntrospectionServer(t, time.Now().Add(time.Minute), &calls)
+
+	profiles, err := NewTokenProfiles(
+		newTestTokenSourceChain(t, []string{"Authorization"}, ""),
+		map[string][]TokenSourceSpec{
+			"graph": {{Type: "header", Names: []string{"X-Graph-Token"}}},
+			"id":    {{Type: "header", Names: []string{"X-Id-Token"}}},
+			"jwt":   {{Type: "header", Names: []string{"X-Jwt"}}},
+		},
+		map[string]string{"jwt": "urn:ietf:params:oauth:token-type:jwt"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initRouter(RouterArgs{tokenPipeline: TokenPipeline{
+		profiles: profiles,
+		introspector: NewTokenIntrospector(
+			NewOAuth2Client(server.URL, "client", "secret"), "access_token",
+		),
+	}})
+
+	for _, tc := range []struct {
+		name         string
+		target       string
+		expectedCode int
+		expectedBody string
+	}{{
+		name:         "1_named_access",
+		target:       "/token/graph",
+		expectedCode: 403,
+		expectedBody: "ErrTokenInactive",
+	}, {
+		name:         "2_id_skipped",
+		target:       "/token/id",
+		expectedCode: 200,
+		expectedBody: `"secret":"inactive"`,
+	}, {
+		name:         "3_configured_type_skipped",
+		target:       "/token/jwt",
+		expectedCode: 200,
+		expectedBody: `"secret":"inactive"`,
+	}, {
+		name:         "4_bundle",
+		target:       "/token?all",
+		expectedCode: 403,
+		expectedBody: "ErrTokenInactive",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", tc.target, nil)
+			request.Header.Set("Authorization", "Bearer active")
+			request.Header.Set("X-Graph-Token", "inactive")
+			request.Header.Set("X-Id-Token", "inactive")
+			request.Header.Set("X-Jwt", "inactive")
+
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, request)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong status code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if !strings.Contains(rr.Body.String(), tc.expectedBody) {
+				t.Errorf("Did not find '%v' in '%v'", tc.expectedBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestTokenPipeline_ExtractTokenAndSource(t *testing.T) {
+	pipeline := newTestTokenPipeline(t)
+
+	r := httptest.NewRequest("GET", "/token", nil)
+	r.Header.Set("Authorization", "active")
+	rr := httptest.NewRecorder()
+
+	token, source, ok := pipeline.ExtractTokenAndSource(rr, r, "")
+	if !ok {
+		t.Fatalf("Unexpected failure: got %v %q", rr.Code, rr.Body.String())
+	}
+	if token.Introspection == nil {
+		t.Error("Wrong token: got no introspection, want introspection")
+	}
+	if source.Introspection != nil {
+		t.Errorf("Wrong source: got introspection %v, want none", source.Introspection)
+	}
+	if source.Fingerprint != token.Fingerprint {
+		t.Errorf("Wrong fingerprint: got %q, want %q", source.Fingerprint, token.Fingerprint)
+	}
+}
+
+func TestTokenPipeline_NotFoundStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		notFoundStatus      int
+		accept              string
+		expectedCode        int
+		expectedContentType string
+	}{{
+		name:                "1_default",
+		notFoundStatus:      0,
+		expectedCode:        444,
+		expectedContentType: "text/plain; charset=utf-8",
+	}, {
+		name:                "2_custom",
+		notFoundStatus:      401,
+		expectedCode:        401,
+		expectedContentType: "text/plain; charset=utf-8",
+	}, {
+		name:                "3_problem_json",
+		notFoundStatus:      404,
+		accept:              "application/json",
+		expectedCode:        404,
+		expectedContentType: "application/problem+json",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			router := initRouter(RouterArgs{tokenPipeline: TokenPipeline{
+				profiles:       newTestTokenProfiles(t, []string{"Authorization"}, ""),
+				notFoundStatus: tc.notFoundStatus,
+			}})
+
+			r := httptest.NewRequest("GET", "/token", nil)
+			if len(tc.accept) > 0 {
+				r.Header.Set("Accept", tc.accept)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			contentType := rr.Header().Get("Content-Type")
+			if contentType != tc.expectedContentType {
+				t.Errorf("Wrong content type: got %q, want %q", contentType, tc.expectedContentType)
+			}
+			if !strings.Contains(rr.Body.String(), `"code":"TokenNotFound"`) &&
+				tc.expectedContentType == "application/problem+json" {
+				t.Errorf("Didn't find code in body: got %q", rr.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ProblemTypeURIPrefix is the prefix of the type URI of every problem. The
+// problem code is appended to it. Both are stable and can be matched by
+// clients.
+const ProblemTypeURIPrefix = "urn:token2go:problem:"
+
+// StatusTokenNotFound is the non-standard default status code of responses
+// to requests without token.
+const StatusTokenNotFound = 444
+
+// Problem is a Problem Details object according to RFC 7807 with the
+// extension member "code".
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Code   string `json:"code"`
+}
+
+// NewProblem creates a Problem with the given status, code, and detail. The
+// type URI is derived from the code and the title from the status.
+func NewProblem(status int, code string, detail string) Problem {
+	title := http.StatusText(status)
+	if status == StatusTokenNotFound {
+		title = "Token Not Found"
+	}
+	if len(title) == 0 {
+		title = "Error"
+	}
+
+	return Problem{
+		Type:   ProblemTypeURIPrefix + code,
+		Title:  title,
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// WriteProblem writes an error response. Clients that prefer JSON according
+// to their Accept header get a Problem encoded as "application/problem+json".
+// All other clients get msg as plain text like with http.Error.
+func WriteProblem(
+	w http.ResponseWriter,
+	r *http.Request,
+	status int,
+	code string,
+	msg string,
+) {
+	if !PrefersJSON(r) {
+		http.Error(w, msg, status)
+		return
+	}
+
+	b, err := json.Marshal(NewProblem(status, code, msg))
+	if err != nil {
+		http.Error(w, msg, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_, _ = w.Write(b)
+}
+
+// WriteInternalError logs err and writes an error response with status code
+// 500 like WriteProblem. The error is only logged and not part of msg, because
+// it may contain internal details like file paths.
+func WriteInternalError(
+	w http.ResponseWriter,
+	r *http.Request,
+	code string,
+	msg string,
+	err error,
+) {
+	log.Printf("%s %s: %s %v", r.Method, r.URL.Path, msg, err)
+	WriteProblem(w, r, http.StatusInternalServerError, code, msg)
+}
+
+// PrefersJSON checks if the Accept header of the request ranks JSON higher
+// than plain text. Wildcards other than "text/*" are ignored. Ties go to plain
+// text.
+func PrefersJSON(r *http.Request) bool {
+	qJSON, qText := acceptQualities(r)
+
+	return qJSON > qText
+}
+
+// acceptQualities returns the highest quality values the Accept header of the
+// request assigns to JSON and to plain text. Zero means not accepted
+// explicitly. Wildcards other than "text/*" are ignored.
+func acceptQualities(r *http.Request) (float64, float64) {
+	var qJSON, qText float64
+
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				q, err = strconv.ParseFloat(v, 64)
+				if err != nil {
+					continue
+				}
+			}
+
+			switch mediaType {
+			case "application/problem+json", "application/json":
+				qJSON = maxFloat(qJSON, q)
+			case "text/plain", "text/*":
+				qText = maxFloat(qText, q)
+			}
+		}
+	}
+
+	return qJSON, qText
+}
+
+// maxFloat returns the larger of the given numbers.
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+
+	return b
+}
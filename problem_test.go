@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewProblem(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		status        int
+		code          string
+		expectedTitle string
+	}{{
+		name:          "1_standard",
+		status:        400,
+		code:          "PEMDecodeFailed",
+		expectedTitle: "Bad Request",
+	}, {
+		name:          "2_token_not_found",
+		status:        444,
+		code:          "TokenNotFound",
+		expectedTitle: "Token Not Found",
+	}, {
+		name:          "3_unknown",
+		status:        499,
+		code:          "X",
+		expectedTitle: "Error",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := NewProblem(tc.status, tc.code, "detail")
+
+			if p.Title != tc.expectedTitle {
+				t.Errorf("Wrong title: got %q, want %q", p.Title, tc.expectedTitle)
+			}
+			if p.Type != ProblemTypeURIPrefix+tc.code {
+				t.Errorf("Wrong type: got %q, want %q", p.Type, ProblemTypeURIPrefix+tc.code)
+			}
+			if p.Status != tc.status {
+				t.Errorf("Wrong status: got %v, want %v", p.Status, tc.status)
+			}
+		})
+	}
+}
+
+func TestWriteProblem(t *testing.T) {
+	for _, tc := range []struct {
+		name                string
+		accept              string
+		expectedContentType string
+	}{{
+		name:                "1_no_accept",
+		accept:              "",
+		expectedContentType: "text/plain; charset=utf-8",
+	}, {
+		name:                "2_json",
+		accept:              "application/json",
+		expectedContentType: "application/problem+json",
+	}, {
+		name:                "3_problem_json",
+		accept:              "application/problem+json",
+		expectedContentType: "application/problem+json",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if len(tc.accept) > 0 {
+				r.Header.Set("Accept", tc.accept)
+			}
+			rr := httptest.NewRecorder()
+
+			WriteProblem(rr, r, 444, "TokenNotFound", "Token not found.")
+
+			if rr.Code != 444 {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, 444)
+			}
+			contentType := rr.Header().Get("Content-Type")
+			if contentType != tc.expectedContentType {
+				t.Errorf("Wrong content type: got %q, want %q", contentType, tc.expectedContentType)
+			}
+
+			if tc.expectedContentType != "application/problem+json" {
+				return
+			}
+
+			var p Problem
+			err := json.Unmarshal(rr.Body.Bytes(), &p)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			expected := NewProblem(444, "TokenNotFound", "Token not found.")
+			if p != expected {
+				t.Errorf("Wrong problem: got %+v, want %+v", p, expected)
+			}
+		})
+	}
+}
+
+func TestWriteInternalError(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+
+	WriteInternalError(rr, r, "InternalError", "Internal Server Error.", errors.New("open /etc/secret"))
+
+	if rr.Code != 500 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 500)
+	}
+	if strings.Contains(rr.Body.String(), "/etc/secret") {
+		t.Errorf("Error leaked into body: got %q", rr.Body.String())
+	}
+
+	var problem Problem
+	if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if problem.Detail != "Internal Server Error." {
+		t.Errorf("Wrong detail: got %v, want %v", problem.Detail, "Internal Server Error.")
+	}
+}
+
+func TestPrefersJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		accept         []string
+		expectedResult bool
+	}{{
+		name:           "1_none",
+		accept:         nil,
+		expectedResult: false,
+	}, {
+		name:           "2_json",
+		accept:         []string{"application/json"},
+		expectedResult: true,
+	}, {
+		name:           "3_wildcard",
+		accept:         []string{"*/*"},
+		expectedResult: false,
+	}, {
+		name:           "4_browser",
+		accept:         []string{"text/html,application/xhtml+xml,*/*;q=0.8"},
+		expectedResult: false,
+	}, {
+		name:           "5_tie",
+		accept:         []string{"text/plain, application/json"},
+		expectedResult: false,
+	}, {
+		name:           "6_json_higher",
+		accept:         []string{"text/*;q=0.5, application/problem+json"},
+		expectedResult: true,
+	}, {
+		name:           "7_text_higher",
+		accept:         []string{"application/json;q=0.5", "text/plain"},
+		expectedResult: false,
+	}, {
+		name:           "8_malformed_q",
+		accept:         []string{"application/json;q=lol"},
+		expectedResult: false,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			for _, accept := range tc.accept {
+				r.Header.Add("Accept", accept)
+			}
+
+			result := PrefersJSON(r)
+			if result != tc.expectedResult {
+				t.Errorf("Wrong result: got %v, want %v", result, tc.expectedResult)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+)
+
+// DefaultTokenProfile is the name of the profile served by "/token" and used
+// by flows if no profile is requested explicitly.
+const DefaultTokenProfile = "access"
+
+// TokenProfile is a named TokenSourceChain. Profiles allow serving multiple
+// tokens like access, ID, and refresh token for the same request. The token
+// type is the RFC 8693 token type identifier of the tokens of the profile.
+type TokenProfile struct {
+	name      string
+	tokenType string
+	sources   TokenSourceChain
+}
+
+// TokenProfiles is an ordered list of token profiles. The first profile is
+// always the DefaultTokenProfile. Use NewTokenProfiles to construct.
+type TokenProfiles []TokenProfile
+
+// TokenBundle maps profile names to tokens extracted with the profile.
+type TokenBundle map[string]Token
+
+var ErrTokenProfileUnknown = errors.New("unknown token profile")
+
+var tokenProfileNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// NewTokenProfiles creates profiles from the given default chain and the given
+// additional named source specs. Profiles are ordered by name after the
+// default profile. Token types are taken from tokenTypes, falling back to
+// DefaultTokenType. Returns an error if a name is invalid, a chain can't be
+// created, or tokenTypes names an unknown profile.
+func NewTokenProfiles(
+	defaultSources TokenSourceChain,
+	specs map[string][]TokenSourceSpec,
+	tokenTypes map[string]string,
+) (TokenProfiles, error) {
+	for _, name := range sortedKeys(tokenTypes) {
+		if _, ok := specs[name]; !ok && name != DefaultTokenProfile {
+			return nil, fmt.Errorf("token type for unknown token profile %q", name)
+		}
+	}
+
+	tokenType := func(name string) string {
+		if t := tokenTypes[name]; len(t) > 0 {
+			return t
+		}
+		return DefaultTokenType(name)
+	}
+
+	profiles := TokenProfiles{{DefaultTokenProfile, tokenType(DefaultTokenProfile), defaultSources}}
+
+	for _, name := range sortedKeys(specs) {
+		if name == DefaultTokenProfile {
+			return nil, fmt.Errorf("token profile %q is reserved", name)
+		}
+		if !tokenProfileNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("token profile %q: invalid name", name)
+		}
+
+		sources, err := NewTokenSourceChain(specs[name])
+		if err != nil {
+			return nil, fmt.Errorf("token profile %q: %w", name, err)
+		}
+
+		profiles = append(profiles, TokenProfile{name, tokenType(name), sources})
+	}
+
+	return profiles, nil
+}
+
+// ParseTokenProfileSpecs unmarshals a JSON object that maps profile names to
+// arrays of TokenSourceSpec.
+func ParseTokenProfileSpecs(s string) (map[string][]TokenSourceSpec, error) {
+	var specs map[string][]TokenSourceSpec
+
+	err := json.Unmarshal([]byte(s), &specs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token profile specs: %w", err)
+	}
+
+	return specs, nil
+}
+
+// DefaultTokenType returns the token type of the profile with the given name
+// if not configured otherwise. Profiles named "id" and "refresh" hold ID and
+// refresh tokens, all others access tokens.
+func DefaultTokenType(name string) string {
+	switch name {
+	case "id":
+		return TokenTypeIDToken
+	case "refresh":
+		return TokenTypeRefreshToken
+	default:
+		return TokenTypeAccessToken
+	}
+}
+
+// ParseTokenProfileTokenTypes unmarshals a JSON object that maps profile names
+// to RFC 8693 token type identifiers.
+func ParseTokenProfileTokenTypes(s string) (map[string]string, error) {
+	var tokenTypes map[string]string
+
+	err := json.Unmarshal([]byte(s), &tokenTypes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token types: %w", err)
+	}
+
+	return tokenTypes, nil
+}
+
+// Default returns the chain of the DefaultTokenProfile.
+func (p TokenProfiles) Default() TokenSourceChain {
+	return p[0].sources
+}
+
+// Get returns the chain of the profile with the given name. Returns
+// ErrTokenProfileUnknown if there is no such profile.
+func (p TokenProfiles) Get(name string) (TokenSourceChain, error) {
+	for _, profile := range p {
+		if profile.name == name {
+			return profile.sources, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrTokenProfileUnknown, name)
+}
+
+// TokenType returns the token type of the profile with the given name. Returns
+// the empty string if there is no such profile.
+func (p TokenProfiles) TokenType(name string) string {
+	for _, profile := range p {
+		if profile.name == name {
+			return profile.tokenType
+		}
+	}
+
+	return ""
+}
+
+// Names returns the names of all profiles in order.
+func (p TokenProfiles) Names() []string {
+	names := make([]string, len(p))
+	for i, profile := range p {
+		names[i] = profile.name
+	}
+
+	return names
+}
+
+// ExtractBundle extracts tokens for all profiles with the given names. If
+// names is empty, all profiles are used and profiles without a token are
+// left out. Otherwise every named profile must offer a token. Returns
+// ErrTokenNotFound if the bundle would be empty or a named profile has no
+// token, and ErrTokenProfileUnknown for unknown names.
+func (p TokenProfiles) ExtractBundle(r *http.Request, names []string) (TokenBundle, error) {
+	bundle := TokenBundle{}
+
+	if len(names) == 0 {
+		for _, profile := range p {
+			token, err := profile.sources.Extract(r)
+			if errors.Is(err, ErrTokenNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			bundle[profile.name] = token
+		}
+
+		if len(bundle) == 0 {
+			return nil, ErrTokenNotFound
+		}
+
+		return bundle, nil
+	}
+
+	for _, name := range names {
+		sources, err := p.Get(name)
+		if err != nil {
+			return nil, err
+		}
+
+		token, err := sources.Extract(r)
+		if err != nil {
+			return nil, fmt.Errorf("token profile %q: %w", name, err)
+		}
+
+		bundle[name] = token
+	}
+
+	return bundle, nil
+}
+
+// AllNames returns the names of all sources of all profiles. Used to inform
+// clients what Token2go is looking for.
+func (p TokenProfiles) AllNames() []string {
+	var names []string
+	for _, profile := range p {
+		for _, name := range profile.sources.Names() {
+			names = append(names, profile.name+"/"+name)
+		}
+	}
+
+	return names
+}
+
+// sortedKeys returns the keys of the given map in ascending order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
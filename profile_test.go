@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func newTestTokenProfiles(
+	t *testing.T,
+	tokenHeaderNames []string,
+	fallbackToken string,
+) TokenProfiles {
+	t.Helper()
+
+	profiles, err := NewTokenProfiles(
+		newTestTokenSourceChain(t, tokenHeaderNames, fallbackToken),
+		map[string][]TokenSourceSpec{
+			"id":      {{Type: "header", Names: []string{"X-Id-Token"}}},
+			"refresh": {{Type: "header", Names: []string{"X-Refresh-Token"}}},
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return profiles
+}
+
+func TestNewTokenProfiles(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		specs         map[string][]TokenSourceSpec
+		expectedNames []string
+		expectedError bool
+	}{{
+		name:          "1_none",
+		specs:         nil,
+		expectedNames: []string{"access"},
+	}, {
+		name: "2_sorted",
+		specs: map[string][]TokenSourceSpec{
+			"refresh": {{Type: "header", Names: []string{"R"}}},
+			"id":      {{Type: "header", Names: []string{"I"}}},
+		},
+		expectedNames: []string{"access", "id", "refresh"},
+	}, {
+		name: "3_reserved",
+		specs: map[string][]TokenSourceSpec{
+			"access": {{Type: "header", Names: []string{"A"}}},
+		},
+		expectedError: true,
+	}, {
+		name: "4_invalid_name",
+		specs: map[string][]TokenSourceSpec{
+			"ID Token": {{Type: "header", Names: []string{"I"}}},
+		},
+		expectedError: true,
+	}, {
+		name: "5_invalid_spec",
+		specs: map[string][]TokenSourceSpec{
+			"id": {{Type: "nope"}},
+		},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			profiles, err := NewTokenProfiles(nil, tc.specs, nil)
+			if tc.expectedError {
+				if err == nil {
+					t.Errorf("Expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if got := profiles.Names(); !reflect.DeepEqual(got, tc.expectedNames) {
+				t.Errorf("Wrong names: got %v, want %v", got, tc.expectedNames)
+			}
+		})
+	}
+}
+
+func TestTokenProfiles_TokenType(t *testing.T) {
+	profiles, err := NewTokenProfiles(nil, map[string][]TokenSourceSpec{
+		"graph":   {{Type: "header", Names: []string{"G"}}},
+		"id":      {{Type: "header", Names: []string{"I"}}},
+		"refresh": {{Type: "header", Names: []string{"R"}}},
+	}, map[string]string{"graph": "urn:ietf:params:oauth:token-type:jwt"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"access":  TokenTypeAccessToken,
+		"graph":   "urn:ietf:params:oauth:token-type:jwt",
+		"id":      TokenTypeIDToken,
+		"refresh": TokenTypeRefreshToken,
+		"unknown": "",
+	} {
+		if got := profiles.TokenType(name); got != want {
+			t.Errorf("Wrong token type of %q: got %q, want %q", name, got, want)
+		}
+	}
+
+	_, err = NewTokenProfiles(nil, nil, map[string]string{"id": TokenTypeIDToken})
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
+
+func TestParseTokenProfileSpecs(t *testing.T) {
+	specs, err := ParseTokenProfileSpecs(
+		`{"id": [{"type": "header", "names": ["X-Id-Token"]}]}`,
+	)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	want := map[string][]TokenSourceSpec{
+		"id": {{Type: "header", Names: []string{"X-Id-Token"}}},
+	}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("Wrong specs: got %v, want %v", specs, want)
+	}
+
+	_, err = ParseTokenProfileSpecs(`[]`)
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestTokenProfiles_ExtractBundle(t *testing.T) {
+	profiles := newTestTokenProfiles(t, []string{"Authorization"}, "")
+
+	for _, tc := range []struct {
+		name            string
+		headers         http.Header
+		names           []string
+		expectedSecrets map[string]string
+		expectedError   error
+	}{{
+		name: "1_all",
+		headers: http.Header{
+			"Authorization": {"Bearer a"},
+			"X-Id-Token":    {"i"},
+		},
+		names:           nil,
+		expectedSecrets: map[string]string{"access": "a", "id": "i"},
+	}, {
+		name:          "2_all_none",
+		headers:       http.Header{},
+		names:         nil,
+		expectedError: ErrTokenNotFound,
+	}, {
+		name: "3_named",
+		headers: http.Header{
+			"Authorization":   {"Bearer a"},
+			"X-Id-Token":      {"i"},
+			"X-Refresh-Token": {"r"},
+		},
+		names:           []string{"refresh"},
+		expectedSecrets: map[string]string{"refresh": "r"},
+	}, {
+		name:          "4_named_missing",
+		headers:       http.Header{"Authorization": {"Bearer a"}},
+		names:         []string{"access", "id"},
+		expectedError: ErrTokenNotFound,
+	}, {
+		name:          "5_named_unknown",
+		headers:       http.Header{"Authorization": {"Bearer a"}},
+		names:         []string{"nope"},
+		expectedError: ErrTokenProfileUnknown,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := http.NewRequestWithContext(context.TODO(), "GET", "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Header = tc.headers
+
+			bundle, err := profiles.ExtractBundle(r, tc.names)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("Wrong error: got %v, want %v", err, tc.expectedError)
+			}
+
+			got := map[string]string{}
+			for name, token := range bundle {
+				got[name] = token.Secret
+			}
+			if tc.expectedError == nil && !reflect.DeepEqual(got, tc.expectedSecrets) {
+				t.Errorf("Wrong secrets: got %v, want %v", got, tc.expectedSecrets)
+			}
+		})
+	}
+}
+
+func TestTokenProfiles_AllNames(t *testing.T) {
+	profiles := newTestTokenProfiles(t, []string{"Authorization"}, "")
+
+	got := profiles.AllNames()
+	want := []string{
+		"access/header:Authorization",
+		"access/static",
+		"id/header:X-Id-Token",
+		"refresh/header:X-Refresh-Token",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Wrong names: got %v, want %v", got, want)
+	}
+}
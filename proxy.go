@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ParseTrustedProxies parses the given CIDR ranges like "10.0.0.0/8". Single
+// addresses are accepted as ranges with one address. Returns an error if an
+// entry is malformed.
+func ParseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			prefix, err := netip.ParsePrefix(entry)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+// isTrustedProxy reports whether addr is in one of the given ranges.
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Client is the client that sent a request as resolved by ResolveClient.
+type Client struct {
+	// IP address of the client.
+	IP string
+
+	// Scheme the client used, "http" or "https".
+	Scheme string
+
+	// Proxied reports whether the direct peer is a trusted proxy.
+	Proxied bool
+}
+
+// forwardedHop is a single hop of a forwarding header.
+type forwardedHop struct {
+	node  string
+	proto string
+}
+
+// clientContextKey is the key of the Client in request contexts.
+type clientContextKey struct{}
+
+// ResolveClient determines the client that sent r. If the direct peer is one
+// of the given trusted proxies, the forwarding headers are applied: The
+// header Forwarded (RFC 7239) takes precedence over X-Forwarded-For, which
+// takes precedence over X-Real-IP. Hops are walked from right to left and the
+// first address that is not a trusted proxy is the client. Addresses left of
+// it can be forged by clients and are ignored. The scheme is taken from the
+// "proto" parameter of the selected Forwarded element or from the last value
+// of X-Forwarded-Proto. Forwarding headers of other peers are ignored.
+func ResolveClient(r *http.Request, trustedProxies []netip.Prefix) Client {
+	client := Client{IP: hostWithoutPort(r.RemoteAddr), Scheme: "http"}
+	if r.TLS != nil {
+		client.Scheme = "https"
+	}
+
+	addr, err := netip.ParseAddr(client.IP)
+	if err != nil {
+		return client
+	}
+	addr = addr.Unmap()
+	client.IP = addr.String()
+
+	if !isTrustedProxy(addr, trustedProxies) {
+		return client
+	}
+	client.Proxied = true
+
+	var hops []forwardedHop
+	switch {
+	case len(r.Header.Values("Forwarded")) > 0:
+		hops = parseForwarded(r.Header.Values("Forwarded"))
+	case len(r.Header.Values("X-Forwarded-For")) > 0:
+		for _, header := range r.Header.Values("X-Forwarded-For") {
+			for _, node := range strings.Split(header, ",") {
+				hops = append(hops, forwardedHop{node: strings.TrimSpace(node)})
+			}
+		}
+		if protos := r.Header.Values("X-Forwarded-Proto"); len(protos) > 0 {
+			values := strings.Split(protos[len(protos)-1], ",")
+			client.Scheme = normalizeScheme(values[len(values)-1], client.Scheme)
+		}
+	case len(r.Header.Get("X-Real-Ip")) > 0:
+		hops = []forwardedHop{{node: strings.TrimSpace(r.Header.Get("X-Real-Ip"))}}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(hostWithoutPort(hops[i].node))
+		if err != nil {
+			break
+		}
+		client.IP = hop.Unmap().String()
+		client.Scheme = normalizeScheme(hops[i].proto, client.Scheme)
+		if !isTrustedProxy(hop.Unmap(), trustedProxies) {
+			break
+		}
+	}
+
+	return client
+}
+
+// parseForwarded parses the elements of the given Forwarded header values.
+// Parameters other than "for" and "proto" are ignored.
+func parseForwarded(headers []string) []forwardedHop {
+	var hops []forwardedHop
+
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			var hop forwardedHop
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				value = strings.Trim(strings.TrimSpace(value), `"`)
+				switch strings.ToLower(key) {
+				case "for":
+					hop.node = value
+				case "proto":
+					hop.proto = value
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+
+	return hops
+}
+
+// normalizeScheme returns the given scheme lowercased if it is "http" or
+// "https" and def otherwise.
+func normalizeScheme(scheme string, def string) string {
+	scheme = strings.ToLower(strings.TrimSpace(scheme))
+	if scheme == "http" || scheme == "https" {
+		return scheme
+	}
+
+	return def
+}
+
+// MakeClientMiddleware returns a middleware that resolves the client of
+// requests with ResolveClient and the given trusted proxies. The result is
+// stored in the request context. Retrieve it with ClientIP and ClientScheme.
+// RemoteAddr is left untouched, so it is always the direct peer.
+func MakeClientMiddleware(trustedProxies []netip.Prefix) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := ResolveClient(r, trustedProxies)
+
+			ctx := context.WithValue(r.Context(), clientContextKey{}, client)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// ClientIP returns the client IP stored in the request context by the
+// middleware from MakeClientMiddleware. Falls back to the host of RemoteAddr
+// if the middleware is not used.
+func ClientIP(r *http.Request) string {
+	if client, ok := r.Context().Value(clientContextKey{}).(Client); ok {
+		return client.IP
+	}
+
+	return hostWithoutPort(r.RemoteAddr)
+}
+
+// FromTrustedProxy reports whether the direct peer of r is a trusted proxy
+// according to the middleware from MakeClientMiddleware. Returns false if the
+// middleware is not used.
+func FromTrustedProxy(r *http.Request) bool {
+	client, _ := r.Context().Value(clientContextKey{}).(Client)
+
+	return client.Proxied
+}
+
+// ClientLogFormatter wraps a LogFormatter, so log entries report the client IP
+// from the request context instead of the direct peer. Must be used after the
+// middleware from MakeClientMiddleware.
+type ClientLogFormatter struct {
+	middleware.LogFormatter
+}
+
+func (f ClientLogFormatter) NewLogEntry(r *http.Request) middleware.LogEntry {
+	if ip := ClientIP(r); ip != hostWithoutPort(r.RemoteAddr) {
+		r = r.WithContext(r.Context())
+		r.RemoteAddr = ip
+	}
+
+	return f.LogFormatter.NewLogEntry(r)
+}
+
+// ClientScheme returns the scheme stored in the request context by the
+// middleware from MakeClientMiddleware. Falls back to "https" for TLS
+// connections and "http" otherwise if the middleware is not used.
+func ClientScheme(r *http.Request) string {
+	if client, ok := r.Context().Value(clientContextKey{}).(Client); ok {
+		return client.Scheme
+	}
+
+	if r.TLS != nil {
+		return "https"
+	}
+
+	return "http"
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		entries          []string
+		expectedError    bool
+		expectedPrefixes string
+	}{{
+		name:             "1_empty",
+		entries:          nil,
+		expectedPrefixes: "[]",
+	}, {
+		name:             "2_cidr",
+		entries:          []string{"10.1.2.3/8", "fd00::/8"},
+		expectedPrefixes: "[10.0.0.0/8 fd00::/8]",
+	}, {
+		name:             "3_single",
+		entries:          []string{"192.0.2.1", "::ffff:192.0.2.2", "::1"},
+		expectedPrefixes: "[192.0.2.1/32 192.0.2.2/32 ::1/128]",
+	}, {
+		name:          "4_invalid",
+		entries:       []string{"10.0.0.0/33"},
+		expectedError: true,
+	}, {
+		name:          "5_hostname",
+		entries:       []string{"proxy.example.com"},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			prefixes, err := ParseTrustedProxies(tc.entries)
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if got := fmt.Sprint(prefixes); got != tc.expectedPrefixes {
+				t.Errorf("Wrong prefixes: got %v, want %v", got, tc.expectedPrefixes)
+			}
+		})
+	}
+}
+
+func TestResolveClient(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		remoteAddr     string
+		tls            bool
+		headers        map[string][]string
+		expectedIP     string
+		expectedScheme string
+	}{{
+		name:           "1_direct",
+		remoteAddr:     "192.0.2.1:1234",
+		expectedIP:     "192.0.2.1",
+		expectedScheme: "http",
+	}, {
+		name:       "2_untrusted_peer",
+		remoteAddr: "192.0.2.1:1234",
+		headers: map[string][]string{
+			"X-Forwarded-For":   {"198.51.100.1"},
+			"X-Forwarded-Proto": {"https"},
+			"Forwarded":         {"for=198.51.100.1;proto=https"},
+			"X-Real-Ip":         {"198.51.100.1"},
+		},
+		expectedIP:     "192.0.2.1",
+		expectedScheme: "http",
+	}, {
+		name:           "3_untrusted_peer_tls",
+		remoteAddr:     "192.0.2.1:1234",
+		tls:            true,
+		headers:        map[string][]string{"X-Forwarded-Proto": {"http"}},
+		expectedIP:     "192.0.2.1",
+		expectedScheme: "https",
+	}, {
+		name:       "4_forwarded_for",
+		remoteAddr: "10.0.0.1:1234",
+		headers: map[string][]string{
+			"X-Forwarded-For":   {"198.51.100.1"},
+			"X-Forwarded-Proto": {"https"},
+		},
+		expectedIP:     "198.51.100.1",
+		expectedScheme: "https",
+	}, {
+		name:           "5_forwarded_for_forged_left",
+		remoteAddr:     "10.0.0.1:1234",
+		headers:        map[string][]string{"X-Forwarded-For": {"203.0.113.66, 198.51.100.1, 10.0.0.2"}},
+		expectedIP:     "198.51.100.1",
+		expectedScheme: "http",
+	}, {
+		name:           "6_forwarded_for_multiple_headers",
+		remoteAddr:     "10.0.0.1:1234",
+		headers:        map[string][]string{"X-Forwarded-For": {"198.51.100.1", "10.0.0.2"}},
+		expectedIP:     "198.51.100.1",
+		expectedScheme: "http",
+	}, {
+		name:           "7_forwarded_for_only_proxies",
+		remoteAddr:     "10.0.0.1:1234",
+		headers:        map[string][]string{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}},
+		expectedIP:     "10.0.0.3",
+		expectedScheme: "http",
+	}, {
+		name:           "8_forwarded_for_garbage",
+		remoteAddr:     "10.0.0.1:1234",
+		headers:        map[string][]string{"X-Forwarded-For": {"198.51.100.1, unknown"}},
+		expectedIP:     "10.0.0.1",
+		expectedScheme: "http",
+	}, {
+		name:       "9_forwarded_proto_last_value",
+		remoteAddr: "10.0.0.1:1234",
+		headers: map[string][]string{
+			"X-Forwarded-For":   {"198.51.100.1"},
+			"X-Forwarded-Proto": {"http, https"},
+		},
+		expectedIP:     "198.51.100.1",
+		expectedScheme: "https",
+	}, {
+		name:       "10_forwarded",
+		remoteAddr: "10.0.0.1:1234",
+		headers: map[string][]string{
+			"Forwarded":       {`for=203.0.113.66;proto=http, for="[2001:db8::1]:4711";proto=https;by=10.0.0.2`},
+			"X-Forwarded-For": {"198.51.100.1"},
+		},
+		expectedIP:     "2001:db8::1",
+		expectedScheme: "https",
+	}, {
+		name:           "11_forwarded_obfuscated",
+		remoteAddr:     "10.0.0.1:1234",
+		headers:        map[string][]string{"Forwarded": {"for=_hidden;proto=https"}},
+		expectedIP:     "10.0.0.1",
+		expectedScheme: "http",
+	}, {
+		name:           "12_forwarded_through_proxies",
+		remoteAddr:     "10.0.0.1:1234",
+		headers:        map[string][]string{"Forwarded": {"for=198.51.100.1;proto=https", "for=10.0.0.2;proto=http"}},
+		expectedIP:     "198.51.100.1",
+		expectedScheme: "https",
+	}, {
+		name:           "13_real_ip",
+		remoteAddr:     "10.0.0.1:1234",
+		headers:        map[string][]string{"X-Real-Ip": {"198.51.100.1"}},
+		expectedIP:     "198.51.100.1",
+		expectedScheme: "http",
+	}, {
+		name:           "14_ipv6_peer",
+		remoteAddr:     "[fd00::1]:1234",
+		headers:        map[string][]string{"X-Forwarded-For": {"[2001:db8::1]:4711"}},
+		expectedIP:     "2001:db8::1",
+		expectedScheme: "http",
+	}, {
+		name:           "15_mapped",
+		remoteAddr:     "[::ffff:10.0.0.1]:1234",
+		headers:        map[string][]string{"X-Forwarded-For": {"::ffff:198.51.100.1"}},
+		expectedIP:     "198.51.100.1",
+		expectedScheme: "http",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for name, values := range tc.headers {
+				for _, value := range values {
+					r.Header.Add(name, value)
+				}
+			}
+
+			client := ResolveClient(r, trustedProxies)
+			if client.IP != tc.expectedIP {
+				t.Errorf("Wrong IP: got %v, want %v", client.IP, tc.expectedIP)
+			}
+			if client.Scheme != tc.expectedScheme {
+				t.Errorf("Wrong scheme: got %v, want %v", client.Scheme, tc.expectedScheme)
+			}
+		})
+	}
+}
+
+func TestMakeClientMiddleware(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name               string
+		remoteAddr         string
+		forwardedFor       string
+		expectedRemoteAddr string
+		expectedIP         string
+	}{{
+		name:               "1_direct",
+		remoteAddr:         "192.0.2.1:1234",
+		expectedRemoteAddr: "192.0.2.1:1234",
+		expectedIP:         "192.0.2.1",
+	}, {
+		name:               "2_forwarded",
+		remoteAddr:         "10.0.0.1:1234",
+		forwardedFor:       "198.51.100.1",
+		expectedRemoteAddr: "10.0.0.1:1234",
+		expectedIP:         "198.51.100.1",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var remoteAddr, ip string
+			handler := MakeClientMiddleware(trustedProxies)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					remoteAddr, ip = r.RemoteAddr, ClientIP(r)
+				}),
+			)
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			if len(tc.forwardedFor) > 0 {
+				r.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if remoteAddr != tc.expectedRemoteAddr {
+				t.Errorf("Wrong remote address: got %v, want %v", remoteAddr, tc.expectedRemoteAddr)
+			}
+			if ip != tc.expectedIP {
+				t.Errorf("Wrong IP: got %v, want %v", ip, tc.expectedIP)
+			}
+		})
+	}
+}
+
+func TestInitRouter_ClientIP(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initRouter(RouterArgs{trustedProxies: trustedProxies, echoEnabled: true})
+
+	r := httptest.NewRequest("GET", "/echo", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("Forwarded", "for=198.51.100.1;proto=https")
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 200 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 200)
+	}
+	for _, substr := range []string{
+		`"remoteAddr":"10.0.0.1:1234"`,
+		`"clientIp":"198.51.100.1"`,
+	} {
+		if !strings.Contains(rr.Body.String(), substr) {
+			t.Errorf("Didn't find substr in body: want %q", substr)
+		}
+	}
+}
+
+func TestClientLogFormatter(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	var remoteAddr string
+	handler := MakeClientMiddleware(trustedProxies)(middleware.RequestLogger(ClientLogFormatter{
+		&middleware.DefaultLogFormatter{Logger: log.New(&buf, "", 0), NoColor: true},
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	})))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if substr := "from 198.51.100.1 - "; !strings.Contains(buf.String(), substr) {
+		t.Errorf("Didn't find substr in log: want %q, got %q", substr, buf.String())
+	}
+	if remoteAddr != "10.0.0.1:1234" {
+		t.Errorf("Wrong remote address: got %v, want %v", remoteAddr, "10.0.0.1:1234")
+	}
+}
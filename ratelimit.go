@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Requests per period Per. Use ParseRateLimit to construct.
+// The zero value disables rate limiting.
+type RateLimit struct {
+	Requests int
+	Per      time.Duration
+}
+
+// ParseRateLimit parses rate limits like "60/m". The unit is one of "s", "m",
+// and "h". The empty string results in the zero RateLimit. Returns an error if
+// the rate limit is malformed.
+func ParseRateLimit(s string) (RateLimit, error) {
+	if len(s) == 0 {
+		return RateLimit{}, nil
+	}
+
+	count, unit, ok := strings.Cut(s, "/")
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: expected format like 60/m", s)
+	}
+
+	requests, err := strconv.Atoi(strings.TrimSpace(count))
+	if err != nil || requests <= 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: requests must be positive number", s)
+	}
+
+	per, ok := map[string]time.Duration{
+		"s": time.Second,
+		"m": time.Minute,
+		"h": time.Hour,
+	}[strings.TrimSpace(unit)]
+	if !ok {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: unit not in s, m, h", s)
+	}
+
+	return RateLimit{Requests: requests, Per: per}, nil
+}
+
+// RateLimiter limits requests per key with token buckets. Every bucket holds
+// up to Requests tokens and is refilled at Requests per Per. A request takes
+// one token. Use NewRateLimiter to construct.
+type RateLimiter struct {
+	limit RateLimit
+	now   func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+}
+
+// tokenBucket is the state of a single key.
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter creates a RateLimiter with the given limit. Returns nil if
+// the limit is the zero RateLimit.
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	if limit.Requests == 0 {
+		return nil
+	}
+
+	return &RateLimiter{
+		limit:   limit,
+		now:     time.Now,
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// Allow takes a token from the bucket of the given key. Returns false and the
+// duration until the next token is available if the bucket is empty. Always
+// returns true if l is nil.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	capacity := float64(l.limit.Requests)
+	rate := capacity / l.limit.Per.Seconds()
+
+	l.prune(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: capacity, updated: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+// prune removes buckets that have been refilled completely, so the number of
+// buckets does not grow with every key ever seen. Runs at most once per
+// period of the limit.
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.limit.Per {
+		return
+	}
+	l.pruned = now
+
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= l.limit.Per {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// MakeRateLimitMiddleware returns a middleware that limits requests per
+// client IP with ipLimiter and per fingerprint of the token extracted with
+// sources with fingerprintLimiter. The client IP is taken from ClientIP.
+// Either limiter can be nil. Limited requests are answered with status code
+// 429 and the header Retry-After. Sources should only contain
+// TokenSourceChain.RequestSources, otherwise all requests that fall back to a
+// static or file token share one bucket.
+func MakeRateLimitMiddleware(
+	ipLimiter *RateLimiter,
+	fingerprintLimiter *RateLimiter,
+	sources TokenSourceChain,
+) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, retryAfter := ipLimiter.Allow(ClientIP(r))
+			if !ok {
+				writeRateLimitExceeded(w, r, "client IP", retryAfter)
+				return
+			}
+
+			if fingerprintLimiter != nil {
+				token, err := sources.Extract(r)
+				if err == nil {
+					ok, retryAfter = fingerprintLimiter.Allow(token.Fingerprint)
+					if !ok {
+						writeRateLimitExceeded(w, r, "token", retryAfter)
+						return
+					}
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// writeRateLimitExceeded writes a 429 problem with the header Retry-After set
+// to the given duration rounded up to full seconds.
+func writeRateLimitExceeded(
+	w http.ResponseWriter,
+	r *http.Request,
+	subject string,
+	retryAfter time.Duration,
+) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	msg := fmt.Sprintf("Too Many Requests. Rate limit per %s exceeded. Retry in %d seconds", subject, seconds)
+	WriteProblem(w, r, http.StatusTooManyRequests, "RateLimitExceeded", msg)
+}
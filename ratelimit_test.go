@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		value         string
+		expectedError bool
+		expectedLimit RateLimit
+	}{
+		{name: "1_empty", value: "", expectedLimit: RateLimit{}},
+		{name: "2_second", value: "5/s", expectedLimit: RateLimit{5, time.Second}},
+		{name: "3_minute", value: " 60 / m ", expectedLimit: RateLimit{60, time.Minute}},
+		{name: "4_hour", value: "1000/h", expectedLimit: RateLimit{1000, time.Hour}},
+		{name: "5_missing_unit", value: "60", expectedError: true},
+		{name: "6_unknown_unit", value: "60/d", expectedError: true},
+		{name: "7_zero", value: "0/s", expectedError: true},
+		{name: "8_not_a_number", value: "x/s", expectedError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			limit, err := ParseRateLimit(tc.value)
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if limit != tc.expectedLimit {
+				t.Errorf("Wrong limit: got %v, want %v", limit, tc.expectedLimit)
+			}
+		})
+	}
+}
+
+func TestRateLimiter_Allow(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewRateLimiter(RateLimit{Requests: 2, Per: time.Minute})
+	l.now = func() time.Time { return now }
+
+	for _, tc := range []struct {
+		name               string
+		advance            time.Duration
+		key                string
+		expectedOK         bool
+		expectedRetryAfter time.Duration
+	}{
+		{"1_first", 0, "a", true, 0},
+		{"2_second", 0, "a", true, 0},
+		{"3_empty", 0, "a", false, 30 * time.Second},
+		{"4_other_key", 0, "b", true, 0},
+		{"5_partially_refilled", 10 * time.Second, "a", false, 20 * time.Second},
+		{"6_refilled", 20 * time.Second, "a", true, 0},
+		{"7_empty_again", 0, "a", false, 30 * time.Second},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			now = now.Add(tc.advance)
+
+			ok, retryAfter := l.Allow(tc.key)
+			if ok != tc.expectedOK {
+				t.Errorf("Wrong result: got %v, want %v", ok, tc.expectedOK)
+			}
+			if retryAfter.Round(time.Millisecond) != tc.expectedRetryAfter {
+				t.Errorf("Wrong retry after: got %v, want %v", retryAfter, tc.expectedRetryAfter)
+			}
+		})
+	}
+
+	now = now.Add(2 * time.Minute)
+	l.Allow("c")
+	if len(l.buckets) != 1 {
+		t.Errorf("Wrong number of buckets after pruning: got %v, want %v", len(l.buckets), 1)
+	}
+}
+
+func TestNewRateLimiter_Disabled(t *testing.T) {
+	l := NewRateLimiter(RateLimit{})
+	if l != nil {
+		t.Fatal("Unexpected rate limiter for zero limit")
+	}
+	if ok, _ := l.Allow("a"); !ok {
+		t.Error("Nil rate limiter refused request")
+	}
+}
+
+func TestInitRouter_RateLimit(t *testing.T) {
+	trustedProxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := initRouter(RouterArgs{
+		trustedProxies:         trustedProxies,
+		ipRateLimiter:          NewRateLimiter(RateLimit{Requests: 2, Per: time.Minute}),
+		fingerprintRateLimiter: NewRateLimiter(RateLimit{Requests: 1, Per: time.Minute}),
+		echoEnabled:            true,
+		tokenPipeline: TokenPipeline{
+			profiles: newTestTokenProfiles(t, []string{"Authorization"}, ""),
+		},
+	})
+
+	for _, tc := range []struct {
+		name               string
+		target             string
+		remoteAddr         string
+		headers            map[string]string
+		expectedCode       int
+		expectedRetryAfter string
+	}{{
+		name:         "1_token",
+		target:       "/token",
+		remoteAddr:   "192.0.2.1:1234",
+		headers:      map[string]string{"Authorization": "a"},
+		expectedCode: 200,
+	}, {
+		name:               "2_same_token_other_ip",
+		target:             "/token",
+		remoteAddr:         "192.0.2.2:1234",
+		headers:            map[string]string{"Authorization": "a"},
+		expectedCode:       429,
+		expectedRetryAfter: "60",
+	}, {
+		name:         "3_other_token",
+		target:       "/token",
+		remoteAddr:   "192.0.2.1:1234",
+		headers:      map[string]string{"Authorization": "b"},
+		expectedCode: 200,
+	}, {
+		name:               "4_ip_exhausted",
+		target:             "/echo",
+		remoteAddr:         "192.0.2.1:1234",
+		expectedCode:       429,
+		expectedRetryAfter: "30",
+	}, {
+		name:         "5_forwarded_ip",
+		target:       "/echo",
+		remoteAddr:   "10.0.0.1:1234",
+		headers:      map[string]string{"X-Forwarded-For": "198.51.100.1"},
+		expectedCode: 200,
+	}, {
+		name:         "6_untrusted_forwarded_ip",
+		target:       "/echo",
+		remoteAddr:   "192.0.2.1:1234",
+		headers:      map[string]string{"X-Forwarded-For": "198.51.100.2"},
+		expectedCode: 429,
+	}, {
+		name:         "7_health_unlimited",
+		target:       "/health",
+		remoteAddr:   "192.0.2.1:1234",
+		expectedCode: 200,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			r.RemoteAddr = tc.remoteAddr
+			for name, value := range tc.headers {
+				r.Header.Set(name, value)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			if tc.expectedCode == http.StatusTooManyRequests && len(tc.expectedRetryAfter) > 0 {
+				if got := rr.Header().Get("Retry-After"); got != tc.expectedRetryAfter {
+					t.Errorf("Wrong Retry-After: got %q, want %q", got, tc.expectedRetryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestInitRouter_RateLimitFallback(t *testing.T) {
+	router := initRouter(RouterArgs{
+		fingerprintRateLimiter: NewRateLimiter(RateLimit{Requests: 1, Per: time.Minute}),
+		tokenPipeline: TokenPipeline{
+			profiles: newTestTokenProfiles(t, []string{"Authorization"}, "fallback"),
+		},
+	})
+
+	for i := 0; i < 3; i++ {
+		r := httptest.NewRequest("GET", "/token", nil)
+		r.RemoteAddr = fmt.Sprintf("192.0.2.%d:1234", i+1)
+		rr := httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("Wrong code for request %d: got %v, want %v", i, rr.Code, http.StatusOK)
+		}
+	}
+}
+
+func TestInitRouter_RateLimitAfterGatewayProof(t *testing.T) {
+	router := initRouter(RouterArgs{
+		ipRateLimiter:   NewRateLimiter(RateLimit{Requests: 1, Per: time.Minute}),
+		gatewayVerifier: NewSharedSecretGatewayVerifier("X-Secret", "s3cr3t"),
+		tokenPipeline: TokenPipeline{
+			profiles: newTestTokenProfiles(t, []string{"Authorization"}, "fallback"),
+		},
+	})
+
+	for _, tc := range []struct {
+		name         string
+		secret       string
+		expectedCode int
+	}{{
+		name:         "1_without_proof",
+		secret:       "",
+		expectedCode: http.StatusForbidden,
+	}, {
+		name:         "2_without_proof_again",
+		secret:       "",
+		expectedCode: http.StatusForbidden,
+	}, {
+		name:         "3_with_proof",
+		secret:       "s3cr3t",
+		expectedCode: http.StatusOK,
+	}, {
+		name:         "4_with_proof_limited",
+		secret:       "s3cr3t",
+		expectedCode: http.StatusTooManyRequests,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/token", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			if len(tc.secret) > 0 {
+				r.Header.Set("X-Secret", tc.secret)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ValidateRedirectTargetPattern returns an error if the given pattern is not
+// an absolute URL without query and fragment. The host may start with "*." to
+// match all subdomains. See MatchRedirectTarget for the matching rules.
+func ValidateRedirectTargetPattern(pattern string) error {
+	u, err := url.Parse(pattern)
+	if err != nil {
+		return fmt.Errorf("redirect target %q: %w", pattern, err)
+	}
+
+	if len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return fmt.Errorf("redirect target %q: must be absolute URL", pattern)
+	}
+	if len(u.RawQuery) > 0 || len(u.Fragment) > 0 || u.User != nil {
+		return fmt.Errorf("redirect target %q: must not have user info, query, or fragment", pattern)
+	}
+	if strings.Contains(strings.TrimPrefix(u.Hostname(), "*."), "*") {
+		return fmt.Errorf("redirect target %q: wildcard only allowed as first label", pattern)
+	}
+
+	return nil
+}
+
+// MatchRedirectTarget reports whether the given target URL matches one of the
+// given patterns. Returns true if there are no patterns, so all targets are
+// allowed unless an allowlist is configured.
+//
+// A target matches a pattern if the scheme and host are equal, the port is
+// equal or the pattern has none, and the path is below the path of the
+// pattern. A host like "*.example.com" matches all subdomains of
+// "example.com" but not "example.com" itself.
+func MatchRedirectTarget(patterns []string, target string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	t, err := url.Parse(target)
+	if err != nil || len(t.Host) == 0 {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		p, err := url.Parse(pattern)
+		if err != nil {
+			continue
+		}
+
+		if !strings.EqualFold(t.Scheme, p.Scheme) {
+			continue
+		}
+		if len(p.Port()) > 0 && t.Port() != p.Port() {
+			continue
+		}
+		if !matchRedirectTargetHost(p.Hostname(), t.Hostname()) {
+			continue
+		}
+		if !matchRedirectTargetPath(p.Path, t.Path) {
+			continue
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// matchRedirectTargetHost compares hosts case-insensitively. The pattern may
+// start with "*." to match subdomains.
+func matchRedirectTargetHost(pattern string, host string) bool {
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+
+	return pattern == host
+}
+
+// matchRedirectTargetPath reports whether path equals the pattern or is below
+// it. The path is cleaned first, so dot segments can't escape the pattern. The
+// pattern "" or "/" matches all paths.
+func matchRedirectTargetPath(pattern string, targetPath string) bool {
+	if len(pattern) == 0 || pattern == "/" {
+		return true
+	}
+
+	targetPath = path.Clean("/" + targetPath)
+	pattern = strings.TrimSuffix(pattern, "/")
+
+	return targetPath == pattern || strings.HasPrefix(targetPath, pattern+"/")
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestValidateRedirectTargetPattern(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		pattern       string
+		expectedError bool
+	}{
+		{name: "1_origin", pattern: "https://example.com", expectedError: false},
+		{name: "2_path", pattern: "http://localhost:8888/callback", expectedError: false},
+		{name: "3_wildcard", pattern: "https://*.example.com", expectedError: false},
+		{name: "4_relative", pattern: "/callback", expectedError: true},
+		{name: "5_query", pattern: "https://example.com?x=y", expectedError: true},
+		{name: "6_fragment", pattern: "https://example.com#x", expectedError: true},
+		{name: "7_user_info", pattern: "https://user@example.com", expectedError: true},
+		{name: "8_inner_wildcard", pattern: "https://api.*.example.com", expectedError: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateRedirectTargetPattern(tc.pattern)
+			if tc.expectedError && err == nil {
+				t.Error("Unexpected success: got nil, want error")
+			}
+			if !tc.expectedError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestMatchRedirectTarget(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		patterns      []string
+		target        string
+		expectedMatch bool
+	}{
+		{"1_no_patterns", nil, "https://evil.example.org", true},
+		{"2_origin", []string{"https://example.com"}, "https://example.com/x?y=z", true},
+		{"3_other_scheme", []string{"https://example.com"}, "http://example.com", false},
+		{"4_other_host", []string{"https://example.com"}, "https://example.org", false},
+		{"5_host_case", []string{"https://Example.com"}, "https://example.COM", true},
+		{"6_any_port", []string{"http://localhost"}, "http://localhost:8888/cb", true},
+		{"7_same_port", []string{"http://localhost:8888"}, "http://localhost:8888/cb", true},
+		{"8_other_port", []string{"http://localhost:8888"}, "http://localhost:9999/cb", false},
+		{"9_path_exact", []string{"https://example.com/cb"}, "https://example.com/cb", true},
+		{"10_path_below", []string{"https://example.com/cb"}, "https://example.com/cb/x", true},
+		{"11_path_sibling", []string{"https://example.com/cb"}, "https://example.com/cbx", false},
+		{"12_path_slash", []string{"https://example.com/cb/"}, "https://example.com/cb/x", true},
+		{"13_wildcard", []string{"https://*.example.com"}, "https://a.b.example.com", true},
+		{"14_wildcard_apex", []string{"https://*.example.com"}, "https://example.com", false},
+		{"15_wildcard_suffix", []string{"https://*.example.com"}, "https://evilexample.com", false},
+		{"16_user_info", []string{"https://example.com"}, "https://example.com@evil.org", false},
+		{"17_relative", []string{"https://example.com"}, "/cb", false},
+		{"18_second_pattern", []string{"https://example.com", "http://localhost"}, "http://localhost", true},
+		{"19_path_dot_dot", []string{"https://example.com/cb"}, "https://example.com/cb/../evil", false},
+		{"20_path_dot_dot_slash", []string{"https://example.com/cb/"}, "https://example.com/cb/../evil", false},
+		{"21_path_encoded_dot_dot", []string{"https://example.com/cb"}, "https://example.com/cb/%2e%2e/evil", false},
+		{"22_path_dot_dot_inside", []string{"https://example.com/cb"}, "https://example.com/cb/a/../b", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			match := MatchRedirectTarget(tc.patterns, tc.target)
+			if match != tc.expectedMatch {
+				t.Errorf("Wrong match: got %v, want %v", match, tc.expectedMatch)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrTokenRefreshFailed = errors.New("token refresh failed")
+
+// TokenRefresher gets fresh access tokens from an OAuth 2.0 token endpoint
+// with the refresh token grant. The refresh token is extracted from the
+// request with its own TokenSourceChain. Refresh tokens never leave Token2go.
+// Use NewTokenRefresher to construct.
+type TokenRefresher struct {
+	client  OAuth2Client
+	sources TokenSourceChain
+}
+
+// NewTokenRefresher creates a TokenRefresher that uses the given client and
+// looks for refresh tokens with the given sources.
+func NewTokenRefresher(client OAuth2Client, sources TokenSourceChain) TokenRefresher {
+	return TokenRefresher{
+		client:  client,
+		sources: sources,
+	}
+}
+
+// Refresh extracts the refresh token from the request and redeems it with
+// Redeem. Refresh tokens never leave Token2go, so a rotated refresh token is
+// discarded and rotation is only logged. The client keeps the old one, which
+// stops working if the token endpoint invalidates it on rotation.
+//
+// Returns ErrTokenNotFound if the request carries no refresh token and
+// ErrTokenRefreshFailed (possibly wrapped) if the token endpoint does not hand
+// out a token.
+func (f TokenRefresher) Refresh(r *http.Request) (Token, error) {
+	refreshToken, err := f.Extract(r)
+	if err != nil {
+		return Token{}, err
+	}
+
+	token, next, err := f.Redeem(r.Context(), refreshToken)
+	if err != nil {
+		return Token{}, err
+	}
+	if next.Fingerprint != refreshToken.Fingerprint {
+		log.Printf(
+			"Token endpoint rotated the refresh token from %s. "+
+				"The client keeps the old one, which may stop working",
+			refreshToken.Source,
+		)
+	}
+
+	return token, nil
+}
+
+// Extract extracts the refresh token from the request. Returns
+// ErrTokenNotFound if the request carries none.
+func (f TokenRefresher) Extract(r *http.Request) (Token, error) {
+	return f.sources.Extract(r)
+}
+
+// Redeem redeems the given refresh token for a fresh access token. The source
+// of the returned access token is the source of the refresh token prefixed
+// with "token-refresh:". Also returns the refresh token to use next: the
+// rotated one if the token endpoint handed one out, otherwise the given one.
+//
+// Returns ErrTokenRefreshFailed (possibly wrapped) if the token endpoint does
+// not hand out a token.
+func (f TokenRefresher) Redeem(ctx context.Context, refreshToken Token) (Token, Token, error) {
+	response, err := f.client.RequestToken(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken.Secret},
+	})
+	if err != nil {
+		return Token{}, Token{}, fmt.Errorf("%w: %w", ErrTokenRefreshFailed, err)
+	}
+
+	next := refreshToken
+	if len(response.RefreshToken) > 0 && response.RefreshToken != refreshToken.Secret {
+		next = NewToken(response.RefreshToken, refreshToken.Source)
+	}
+
+	return NewToken(response.AccessToken, "token-refresh:"+refreshToken.Source), next, nil
+}
+
+// Names returns the names of the sources refresh tokens are looked for in.
+func (f TokenRefresher) Names() []string {
+	return f.sources.Names()
+}
+
+// CheckProfiles returns an error if one of the given profiles discloses
+// refresh tokens. A profile does so if it reads from one of the refresher's
+// sources, the refresh token of an oauth2-proxy session, or the header
+// X-Auth-Request-Refresh-Token oauth2-proxy passes the refresh token in.
+func (f TokenRefresher) CheckProfiles(profiles TokenProfiles) error {
+	refreshNames := f.Names()
+
+	for _, profile := range profiles {
+		for _, name := range profile.sources.Names() {
+			disclosed := strings.EqualFold(name, "header:X-Auth-Request-Refresh-Token") ||
+				strings.HasPrefix(name, "oauth2-proxy-session:") && strings.HasSuffix(name, ":refresh")
+			for _, refreshName := range refreshNames {
+				disclosed = disclosed || strings.EqualFold(name, refreshName)
+			}
+			if disclosed {
+				return fmt.Errorf(
+					"token profile %q: source %s discloses refresh tokens of token refresh endpoint",
+					profile.name, name,
+				)
+			}
+		}
+	}
+
+	return nil
+}
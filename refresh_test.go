@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestTokenRefreshServer starts a stub token endpoint for the refresh token
+// grant. It accepts the client "client" with secret "secret". Refresh token
+// "rt-1" is redeemed for access token "at-1" and rotated to "rt-2". All other
+// refresh tokens are refused.
+func newTestTokenRefreshServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		clientID, clientSecret, _ := r.BasicAuth()
+		if r.ParseForm() != nil || clientID != "client" || clientSecret != "secret" ||
+			r.PostForm.Get("grant_type") != "refresh_token" ||
+			r.PostForm.Get("refresh_token") != "rt-1" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  "at-1",
+			"refresh_token": "rt-2",
+			"token_type":    "Bearer",
+			"expires_in":    60,
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func newTestTokenRefresher(t *testing.T, endpoint string) TokenRefresher {
+	t.Helper()
+
+	sources, err := NewTokenSourceChain([]TokenSourceSpec{{
+		Type:  "header",
+		Names: []string{"X-Auth-Request-Refresh-Token"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewTokenRefresher(NewOAuth2Client(endpoint, "client", "secret"), sources)
+}
+
+func TestTokenRefresher_Refresh(t *testing.T) {
+	refresher := newTestTokenRefresher(t, newTestTokenRefreshServer(t).URL)
+
+	for _, tc := range []struct {
+		name           string
+		headers        http.Header
+		expectedSecret string
+		expectedError  error
+	}{{
+		name:           "1_success",
+		headers:        http.Header{"X-Auth-Request-Refresh-Token": {"rt-1"}},
+		expectedSecret: "at-1",
+	}, {
+		name:          "2_missing",
+		headers:       http.Header{"Authorization": {"Bearer at-0"}},
+		expectedError: ErrTokenNotFound,
+	}, {
+		name:          "3_rejected",
+		headers:       http.Header{"X-Auth-Request-Refresh-Token": {"rt-0"}},
+		expectedError: ErrTokenRefreshFailed,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/token/refresh", nil)
+			r.Header = tc.headers
+
+			token, err := refresher.Refresh(r)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("Wrong error: got %v, want %v", err, tc.expectedError)
+			}
+			if err != nil {
+				return
+			}
+
+			if token.Secret != tc.expectedSecret {
+				t.Errorf("Wrong secret: got %q, want %q", token.Secret, tc.expectedSecret)
+			}
+			want := "token-refresh:header:X-Auth-Request-Refresh-Token"
+			if token.Source != want {
+				t.Errorf("Wrong source: got %q, want %q", token.Source, want)
+			}
+		})
+	}
+}
+
+func TestTokenRefresher_Redeem(t *testing.T) {
+	refresher := newTestTokenRefresher(t, newTestTokenRefreshServer(t).URL)
+
+	token, next, err := refresher.Redeem(context.Background(), NewToken("rt-1", "header:X"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if token.Secret != "at-1" {
+		t.Errorf("Wrong secret: got %q, want %q", token.Secret, "at-1")
+	}
+	if next.Secret != "rt-2" || next.Source != "header:X" {
+		t.Errorf("Wrong next refresh token: got %q from %q, want %q from %q",
+			next.Secret, next.Source, "rt-2", "header:X")
+	}
+
+	_, _, err = refresher.Redeem(context.Background(), next)
+	if !errors.Is(err, ErrTokenRefreshFailed) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrTokenRefreshFailed)
+	}
+}
+
+func TestMakeGetTokenRefreshHandler_Introspection(t *testing.T) {
+	handler := MakeGetTokenRefreshHandler(
+		newTestTokenRefresher(t, newTestTokenRefreshServer(t).URL), newTestTokenPipeline(t),
+	)
+
+	r := httptest.NewRequest("GET", "/token/refresh", nil)
+	r.Header.Set("X-Auth-Request-Refresh-Token", "rt-1")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, r)
+
+	// The introspection stub doesn't know the refreshed token "at-1".
+	if rr.Code != 502 {
+		t.Errorf("Wrong status code: got %v, want %v", rr.Code, 502)
+	}
+	if body := rr.Body.String(); !strings.Contains(body, "ErrTokenIntrospectionFailed") {
+		t.Errorf("Did not find 'ErrTokenIntrospectionFailed' in '%v'", body)
+	}
+}
+
+func TestTokenRefresher_CheckProfiles(t *testing.T) {
+	refresher := newTestTokenRefresher(t, "http://localhost")
+
+	for _, tc := range []struct {
+		name          string
+		specs         []TokenSourceSpec
+		expectedError bool
+	}{{
+		name:  "1_access",
+		specs: []TokenSourceSpec{{Type: "header", Names: []string{"Authorization"}}},
+	}, {
+		name:  "2_session_id",
+		specs: []TokenSourceSpec{{Type: "oauth2-proxy-session", Names: []string{"_o"}, Secret: "s", Token: "id"}},
+	}, {
+		name:          "3_refresh_header",
+		specs:         []TokenSourceSpec{{Type: "header", Names: []string{"X-Auth-Request-Refresh-Token"}}},
+		expectedError: true,
+	}, {
+		name: "4_session_refresh",
+		specs: []TokenSourceSpec{
+			{Type: "oauth2-proxy-session", Names: []string{"_o"}, Secret: "s", Token: "refresh"},
+		},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			profiles, err := NewTokenProfiles(
+				newTestTokenSourceChain(t, []string{"Authorization"}, ""),
+				map[string][]TokenSourceSpec{"other": tc.specs},
+				nil,
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = refresher.CheckProfiles(profiles)
+			if (err != nil) != tc.expectedError {
+				t.Errorf("Wrong error: got %v, want error %v", err, tc.expectedError)
+			}
+		})
+	}
+}
+
+func TestMakeGetTokenRefreshHandler(t *testing.T) {
+	handler := MakeGetTokenRefreshHandler(
+		newTestTokenRefresher(t, newTestTokenRefreshServer(t).URL), TokenPipeline{},
+	)
+
+	for _, tc := range []struct {
+		name         string
+		refreshToken string
+		expectedCode int
+		expectedBody string
+	}{{
+		name:         "1_success",
+		refreshToken: "rt-1",
+		expectedCode: 200,
+		expectedBody: `"secret":"at-1"`,
+	}, {
+		name:         "2_missing",
+		refreshToken: "",
+		expectedCode: 444,
+		expectedBody: "header:X-Auth-Request-Refresh-Token",
+	}, {
+		name:         "3_rejected",
+		refreshToken: "rt-0",
+		expectedCode: 502,
+		expectedBody: "ErrTokenRefreshFailed",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/token/refresh", nil)
+			if len(tc.refreshToken) > 0 {
+				r.Header.Set("X-Auth-Request-Refresh-Token", tc.refreshToken)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong status code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+
+			body := rr.Body.String()
+			if !strings.Contains(body, tc.expectedBody) {
+				t.Errorf("Did not find '%v' in '%v'", tc.expectedBody, body)
+			}
+			if strings.Contains(body, "rt-") {
+				t.Errorf("Refresh token disclosed in '%v'", body)
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// EnvFile is a file with T2G_* options in the format "T2G_KEY=value". Empty
+// lines and lines starting with "#" are ignored. Values may be enclosed in
+// single or double quotes. Options in the file take precedence over the
+// environment. Use NewEnvFile to construct.
+type EnvFile struct {
+	path string
+
+	// original values of the environment variables the file has touched.
+	// Nil for variables that were not set.
+	original map[string]*string
+}
+
+// NewEnvFile creates an EnvFile for the file at the given path. The file is
+// not read until Apply is called.
+func NewEnvFile(path string) *EnvFile {
+	return &EnvFile{path: path, original: map[string]*string{}}
+}
+
+// ParseEnvFile parses the given content of an EnvFile. Returns an error if a
+// line is malformed or a key does not start with "T2G_".
+func ParseEnvFile(data []byte) (map[string]string, error) {
+	values := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !strings.HasPrefix(key, "T2G_") || key == "T2G_CONFIG_FILE" {
+			return nil, fmt.Errorf("line %d: expected T2G_KEY=value", n)
+		}
+
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		values[key] = value
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan: %w", err)
+	}
+
+	return values, nil
+}
+
+// Read reads and parses the file. Returns the raw content next to the values.
+func (f *EnvFile) Read() ([]byte, map[string]string, error) {
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values, err := ParseEnvFile(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid config file %q: %w", f.path, err)
+	}
+
+	return data, values, nil
+}
+
+// Apply reads the file and sets its options in the environment. Options that
+// have been removed from the file since the last call are reset to their
+// original values. The environment is left untouched if the file can't be
+// read or parsed.
+func (f *EnvFile) Apply() error {
+	_, values, err := f.Read()
+	if err != nil {
+		return err
+	}
+
+	for key, original := range f.original {
+		if _, ok := values[key]; ok {
+			continue
+		}
+		if original == nil {
+			err = os.Unsetenv(key)
+		} else {
+			err = os.Setenv(key, *original)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to reset %s: %w", key, err)
+		}
+	}
+
+	for key, value := range values {
+		if _, ok := f.original[key]; !ok {
+			if original, set := os.LookupEnv(key); set {
+				f.original[key] = &original
+			} else {
+				f.original[key] = nil
+			}
+		}
+		err = os.Setenv(key, value)
+		if err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+	}
+
+	return nil
+}
+
+// ConfigEnv returns all non-empty T2G_* environment variables.
+func ConfigEnv() map[string]string {
+	env := map[string]string{}
+
+	for _, kv := range os.Environ() {
+		key, value, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(key, "T2G_") && len(value) > 0 {
+			env[key] = value
+		}
+	}
+
+	return env
+}
+
+// restoreConfigEnv makes the T2G_* environment variables equal to env.
+func restoreConfigEnv(env map[string]string) {
+	for key := range ConfigEnv() {
+		if _, ok := env[key]; !ok {
+			_ = os.Unsetenv(key)
+		}
+	}
+	for key, value := range env {
+		_ = os.Setenv(key, value)
+	}
+}
+
+// IsSecretConfigKey reports whether the value of the given T2G_* option may
+// contain secrets. JSON options are treated as secret as a whole.
+func IsSecretConfigKey(key string) bool {
+	key = strings.TrimPrefix(key, "T2G_")
+
+	for _, suffix := range []string{"_SECRET", "_PASSWORD"} {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+
+	return contains([]string{
+		"FALLBACK_TOKEN",
+		"ECHO_ADMIN_TOKEN",
+		"TOKEN_SOURCES",
+		"TOKEN_PROFILES",
+		"REFRESH_TOKEN_SOURCES",
+		"TENANTS",
+	}, key)
+}
+
+// DiffConfigEnv returns one line per option that differs between before and
+// after ordered by key. Values of options for which IsSecretConfigKey is true
+// are redacted.
+func DiffConfigEnv(before map[string]string, after map[string]string) []string {
+	keys := map[string]bool{}
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	var diff []string
+
+	for _, key := range sortedKeys(keys) {
+		o, oldSet := before[key]
+		n, newSet := after[key]
+		if oldSet == newSet && o == n {
+			continue
+		}
+
+		switch {
+		case IsSecretConfigKey(key):
+			diff = append(diff, key+": changed (redacted)")
+		case !oldSet:
+			diff = append(diff, fmt.Sprintf("%s: added %q", key, n))
+		case !newSet:
+			diff = append(diff, fmt.Sprintf("%s: removed %q", key, o))
+		default:
+			diff = append(diff, fmt.Sprintf("%s: %q -> %q", key, o, n))
+		}
+	}
+
+	return diff
+}
+
+// Reloader serves requests with a TenantRouter that is rebuilt from the
+// environment and an optional EnvFile on Reload. The router is swapped
+// atomically. Requests in flight finish with the router they started with.
+// Use NewReloader to construct.
+type Reloader struct {
+	mu     sync.Mutex
+	file   *EnvFile
+	state  *RouterState
+	config Config
+	env    map[string]string
+	router atomic.Pointer[TenantRouter]
+}
+
+// NewReloader applies the given EnvFile if not nil and builds the initial
+// Config and TenantRouter. Returns an error if either fails. The RouterState
+// created here is kept for all reloads.
+func NewReloader(file *EnvFile) (*Reloader, error) {
+	rl := &Reloader{file: file, state: NewRouterState()}
+
+	if file != nil {
+		err := file.Apply()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := NewConfig()
+	if err != nil {
+		return nil, err
+	}
+	router, err := NewTenantRouter(c, rl.state)
+	if err != nil {
+		return nil, err
+	}
+
+	rl.config = c
+	rl.env = ConfigEnv()
+	rl.router.Store(&router)
+
+	return rl, nil
+}
+
+// Config returns the Config currently in use.
+func (rl *Reloader) Config() Config {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	return rl.config
+}
+
+// ServeHTTP serves r with the current router.
+func (rl *Reloader) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rl.router.Load().ServeHTTP(w, r)
+}
+
+// Reload re-applies the EnvFile and rebuilds Config and router. The
+// RouterState is carried over, so rate limits, cached introspections, open
+// streams, and pending confirmations survive. Returns the diff of the options
+// as computed by DiffConfigEnv. If anything fails, the current router stays
+// in place, the environment is restored, and an error is returned.
+func (rl *Reloader) Reload() ([]string, error) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.file != nil {
+		err := rl.file.Apply()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c, err := NewConfig()
+	if err != nil {
+		restoreConfigEnv(rl.env)
+		return nil, err
+	}
+	router, err := NewTenantRouter(c, rl.state)
+	if err != nil {
+		restoreConfigEnv(rl.env)
+		return nil, err
+	}
+
+	env := ConfigEnv()
+	diff := DiffConfigEnv(rl.env, env)
+
+	rl.config = c
+	rl.env = env
+	rl.router.Store(&router)
+
+	return diff, nil
+}
+
+// Watch reloads on SIGHUP and, if there is an EnvFile and interval is
+// positive, whenever the content of the file changes. The file is polled in
+// the given interval. Outcomes are logged. Blocks forever.
+func (rl *Reloader) Watch(interval time.Duration) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	var ticks <-chan time.Time
+	var checksum [sha256.Size]byte
+	if rl.file != nil && interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+
+		if data, _, err := rl.file.Read(); err == nil {
+			checksum = sha256.Sum256(data)
+		}
+	}
+
+	for {
+		select {
+		case <-signals:
+			log.Print("Reloading configuration on SIGHUP")
+			if ticks != nil {
+				if data, _, err := rl.file.Read(); err == nil {
+					checksum = sha256.Sum256(data)
+				}
+			}
+		case <-ticks:
+			data, _, err := rl.file.Read()
+			if err != nil || sha256.Sum256(data) == checksum {
+				continue
+			}
+			checksum = sha256.Sum256(data)
+			log.Printf("Reloading configuration on change of %s", rl.file.path)
+		}
+
+		oldPort := rl.Config().serverPort
+
+		diff, err := rl.Reload()
+		if err != nil {
+			log.Printf("Reloading configuration failed, keeping current: %v", err)
+			continue
+		}
+
+		if len(diff) == 0 {
+			log.Print("Reloaded configuration without changes")
+		}
+		for _, line := range diff {
+			log.Print("Reloaded configuration: " + line)
+		}
+		if rl.Config().serverPort != oldPort {
+			log.Print("Changing T2G_SERVER_PORT requires a restart")
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvFile(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		data           string
+		expectedError  bool
+		expectedValues map[string]string
+	}{{
+		name: "1_valid",
+		data: "# Comment\n\nT2G_UI_TITLE=Title\nT2G_UI_TARGET = \"Orders API\"\nT2G_UI_MISC='a=b'\n",
+		expectedValues: map[string]string{
+			"T2G_UI_TITLE":  "Title",
+			"T2G_UI_TARGET": "Orders API",
+			"T2G_UI_MISC":   "a=b",
+		},
+	}, {
+		name:          "2_missing_equals",
+		data:          "T2G_UI_TITLE\n",
+		expectedError: true,
+	}, {
+		name:          "3_foreign_key",
+		data:          "PATH=/tmp\n",
+		expectedError: true,
+	}, {
+		name:          "4_config_file",
+		data:          "T2G_CONFIG_FILE=/etc/other.env\n",
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			values, err := ParseEnvFile([]byte(tc.data))
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if len(values) != len(tc.expectedValues) {
+				t.Errorf("Wrong number of values: got %v, want %v", len(values), len(tc.expectedValues))
+			}
+			for key, value := range tc.expectedValues {
+				if values[key] != value {
+					t.Errorf("Wrong value for %s: got %q, want %q", key, values[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestEnvFile_Apply(t *testing.T) {
+	t.Setenv("T2G_UI_TITLE", "Env")
+	t.Setenv("T2G_UI_TARGET", "")
+	os.Unsetenv("T2G_UI_TARGET")
+
+	path := filepath.Join(t.TempDir(), "token2go.env")
+	writeTestFile(t, path, "T2G_UI_TITLE=File\nT2G_UI_TARGET=Orders\n")
+
+	f := NewEnvFile(path)
+	if err := f.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if v := os.Getenv("T2G_UI_TITLE"); v != "File" {
+		t.Errorf("Wrong title: got %q, want %q", v, "File")
+	}
+	if v := os.Getenv("T2G_UI_TARGET"); v != "Orders" {
+		t.Errorf("Wrong target: got %q, want %q", v, "Orders")
+	}
+
+	writeTestFile(t, path, "# Nothing\n")
+	if err := f.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if v := os.Getenv("T2G_UI_TITLE"); v != "Env" {
+		t.Errorf("Wrong title: got %q, want %q", v, "Env")
+	}
+	if _, ok := os.LookupEnv("T2G_UI_TARGET"); ok {
+		t.Error("Unexpected T2G_UI_TARGET in environment")
+	}
+
+	writeTestFile(t, path, "broken\n")
+	if err := f.Apply(); err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
+
+func TestDiffConfigEnv(t *testing.T) {
+	diff := DiffConfigEnv(map[string]string{
+		"T2G_UI_TITLE":                "A",
+		"T2G_UI_MISC":                 "x",
+		"T2G_FALLBACK_TOKEN":          "secret-a",
+		"T2G_TOKEN_EXCHANGE_URL":      "https://idp.example.com",
+		"T2G_INTROSPECTION_CLIENT_ID": "token2go",
+	}, map[string]string{
+		"T2G_UI_TITLE":                    "B",
+		"T2G_UI_TARGET":                   "Orders",
+		"T2G_FALLBACK_TOKEN":              "secret-b",
+		"T2G_TOKEN_EXCHANGE_URL":          "https://idp.example.com",
+		"T2G_INTROSPECTION_CLIENT_ID":     "token2go",
+		"T2G_INTROSPECTION_CLIENT_SECRET": "secret-c",
+	})
+
+	expected := []string{
+		`T2G_FALLBACK_TOKEN: changed (redacted)`,
+		`T2G_INTROSPECTION_CLIENT_SECRET: changed (redacted)`,
+		`T2G_UI_MISC: removed "x"`,
+		`T2G_UI_TARGET: added "Orders"`,
+		`T2G_UI_TITLE: "A" -> "B"`,
+	}
+	if got, want := strings.Join(diff, "\n"), strings.Join(expected, "\n"); got != want {
+		t.Errorf("Wrong diff: got %q, want %q", got, want)
+	}
+	for _, line := range diff {
+		if strings.Contains(line, "secret-") {
+			t.Errorf("Secret in diff: %q", line)
+		}
+	}
+}
+
+func TestReloader(t *testing.T) {
+	t.Setenv("T2G_UI_TITLE", "")
+	t.Setenv("T2G_TOKEN_HEADER_NAMES", "")
+	t.Setenv("T2G_ECHO_ENABLED", "")
+
+	path := filepath.Join(t.TempDir(), "token2go.env")
+	writeTestFile(t, path, "T2G_UI_TITLE=Before\nT2G_TOKEN_HEADER_NAMES=X-Before\n")
+
+	rl, err := NewReloader(NewEnvFile(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(target string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		for name, value := range headers {
+			r.Header.Set(name, value)
+		}
+		rr := httptest.NewRecorder()
+		rl.ServeHTTP(rr, r)
+		return rr
+	}
+
+	if rr := serve("/", nil); !strings.Contains(rr.Body.String(), "<title>Before</title>") {
+		t.Error("Didn't find initial title in body")
+	}
+	oldRouter := rl.router.Load()
+
+	writeTestFile(t, path, "T2G_UI_TITLE=After\nT2G_TOKEN_HEADER_NAMES=X-After\n")
+	diff, err := rl.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `T2G_TOKEN_HEADER_NAMES: "X-Before" -> "X-After"` + "\n" + `T2G_UI_TITLE: "Before" -> "After"`
+	if got := strings.Join(diff, "\n"); got != expected {
+		t.Errorf("Wrong diff: got %q, want %q", got, expected)
+	}
+
+	if rr := serve("/", nil); !strings.Contains(rr.Body.String(), "<title>After</title>") {
+		t.Error("Didn't find reloaded title in body")
+	}
+	if rr := serve("/token?format=text", map[string]string{"X-After": "a"}); rr.Code != 200 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 200)
+	}
+	if rr := serve("/token?format=text", map[string]string{"X-Before": "a"}); rr.Code != StatusTokenNotFound {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, StatusTokenNotFound)
+	}
+
+	// Requests that started before the reload keep using the old router.
+	rr := httptest.NewRecorder()
+	oldRouter.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	if !strings.Contains(rr.Body.String(), "<title>Before</title>") {
+		t.Error("Didn't find initial title in body of old router")
+	}
+
+	// Invalid configuration is rejected and the current one kept.
+	writeTestFile(t, path, "T2G_UI_TITLE=Broken\nT2G_ECHO_ENABLED=maybe\n")
+	if _, err := rl.Reload(); err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+	if rr := serve("/", nil); !strings.Contains(rr.Body.String(), "<title>After</title>") {
+		t.Error("Didn't find kept title in body")
+	}
+	if v := os.Getenv("T2G_UI_TITLE"); v != "After" {
+		t.Errorf("Wrong title in environment: got %q, want %q", v, "After")
+	}
+}
+
+func writeTestFile(t *testing.T, path string, data string) {
+	t.Helper()
+
+	err := os.WriteFile(path, []byte(data), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
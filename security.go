@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// CSPNoncePlaceholder is replaced with the nonce of the request in the
+// Content-Security-Policy.
+const CSPNoncePlaceholder = "{nonce}"
+
+// DefaultContentSecurityPolicy only allows resources from the own origin and
+// inline scripts and styles that carry the nonce of the request. Embedding the
+// pages in frames is forbidden.
+const DefaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'nonce-" + CSPNoncePlaceholder + "'; " +
+	"style-src 'self' 'nonce-" + CSPNoncePlaceholder + "'; " +
+	"img-src 'self' data:; " +
+	"object-src 'none'; " +
+	"base-uri 'none'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// PermissionsPolicy disables browser features no page of Token2go needs.
+const PermissionsPolicy = "camera=(), geolocation=(), microphone=(), payment=(), usb=()"
+
+// cspNonceContextKey is the key of the nonce in request contexts.
+type cspNonceContextKey struct{}
+
+// ValidateContentSecurityPolicy returns an error if the given policy contains
+// characters that are not allowed in header values.
+func ValidateContentSecurityPolicy(policy string) error {
+	if strings.ContainsAny(policy, "\r\n") {
+		return fmt.Errorf("content security policy must be a single line")
+	}
+
+	return nil
+}
+
+// MakeSecurityHeadersMiddleware returns a middleware that sets security
+// headers on all responses. The given Content-Security-Policy is sent with
+// CSPNoncePlaceholder replaced by a nonce generated per request. Retrieve the
+// nonce with CSPNonce. The header is left out if policy is empty.
+// Strict-Transport-Security with the given max-age in seconds is only sent if
+// ClientScheme is "https" and hstsMaxAge is positive.
+func MakeSecurityHeadersMiddleware(policy string, hstsMaxAge int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			h.Set("Referrer-Policy", "no-referrer")
+			h.Set("X-Frame-Options", "DENY")
+			h.Set("X-Content-Type-Options", "nosniff")
+			h.Set("Permissions-Policy", PermissionsPolicy)
+
+			if hstsMaxAge > 0 && ClientScheme(r) == "https" {
+				h.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(hstsMaxAge))
+			}
+
+			if len(policy) > 0 {
+				b, err := GenRandBytes(16)
+				if err != nil {
+					panic(err)
+				}
+				nonce := base64.RawURLEncoding.EncodeToString(b)
+
+				h.Set("Content-Security-Policy", strings.ReplaceAll(policy, CSPNoncePlaceholder, nonce))
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceContextKey{}, nonce))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// CSPNonce returns the nonce stored in the request context by the middleware
+// from MakeSecurityHeadersMiddleware. Returns the empty string if there is
+// none.
+func CSPNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceContextKey{}).(string)
+
+	return nonce
+}
+
+// AllowCSPFormAction adds the given source to the directive form-action of
+// the Content-Security-Policy already set on w. Browsers apply the directive
+// also to redirects after form submissions, so pages with forms that end up
+// on other origins must allow them. Does nothing if the policy has no such
+// directive, as form-action does not fall back to default-src.
+func AllowCSPFormAction(w http.ResponseWriter, source string) {
+	policy := w.Header().Get("Content-Security-Policy")
+	if len(policy) == 0 {
+		return
+	}
+
+	directives := strings.Split(policy, ";")
+	for i, directive := range directives {
+		fields := strings.Fields(directive)
+		if len(fields) > 0 && strings.EqualFold(fields[0], "form-action") {
+			directives[i] = strings.TrimRight(directive, " ") + " " + source
+		}
+	}
+
+	w.Header().Set("Content-Security-Policy", strings.Join(directives, ";"))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"crypto/tls"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMakeSecurityHeadersMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		policy       string
+		hstsMaxAge   int
+		tls          bool
+		expectedCSP  bool
+		expectedHSTS string
+	}{{
+		name:        "1_http",
+		policy:      DefaultContentSecurityPolicy,
+		hstsMaxAge:  31536000,
+		expectedCSP: true,
+	}, {
+		name:         "2_https",
+		policy:       DefaultContentSecurityPolicy,
+		hstsMaxAge:   31536000,
+		tls:          true,
+		expectedCSP:  true,
+		expectedHSTS: "max-age=31536000",
+	}, {
+		name:        "3_hsts_disabled",
+		policy:      DefaultContentSecurityPolicy,
+		tls:         true,
+		expectedCSP: true,
+	}, {
+		name:         "4_csp_disabled",
+		hstsMaxAge:   60,
+		tls:          true,
+		expectedHSTS: "max-age=60",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			var nonce string
+			handler := MakeSecurityHeadersMiddleware(tc.policy, tc.hstsMaxAge)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					nonce = CSPNonce(r)
+				}),
+			)
+
+			r := httptest.NewRequest("GET", "/", nil)
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			for header, want := range map[string]string{
+				"Referrer-Policy":           "no-referrer",
+				"X-Frame-Options":           "DENY",
+				"X-Content-Type-Options":    "nosniff",
+				"Permissions-Policy":        PermissionsPolicy,
+				"Strict-Transport-Security": tc.expectedHSTS,
+			} {
+				if got := rr.Header().Get(header); got != want {
+					t.Errorf("Wrong %s: got %q, want %q", header, got, want)
+				}
+			}
+
+			csp := rr.Header().Get("Content-Security-Policy")
+			if !tc.expectedCSP {
+				if len(csp) > 0 || len(nonce) > 0 {
+					t.Errorf("Unexpected policy: got %q and nonce %q, want none", csp, nonce)
+				}
+				return
+			}
+			if len(nonce) == 0 {
+				t.Fatal("Missing nonce in request context")
+			}
+			if substr := "'nonce-" + nonce + "'"; !strings.Contains(csp, substr) {
+				t.Errorf("Didn't find substr in policy: got %q, want %q", csp, substr)
+			}
+			if substr := "frame-ancestors 'none'"; !strings.Contains(csp, substr) {
+				t.Errorf("Didn't find substr in policy: got %q, want %q", csp, substr)
+			}
+		})
+	}
+}
+
+func TestMakeSecurityHeadersMiddleware_UniqueNonce(t *testing.T) {
+	handler := MakeSecurityHeadersMiddleware(DefaultContentSecurityPolicy, 0)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+
+	policies := map[string]bool{}
+	for i := 0; i < 10; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		policies[rr.Header().Get("Content-Security-Policy")] = true
+	}
+
+	if len(policies) != 10 {
+		t.Errorf("Wrong number of distinct policies: got %v, want %v", len(policies), 10)
+	}
+}
+
+func TestInitRouter_SecurityHeaders(t *testing.T) {
+	tmpl := template.Must(template.New("index.html").Parse(
+		`<style nonce="{{ .Nonce }}">h1 { color: red; }</style>`,
+	))
+	router := initRouter(RouterArgs{
+		contentSecurityPolicy: DefaultContentSecurityPolicy,
+		uiTemplates:           tmpl,
+	})
+
+	for _, path := range []string{"/", "/health", "/swagger-ui/"} {
+		t.Run(path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+
+			csp := rr.Header().Get("Content-Security-Policy")
+			if substr := "frame-ancestors 'none'"; !strings.Contains(csp, substr) {
+				t.Errorf("Didn't find substr in policy: got %q, want %q", csp, substr)
+			}
+			if got := rr.Header().Get("Referrer-Policy"); got != "no-referrer" {
+				t.Errorf("Wrong Referrer-Policy: got %q, want %q", got, "no-referrer")
+			}
+
+			if path != "/" {
+				return
+			}
+			_, rest, _ := strings.Cut(csp, "'nonce-")
+			nonce, _, _ := strings.Cut(rest, "'")
+			if substr := `<style nonce="` + nonce + `">`; !strings.Contains(rr.Body.String(), substr) {
+				t.Errorf("Didn't find substr in body: want %q", substr)
+			}
+		})
+	}
+}
+
+func TestAllowCSPFormAction(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		policy   string
+		expected string
+	}{{
+		name:     "1_directive",
+		policy:   "default-src 'self'; form-action 'self'; frame-ancestors 'none'",
+		expected: "default-src 'self'; form-action 'self' https://a.example.com; frame-ancestors 'none'",
+	}, {
+		name:     "2_first_directive",
+		policy:   "form-action 'self'",
+		expected: "form-action 'self' https://a.example.com",
+	}, {
+		name:     "3_no_directive",
+		policy:   "default-src 'self'",
+		expected: "default-src 'self'",
+	}, {
+		name:     "4_no_policy",
+		policy:   "",
+		expected: "",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			if len(tc.policy) > 0 {
+				rr.Header().Set("Content-Security-Policy", tc.policy)
+			}
+
+			AllowCSPFormAction(rr, "https://a.example.com")
+
+			if got := rr.Header().Get("Content-Security-Policy"); got != tc.expected {
+				t.Errorf("Wrong policy: got %q, want %q", got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// SnippetTokenPlaceholder is rendered into usage snippets in place of the
+// token. The web page replaces it with the live token when copying a snippet,
+// so tokens are never part of the page itself.
+const SnippetTokenPlaceholder = "<TOKEN>"
+
+var ErrRequestHostInvalid = errors.New("request host invalid")
+
+// requestHostRegexp matches host names and IP addresses with optional port.
+// Anything else could break out of the quoting in snippets and downloads.
+var requestHostRegexp = regexp.MustCompile(
+	`^([A-Za-z0-9_-]+(\.[A-Za-z0-9_-]+)*\.?|\[[0-9A-Fa-f:.]+\])(:[0-9]{1,5})?$`,
+)
+
+// DefaultSnippetAPIBase is the target API base used in usage snippets if the
+// operator does not configure one.
+const DefaultSnippetAPIBase = "https://api.example.com"
+
+// SnippetData is the input data for usage snippet templates.
+type SnippetData struct {
+	// BaseURL of the Token2go server as seen by the client. For example
+	// "https://token2go.example.com".
+	BaseURL string
+
+	// APIBase is the base URL of the API the token is meant for.
+	APIBase string
+
+	// Token is the SnippetTokenPlaceholder.
+	Token string
+}
+
+// Snippet is a usage snippet template. Use NewSnippets to construct.
+type Snippet struct {
+	name  string
+	title string
+	tmpl  *template.Template
+}
+
+// RenderedSnippet is a usage snippet rendered for the web page.
+type RenderedSnippet struct {
+	Name  string
+	Title string
+	Code  string
+}
+
+// Snippets are the usage snippets shown on the web page in the configured
+// order. Use NewSnippets to construct.
+type Snippets struct {
+	apiBase  string
+	snippets []Snippet
+}
+
+// BuiltinSnippetTitles returns the titles of the built-in usage snippets keyed
+// by name. Their templates are embedded into the server.
+func BuiltinSnippetTitles() map[string]string {
+	return map[string]string{
+		"curl":       "curl",
+		"httpie":     "HTTPie",
+		"python":     "Python requests",
+		"powershell": "PowerShell",
+		"jupyter":    "Jupyter",
+	}
+}
+
+// DefaultSnippetNames returns the names of the usage snippets shown by
+// default.
+func DefaultSnippetNames() []string {
+	return []string{"curl", "httpie", "python", "powershell", "jupyter"}
+}
+
+// NewSnippets creates usage snippets with the given names from the templates
+// named like "curl.tmpl" in fsys. Snippets are rendered once up front. Returns
+// an error if a name is invalid or a template can't be loaded or rendered.
+func NewSnippets(fsys fs.FS, names []string, apiBase string) (Snippets, error) {
+	if len(apiBase) == 0 {
+		apiBase = DefaultSnippetAPIBase
+	}
+
+	s := Snippets{apiBase: strings.TrimSuffix(apiBase, "/")}
+	titles := BuiltinSnippetTitles()
+
+	for _, name := range names {
+		if !tokenProfileNameRegexp.MatchString(name) {
+			return Snippets{}, fmt.Errorf("snippet %q: invalid name", name)
+		}
+
+		text, err := fs.ReadFile(fsys, name+".tmpl")
+		if err != nil {
+			return Snippets{}, fmt.Errorf("snippet %q: failed to read template: %w", name, err)
+		}
+
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return Snippets{}, fmt.Errorf("snippet %q: failed to parse template: %w", name, err)
+		}
+
+		title, ok := titles[name]
+		if !ok {
+			title = name
+		}
+
+		s.snippets = append(s.snippets, Snippet{name: name, title: title, tmpl: tmpl})
+	}
+
+	// Render once to detect broken templates before the first request.
+	_, err := s.Render("http://localhost")
+	if err != nil {
+		return Snippets{}, err
+	}
+
+	return s, nil
+}
+
+// Render renders all snippets with the given base URL of the Token2go server.
+func (s Snippets) Render(baseURL string) ([]RenderedSnippet, error) {
+	data := SnippetData{
+		BaseURL: baseURL,
+		APIBase: s.apiBase,
+		Token:   SnippetTokenPlaceholder,
+	}
+
+	rendered := make([]RenderedSnippet, 0, len(s.snippets))
+
+	for _, snippet := range s.snippets {
+		var buffer bytes.Buffer
+
+		err := snippet.tmpl.Execute(&buffer, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render snippet %q: %w", snippet.name, err)
+		}
+
+		rendered = append(rendered, RenderedSnippet{
+			Name:  snippet.name,
+			Title: snippet.title,
+			Code:  strings.TrimSpace(buffer.String()),
+		})
+	}
+
+	return rendered, nil
+}
+
+// RequestHost returns the host the client used to reach the server. Returns
+// ErrRequestHostInvalid (wrapped) if it is no host name or IP address with
+// optional port, so it can be rendered into snippets and downloads safely.
+func RequestHost(r *http.Request) (string, error) {
+	if !requestHostRegexp.MatchString(r.Host) {
+		return "", fmt.Errorf("%w: %q", ErrRequestHostInvalid, r.Host)
+	}
+
+	return r.Host, nil
+}
+
+// RequestBaseURL returns the scheme, host, and BasePath the client used to
+// reach the server. The scheme is taken from ClientScheme, so the URL stays
+// correct behind a trusted TLS terminating proxy. Returns an error like
+// RequestHost if the host is invalid.
+func RequestBaseURL(r *http.Request) (string, error) {
+	host, err := RequestHost(r)
+	if err != nil {
+		return "", err
+	}
+
+	return ClientScheme(r) + "://" + host + BasePath(r), nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNewSnippets(t *testing.T) {
+	embedded, err := NewUIContent("template/snippet", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	fsys := OverlayFS{fstest.MapFS{
+		"wget.tmpl":    {Data: []byte("wget --header 'Authorization: Bearer {{ .Token }}' {{ .APIBase }}\n")},
+		"missing.tmpl": {Data: []byte("{{ .Nope }}")},
+		"broken.tmpl":  {Data: []byte("{{ .Token")},
+	}, embedded}
+
+	for _, tc := range []struct {
+		name          string
+		names         []string
+		expectedError bool
+		expectedTitle string
+		expectedCode  string
+	}{{
+		name:          "1_builtin",
+		names:         []string{"curl"},
+		expectedError: false,
+		expectedTitle: "curl",
+		expectedCode:  "curl -H 'Authorization: Bearer <TOKEN>' 'https://api.example.com/v1'",
+	}, {
+		name:          "2_custom",
+		names:         []string{"wget"},
+		expectedError: false,
+		expectedTitle: "wget",
+		expectedCode:  "wget --header 'Authorization: Bearer <TOKEN>' https://api.example.com/v1",
+	}, {
+		name:          "3_all_builtins",
+		names:         DefaultSnippetNames(),
+		expectedError: false,
+		expectedTitle: "curl",
+		expectedCode:  "curl -H 'Authorization: Bearer <TOKEN>' 'https://api.example.com/v1'",
+	}, {
+		name:          "4_unknown",
+		names:         []string{"nope"},
+		expectedError: true,
+	}, {
+		name:          "5_missing_value",
+		names:         []string{"missing"},
+		expectedError: true,
+	}, {
+		name:          "6_broken",
+		names:         []string{"broken"},
+		expectedError: true,
+	}, {
+		name:          "7_invalid_name",
+		names:         []string{"../curl"},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			snippets, err := NewSnippets(fsys, tc.names, "https://api.example.com/v1/")
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			rendered, err := snippets.Render("https://token2go.example.com")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(rendered) != len(tc.names) {
+				t.Fatalf("Wrong number of snippets: got %v, want %v", len(rendered), len(tc.names))
+			}
+			if rendered[0].Title != tc.expectedTitle {
+				t.Errorf("Wrong title: got %q, want %q", rendered[0].Title, tc.expectedTitle)
+			}
+			if rendered[0].Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %q, want %q", rendered[0].Code, tc.expectedCode)
+			}
+		})
+	}
+}
+
+func TestRequestBaseURL(t *testing.T) {
+	for _, tc := range []struct {
+		name            string
+		host            string
+		tls             bool
+		trusted         bool
+		forwardedProto  string
+		basePath        string
+		expectedBaseURL string
+	}{{
+		name:            "1_http",
+		expectedBaseURL: "http://example.com",
+	}, {
+		name:            "2_tls",
+		tls:             true,
+		expectedBaseURL: "https://example.com",
+	}, {
+		name:            "3_forwarded_proto",
+		trusted:         true,
+		forwardedProto:  "https",
+		expectedBaseURL: "https://example.com",
+	}, {
+		name:            "4_forwarded_proto_invalid",
+		trusted:         true,
+		forwardedProto:  "javascript",
+		expectedBaseURL: "http://example.com",
+	}, {
+		name:            "5_forwarded_proto_untrusted",
+		forwardedProto:  "https",
+		expectedBaseURL: "http://example.com",
+	}, {
+		name:            "6_base_path",
+		basePath:        "/token2go",
+		expectedBaseURL: "http://example.com/token2go",
+	}, {
+		name:            "7_port",
+		host:            "localhost:8080",
+		expectedBaseURL: "http://localhost:8080",
+	}, {
+		name:            "8_ipv6",
+		host:            "[::1]:8080",
+		expectedBaseURL: "http://[::1]:8080",
+	}, {
+		name:            "9_quote",
+		host:            "example.com'$(id)'",
+		expectedBaseURL: "",
+	}, {
+		name:            "10_script",
+		host:            "example.com\"+__import__('os')+\"",
+		expectedBaseURL: "",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if len(tc.host) > 0 {
+				r.Host = tc.host
+			}
+			if tc.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			if len(tc.forwardedProto) > 0 {
+				r.Header.Set("X-Forwarded-For", "198.51.100.1")
+				r.Header.Set("X-Forwarded-Proto", tc.forwardedProto)
+			}
+			var trustedProxies []netip.Prefix
+			if tc.trusted {
+				trustedProxies = []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}
+			}
+
+			var baseURL string
+			var err error
+			MakeClientMiddleware(trustedProxies)(MakeBasePathMiddleware(tc.basePath, false)(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					baseURL, err = RequestBaseURL(r)
+				}),
+			)).ServeHTTP(httptest.NewRecorder(), r)
+
+			if len(tc.expectedBaseURL) == 0 {
+				if !errors.Is(err, ErrRequestHostInvalid) {
+					t.Errorf("Wrong error: got %v, want %v", err, ErrRequestHostInvalid)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if baseURL != tc.expectedBaseURL {
+				t.Errorf("Wrong base URL: got %q, want %q", baseURL, tc.expectedBaseURL)
+			}
+		})
+	}
+}
+
+func TestInitRouter_Snippets(t *testing.T) {
+	t.Setenv("T2G_UI_API_BASE", "https://api.example.com/v1")
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewRouterArgs(c, NewRouterState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initRouter(a)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "token2go.example.com"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 200 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 200)
+	}
+	for _, substr := range []string{
+		`id="snippet-curl"`,
+		`Bearer &lt;TOKEN&gt;&#39; &#39;https://api.example.com/v1&#39;`,
+		`http://token2go.example.com/flow/redirect/token`,
+		`data-snippet="snippet-jupyter"`,
+	} {
+		if !strings.Contains(rr.Body.String(), substr) {
+			t.Errorf("Didn't find substr in body: want %q", substr)
+		}
+	}
+}
+
+func TestInitRouter_SnippetsInvalidHost(t *testing.T) {
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewRouterArgs(c, NewRouterState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initRouter(a)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Host = "example.com'; rm -rf ~; '"
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 400 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 400)
+	}
+	if substr := "ErrRequestHostInvalid"; !strings.Contains(rr.Body.String(), substr) {
+		t.Errorf("Didn't find substr in body: want %q", substr)
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var ErrTokenNotFound = errors.New("failed to find token")
+
+// TokenSource is a single place a token can be extracted from. Sources are
+// combined into a TokenSourceChain.
+type TokenSource interface {
+	// Name identifies the source. It is recorded in extracted tokens.
+	Name() string
+
+	// Extract returns the secret found in the request. Returns ErrTokenNotFound
+	// (possibly wrapped) if the source has nothing to offer. Other errors abort
+	// the chain.
+	Extract(r *http.Request) (string, error)
+}
+
+// HeaderTokenSource extracts the first value of a header. The header name is
+// matched exactly against the keys of the request's header map.
+type HeaderTokenSource struct {
+	headerName string
+}
+
+func (s HeaderTokenSource) Name() string {
+	return "header:" + s.headerName
+}
+
+func (s HeaderTokenSource) Extract(r *http.Request) (string, error) {
+	if values, ok := r.Header[s.headerName]; ok && len(values) > 0 && len(values[0]) > 0 {
+		return values[0], nil
+	}
+
+	return "", ErrTokenNotFound
+}
+
+// CookieTokenSource extracts the value of a cookie. Cookies split into chunks
+// are reassembled with ReadChunkedCookie.
+type CookieTokenSource struct {
+	cookieName string
+}
+
+func (s CookieTokenSource) Name() string {
+	return "cookie:" + s.cookieName
+}
+
+func (s CookieTokenSource) Extract(r *http.Request) (string, error) {
+	if value, ok := ReadChunkedCookie(r.Header, s.cookieName); ok {
+		return value, nil
+	}
+
+	return "", ErrTokenNotFound
+}
+
+// QueryTokenSource extracts the value of a query parameter.
+type QueryTokenSource struct {
+	paramName string
+}
+
+func (s QueryTokenSource) Name() string {
+	return "query:" + s.paramName
+}
+
+func (s QueryTokenSource) Extract(r *http.Request) (string, error) {
+	if value := r.URL.Query().Get(s.paramName); len(value) > 0 {
+		return value, nil
+	}
+
+	return "", ErrTokenNotFound
+}
+
+// BasicAuthTokenSource extracts the password from HTTP Basic authentication.
+// The username is ignored.
+type BasicAuthTokenSource struct{}
+
+func (s BasicAuthTokenSource) Name() string {
+	return "basic"
+}
+
+func (s BasicAuthTokenSource) Extract(r *http.Request) (string, error) {
+	if _, password, ok := r.BasicAuth(); ok && len(password) > 0 {
+		return password, nil
+	}
+
+	return "", ErrTokenNotFound
+}
+
+// FileTokenSource reads the token from a file on every extraction. This allows
+// the file to be rotated, for example by a Kubernetes projected volume.
+// Surrounding whitespace is trimmed. A missing file counts as no token.
+type FileTokenSource struct {
+	path string
+}
+
+func (s FileTokenSource) Name() string {
+	return "file:" + s.path
+}
+
+func (s FileTokenSource) Extract(r *http.Request) (string, error) {
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrTokenNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+
+	if secret := strings.TrimSpace(string(b)); len(secret) > 0 {
+		return secret, nil
+	}
+
+	return "", ErrTokenNotFound
+}
+
+// StaticTokenSource always returns the same secret. Used for the fallback
+// token.
+type StaticTokenSource struct {
+	secret string
+}
+
+func (s StaticTokenSource) Name() string {
+	return "static"
+}
+
+func (s StaticTokenSource) Extract(r *http.Request) (string, error) {
+	if len(s.secret) > 0 {
+		return s.secret, nil
+	}
+
+	return "", ErrTokenNotFound
+}
+
+// OAuth2ProxySessionTokenSource extracts the access, ID, or refresh token from
+// the encrypted oauth2-proxy session cookie. Undecodable cookies count as no
+// token.
+type OAuth2ProxySessionTokenSource struct {
+	cookie *OAuth2ProxySessionCookie
+	token  string
+}
+
+func (s OAuth2ProxySessionTokenSource) Name() string {
+	if s.token == "access" {
+		return "oauth2-proxy-session:" + s.cookie.name
+	}
+
+	return "oauth2-proxy-session:" + s.cookie.name + ":" + s.token
+}
+
+func (s OAuth2ProxySessionTokenSource) Extract(r *http.Request) (string, error) {
+	session, err := s.cookie.Decode(r.Header)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrTokenNotFound, err)
+	}
+
+	var secret string
+	switch s.token {
+	case "access":
+		secret = session.AccessToken
+	case "id":
+		secret = session.IDToken
+	case "refresh":
+		secret = session.RefreshToken
+	}
+
+	if len(secret) > 0 {
+		return secret, nil
+	}
+
+	return "", ErrTokenNotFound
+}
+
+// ProcessedTokenSource wraps a TokenSource and post-processes its secrets.
+// First, the prefix is trimmed. Next, if a pattern is set, the secret must
+// match it. If the pattern contains a capturing group, the first group
+// becomes the secret. Otherwise the whole match does. Secrets that do not
+// match count as no token.
+type ProcessedTokenSource struct {
+	TokenSource
+	trimPrefix string
+	pattern    *regexp.Regexp
+}
+
+func (s ProcessedTokenSource) Extract(r *http.Request) (string, error) {
+	secret, err := s.TokenSource.Extract(r)
+	if err != nil {
+		return "", err
+	}
+
+	secret = strings.TrimPrefix(secret, s.trimPrefix)
+
+	if s.pattern != nil {
+		match := s.pattern.FindStringSubmatch(secret)
+		switch {
+		case match == nil:
+			return "", ErrTokenNotFound
+		case len(match) > 1:
+			secret = match[1]
+		default:
+			secret = match[0]
+		}
+	}
+
+	if len(secret) == 0 {
+		return "", ErrTokenNotFound
+	}
+
+	return secret, nil
+}
+
+// TokenSourceChain is an ordered list of token sources. The first source that
+// returns a token wins.
+type TokenSourceChain []TokenSource
+
+// Extract goes through the chain and returns the first token found. The token
+// records the name of the source. Returns ErrTokenNotFound if no source
+// offered a token. Other errors from sources are bubbled up.
+func (c TokenSourceChain) Extract(r *http.Request) (Token, error) {
+	for _, source := range c {
+		secret, err := source.Extract(r)
+		if errors.Is(err, ErrTokenNotFound) {
+			continue
+		}
+		if err != nil {
+			return Token{}, fmt.Errorf("token source %s failed: %w", source.Name(), err)
+		}
+
+		return NewToken(secret, source.Name()), nil
+	}
+
+	return Token{}, ErrTokenNotFound
+}
+
+// RequestSources returns the sources of the chain that read the token from the
+// request. Static and file sources are left out because they return the same
+// token for every request.
+func (c TokenSourceChain) RequestSources() TokenSourceChain {
+	var chain TokenSourceChain
+
+	for _, source := range c {
+		inner := source
+		if processed, ok := source.(ProcessedTokenSource); ok {
+			inner = processed.TokenSource
+		}
+		switch inner.(type) {
+		case StaticTokenSource, FileTokenSource:
+			continue
+		}
+		chain = append(chain, source)
+	}
+
+	return chain
+}
+
+// Names returns the names of all sources in the chain.
+func (c TokenSourceChain) Names() []string {
+	names := make([]string, len(c))
+	for i, source := range c {
+		names[i] = source.Name()
+	}
+
+	return names
+}
+
+// TokenSourceSpec is the configuration of one or more token sources of the
+// same type. Sources are configured as a JSON array of TokenSourceSpec.
+type TokenSourceSpec struct {
+	// Type is one of "header", "cookie", "query", "basic", "file", "static",
+	// and "oauth2-proxy-session".
+	Type string `json:"type"`
+
+	// Names of headers, cookies, or query parameters. One source is created
+	// per name. For "oauth2-proxy-session" the name of the session cookie.
+	Names []string `json:"names,omitempty"`
+
+	// Path of the file for "file".
+	Path string `json:"path,omitempty"`
+
+	// Secret for "static". Cookie secret for "oauth2-proxy-session".
+	Secret string `json:"secret,omitempty"`
+
+	// TrimPrefix is removed from the start of extracted secrets.
+	TrimPrefix string `json:"trimPrefix,omitempty"`
+
+	// Pattern is a regular expression extracted secrets must match.
+	Pattern string `json:"pattern,omitempty"`
+
+	// Token selects "access" (default), "id", or "refresh" token for
+	// "oauth2-proxy-session".
+	Token string `json:"token,omitempty"`
+
+	// Expire is the number of seconds a cookie of "oauth2-proxy-session" is
+	// accepted after it has been written. Defaults to
+	// T2G_OAUTH2_PROXY_COOKIE_EXPIRE.
+	Expire int `json:"expire,omitempty"`
+}
+
+// ParseTokenSourceSpecs unmarshals a JSON array of TokenSourceSpec.
+func ParseTokenSourceSpecs(s string) ([]TokenSourceSpec, error) {
+	var specs []TokenSourceSpec
+
+	err := json.Unmarshal([]byte(s), &specs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token source specs: %w", err)
+	}
+
+	return specs, nil
+}
+
+// NewTokenSourceChain creates a chain from the given specs while keeping the
+// order. Returns an error if a spec is invalid.
+func NewTokenSourceChain(specs []TokenSourceSpec) (TokenSourceChain, error) {
+	var chain TokenSourceChain
+
+	for i, spec := range specs {
+		var sources []TokenSource
+
+		switch spec.Type {
+		case "header":
+			for _, name := range spec.Names {
+				sources = append(sources, HeaderTokenSource{name})
+			}
+		case "cookie":
+			for _, name := range spec.Names {
+				sources = append(sources, CookieTokenSource{name})
+			}
+		case "query":
+			for _, name := range spec.Names {
+				sources = append(sources, QueryTokenSource{name})
+			}
+		case "basic":
+			sources = append(sources, BasicAuthTokenSource{})
+		case "file":
+			if len(spec.Path) == 0 {
+				return nil, fmt.Errorf("token source %d: path required", i)
+			}
+			sources = append(sources, FileTokenSource{spec.Path})
+		case "static":
+			sources = append(sources, StaticTokenSource{spec.Secret})
+		case "oauth2-proxy-session":
+			if len(spec.Secret) == 0 {
+				return nil, fmt.Errorf("token source %d: secret required", i)
+			}
+			token := spec.Token
+			if len(token) == 0 {
+				token = "access"
+			}
+			if token != "access" && token != "id" && token != "refresh" {
+				return nil, fmt.Errorf("token source %d: unknown token %q", i, token)
+			}
+			for _, name := range spec.Names {
+				sources = append(sources, OAuth2ProxySessionTokenSource{
+					NewOAuth2ProxySessionCookie(name, spec.Secret, time.Duration(spec.Expire)*time.Second), token,
+				})
+			}
+		default:
+			return nil, fmt.Errorf("token source %d: unknown type %q", i, spec.Type)
+		}
+
+		var pattern *regexp.Regexp
+		if len(spec.Pattern) > 0 {
+			var err error
+			pattern, err = regexp.Compile(spec.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("token source %d: invalid pattern: %w", i, err)
+			}
+		}
+
+		for _, source := range sources {
+			if len(spec.TrimPrefix) > 0 || pattern != nil {
+				source = ProcessedTokenSource{source, spec.TrimPrefix, pattern}
+			}
+			chain = append(chain, source)
+		}
+	}
+
+	return chain, nil
+}
+
+// TokenSourceSpecsHeaderNames returns the names of all headers that may
+// contain tokens according to the given specs. Includes "Cookie" if tokens
+// are taken from cookies.
+func TokenSourceSpecsHeaderNames(specs []TokenSourceSpec) []string {
+	var names []string
+	var cookie bool
+
+	for _, spec := range specs {
+		switch spec.Type {
+		case "header":
+			names = append(names, spec.Names...)
+		case "cookie", "oauth2-proxy-session":
+			cookie = true
+		case "basic":
+			names = append(names, "Authorization")
+		}
+	}
+
+	if cookie {
+		names = append(names, "Cookie")
+	}
+
+	return names
+}
+
+// TokenSourceSpecsQueryNames returns the names of all query parameters that
+// may contain tokens according to the given specs.
+func TokenSourceSpecsQueryNames(specs []TokenSourceSpec) []string {
+	var names []string
+
+	for _, spec := range specs {
+		if spec.Type == "query" {
+			names = append(names, spec.Names...)
+		}
+	}
+
+	return names
+}
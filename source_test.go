@@ -0,0 +1,403 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestTokenSourceChain creates a chain that behaves like the one built from
+// T2G_TOKEN_HEADER_NAMES and T2G_FALLBACK_TOKEN.
+func newTestTokenSourceChain(
+	t *testing.T,
+	tokenHeaderNames []string,
+	fallbackToken string,
+) TokenSourceChain {
+	t.Helper()
+
+	chain, err := NewTokenSourceChain([]TokenSourceSpec{{
+		Type:       "header",
+		Names:      tokenHeaderNames,
+		TrimPrefix: "Bearer ",
+	}, {
+		Type:       "static",
+		Secret:     fallbackToken,
+		TrimPrefix: "Bearer ",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return chain
+}
+
+func TestTokenSources(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(tokenFile, []byte("  from-file\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name           string
+		source         TokenSource
+		target         string
+		headers        http.Header
+		expectedSecret string
+		expectedError  error
+	}{{
+		name:           "1_header",
+		source:         HeaderTokenSource{"Foo"},
+		headers:        http.Header{"Foo": {"x", "y"}},
+		expectedSecret: "x",
+	}, {
+		name:          "2_header_empty",
+		source:        HeaderTokenSource{"Foo"},
+		headers:       http.Header{"Foo": {""}},
+		expectedError: ErrTokenNotFound,
+	}, {
+		name:           "3_query",
+		source:         QueryTokenSource{"access_token"},
+		target:         "/token?access_token=x",
+		expectedSecret: "x",
+	}, {
+		name:          "4_query_missing",
+		source:        QueryTokenSource{"access_token"},
+		target:        "/token?token=x",
+		expectedError: ErrTokenNotFound,
+	}, {
+		name:           "5_basic",
+		source:         BasicAuthTokenSource{},
+		headers:        http.Header{"Authorization": {"Basic dXNlcjpwYXNz"}},
+		expectedSecret: "pass",
+	}, {
+		name:          "6_basic_bearer",
+		source:        BasicAuthTokenSource{},
+		headers:       http.Header{"Authorization": {"Bearer x"}},
+		expectedError: ErrTokenNotFound,
+	}, {
+		name:           "7_file",
+		source:         FileTokenSource{tokenFile},
+		expectedSecret: "from-file",
+	}, {
+		name:          "8_file_missing",
+		source:        FileTokenSource{tokenFile + "-missing"},
+		expectedError: ErrTokenNotFound,
+	}, {
+		name:           "9_static",
+		source:         StaticTokenSource{"x"},
+		expectedSecret: "x",
+	}, {
+		name: "10_pattern_group",
+		source: ProcessedTokenSource{
+			HeaderTokenSource{"Foo"}, "", regexp.MustCompile(`token=(\w+)`),
+		},
+		headers:        http.Header{"Foo": {"a=b; token=x; c=d"}},
+		expectedSecret: "x",
+	}, {
+		name: "11_pattern_mismatch",
+		source: ProcessedTokenSource{
+			HeaderTokenSource{"Foo"}, "", regexp.MustCompile(`^ey`),
+		},
+		headers:       http.Header{"Foo": {"x"}},
+		expectedError: ErrTokenNotFound,
+	}, {
+		name: "12_prefix_only",
+		source: ProcessedTokenSource{
+			HeaderTokenSource{"Foo"}, "Bearer ", nil,
+		},
+		headers:       http.Header{"Foo": {"Bearer "}},
+		expectedError: ErrTokenNotFound,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.target) == 0 {
+				tc.target = "/token"
+			}
+			request := httptest.NewRequest("GET", tc.target, nil)
+			if tc.headers != nil {
+				request.Header = tc.headers
+			}
+
+			secret, err := tc.source.Extract(request)
+			if !errors.Is(err, tc.expectedError) {
+				t.Errorf("Wrong error: got %v, want %v", err, tc.expectedError)
+			}
+			if secret != tc.expectedSecret {
+				t.Errorf("Wrong secret: got %q, want %q", secret, tc.expectedSecret)
+			}
+		})
+	}
+}
+
+func TestNewTokenSourceChain(t *testing.T) {
+	specs, err := ParseTokenSourceSpecs(`[
+		{"type": "header", "names": ["A", "B"], "trimPrefix": "Bearer "},
+		{"type": "cookie", "names": ["c"]},
+		{"type": "query", "names": ["q"], "pattern": "^ey"},
+		{"type": "basic"},
+		{"type": "file", "path": "/tmp/token"},
+		{"type": "static", "secret": "x"}
+	]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	chain, err := NewTokenSourceChain(specs)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	got := strings.Join(chain.Names(), ",")
+	want := "header:A,header:B,cookie:c,query:q,basic,file:/tmp/token,static"
+	if got != want {
+		t.Errorf("Wrong names: got %q, want %q", got, want)
+	}
+
+	for _, invalid := range []string{
+		`[{"type": "lol"}]`,
+		`[{"type": "file"}]`,
+		`[{"type": "oauth2-proxy-session", "names": ["x"]}]`,
+		`[{"type": "header", "names": ["x"], "pattern": "("}]`,
+	} {
+		specs, err := ParseTokenSourceSpecs(invalid)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = NewTokenSourceChain(specs)
+		if err == nil {
+			t.Errorf("Unexpected success for %s", invalid)
+		}
+	}
+
+	_, err = ParseTokenSourceSpecs("lol")
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
+
+func TestTokenSourceChain_Error(t *testing.T) {
+	chain := TokenSourceChain{
+		FileTokenSource{t.TempDir()},
+		StaticTokenSource{"x"},
+	}
+
+	_, err := chain.Extract(httptest.NewRequest("GET", "/token", nil))
+	if err == nil || errors.Is(err, ErrTokenNotFound) {
+		t.Errorf("Wrong error: got %v, want file read error", err)
+	}
+}
+
+func TestTokenSourceChain_RequestSources(t *testing.T) {
+	chain := TokenSourceChain{
+		HeaderTokenSource{"Authorization"},
+		ProcessedTokenSource{TokenSource: StaticTokenSource{"x"}, trimPrefix: "Bearer "},
+		FileTokenSource{"/token"},
+		QueryTokenSource{"token"},
+		StaticTokenSource{"y"},
+	}
+
+	got := strings.Join(chain.RequestSources().Names(), ",")
+	want := "header:Authorization,query:token"
+	if got != want {
+		t.Errorf("Wrong sources: got %q, want %q", got, want)
+	}
+}
+
+func TestTokenSourceSpecsHeaderNames(t *testing.T) {
+	got := strings.Join(TokenSourceSpecsHeaderNames([]TokenSourceSpec{
+		{Type: "header", Names: []string{"A", "B"}},
+		{Type: "oauth2-proxy-session"},
+		{Type: "basic"},
+		{Type: "static"},
+	}), ",")
+	want := "A,B,Authorization,Cookie"
+	if got != want {
+		t.Errorf("Wrong header names: got %q, want %q", got, want)
+	}
+}
+
+func TestTokenSourceSpecsQueryNames(t *testing.T) {
+	got := strings.Join(TokenSourceSpecsQueryNames([]TokenSourceSpec{
+		{Type: "header", Names: []string{"A"}},
+		{Type: "query", Names: []string{"token", "access_token"}},
+		{Type: "cookie", Names: []string{"t"}},
+	}), ",")
+	want := "token,access_token"
+	if got != want {
+		t.Errorf("Wrong query names: got %q, want %q", got, want)
+	}
+}
+
+func TestTokenSourceChain_Cookies(t *testing.T) {
+	chain, err := NewTokenSourceChain([]TokenSourceSpec{{
+		Type:  "header",
+		Names: []string{"Token"},
+	}, {
+		Type:  "cookie",
+		Names: []string{"t"},
+	}, {
+		Type:   "oauth2-proxy-session",
+		Names:  []string{"_oauth2_proxy"},
+		Secret: testCookieSecret,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessionValue := encodeTestSessionCookie(
+		t, "_oauth2_proxy", testCookieSecret, time.Now(), map[string]any{"at": "session"},
+	)
+
+	for _, tc := range []struct {
+		name           string
+		headers        http.Header
+		expectedSecret string
+		expectedSource string
+		expectedError  bool
+	}{{
+		name:           "1_header_first",
+		headers:        http.Header{"Token": {"header"}, "Cookie": {"t=cookie"}},
+		expectedSecret: "header",
+		expectedSource: "header:Token",
+		expectedError:  false,
+	}, {
+		name:           "2_cookie",
+		headers:        http.Header{"Cookie": {"u=other; t=cookie"}},
+		expectedSecret: "cookie",
+		expectedSource: "cookie:t",
+		expectedError:  false,
+	}, {
+		name:           "3_chunked_cookie",
+		headers:        http.Header{"Cookie": {"t_1=bar; t_0=foo"}},
+		expectedSecret: "foobar",
+		expectedSource: "cookie:t",
+		expectedError:  false,
+	}, {
+		name:           "4_session_cookie",
+		headers:        http.Header{"Cookie": {"_oauth2_proxy=" + sessionValue}},
+		expectedSecret: "session",
+		expectedSource: "oauth2-proxy-session:_oauth2_proxy",
+		expectedError:  false,
+	}, {
+		name:           "5_session_cookie_tampered",
+		headers:        http.Header{"Cookie": {"_oauth2_proxy=x" + sessionValue}},
+		expectedSecret: "",
+		expectedError:  true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/token", nil)
+			request.Header = tc.headers
+
+			token, err := chain.Extract(request)
+			if tc.expectedError && err == nil {
+				t.Errorf("Unexpected success: got %q, want error", token.Secret)
+			}
+			if !tc.expectedError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tc.expectedSecret != token.Secret {
+				t.Errorf(
+					"Wrong secret extracted: got %q, want %q",
+					token.Secret, tc.expectedSecret,
+				)
+			}
+			if tc.expectedSource != token.Source {
+				t.Errorf(
+					"Wrong source recorded: got %q, want %q",
+					token.Source, tc.expectedSource,
+				)
+			}
+		})
+	}
+}
+
+func TestTokenSourceChain_Legacy(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		headers          http.Header
+		tokenHeaderNames []string
+		fallbackToken    string
+		expectedSecret   string
+		expectedError    bool
+	}{{
+		name: "1_case_sensitive",
+		headers: http.Header{
+			"Baz":   {"c"},
+			"Token": {"d"},
+		},
+		tokenHeaderNames: []string{"foo", "bar", "baz"},
+		fallbackToken:    "",
+		expectedSecret:   "",
+		expectedError:    true,
+	}, {
+		name: "2_token_header_empty",
+		headers: http.Header{
+			"Baz":   {"c"},
+			"token": {"d"},
+			"Token": {},
+		},
+		tokenHeaderNames: []string{"Token"},
+		fallbackToken:    "",
+		expectedSecret:   "",
+		expectedError:    true,
+	}, {
+		name: "3_header_order",
+		headers: http.Header{
+			"A": {"a"},
+			"C": {"c"},
+			"B": {"b"},
+		},
+		tokenHeaderNames: []string{"X", "B", "C"},
+		fallbackToken:    "",
+		expectedSecret:   "b",
+		expectedError:    false,
+	}, {
+		name: "4_fallback",
+		headers: http.Header{
+			"x": {"a"},
+			"y": {},
+			"z": {"c"},
+		},
+		tokenHeaderNames: []string{"X", "Y", "Z"},
+		fallbackToken:    "Foobar",
+		expectedSecret:   "Foobar",
+		expectedError:    false,
+	}, {
+		name: "BearerToken",
+		headers: http.Header{
+			"x": {"a"},
+			"y": {},
+			"z": {"Bearer secret"},
+		},
+		tokenHeaderNames: []string{"z"},
+		fallbackToken:    "",
+		expectedSecret:   "secret",
+		expectedError:    false,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "/token", nil)
+			request.Header = tc.headers
+
+			chain := newTestTokenSourceChain(t, tc.tokenHeaderNames, tc.fallbackToken)
+			token, err := chain.Extract(request)
+			if tc.expectedError && err == nil {
+				t.Errorf("Unexpected success: got %q, want error", token.Secret)
+			}
+			if !tc.expectedError && err != nil {
+				t.Errorf("Unexpected error: %v", err)
+			}
+			if tc.expectedSecret != token.Secret {
+				t.Errorf(
+					"Wrong secret extracted: got %q, want %q",
+					token.Secret, tc.expectedSecret,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"sync"
+)
+
+// RouterState holds the stateful components of the routers: rate limiters,
+// introspection caches, the stream registry, wrapped tokens, and the key that
+// signs redirect confirmations. It is created once and passed to every
+// NewRouterArgs, so the state survives reloads and is shared by all tenants.
+// Components are created on first use and kept as long as their settings don't
+// change. Use NewRouterState to construct.
+type RouterState struct {
+	mu              sync.Mutex
+	rateLimiters    map[string]*RateLimiter
+	introspectors   map[introspectorKey]*TokenIntrospector
+	streamRegistry  *StreamRegistry
+	wrapStores      map[string]WrapStore
+	newWrapStore    func(tenant string) WrapStore
+	confirmationKey []byte
+}
+
+// introspectorKey identifies the settings of a TokenIntrospector.
+type introspectorKey struct {
+	endpoint      string
+	clientID      string
+	clientSecret  string
+	tokenTypeHint string
+}
+
+// NewRouterState creates an empty RouterState. Wrapped tokens are kept in a
+// MemoryWrapStore per tenant.
+func NewRouterState() *RouterState {
+	return NewRouterStateWithWrapStore(nil)
+}
+
+// NewRouterStateWithWrapStore creates an empty RouterState that keeps wrapped
+// tokens in the WrapStore returned by newWrapStore for the respective tenant,
+// for example one shared by all replicas. It is called once per tenant. Falls
+// back to a MemoryWrapStore per tenant if newWrapStore is nil.
+func NewRouterStateWithWrapStore(newWrapStore func(tenant string) WrapStore) *RouterState {
+	return &RouterState{
+		rateLimiters:  map[string]*RateLimiter{},
+		introspectors: map[introspectorKey]*TokenIntrospector{},
+		wrapStores:    map[string]WrapStore{},
+		newWrapStore:  newWrapStore,
+	}
+}
+
+// RateLimiter returns the RateLimiter with the given name. A new one is
+// created if there is none yet or its limit differs. Returns nil if the limit
+// is the zero RateLimit.
+func (s *RouterState) RateLimiter(name string, limit RateLimit) *RateLimiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, ok := s.rateLimiters[name]
+	if !ok || l == nil || l.limit != limit {
+		l = NewRateLimiter(limit)
+		s.rateLimiters[name] = l
+	}
+
+	return l
+}
+
+// TokenIntrospector returns the TokenIntrospector for the given client and
+// token type hint, so its cache is kept as long as the settings stay the same.
+func (s *RouterState) TokenIntrospector(client OAuth2Client, tokenTypeHint string) *TokenIntrospector {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := introspectorKey{
+		endpoint:      client.endpoint,
+		clientID:      client.clientID,
+		clientSecret:  client.clientSecret,
+		tokenTypeHint: tokenTypeHint,
+	}
+	i, ok := s.introspectors[key]
+	if !ok {
+		i = NewTokenIntrospector(client, tokenTypeHint)
+		s.introspectors[key] = i
+	}
+
+	return i
+}
+
+// StreamRegistry returns the StreamRegistry with the limits of the given
+// settings. Open streams stay registered when the limits change.
+func (s *RouterState) StreamRegistry(settings StreamSettings) *StreamRegistry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.streamRegistry == nil {
+		s.streamRegistry = NewStreamRegistry(settings)
+	} else {
+		s.streamRegistry.SetLimits(settings)
+	}
+
+	return s.streamRegistry
+}
+
+// WrapStore returns the WrapStore of the given tenant. Every tenant has its
+// own store, so tokens can only be unwrapped at the tenant that wrapped them.
+// The maximum number of entries only applies to the default MemoryWrapStore.
+// Stored tokens are kept when the maximum changes.
+func (s *RouterState) WrapStore(tenant string, maxEntries int) WrapStore {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	store, ok := s.wrapStores[tenant]
+	if !ok {
+		if s.newWrapStore != nil {
+			store = s.newWrapStore(tenant)
+		} else {
+			store = NewMemoryWrapStore(maxEntries)
+		}
+		s.wrapStores[tenant] = store
+	} else if s.newWrapStore == nil {
+		store.(*MemoryWrapStore).SetMaxEntries(maxEntries)
+	}
+
+	return store
+}
+
+// ConfirmationKey returns the key that signs redirect confirmations. It is
+// generated on first use. Returns an error if no random key can be generated.
+func (s *RouterState) ConfirmationKey() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.confirmationKey == nil {
+		key, err := GenRandBytes(32)
+		if err != nil {
+			return nil, err
+		}
+		s.confirmationKey = key
+	}
+
+	return s.confirmationKey, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRouterStateRateLimiter(t *testing.T) {
+	s := NewRouterState()
+	limit := RateLimit{Requests: 1, Per: time.Minute}
+
+	l := s.RateLimiter("ip", limit)
+	if ok, _ := l.Allow("a"); !ok {
+		t.Fatal("Wrong allow: got false, want true")
+	}
+
+	if s.RateLimiter("ip", limit) != l {
+		t.Error("Wrong rate limiter: got new one, want same for unchanged limit")
+	}
+	if ok, _ := s.RateLimiter("ip", limit).Allow("a"); ok {
+		t.Error("Wrong allow: got true, want false as bucket is kept")
+	}
+	if s.RateLimiter("fingerprint", limit) == l {
+		t.Error("Wrong rate limiter: got same, want new one for other name")
+	}
+	if s.RateLimiter("ip", RateLimit{Requests: 2, Per: time.Minute}) == l {
+		t.Error("Wrong rate limiter: got same, want new one for changed limit")
+	}
+	if s.RateLimiter("ip", RateLimit{}) != nil {
+		t.Error("Wrong rate limiter: got not nil, want nil for zero limit")
+	}
+}
+
+func TestRouterStateTokenIntrospector(t *testing.T) {
+	s := NewRouterState()
+
+	i := s.TokenIntrospector(NewOAuth2Client("https://a", "client", "secret"), "")
+	if s.TokenIntrospector(NewOAuth2Client("https://a", "client", "secret"), "") != i {
+		t.Error("Wrong introspector: got new one, want same for unchanged settings")
+	}
+	if s.TokenIntrospector(NewOAuth2Client("https://a", "client", "other"), "") == i {
+		t.Error("Wrong introspector: got same, want new one for changed secret")
+	}
+	if s.TokenIntrospector(NewOAuth2Client("https://a", "client", "secret"), "access_token") == i {
+		t.Error("Wrong introspector: got same, want new one for changed hint")
+	}
+}
+
+func TestRouterStateStreamRegistry(t *testing.T) {
+	s := NewRouterState()
+
+	g := s.StreamRegistry(StreamSettings{MaxConnections: 2, MaxConnectionsPerToken: 2})
+	_, err := g.Register("a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if s.StreamRegistry(StreamSettings{MaxConnections: 1, MaxConnectionsPerToken: 1}) != g {
+		t.Fatal("Wrong registry: got new one, want same")
+	}
+	if got := g.Count(); got != 1 {
+		t.Errorf("Wrong count: got %d, want 1", got)
+	}
+	if _, err := g.Register("b"); err == nil {
+		t.Error("Unexpected success: got nil, want error as new limit is reached")
+	}
+}
+
+func TestRouterStateWrapStore(t *testing.T) {
+	s := NewRouterState()
+
+	store := s.WrapStore("", 1)
+	err := store.Put(context.Background(), "a", WrappedToken{ExpiresAt: time.Now().Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if s.WrapStore("", 2) != store {
+		t.Fatal("Wrong store: got new one, want same")
+	}
+	if _, err := store.Take(context.Background(), "a"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if s.WrapStore("other", 2) == store {
+		t.Error("Wrong store: got same, want new one for other tenant")
+	}
+}
+
+func TestRouterStateWrapStore_Custom(t *testing.T) {
+	var tenants []string
+	s := NewRouterStateWithWrapStore(func(tenant string) WrapStore {
+		tenants = append(tenants, tenant)
+		return NewMemoryWrapStore(1)
+	})
+
+	store := s.WrapStore("a", 5)
+	if s.WrapStore("a", 5) != store {
+		t.Fatal("Wrong store: got new one, want same")
+	}
+	s.WrapStore("b", 5)
+
+	if len(tenants) != 2 || tenants[0] != "a" || tenants[1] != "b" {
+		t.Errorf("Wrong tenants: got %v, want %v", tenants, []string{"a", "b"})
+	}
+}
+
+func TestRouterStateConfirmationKey(t *testing.T) {
+	s := NewRouterState()
+
+	key, err := s.ConfirmationKey()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(key) != 32 {
+		t.Errorf("Wrong key length: got %d, want 32", len(key))
+	}
+
+	again, err := s.ConfirmationKey()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Equal(key, again) {
+		t.Error("Wrong key: got new one, want same")
+	}
+}
@@ -8,10 +8,31 @@ const autoDeleteSwitch = document.getElementById("switch-auto-delete");
 const tokenInput = document.getElementById("token-input");
 const fingerInput = document.getElementById("finger-input");
 
+const details = document.getElementById("details");
+const detailsIssuedAt = document.getElementById("details-issued-at");
+const detailsExpiresAt = document.getElementById("details-expires-at");
+const detailsExpiresIn = document.getElementById("details-expires-in");
+const detailsHeader = document.getElementById("details-header");
+const detailsClaims = document.getElementById("details-claims");
+
+// Copy buttons of usage snippets. Only rendered if snippets are configured.
+const snippetButtons = document.querySelectorAll("[data-snippet]");
+
+// Placeholder the server renders into usage snippets in place of the token.
+const tokenPlaceholder = "<TOKEN>";
+
+// Tabs are only rendered if more than one token profile is configured.
+const profileTabs = document.querySelectorAll("#profile-tabs [role=tab]");
+
 // State for usage within this script.
 var token = null;
 var finger = null;
 
+// Tokens of all profiles keyed by profile name and the selected profile. Only
+// used if profile tabs are present.
+var bundle = null;
+var profile = localStorage.getItem("profile") || "access";
+
 // Indiciates last time token has been updated / retrieved from backend. Used
 // by autoDelete to check if to proceed with deletion after timeout.
 let lastUpdate = null;
@@ -19,6 +40,29 @@ let lastUpdate = null;
 // Seconds to wait for token deletion to take place in case autoDelete is on.
 const autoDeleteDelay = 10
 
+// Seconds before expiry to warn or reload the token at. Configured by the server.
+const expiryWarning = Number(details.dataset.expiryWarning);
+const expiryReload = details.dataset.expiryReload === "true";
+
+// Point in time in milliseconds the shown token expires at. Null if unknown.
+let expiryDeadline = null;
+
+// Fingerprint of the token the expiry has been handled for. Prevents repeated
+// warnings and reloads for the same token.
+let expiryHandled = null;
+
+// Localized messages rendered into the page by the server.
+const messages = JSON.parse(document.getElementById("messages").textContent);
+
+// Returns the message for key with placeholders like "{profile}" replaced.
+function t(key, args = {}) {
+  let msg = messages[key] || key;
+  for (const [name, value] of Object.entries(args)) {
+    msg = msg.replaceAll(`{${name}}`, value);
+  }
+  return msg;
+}
+
 const toast = {
   error: "#cb5f59",
   info: "#58abc2",
@@ -47,7 +91,7 @@ function setPreference(key, value, element, snackOnSuccess) {
     localStorage.setItem(key, "true");
     console.info(`Enabled ${key}.`);
     if (snackOnSuccess) {
-      snack(toast.success, "Saved preference");
+      snack(toast.success, t("savedPreference"));
     }
     return true
   } else {
@@ -55,7 +99,7 @@ function setPreference(key, value, element, snackOnSuccess) {
     localStorage.setItem(key, "false");
     console.info(`Disabled ${key}.`);
     if (snackOnSuccess) {
-      snack(toast.success, "Saved preference");
+      snack(toast.success, t("savedPreference"));
     }
     return false
   }
@@ -80,9 +124,77 @@ if (localStorage.getItem("finger")) {
   fingerInput.value = "..."
 }
 
+function selectProfile(name) {
+  profile = name;
+  localStorage.setItem("profile", profile);
+
+  profileTabs.forEach((tab) => {
+    tab.setAttribute("aria-selected", String(tab.dataset.profile === profile));
+  });
+}
+
+// Shows the decoded token in the details panel. The panel is hidden if the token
+// is no JWT. The remaining lifetime comes from the server to not depend on the
+// clock of the client.
+function showDetails(data) {
+  if (!data || !data.decoded) {
+    details.hidden = true;
+    expiryDeadline = null;
+    return;
+  }
+
+  const lang = document.documentElement.lang;
+  const decoded = data.decoded;
+
+  detailsHeader.textContent = JSON.stringify(decoded.header, null, 2);
+  detailsClaims.textContent = JSON.stringify(decoded.claims, null, 2);
+  detailsIssuedAt.textContent = decoded.issuedAt ? new Date(decoded.issuedAt).toLocaleString(lang) : "-";
+  detailsExpiresAt.textContent = decoded.expiresAt ? new Date(decoded.expiresAt).toLocaleString(lang) : "-";
+
+  expiryDeadline = typeof decoded.expiresIn === "number" ? Date.now() + decoded.expiresIn * 1000 : null;
+
+  details.hidden = false;
+  updateCountdown();
+}
+
+// Formats the given number of seconds like "1:02:03".
+function formatDuration(seconds) {
+  const h = Math.floor(seconds / 3600);
+  const m = String(Math.floor((seconds % 3600) / 60)).padStart(2, "0");
+  const s = String(seconds % 60).padStart(2, "0");
+  return `${h}:${m}:${s}`;
+}
+
+// Updates the countdown and warns or reloads the token once it is close to
+// expiry.
+function updateCountdown() {
+  if (expiryDeadline === null) {
+    detailsExpiresIn.textContent = "-";
+    return;
+  }
+
+  const remaining = Math.max(0, Math.ceil((expiryDeadline - Date.now()) / 1000));
+  detailsExpiresIn.textContent = remaining > 0 ? formatDuration(remaining) : t("expired");
+
+  if (remaining > expiryWarning || expiryHandled === finger) {
+    return;
+  }
+  expiryHandled = finger;
+
+  if (expiryReload) {
+    updateToken();
+  } else if (remaining > 0) {
+    snack(toast.warning, t("expiryWarning", { seconds: remaining }));
+  } else {
+    snack(toast.warning, t("tokenExpired"));
+  }
+}
+
+setInterval(updateCountdown, 1000);
+
 async function updateToken() {
   try {
-    const response = await fetch("token");
+    const response = await fetch(profileTabs.length > 0 ? "token?all&decode" : "token?decode");
     if (!response.ok) {
       throw new Error("Failed to retrieve response from /token endpoint.");
     }
@@ -92,7 +204,14 @@ async function updateToken() {
       throw new Error("Response content from /token endpoint not JSON.");
     }
 
-    const data = await response.json();
+    let data = await response.json();
+    if (profileTabs.length > 0) {
+      bundle = data;
+      if (!bundle[profile]) {
+        selectProfile(Object.keys(bundle)[0]);
+      }
+      data = bundle[profile];
+    }
     if (!(data.fingerprint && data.secret)) {
       throw new Error("Response from /token endpoint is missing fields.");
     }
@@ -107,9 +226,11 @@ async function updateToken() {
 
     tokenInput.select();
 
+    showDetails(data);
+
     if (autoCopy && token) {
       navigator.clipboard.writeText(token);
-      snack(toast.success, "Copied token to clipboard");
+      snack(toast.success, t("copiedToken"));
     }
 
     if (autoDelete) {
@@ -119,19 +240,42 @@ async function updateToken() {
         if (autoDelete && token && (lastUpdate === localLastUpdate)) {
           token = null;
           tokenInput.value = "...";
+          showDetails(null);
           console.debug("Deleted token.")
-          snack(toast.success, "Deleted token")
+          snack(toast.success, t("deletedToken"))
         }
       }, autoDeleteDelay * 1000)
     }
   } catch (error) {
     fingerInput.value = "❌";
     tokenInput.value = "❌";
+    showDetails(null);
     console.error(error);
-    snack(toast.error, error.message.slice(0, -1));
+    snack(toast.error, t("tokenRetrievalFailed"));
   }
 }
 
+selectProfile(profile);
+
+profileTabs.forEach((tab) => {
+  tab.addEventListener("click", () => {
+    selectProfile(tab.dataset.profile);
+    if (bundle && bundle[profile]) {
+      token = bundle[profile].secret;
+      finger = bundle[profile].fingerprint;
+      tokenInput.value = token;
+      fingerInput.value = finger;
+      tokenInput.select();
+      showDetails(bundle[profile]);
+    } else {
+      token = null;
+      tokenInput.value = "...";
+      showDetails(null);
+      snack(toast.info, t("profileTokenUnavailable", { profile }));
+    }
+  });
+});
+
 window.addEventListener("DOMContentLoaded", updateToken);
 
 reloadButton.addEventListener("click", updateToken);
@@ -139,12 +283,24 @@ reloadButton.addEventListener("click", updateToken);
 copyButton.addEventListener("click", () => {
   if (token) {
     navigator.clipboard.writeText(token);
-    snack(toast.success, "Copied token to clipboard");
+    snack(toast.success, t("copiedToken"));
   } else {
-    snack(toast.error, "Token unavailable");
+    snack(toast.error, t("tokenUnavailable"));
   }
 });
 
+snippetButtons.forEach((button) => {
+  button.addEventListener("click", () => {
+    const code = document.getElementById(button.dataset.snippet).textContent;
+    if (code.includes(tokenPlaceholder) && !token) {
+      snack(toast.error, t("tokenUnavailable"));
+      return;
+    }
+    navigator.clipboard.writeText(code.replaceAll(tokenPlaceholder, token));
+    snack(toast.success, t("copiedSnippet"));
+  });
+});
+
 autoCopySwitch.addEventListener("click", () => {
   if (autoCopySwitch.getAttribute("aria-checked") === "true") {
     setPreference("autoCopy", false, autoCopySwitch, true);
@@ -166,8 +322,9 @@ deleteButton.addEventListener("click", () => {
     token = null;
     finger = null;
     tokenInput.value = "...";
-    snack(toast.success, "Deleted token");
+    showDetails(null);
+    snack(toast.success, t("deletedToken"));
   } else {
-    snack(toast.info, "Nothing to delete");
+    snack(toast.info, t("nothingToDelete"));
   }
 });
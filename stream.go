@@ -0,0 +1,383 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrStreamLimitReached = errors.New("stream limit reached")
+
+// StreamSettings holds the settings of the token stream flow.
+type StreamSettings struct {
+	// RenewBefore is how long before the expiry of a JWT it is renewed.
+	RenewBefore time.Duration
+
+	// RenewInterval is how often tokens without expiry are checked for
+	// renewal. Also the delay before the next attempt if a renewal did not
+	// bring a new token.
+	RenewInterval time.Duration
+
+	// HeartbeatInterval is the interval comments are sent in to keep the
+	// connection open.
+	HeartbeatInterval time.Duration
+
+	// MaxConnections is the maximum number of streams open at the same time.
+	MaxConnections int
+
+	// MaxConnectionsPerToken is the maximum number of streams open at the same
+	// time for the same token.
+	MaxConnectionsPerToken int
+}
+
+// StreamRegistry keeps track of the open streams and enforces the limits of
+// StreamSettings. Use NewStreamRegistry to construct.
+type StreamRegistry struct {
+	mu                     sync.Mutex
+	maxConnections         int
+	maxConnectionsPerToken int
+	total                  int
+	perToken               map[string]int
+}
+
+// NewStreamRegistry creates a StreamRegistry with the limits of the given
+// settings.
+func NewStreamRegistry(settings StreamSettings) *StreamRegistry {
+	return &StreamRegistry{
+		maxConnections:         settings.MaxConnections,
+		maxConnectionsPerToken: settings.MaxConnectionsPerToken,
+		perToken:               map[string]int{},
+	}
+}
+
+// SetLimits changes the limits to those of the given settings. Open streams
+// stay registered and count against the new limits.
+func (g *StreamRegistry) SetLimits(settings StreamSettings) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.maxConnections = settings.MaxConnections
+	g.maxConnectionsPerToken = settings.MaxConnectionsPerToken
+}
+
+// Register registers a stream for the token with the given fingerprint.
+// Returns a function that must be called when the stream ends. Returns
+// ErrStreamLimitReached (wrapped) if a limit would be exceeded.
+func (g *StreamRegistry) Register(fingerprint string) (func(), error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.total >= g.maxConnections {
+		return nil, fmt.Errorf("%w: %d streams open", ErrStreamLimitReached, g.total)
+	}
+	if g.perToken[fingerprint] >= g.maxConnectionsPerToken {
+		return nil, fmt.Errorf("%w: %d streams open for token", ErrStreamLimitReached, g.perToken[fingerprint])
+	}
+
+	g.total++
+	g.perToken[fingerprint]++
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+
+			g.total--
+			g.perToken[fingerprint]--
+			if g.perToken[fingerprint] == 0 {
+				delete(g.perToken, fingerprint)
+			}
+		})
+	}, nil
+}
+
+// Count returns the number of open streams.
+func (g *StreamRegistry) Count() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.total
+}
+
+// tokenExpiry returns the expiry of the given token if its secret is a JWT
+// with the claim "exp".
+func tokenExpiry(token Token) (time.Time, bool) {
+	jwt, err := ParseJWT(token.Secret)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return jwt.NumericDate("exp")
+}
+
+// renewDelay returns how long to wait before renewing the given token. JWTs
+// are renewed RenewBefore ahead of their expiry, or halfway to the expiry if
+// that is already past. Other tokens are checked every RenewInterval.
+func (s StreamSettings) renewDelay(token Token, now time.Time) time.Duration {
+	exp, ok := tokenExpiry(token)
+	if !ok {
+		return s.RenewInterval
+	}
+
+	delay := exp.Add(-s.RenewBefore).Sub(now)
+	if delay <= 0 {
+		delay = exp.Sub(now) / 2
+	}
+	if delay < time.Second {
+		delay = time.Second
+	}
+
+	return delay
+}
+
+// retryDelay returns how long to wait before trying again to renew the given
+// token after a renewal did not bring a new one. Returns false if the token
+// has expired.
+func (s StreamSettings) retryDelay(token Token, now time.Time) (time.Duration, bool) {
+	exp, ok := tokenExpiry(token)
+	if !ok {
+		return s.RenewInterval, true
+	}
+	if !now.Before(exp) {
+		return 0, false
+	}
+
+	delay := exp.Sub(now)
+	if delay > s.RenewInterval {
+		delay = s.RenewInterval
+	}
+
+	return delay, true
+}
+
+// streamRenewer gets the tokens to push in a stream. The headers of the
+// request are the same for the whole stream, so tokens can only be renewed
+// with a TokenRefresher or by extracting them again from sources that are
+// read anew, which are files.
+type streamRenewer struct {
+	tokenPipeline  TokenPipeline
+	tokenRefresher *TokenRefresher
+
+	// refreshToken is the refresh token to redeem next. Starts out as the one
+	// from the request and is replaced whenever the token endpoint rotates it.
+	refreshToken Token
+}
+
+// renew gets the token to push next. With a TokenRefresher the refresh token
+// is redeemed. Otherwise, or if r carries no refresh token, the token of the
+// DefaultTokenProfile is extracted again, which picks up tokens rotated in
+// files. The token is neither introspected nor exchanged yet, so unchanged
+// tokens can be recognized by their fingerprint.
+func (s *streamRenewer) renew(r *http.Request) (Token, error) {
+	if s.tokenRefresher != nil {
+		if len(s.refreshToken.Secret) == 0 {
+			refreshToken, err := s.tokenRefresher.Extract(r)
+			if err != nil && !errors.Is(err, ErrTokenNotFound) {
+				return Token{}, err
+			}
+			s.refreshToken = refreshToken
+		}
+
+		if len(s.refreshToken.Secret) > 0 {
+			token, next, err := s.tokenRefresher.Redeem(r.Context(), s.refreshToken)
+			if err != nil {
+				return Token{}, err
+			}
+			s.refreshToken = next
+
+			return token, nil
+		}
+	}
+
+	return s.tokenPipeline.profiles.Default().Extract(r)
+}
+
+// processStreamToken runs the given token through introspection and, if the
+// query parameters of r request it, exchange.
+func processStreamToken(r *http.Request, tokenPipeline TokenPipeline, token Token) (Token, error) {
+	token, err := tokenPipeline.introspector.Introspect(r.Context(), token)
+	if err != nil {
+		return Token{}, err
+	}
+
+	if exchangeRequest, exchange := ParseTokenExchangeRequest(r.URL.Query()); exchange {
+		return tokenPipeline.exchanger.Exchange(r.Context(), token, "", exchangeRequest)
+	}
+
+	return token, nil
+}
+
+// sealStreamToken encrypts the given token for the given public key.
+func sealStreamToken(publicKey []byte, token Token) (Envelope, error) {
+	payload, err := json.Marshal(token)
+	if err != nil {
+		return Envelope{}, fmt.Errorf("failed to marshal token: %w", err)
+	}
+
+	return SealEnvelope(publicKey, payload)
+}
+
+// writeStreamEvent writes a server-sent event with the given name, id, and
+// data encoded as JSON and flushes it to the client.
+func writeStreamEvent(w io.Writer, flusher http.Flusher, event string, id int, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\nid: %d\ndata: %s\n\n", event, id, b)
+	if err != nil {
+		return err
+	}
+	flusher.Flush()
+
+	return nil
+}
+
+// MakeGetTokenStreamFlowHandler returns a handler for the token stream flow.
+// The handler keeps the connection open and pushes the token of the
+// DefaultTokenProfile as server-sent events. Every event "token" contains an
+// Envelope with the token encrypted for the public key from the query
+// parameters like in the token redirect flow. Tokens go through the given
+// TokenPipeline like with MakeGetTokenHandler.
+//
+// The first event is sent right away. Later ones follow whenever a renewal
+// brings a new token. Renewal is scheduled with the given StreamSettings and
+// done with a streamRenewer. The request and its headers don't change while
+// the stream is open, so tokens refreshed by the gateway in front of Token2go
+// are never pushed. Only tokens from files change, and with a TokenRefresher
+// refresh tokens are redeemed. Rotated refresh tokens are kept for the next
+// renewal within the stream. If a token expires without being renewed, the
+// event "expired" is sent and the stream ends. Failed renewals end the stream
+// with the event "error" containing a Problem. Comments are sent every
+// HeartbeatInterval to keep the connection open.
+//
+// Streams are registered with the given StreamRegistry. Requests exceeding
+// its limits are answered with status code 503.
+func MakeGetTokenStreamFlowHandler(
+	tokenPipeline TokenPipeline,
+	tokenRefresher *TokenRefresher,
+	registry *StreamRegistry,
+	settings StreamSettings,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		queryParams := r.URL.Query()
+
+		// Extract query parameters.
+		publicKeyType := queryParams.Get("publicKeyType")
+		publicKey := []byte(queryParams.Get("publicKey"))
+
+		// Ensure query parameters are set and allowed.
+		if !IsRequiredQueryParamSet(w, r, "publicKeyType", "publicKey") {
+			return
+		}
+		if !IsQueryParamValueAllowed(w, r, "publicKeyType", publicKeyType,
+			"rsa2048-rfc5280-x509-pem", "rsa2048-rfc8017-pksc1-pem",
+		) {
+			return
+		}
+		_, err := ParseRSAPublicKey(publicKey)
+		if !IsSucceededEncryptWithRSA(w, r, err) {
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			msg := "Internal Server Error. Streaming not supported."
+			WriteProblem(w, r, http.StatusInternalServerError, "StreamingUnsupported", msg)
+			return
+		}
+
+		// Extract first token. The source token is kept to recognize renewals
+		// that did not bring a new token.
+		token, source, ok := tokenPipeline.ExtractTokenAndSource(w, r, DefaultTokenProfile)
+		if !ok {
+			return
+		}
+		envelope, err := sealStreamToken(publicKey, token)
+		if !IsSucceededEncryptWithRSA(w, r, err) {
+			return
+		}
+
+		// Register stream.
+		unregister, err := registry.Register(source.Fingerprint)
+		if err != nil {
+			msg := fmt.Sprintf("Service Unavailable. %v", err)
+			WriteProblem(w, r, http.StatusServiceUnavailable, "StreamLimitReached", msg)
+			return
+		}
+		defer unregister()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		id := 1
+		if writeStreamEvent(w, flusher, "token", id, envelope) != nil {
+			return
+		}
+
+		renewer := streamRenewer{tokenPipeline: tokenPipeline, tokenRefresher: tokenRefresher}
+		renew := time.NewTimer(settings.renewDelay(source, time.Now()))
+		defer renew.Stop()
+		heartbeat := time.NewTicker(settings.HeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+
+			case <-heartbeat.C:
+				_, err = io.WriteString(w, ": heartbeat\n\n")
+				if err != nil {
+					return
+				}
+				flusher.Flush()
+
+			case <-renew.C:
+				renewed, err := renewer.renew(r)
+				if err == nil && renewed.Fingerprint == source.Fingerprint {
+					delay, ok := settings.retryDelay(source, time.Now())
+					if !ok {
+						msg := "Token expired and could not be renewed."
+						id++
+						_ = writeStreamEvent(w, flusher, "expired", id,
+							NewProblem(http.StatusUnauthorized, "TokenExpired", msg))
+						return
+					}
+					renew.Reset(delay)
+					continue
+				}
+				if err == nil {
+					token, err = processStreamToken(r, tokenPipeline, renewed)
+				}
+				if err == nil {
+					envelope, err = sealStreamToken(publicKey, token)
+				}
+				if err != nil {
+					msg := "Token renewal failed."
+					log.Printf("%s %s: %s %v", r.Method, r.URL.Path, msg, err)
+					id++
+					_ = writeStreamEvent(w, flusher, "error", id,
+						NewProblem(http.StatusBadGateway, "TokenRenewalFailed", msg))
+					return
+				}
+
+				id++
+				if writeStreamEvent(w, flusher, "token", id, envelope) != nil {
+					return
+				}
+				source = renewed
+				renew.Reset(settings.renewDelay(source, time.Now()))
+			}
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openEnvelope decrypts the given Envelope with the test private key "a".
+func openEnvelope(t *testing.T, envelope Envelope) Token {
+	t.Helper()
+
+	privateKey, ok := readTestPrivateKey(t, "a-private-key-rsa2048-rfc5958-pksc8.pem").(*rsa.PrivateKey)
+	if !ok {
+		t.Fatal("unexpected; not an RSA key")
+	}
+
+	decode := func(s string) []byte {
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	payloadKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, decode(envelope.Key), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(payloadKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := aesgcm.Open(nil, decode(envelope.Nonce), decode(envelope.Payload), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var token Token
+	err = json.Unmarshal(payload, &token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return token
+}
+
+func TestSealEnvelope(t *testing.T) {
+	publicKey, err := os.ReadFile("testdata/a-public-key-rsa2048-rfc5280-x509.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	envelope, err := SealEnvelope(publicKey, []byte(`{"secret":"foo"}`))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := openEnvelope(t, envelope).Secret; got != "foo" {
+		t.Errorf("Wrong secret: got %q, want %q", got, "foo")
+	}
+
+	_, err = SealEnvelope([]byte("nope"), nil)
+	if !errors.Is(err, ErrPEMDecode) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrPEMDecode)
+	}
+}
+
+func TestStreamRegistry(t *testing.T) {
+	registry := NewStreamRegistry(StreamSettings{MaxConnections: 3, MaxConnectionsPerToken: 2})
+
+	unregisterA1, err := registry.Register("a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = registry.Register("a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Limit per token.
+	_, err = registry.Register("a")
+	if !errors.Is(err, ErrStreamLimitReached) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrStreamLimitReached)
+	}
+
+	_, err = registry.Register("b")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Limit of all streams.
+	_, err = registry.Register("c")
+	if !errors.Is(err, ErrStreamLimitReached) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrStreamLimitReached)
+	}
+
+	// Unregistering twice frees only one slot.
+	unregisterA1()
+	unregisterA1()
+	if got := registry.Count(); got != 2 {
+		t.Errorf("Wrong count: got %v, want %v", got, 2)
+	}
+	_, err = registry.Register("a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestStreamSettings_Delay(t *testing.T) {
+	settings := StreamSettings{RenewBefore: time.Minute, RenewInterval: 5 * time.Minute}
+	now := time.Unix(1700000000, 0)
+	key := readTestPrivateKey(t, "a-private-key-rsa2048-rfc5958-pksc8.pem")
+
+	for _, tc := range []struct {
+		name                string
+		secret              string
+		expectedRenewDelay  time.Duration
+		expectedRetryDelay  time.Duration
+		expectedRetryExpiry bool
+	}{{
+		name:               "1_opaque",
+		secret:             "opaque",
+		expectedRenewDelay: 5 * time.Minute,
+		expectedRetryDelay: 5 * time.Minute,
+	}, {
+		name:               "2_expires_later",
+		secret:             signTestJWT(t, key, map[string]any{"exp": now.Add(time.Hour).Unix()}),
+		expectedRenewDelay: 59 * time.Minute,
+		expectedRetryDelay: 5 * time.Minute,
+	}, {
+		name:               "3_expires_soon",
+		secret:             signTestJWT(t, key, map[string]any{"exp": now.Add(40 * time.Second).Unix()}),
+		expectedRenewDelay: 20 * time.Second,
+		expectedRetryDelay: 40 * time.Second,
+	}, {
+		name:                "4_expired",
+		secret:              signTestJWT(t, key, map[string]any{"exp": now.Add(-time.Second).Unix()}),
+		expectedRenewDelay:  time.Second,
+		expectedRetryExpiry: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			token := Token{Secret: tc.secret}
+
+			if got := settings.renewDelay(token, now); got != tc.expectedRenewDelay {
+				t.Errorf("Wrong renew delay: got %v, want %v", got, tc.expectedRenewDelay)
+			}
+
+			got, ok := settings.retryDelay(token, now)
+			if ok == tc.expectedRetryExpiry {
+				t.Errorf("Wrong retry: got %v, want expired %v", ok, tc.expectedRetryExpiry)
+			}
+			if got != tc.expectedRetryDelay {
+				t.Errorf("Wrong retry delay: got %v, want %v", got, tc.expectedRetryDelay)
+			}
+		})
+	}
+}
+
+// readStreamEvent reads the next event from the stream and skips comments.
+func readStreamEvent(t *testing.T, scanner *bufio.Scanner) (string, string) {
+	t.Helper()
+
+	var event, data string
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case len(line) == 0 && len(event) > 0:
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	t.Fatalf("Stream ended: %v", scanner.Err())
+
+	return "", ""
+}
+
+// readStreamToken reads the next event from the stream, which must be a token
+// event, and decrypts the token.
+func readStreamToken(t *testing.T, scanner *bufio.Scanner) Token {
+	t.Helper()
+
+	event, data := readStreamEvent(t, scanner)
+	if event != "token" {
+		t.Fatalf("Wrong event: got %q, want %q", event, "token")
+	}
+
+	var envelope Envelope
+	err := json.Unmarshal([]byte(data), &envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return openEnvelope(t, envelope)
+}
+
+func TestInitRouter_TokenStream(t *testing.T) {
+	publicKey, err := os.ReadFile("testdata/a-public-key-rsa2048-rfc5280-x509.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err = os.WriteFile(tokenFile, []byte("first"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources, err := NewTokenSourceChain([]TokenSourceSpec{{Type: "file", Path: tokenFile}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := NewTokenProfiles(sources, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	settings := StreamSettings{
+		RenewInterval:          20 * time.Millisecond,
+		HeartbeatInterval:      10 * time.Millisecond,
+		MaxConnections:         10,
+		MaxConnectionsPerToken: 1,
+	}
+	registry := NewStreamRegistry(settings)
+	server := httptest.NewServer(initRouter(RouterArgs{
+		tokenPipeline:  TokenPipeline{profiles: profiles},
+		streamSettings: settings,
+		streamRegistry: registry,
+	}))
+	defer server.Close()
+
+	streamURL := server.URL + "/flow/stream/token?" + url.Values{
+		"publicKeyType": {"rsa2048-rfc5280-x509-pem"},
+		"publicKey":     {string(publicKey)},
+	}.Encode()
+
+	response, err := http.Get(streamURL) //nolint:noctx
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if got := response.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("Wrong content type: got %q, want %q", got, "text/event-stream")
+	}
+
+	scanner := bufio.NewScanner(response.Body)
+
+	if got := readStreamToken(t, scanner).Secret; got != "first" {
+		t.Errorf("Wrong secret: got %q, want %q", got, "first")
+	}
+
+	// Second stream for the same token exceeds the limit.
+	request, err := http.NewRequest("GET", streamURL, nil) //nolint:noctx
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Accept", "application/problem+json")
+	limited, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(limited.Body)
+	limited.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if limited.StatusCode != 503 {
+		t.Errorf("Wrong code: got %v, want %v", limited.StatusCode, 503)
+	}
+	if substr := `"code":"StreamLimitReached"`; !strings.Contains(string(body), substr) {
+		t.Errorf("Didn't find substr in body: want %q", substr)
+	}
+
+	// Rotated token is pushed.
+	err = os.WriteFile(tokenFile, []byte("second"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := readStreamToken(t, scanner).Secret; got != "second" {
+		t.Errorf("Wrong secret: got %q, want %q", got, "second")
+	}
+
+	// Closing the stream unregisters it.
+	response.Body.Close()
+	for i := 0; registry.Count() > 0 && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := registry.Count(); got != 0 {
+		t.Errorf("Wrong count: got %v, want %v", got, 0)
+	}
+}
+
+// newTestRotatingTokenRefreshServer starts a stub token endpoint that accepts
+// the refresh token "rt-1" first. Redeeming "rt-<n>" hands out the access
+// token "at-<n>" and rotates the refresh token to "rt-<n+1>". Refresh tokens
+// that have been rotated are refused.
+func newTestRotatingTokenRefreshServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	var mu sync.Mutex
+	n := 1
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.ParseForm() != nil || r.PostForm.Get("refresh_token") != fmt.Sprintf("rt-%d", n) {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"access_token":  fmt.Sprintf("at-%d", n),
+			"refresh_token": fmt.Sprintf("rt-%d", n+1),
+			"token_type":    "Bearer",
+		})
+		n++
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestInitRouter_TokenStream_Refresh(t *testing.T) {
+	publicKey, err := os.ReadFile("testdata/a-public-key-rsa2048-rfc5280-x509.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresher := newTestTokenRefresher(t, newTestRotatingTokenRefreshServer(t).URL)
+	settings := StreamSettings{
+		RenewInterval:          20 * time.Millisecond,
+		HeartbeatInterval:      10 * time.Millisecond,
+		MaxConnections:         10,
+		MaxConnectionsPerToken: 1,
+	}
+	server := httptest.NewServer(initRouter(RouterArgs{
+		tokenPipeline: TokenPipeline{
+			profiles: newTestTokenProfiles(t, []string{"Authorization"}, ""),
+		},
+		tokenRefresher: &refresher,
+		streamSettings: settings,
+		streamRegistry: NewStreamRegistry(settings),
+	}))
+	defer server.Close()
+
+	request, err := http.NewRequest("GET", server.URL+"/flow/stream/token?"+url.Values{ //nolint:noctx
+		"publicKeyType": {"rsa2048-rfc5280-x509-pem"},
+		"publicKey":     {string(publicKey)},
+	}.Encode(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header.Set("Authorization", "Bearer at-0")
+	request.Header.Set("X-Auth-Request-Refresh-Token", "rt-1")
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	scanner := bufio.NewScanner(response.Body)
+
+	// The second renewal only succeeds with the refresh token rotated by the
+	// first one.
+	for _, want := range []string{"at-0", "at-1", "at-2", "at-3"} {
+		token := readStreamToken(t, scanner)
+		if token.Secret != want {
+			t.Errorf("Wrong secret: got %q, want %q", token.Secret, want)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 window.onload = function () {
   window.ui = SwaggerUIBundle({
-    url: "/swagger.yaml",
+    url: "../swagger.yaml",
     dom_id: '#swagger-ui',
     deepLinking: true,
     presets: [
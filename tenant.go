@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// TenantSpec is the JSON representation of a tenant in T2G_TENANTS. A tenant
+// is selected either by Hosts or by PathPrefix. Settings left out are taken
+// from the default tenant, which is configured with the other T2G_* options.
+type TenantSpec struct {
+	// Hosts the tenant is selected by. Compared to the Host header without
+	// port and case-insensitively.
+	Hosts []string `json:"hosts,omitempty"`
+
+	// PathPrefix the tenant is selected by and served under. Appended to
+	// T2G_BASE_PATH.
+	PathPrefix string `json:"pathPrefix,omitempty"`
+
+	// TokenHeaderNames replace T2G_TOKEN_HEADER_NAMES.
+	TokenHeaderNames []string `json:"tokenHeaderNames,omitempty"`
+
+	// FallbackToken replaces T2G_FALLBACK_TOKEN.
+	FallbackToken string `json:"fallbackToken,omitempty"`
+
+	// TokenSources replace the token source chain of the default profile
+	// like T2G_TOKEN_SOURCES. Takes precedence over TokenHeaderNames and
+	// FallbackToken.
+	TokenSources []TokenSourceSpec `json:"tokenSources,omitempty"`
+
+	// UI replaces all T2G_UI_TARGET, T2G_UI_TITLE, T2G_UI_DESC1,
+	// T2G_UI_DESC2, and T2G_UI_MISC strings including localized ones.
+	UI *TenantUISpec `json:"ui,omitempty"`
+
+	// RedirectTargets replace T2G_REDIRECT_TARGETS.
+	RedirectTargets []string `json:"redirectTargets,omitempty"`
+
+	// CORSOrigins replace T2G_CORS_ORIGINS.
+	CORSOrigins []string `json:"corsOrigins,omitempty"`
+
+	// TokenExchange replaces the T2G_TOKEN_EXCHANGE_* options. An empty URL
+	// disables token exchange for the tenant.
+	TokenExchange *TenantTokenExchangeSpec `json:"tokenExchange,omitempty"`
+}
+
+// TenantUISpec holds the UI strings of a tenant.
+type TenantUISpec struct {
+	UIStrings
+
+	// Localized strings keyed by locale like "de".
+	Localized map[string]UIStrings `json:"localized,omitempty"`
+}
+
+// TenantTokenExchangeSpec holds the token exchange settings of a tenant.
+type TenantTokenExchangeSpec struct {
+	URL              string   `json:"url"`
+	ClientID         string   `json:"clientId,omitempty"`
+	ClientSecret     string   `json:"clientSecret,omitempty"`
+	SubjectTokenType string   `json:"subjectTokenType,omitempty"`
+	Audiences        []string `json:"audiences,omitempty"`
+	Scopes           []string `json:"scopes,omitempty"`
+}
+
+// ParseTenantSpecs unmarshals a JSON object that maps tenant names to
+// TenantSpec.
+func ParseTenantSpecs(s string) (map[string]TenantSpec, error) {
+	var specs map[string]TenantSpec
+
+	err := json.Unmarshal([]byte(s), &specs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tenant specs: %w", err)
+	}
+
+	return specs, nil
+}
+
+// TenantConfig returns a copy of c with the settings of the given tenant
+// applied. The token source chain is rebuilt from the header names and the
+// fallback token of the tenant unless the tenant lists token sources. Returns
+// an error if the spec is invalid.
+func (c Config) TenantConfig(spec TenantSpec) (Config, error) {
+	t := c
+	t.tenantSpecs = nil
+
+	if len(spec.PathPrefix) > 0 {
+		prefix, err := NormalizeBasePath(spec.PathPrefix)
+		if err != nil {
+			return t, err
+		}
+		if len(prefix) == 0 {
+			return t, fmt.Errorf("path prefix must not be root")
+		}
+		t.basePath = c.basePath + prefix
+	}
+
+	// Token extraction.
+	if len(spec.TokenHeaderNames) > 0 {
+		t.tokenHeaderNames = spec.TokenHeaderNames
+	}
+	if len(spec.FallbackToken) > 0 {
+		t.fallbackToken = spec.FallbackToken
+	}
+	switch {
+	case len(spec.TokenSources) > 0:
+		t.tokenSourceSpecs = append([]TokenSourceSpec{}, spec.TokenSources...)
+		t.completeTokenSourceSpecs(t.tokenSourceSpecs)
+	case len(spec.TokenHeaderNames) > 0 || len(spec.FallbackToken) > 0:
+		if c.tokenSourcesSet {
+			return t, fmt.Errorf(
+				"tokenHeaderNames and fallbackToken conflict with T2G_TOKEN_SOURCES, use tokenSources",
+			)
+		}
+		t.tokenSourceSpecs = t.defaultTokenSourceSpecs()
+	}
+	t.echoRedactHeaderNames = appendMissingHeaderNames(
+		append([]string{}, c.echoRedactHeaderNames...),
+		TokenSourceSpecsHeaderNames(t.tokenSourceSpecs)...,
+	)
+	t.echoRedactQueryNames = append(
+		append([]string{}, c.echoRedactQueryNames...),
+		TokenSourceSpecsQueryNames(t.tokenSourceSpecs)...,
+	)
+
+	// User interface.
+	if spec.UI != nil {
+		t.uiTarget = spec.UI.Target
+		t.uiTitle = spec.UI.Title
+		t.uiDesc1 = spec.UI.Desc1
+		t.uiDesc2 = spec.UI.Desc2
+		t.uiMisc = spec.UI.Misc
+		t.uiLocalized = make(map[string]UIStrings, len(spec.UI.Localized))
+		for locale, s := range spec.UI.Localized {
+			t.uiLocalized[NormalizeUILocale(locale)] = s
+		}
+	}
+
+	// Targets the token redirect flow may redirect to.
+	if spec.RedirectTargets != nil {
+		for _, target := range spec.RedirectTargets {
+			err := ValidateRedirectTargetPattern(target)
+			if err != nil {
+				return t, err
+			}
+		}
+		t.redirectTargets = spec.RedirectTargets
+	}
+
+	// Origins of browser apps allowed to send cross-origin requests.
+	if spec.CORSOrigins != nil {
+		for _, origin := range spec.CORSOrigins {
+			err := ValidateCORSOrigin(origin)
+			if err != nil {
+				return t, err
+			}
+		}
+		t.corsOrigins = spec.CORSOrigins
+	}
+
+	// Token exchange.
+	if e := spec.TokenExchange; e != nil {
+		if len(e.URL) > 0 && len(e.ClientID) == 0 {
+			return t, fmt.Errorf("tokenExchange.clientId required for token exchange")
+		}
+		t.tokenExchangeURL = e.URL
+		t.tokenExchangeClientID = e.ClientID
+		t.tokenExchangeClientSecret = e.ClientSecret
+		t.tokenExchangeSubjectTokenType = e.SubjectTokenType
+		if len(t.tokenExchangeSubjectTokenType) == 0 {
+			t.tokenExchangeSubjectTokenType = TokenTypeAccessToken
+		}
+		t.tokenExchangeAudiences = e.Audiences
+		t.tokenExchangeScopes = e.Scopes
+	}
+
+	return t, nil
+}
+
+// appendMissingHeaderNames appends the given header names to names unless
+// they are already contained. Header names are compared case-insensitively.
+func appendMissingHeaderNames(names []string, add ...string) []string {
+	for _, name := range add {
+		found := false
+		for _, n := range names {
+			if strings.EqualFold(n, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// TenantRouter dispatches requests to the router of the tenant selected by
+// the Host header or the path prefix. Hosts take precedence over path
+// prefixes, longer prefixes over shorter ones. Requests that select no tenant
+// go to the default tenant. Use NewTenantRouter to construct.
+type TenantRouter struct {
+	hosts    map[string]http.Handler
+	prefixes []tenantPrefix
+	fallback http.Handler
+}
+
+// tenantPrefix is the router of a tenant selected by path prefix.
+type tenantPrefix struct {
+	prefix  string
+	handler http.Handler
+}
+
+// NewTenantRouter builds the routers of the default tenant and of all tenants
+// in the given Config. Returns an error if a tenant is invalid, selects the
+// same host or path prefix as another tenant, or its RouterArgs can't be
+// built. All tenants share the stateful components of the given RouterState.
+func NewTenantRouter(c Config, state *RouterState) (TenantRouter, error) {
+	a, err := NewRouterArgs(c, state)
+	if err != nil {
+		return TenantRouter{}, err
+	}
+
+	t := TenantRouter{
+		hosts:    map[string]http.Handler{},
+		fallback: initRouter(a),
+	}
+	prefixTenants := map[string]string{}
+	hostTenants := map[string]string{}
+
+	for _, name := range sortedKeys(c.tenantSpecs) {
+		spec := c.tenantSpecs[name]
+
+		if !tokenProfileNameRegexp.MatchString(name) {
+			return TenantRouter{}, fmt.Errorf("tenant %q: invalid name", name)
+		}
+		if (len(spec.Hosts) > 0) == (len(spec.PathPrefix) > 0) {
+			return TenantRouter{}, fmt.Errorf("tenant %q: requires either hosts or pathPrefix", name)
+		}
+
+		tc, err := c.TenantConfig(spec)
+		if err != nil {
+			return TenantRouter{}, fmt.Errorf("tenant %q: %w", name, err)
+		}
+		tc.tenant = name
+		a, err := NewRouterArgs(tc, state)
+		if err != nil {
+			return TenantRouter{}, fmt.Errorf("tenant %q: %w", name, err)
+		}
+		handler := initRouter(a)
+
+		for _, host := range spec.Hosts {
+			host = normalizeTenantHost(host)
+			if len(host) == 0 {
+				return TenantRouter{}, fmt.Errorf("tenant %q: empty host", name)
+			}
+			if other, ok := hostTenants[host]; ok {
+				return TenantRouter{}, fmt.Errorf("tenant %q: host %q already used by %q", name, host, other)
+			}
+			hostTenants[host] = name
+			t.hosts[host] = handler
+		}
+
+		if len(spec.PathPrefix) > 0 {
+			if other, ok := prefixTenants[tc.basePath]; ok {
+				return TenantRouter{}, fmt.Errorf(
+					"tenant %q: path prefix %q already used by %q", name, tc.basePath, other,
+				)
+			}
+			prefixTenants[tc.basePath] = name
+			t.prefixes = append(t.prefixes, tenantPrefix{prefix: tc.basePath, handler: handler})
+		}
+	}
+
+	sort.SliceStable(t.prefixes, func(i, j int) bool {
+		return len(t.prefixes[i].prefix) > len(t.prefixes[j].prefix)
+	})
+
+	return t, nil
+}
+
+// ServeHTTP dispatches r to the router of the selected tenant.
+func (t TenantRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler, ok := t.hosts[normalizeTenantHost(r.Host)]; ok {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	for _, p := range t.prefixes {
+		if r.URL.Path == p.prefix || strings.HasPrefix(r.URL.Path, p.prefix+"/") {
+			p.handler.ServeHTTP(w, r)
+			return
+		}
+	}
+
+	t.fallback.ServeHTTP(w, r)
+}
+
+// normalizeTenantHost removes the port and a trailing dot from the given host
+// and lowercases it.
+func normalizeTenantHost(host string) string {
+	return strings.ToLower(strings.TrimSuffix(hostWithoutPort(strings.TrimSpace(host)), "."))
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConfig_TenantConfig(t *testing.T) {
+	base := Config{
+		tokenHeaderNames:      []string{"Authorization"},
+		echoRedactHeaderNames: []string{"Authorization"},
+		uiTitle:               "Default",
+		uiLocalized:           map[string]UIStrings{"de": {Title: "Standard"}},
+		redirectTargets:       []string{"https://example.com"},
+	}
+	base.tokenSourceSpecs = base.defaultTokenSourceSpecs()
+
+	explicit := base
+	explicit.tokenSourcesSet = true
+
+	for _, tc := range []struct {
+		name                  string
+		config                Config
+		spec                  TenantSpec
+		expectedError         bool
+		expectedBasePath      string
+		expectedHeaderNames   string
+		expectedRedactNames   string
+		expectedTitle         string
+		expectedLocalized     int
+		expectedTargets       string
+		expectedCORSOrigins   string
+		expectedExchangeURL   string
+		expectedSubjectTokenT string
+	}{{
+		name:                "1_inherit",
+		config:              base,
+		spec:                TenantSpec{Hosts: []string{"a.example.com"}},
+		expectedHeaderNames: "Authorization,Cookie",
+		expectedRedactNames: "Authorization,Cookie",
+		expectedTitle:       "Default",
+		expectedLocalized:   1,
+		expectedTargets:     "https://example.com",
+	}, {
+		name:   "2_override",
+		config: base,
+		spec: TenantSpec{
+			PathPrefix:       "orders/",
+			TokenHeaderNames: []string{"X-Orders-Token"},
+			UI:               &TenantUISpec{UIStrings: UIStrings{Title: "Orders"}},
+			RedirectTargets:  []string{"http://localhost"},
+			CORSOrigins:      []string{"https://orders.example.com"},
+			TokenExchange:    &TenantTokenExchangeSpec{URL: "https://idp.example.com/token", ClientID: "orders"},
+		},
+		expectedBasePath:      "/orders",
+		expectedHeaderNames:   "X-Orders-Token,Cookie",
+		expectedRedactNames:   "Authorization,X-Orders-Token,Cookie",
+		expectedTitle:         "Orders",
+		expectedLocalized:     0,
+		expectedTargets:       "http://localhost",
+		expectedCORSOrigins:   "https://orders.example.com",
+		expectedExchangeURL:   "https://idp.example.com/token",
+		expectedSubjectTokenT: TokenTypeAccessToken,
+	}, {
+		name:   "3_token_sources",
+		config: explicit,
+		spec: TenantSpec{
+			Hosts:        []string{"a.example.com"},
+			TokenSources: []TokenSourceSpec{{Type: "header", Names: []string{"X-A-Token"}}},
+		},
+		expectedHeaderNames: "X-A-Token",
+		expectedRedactNames: "Authorization,X-A-Token",
+		expectedTitle:       "Default",
+		expectedLocalized:   1,
+		expectedTargets:     "https://example.com",
+	}, {
+		name:          "4_conflict_with_token_sources",
+		config:        explicit,
+		spec:          TenantSpec{Hosts: []string{"a.example.com"}, FallbackToken: "x"},
+		expectedError: true,
+	}, {
+		name:          "5_root_prefix",
+		config:        base,
+		spec:          TenantSpec{PathPrefix: "/"},
+		expectedError: true,
+	}, {
+		name:          "6_invalid_redirect_target",
+		config:        base,
+		spec:          TenantSpec{Hosts: []string{"a.example.com"}, RedirectTargets: []string{"/cb"}},
+		expectedError: true,
+	}, {
+		name:   "7_exchange_without_client",
+		config: base,
+		spec: TenantSpec{
+			Hosts:         []string{"a.example.com"},
+			TokenExchange: &TenantTokenExchangeSpec{URL: "https://idp.example.com/token"},
+		},
+		expectedError: true,
+	}, {
+		name:          "8_invalid_cors_origin",
+		config:        base,
+		spec:          TenantSpec{Hosts: []string{"a.example.com"}, CORSOrigins: []string{"https://a.example.com/app"}},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := tc.config.TenantConfig(tc.spec)
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if c.basePath != tc.expectedBasePath {
+				t.Errorf("Wrong base path: got %q, want %q", c.basePath, tc.expectedBasePath)
+			}
+			headerNames := strings.Join(TokenSourceSpecsHeaderNames(c.tokenSourceSpecs), ",")
+			if headerNames != tc.expectedHeaderNames {
+				t.Errorf("Wrong header names: got %q, want %q", headerNames, tc.expectedHeaderNames)
+			}
+			if got := strings.Join(c.echoRedactHeaderNames, ","); got != tc.expectedRedactNames {
+				t.Errorf("Wrong redact header names: got %q, want %q", got, tc.expectedRedactNames)
+			}
+			if c.uiTitle != tc.expectedTitle {
+				t.Errorf("Wrong title: got %q, want %q", c.uiTitle, tc.expectedTitle)
+			}
+			if len(c.uiLocalized) != tc.expectedLocalized {
+				t.Errorf("Wrong number of localized strings: got %v, want %v",
+					len(c.uiLocalized), tc.expectedLocalized)
+			}
+			if got := strings.Join(c.redirectTargets, ","); got != tc.expectedTargets {
+				t.Errorf("Wrong redirect targets: got %q, want %q", got, tc.expectedTargets)
+			}
+			if got := strings.Join(c.corsOrigins, ","); got != tc.expectedCORSOrigins {
+				t.Errorf("Wrong CORS origins: got %q, want %q", got, tc.expectedCORSOrigins)
+			}
+			if c.tokenExchangeURL != tc.expectedExchangeURL {
+				t.Errorf("Wrong exchange URL: got %q, want %q", c.tokenExchangeURL, tc.expectedExchangeURL)
+			}
+			if c.tokenExchangeSubjectTokenType != tc.expectedSubjectTokenT {
+				t.Errorf("Wrong subject token type: got %q, want %q",
+					c.tokenExchangeSubjectTokenType, tc.expectedSubjectTokenT)
+			}
+			if len(tc.config.echoRedactHeaderNames) != 1 {
+				t.Error("Unexpected modification of base config")
+			}
+		})
+	}
+}
+
+func TestNewTenantRouter(t *testing.T) {
+	t.Setenv("T2G_TOKEN_HEADER_NAMES", "Authorization")
+	t.Setenv("T2G_UI_TITLE", "Default")
+	t.Setenv("T2G_TENANTS", `{
+		"products": {
+			"hosts": ["Products.example.com"],
+			"tokenHeaderNames": ["X-Products-Token"],
+			"ui": {"title": "Products", "localized": {"de": {"title": "Produkte"}}}
+		},
+		"orders": {
+			"pathPrefix": "/orders",
+			"fallbackToken": "orders-token",
+			"redirectTargets": ["http://localhost"]
+		}
+	}`)
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := NewTenantRouter(c, NewRouterState())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name         string
+		host         string
+		target       string
+		headers      map[string]string
+		expectedCode int
+		expectedBody []string
+	}{{
+		name:         "1_host_token",
+		host:         "products.example.com:8080",
+		target:       "/token?format=text",
+		headers:      map[string]string{"X-Products-Token": "a"},
+		expectedCode: 200,
+		expectedBody: []string{"a"},
+	}, {
+		name:         "2_host_other_header",
+		host:         "products.example.com",
+		target:       "/token?format=text",
+		headers:      map[string]string{"Authorization": "a"},
+		expectedCode: StatusTokenNotFound,
+	}, {
+		name:         "3_host_ui",
+		host:         "products.example.com",
+		target:       "/",
+		expectedCode: 200,
+		expectedBody: []string{"<title>Products</title>"},
+	}, {
+		name:         "4_host_ui_localized",
+		host:         "products.example.com",
+		target:       "/?lang=de",
+		expectedCode: 200,
+		expectedBody: []string{"<title>Produkte</title>"},
+	}, {
+		name:         "5_prefix_token",
+		target:       "/orders/token?format=text",
+		expectedCode: 200,
+		expectedBody: []string{"orders-token"},
+	}, {
+		name:         "6_prefix_ui",
+		target:       "/orders/",
+		expectedCode: 200,
+		expectedBody: []string{"<title>Default</title>", `href="/orders/css/main.css"`},
+	}, {
+		name:         "7_prefix_redirect_target",
+		target:       "/orders/flow/redirect/token?target=https://example.com&state=s&publicKeyType=x&publicKey=x",
+		expectedCode: 403,
+	}, {
+		name:         "8_default",
+		target:       "/token?format=text",
+		headers:      map[string]string{"Authorization": "b"},
+		expectedCode: 200,
+		expectedBody: []string{"b"},
+	}, {
+		name:         "9_default_sibling_path",
+		target:       "/ordersx",
+		expectedCode: 404,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tc.target, nil)
+			if len(tc.host) > 0 {
+				r.Host = tc.host
+			}
+			for name, value := range tc.headers {
+				r.Header.Set(name, value)
+			}
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, r)
+
+			if rr.Code != tc.expectedCode {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, tc.expectedCode)
+			}
+			for _, substr := range tc.expectedBody {
+				if !strings.Contains(rr.Body.String(), substr) {
+					t.Errorf("Didn't find substr in body: want %q", substr)
+				}
+			}
+		})
+	}
+}
+
+func TestNewTenantRouter_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		tenants string
+	}{{
+		name:    "1_invalid_name",
+		tenants: `{"Products": {"hosts": ["a.example.com"]}}`,
+	}, {
+		name:    "2_no_selector",
+		tenants: `{"products": {}}`,
+	}, {
+		name:    "3_both_selectors",
+		tenants: `{"products": {"hosts": ["a.example.com"], "pathPrefix": "/a"}}`,
+	}, {
+		name:    "4_duplicate_host",
+		tenants: `{"a": {"hosts": ["a.example.com"]}, "b": {"hosts": ["A.example.com:443"]}}`,
+	}, {
+		name:    "5_duplicate_prefix",
+		tenants: `{"a": {"pathPrefix": "/a"}, "b": {"pathPrefix": "a/"}}`,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("T2G_TENANTS", tc.tenants)
+
+			c, err := NewConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = NewTenantRouter(c, NewRouterState())
+			if err == nil {
+				t.Error("Unexpected success: got nil, want error")
+			}
+		})
+	}
+}
@@ -2,10 +2,7 @@ package main
 
 import (
 	"crypto/sha512"
-	"errors"
 	"fmt"
-	"net/http"
-	"strings"
 	"time"
 )
 
@@ -15,50 +12,67 @@ type Token struct {
 	Timestamp   string `json:"timestamp"`
 	Fingerprint string `json:"fingerprint"`
 	Secret      string `json:"secret"`
+	Source      string `json:"source"`
+
+	// Introspection is the RFC 7662 introspection response. Only set if
+	// introspection is enabled.
+	Introspection map[string]any `json:"introspection,omitempty"`
+
+	// Decoded is the decoded JWT. Only set if requested and the secret is a
+	// JWT.
+	Decoded *DecodedToken `json:"decoded,omitempty"`
 }
 
-// NewToken creates a token representation that includes metadata.
-func NewToken(secret string) Token {
+// DecodedToken is the decoded header and claims of a token that is a JWT. The
+// signature is not verified. Use the function DecodeToken to construct.
+type DecodedToken struct {
+	Header map[string]any `json:"header"`
+	Claims map[string]any `json:"claims"`
+
+	// IssuedAt is the "iat" claim formatted as RFC 3339 timestamp.
+	IssuedAt string `json:"issuedAt,omitempty"`
+
+	// ExpiresAt is the "exp" claim formatted as RFC 3339 timestamp.
+	ExpiresAt string `json:"expiresAt,omitempty"`
+
+	// ExpiresIn is the number of seconds from decoding until the "exp" claim.
+	// Negative if the token has already expired. Lets clients count down
+	// without depending on their own clock.
+	ExpiresIn *int64 `json:"expiresIn,omitempty"`
+}
+
+// NewToken creates a token representation that includes metadata. The source
+// is the name of the TokenSource the secret has been extracted from.
+func NewToken(secret string, source string) Token {
 	salt := "03c49494-c1f3-4b3c-a9e3-28b1c4e42177"
 	return Token{
 		Timestamp:   time.Now().Format(time.RFC3339),
 		Fingerprint: fmt.Sprintf("%x", sha512.Sum512_256([]byte(salt+secret))),
 		Secret:      secret,
+		Source:      source,
 	}
 }
 
-// ExtractToken returns token value from a given map of headers based on a
-// given list of possible token header names. First match is returned. Error
-// is returned if no match occurs and fallbackToken is not set.
-func ExtractToken(
-	headers http.Header,
-	tokenHeaderNames []string,
-	fallbackToken string,
-) (Token, error) {
-	var secret string
-	var err error = nil
-
-	// Extract secret from header.
-	for _, tokenHeaderName := range tokenHeaderNames {
-		if tokenHeader, ok := headers[tokenHeaderName]; ok {
-			if len(tokenHeader) > 0 && len(tokenHeader[0]) > 0 {
-				secret = tokenHeader[0]
-				break
-			}
-		}
+// DecodeToken returns the given token with the field Decoded set if the secret
+// is a JWT. Other tokens are returned unchanged. The given time is used to
+// calculate ExpiresIn.
+func DecodeToken(token Token, now time.Time) Token {
+	jwt, err := ParseJWT(token.Secret)
+	if err != nil {
+		return token
 	}
 
-	// Remove optional authorization type.
-	secret = strings.TrimPrefix(secret, "Bearer ")
-
-	// Use optional fallback token.
-	if len(secret) == 0 && len(fallbackToken) > 0 {
-		secret = fallbackToken
+	decoded := DecodedToken{Header: jwt.Header, Claims: jwt.Claims}
+	if iat, ok := jwt.NumericDate("iat"); ok {
+		decoded.IssuedAt = iat.UTC().Format(time.RFC3339)
 	}
-
-	if len(secret) == 0 {
-		err = errors.New("failed to find token")
+	if exp, ok := jwt.NumericDate("exp"); ok {
+		decoded.ExpiresAt = exp.UTC().Format(time.RFC3339)
+		expiresIn := int64(exp.Sub(now).Seconds())
+		decoded.ExpiresIn = &expiresIn
 	}
 
-	return NewToken(secret), err
+	token.Decoded = &decoded
+
+	return token
 }
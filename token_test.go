@@ -2,27 +2,41 @@ package main
 
 import (
 	"encoding/json"
-	"net/http"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestTokenMarshalToJSON(t *testing.T) {
-	b, err := json.Marshal(Token{"x", "x", "x"})
+	b, err := json.Marshal(Token{"x", "x", "x", "x", map[string]any{"active": true}, nil})
 	if err != nil {
 		t.Fatalf("Unexpected error: %v", err)
 	}
 
 	str := string(b)
-	for _, substr := range []string{`"timestamp"`, `"fingerprint"`, `"secret"`} {
+	for _, substr := range []string{
+		`"timestamp"`, `"fingerprint"`, `"secret"`, `"source"`,
+		`"introspection":{"active":true}`,
+	} {
 		if !strings.Contains(str, substr) {
 			t.Errorf("Failed to find in marshalled JSON: str %q, substr %q", str, substr)
 		}
 	}
 }
 
+func TestTokenMarshalToJSON_OmitIntrospection(t *testing.T) {
+	b, err := json.Marshal(NewToken("x", "static"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if strings.Contains(string(b), "introspection") {
+		t.Errorf("Unexpected introspection in marshalled JSON: %s", b)
+	}
+}
+
 func TestNewToken(t *testing.T) {
-	token := NewToken("mysecret")
+	token := NewToken("mysecret", "static")
 	if len(token.Fingerprint) == 0 {
 		t.Error(`Field "Fingerprint" must be set.`)
 	}
@@ -32,86 +46,71 @@ func TestNewToken(t *testing.T) {
 	if len(token.Timestamp) == 0 {
 		t.Error(`Field "Timestamp" must be set.`)
 	}
+	if len(token.Source) == 0 {
+		t.Error(`Field "Source" must be set.`)
+	}
 }
 
-func TestExtractToken(t *testing.T) {
+func TestDecodeToken(t *testing.T) {
+	key := readTestPrivateKey(t, "a-private-key-rsa2048-rfc5958-pksc8.pem")
+	now := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+
 	for _, tc := range []struct {
-		name             string
-		headers          http.Header
-		tokenHeaderNames []string
-		fallbackToken    string
-		expectedSecret   string
-		expectedError    bool
+		name              string
+		secret            string
+		expectedDecoded   bool
+		expectedIssuedAt  string
+		expectedExpiresAt string
+		expectedExpiresIn *int64
 	}{{
-		name: "1_case_sensitive",
-		headers: http.Header{
-			"Baz":   {"c"},
-			"Token": {"d"},
-		},
-		tokenHeaderNames: []string{"foo", "bar", "baz"},
-		fallbackToken:    "",
-		expectedSecret:   "",
-		expectedError:    true,
+		name:            "1_opaque",
+		secret:          "opaque",
+		expectedDecoded: false,
 	}, {
-		name: "2_token_header_empty",
-		headers: http.Header{
-			"Baz":   {"c"},
-			"token": {"d"},
-			"Token": {},
-		},
-		tokenHeaderNames: []string{"Token"},
-		fallbackToken:    "",
-		expectedSecret:   "",
-		expectedError:    true,
+		name: "2_jwt",
+		secret: signTestJWT(t, key, map[string]any{
+			"sub": "alice",
+			"iat": now.Add(-time.Hour).Unix(),
+			"exp": now.Add(90 * time.Second).Unix(),
+		}),
+		expectedDecoded:   true,
+		expectedIssuedAt:  "2030-01-02T02:04:05Z",
+		expectedExpiresAt: "2030-01-02T03:05:35Z",
+		expectedExpiresIn: func() *int64 { v := int64(90); return &v }(),
 	}, {
-		name: "3_header_order",
-		headers: http.Header{
-			"A": {"a"},
-			"C": {"c"},
-			"B": {"b"},
-		},
-		tokenHeaderNames: []string{"X", "B", "C"},
-		fallbackToken:    "",
-		expectedSecret:   "b",
-		expectedError:    false,
+		name: "3_expired",
+		secret: signTestJWT(t, key, map[string]any{
+			"exp": now.Add(-time.Minute).Unix(),
+		}),
+		expectedDecoded:   true,
+		expectedExpiresAt: "2030-01-02T03:03:05Z",
+		expectedExpiresIn: func() *int64 { v := int64(-60); return &v }(),
 	}, {
-		name: "4_fallback",
-		headers: http.Header{
-			"x": {"a"},
-			"y": {},
-			"z": {"c"},
-		},
-		tokenHeaderNames: []string{"X", "Y", "Z"},
-		fallbackToken:    "Foobar",
-		expectedSecret:   "Foobar",
-		expectedError:    false,
-	}, {
-		name: "BearerToken",
-		headers: http.Header{
-			"x": {"a"},
-			"y": {},
-			"z": {"Bearer secret"},
-		},
-		tokenHeaderNames: []string{"z"},
-		fallbackToken:    "",
-		expectedSecret:   "secret",
-		expectedError:    false,
+		name:            "4_without_dates",
+		secret:          signTestJWT(t, key, map[string]any{"sub": "alice"}),
+		expectedDecoded: true,
 	}} {
 		t.Run(tc.name, func(t *testing.T) {
-			token, err := ExtractToken(
-				tc.headers, tc.tokenHeaderNames, tc.fallbackToken,
-			)
-			if tc.expectedError && err == nil {
-				t.Errorf("Unexpected success: got %q, want error", token)
+			token := DecodeToken(NewToken(tc.secret, "static"), now)
+
+			if (token.Decoded != nil) != tc.expectedDecoded {
+				t.Fatalf("Wrong decoded: got %v, want %v", token.Decoded != nil, tc.expectedDecoded)
+			}
+			if token.Decoded == nil {
+				return
+			}
+			if token.Decoded.Header["alg"] != "RS256" {
+				t.Errorf("Wrong alg: got %v, want %v", token.Decoded.Header["alg"], "RS256")
+			}
+			if token.Decoded.IssuedAt != tc.expectedIssuedAt {
+				t.Errorf("Wrong issuedAt: got %q, want %q", token.Decoded.IssuedAt, tc.expectedIssuedAt)
 			}
-			if !tc.expectedError && err != nil {
-				t.Errorf("Unexpected error: %v", err)
+			if token.Decoded.ExpiresAt != tc.expectedExpiresAt {
+				t.Errorf("Wrong expiresAt: got %q, want %q", token.Decoded.ExpiresAt, tc.expectedExpiresAt)
 			}
-			if tc.expectedSecret != token.Secret {
-				t.Errorf(
-					"Wrong secret extracted: got %q, want %q",
-					token.Secret, tc.expectedSecret,
-				)
+			if (token.Decoded.ExpiresIn == nil) != (tc.expectedExpiresIn == nil) ||
+				(tc.expectedExpiresIn != nil && *token.Decoded.ExpiresIn != *tc.expectedExpiresIn) {
+				t.Errorf("Wrong expiresIn: got %v, want %v", token.Decoded.ExpiresIn, tc.expectedExpiresIn)
 			}
 		})
 	}
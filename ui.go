@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"sort"
+)
+
+// UIIndexTemplate is the name of the template rendered as web page.
+const UIIndexTemplate = "index.html"
+
+// OverlayFS is a file system made of layers. Files are looked up in the
+// layers in order and the first layer that has the file wins. Directory
+// listings are merged.
+type OverlayFS []fs.FS
+
+// Open opens the named file from the first layer that has it.
+func (o OverlayFS) Open(name string) (fs.File, error) {
+	for _, layer := range o {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err //nolint:wrapcheck // Errors of layers are passed through.
+		}
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// ReadDir reads the named directory from all layers that have it. Entries of
+// earlier layers shadow entries of later layers. Entries are sorted by name.
+func (o OverlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries := map[string]fs.DirEntry{}
+	found := false
+
+	for _, layer := range o {
+		layerEntries, err := fs.ReadDir(layer, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err //nolint:wrapcheck // Errors of layers are passed through.
+		}
+
+		found = true
+		for _, entry := range layerEntries {
+			if _, ok := entries[entry.Name()]; !ok {
+				entries[entry.Name()] = entry
+			}
+		}
+	}
+
+	if !found {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	result := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name() < result[j].Name() })
+
+	return result, nil
+}
+
+// NewUIContent returns the embedded directory dir overlaid by the operator
+// directory overlayDir if set. Returns an error if overlayDir is not a
+// directory.
+func NewUIContent(dir string, overlayDir string) (fs.FS, error) {
+	embedded, err := fs.Sub(content, dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access embedded %s: %w", dir, err)
+	}
+
+	if len(overlayDir) == 0 {
+		return embedded, nil
+	}
+
+	info, err := os.Stat(overlayDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access %s overlay: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("failed to access %s overlay: %q not a directory", dir, overlayDir)
+	}
+
+	return OverlayFS{os.DirFS(overlayDir), embedded}, nil
+}
+
+// NewUITemplates parses all HTML templates in the top level of fsys. The
+// UIIndexTemplate is parsed first, so other files can redefine its blocks
+// "head", "logo", and "help". Returns an error if a template is broken.
+func NewUITemplates(fsys fs.FS) (*template.Template, error) {
+	files, err := fs.Glob(fsys, "*.html")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list UI templates: %w", err)
+	}
+
+	patterns := []string{UIIndexTemplate}
+	for _, file := range files {
+		if file != UIIndexTemplate {
+			patterns = append(patterns, file)
+		}
+	}
+
+	tmpl, err := template.ParseFS(fsys, patterns...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse UI templates: %w", err)
+	}
+
+	return tmpl, nil
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestOverlayFS(t *testing.T) {
+	overlay := OverlayFS{
+		fstest.MapFS{
+			"a.txt":     {Data: []byte("top")},
+			"dir/b.txt": {Data: []byte("top")},
+		},
+		fstest.MapFS{
+			"a.txt":     {Data: []byte("bottom")},
+			"c.txt":     {Data: []byte("bottom")},
+			"dir/d.txt": {Data: []byte("bottom")},
+		},
+	}
+
+	for _, tc := range []struct {
+		name         string
+		file         string
+		expectedData string
+		expectedErr  error
+	}{{
+		name:         "1_shadowed",
+		file:         "a.txt",
+		expectedData: "top",
+	}, {
+		name:         "2_fall_through",
+		file:         "c.txt",
+		expectedData: "bottom",
+	}, {
+		name:         "3_nested",
+		file:         "dir/d.txt",
+		expectedData: "bottom",
+	}, {
+		name:        "4_missing",
+		file:        "nope.txt",
+		expectedErr: fs.ErrNotExist,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			data, err := fs.ReadFile(overlay, tc.file)
+			if !errors.Is(err, tc.expectedErr) {
+				t.Fatalf("Wrong error: got %v, want %v", err, tc.expectedErr)
+			}
+			if string(data) != tc.expectedData {
+				t.Errorf("Wrong data: got %q, want %q", data, tc.expectedData)
+			}
+		})
+	}
+
+	entries, err := fs.ReadDir(overlay, "dir")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if strings.Join(names, ",") != "b.txt,d.txt" {
+		t.Errorf("Wrong entries: got %v, want %v", names, "b.txt,d.txt")
+	}
+
+	_, err = fs.ReadDir(overlay, "nope")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Wrong error: got %v, want %v", err, fs.ErrNotExist)
+	}
+}
+
+func TestNewUITemplates(t *testing.T) {
+	embedded, err := NewUIContent("template", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		files         fstest.MapFS
+		expectedError bool
+		expectedBody  string
+	}{{
+		name:          "1_embedded",
+		files:         fstest.MapFS{},
+		expectedError: false,
+		expectedBody:  "Auto copy token to clipboard",
+	}, {
+		name: "2_blocks",
+		files: fstest.MapFS{
+			"corporate.html": {Data: []byte(
+				`{{ define "head" }}<link rel="stylesheet" href="/corporate.css">{{ end }}` +
+					`{{ define "logo" }}<img src="/logo.svg">{{ end }}` +
+					`{{ define "help" }}<section>Ask the help desk.</section>{{ end }}`,
+			)},
+		},
+		expectedError: false,
+		expectedBody:  "Ask the help desk.",
+	}, {
+		name: "3_index_replaced",
+		files: fstest.MapFS{
+			"index.html": {Data: []byte(`<p>{{ .Title }}</p>`)},
+		},
+		expectedError: false,
+		expectedBody:  "<p>Token2go</p>",
+	}, {
+		name: "4_broken",
+		files: fstest.MapFS{
+			"broken.html": {Data: []byte(`{{ if }}`)},
+		},
+		expectedError: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := NewUITemplates(OverlayFS{tc.files, embedded})
+			if tc.expectedError {
+				if err == nil {
+					t.Error("Unexpected success: got nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			var buffer bytes.Buffer
+			err = tmpl.Execute(&buffer, NewIndexTmplData("", "", "", "", ""))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !strings.Contains(buffer.String(), tc.expectedBody) {
+				t.Errorf("Didn't find substr in body: got %q, want %q", buffer.String(), tc.expectedBody)
+			}
+		})
+	}
+}
+
+func TestNewRouterArgs_UIOverlays(t *testing.T) {
+	templateDir := t.TempDir()
+	staticDir := t.TempDir()
+
+	err := os.WriteFile(filepath.Join(templateDir, "help.html"),
+		[]byte(`{{ define "help" }}<p>Corporate help</p>{{ end }}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(staticDir, "logo.svg"), []byte("<svg></svg>"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("T2G_UI_TEMPLATE_DIR", templateDir)
+	t.Setenv("T2G_UI_STATIC_DIR", staticDir)
+
+	c, err := NewConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := NewRouterArgs(c, NewRouterState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initRouter(a)
+
+	for _, tc := range []struct {
+		name         string
+		target       string
+		expectedBody string
+	}{{
+		name:         "1_help",
+		target:       "/",
+		expectedBody: "<p>Corporate help</p>",
+	}, {
+		name:         "2_overlay_static",
+		target:       "/logo.svg",
+		expectedBody: "<svg></svg>",
+	}, {
+		name:         "3_embedded_static",
+		target:       "/js/index.js",
+		expectedBody: "function",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest("GET", tc.target, nil))
+
+			if rr.Code != 200 {
+				t.Errorf("Wrong code: got %v, want %v", rr.Code, 200)
+			}
+			if !strings.Contains(rr.Body.String(), tc.expectedBody) {
+				t.Errorf("Didn't find substr in body: want %q", tc.expectedBody)
+			}
+		})
+	}
+
+	err = os.WriteFile(filepath.Join(templateDir, "help.html"),
+		[]byte(`{{ define "help" }}{{ .Nope }}{{ end }}`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewRouterArgs(c, NewRouterState())
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+
+	c.uiStaticDir = filepath.Join(staticDir, "logo.svg")
+	_, err = NewRouterArgs(c, NewRouterState())
+	if err == nil {
+		t.Error("Unexpected success: got nil, want error")
+	}
+}
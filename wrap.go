@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrWrappedTokenNotFound = errors.New("wrapped token not found")
+
+var ErrWrapStoreFull = errors.New("wrap store full")
+
+// WrappedToken is a token stored server-side until it is unwrapped once.
+type WrappedToken struct {
+	// Profile is the name of the token profile the token was extracted with.
+	Profile string
+
+	Token Token
+
+	// ExpiresAt is the time after which the token can't be unwrapped anymore.
+	ExpiresAt time.Time
+}
+
+// WrapStore stores wrapped tokens. Implementations must be safe for
+// concurrent use. Keys are derived from wrapping IDs, so the IDs themselves
+// never reach the store.
+type WrapStore interface {
+	// Put stores the wrapped token under the given key. Returns
+	// ErrWrapStoreFull if the store can't take more tokens.
+	Put(ctx context.Context, key string, wrapped WrappedToken) error
+
+	// Take returns the wrapped token stored under the given key and deletes
+	// it. Returns ErrWrappedTokenNotFound if there is no such token or it has
+	// expired.
+	Take(ctx context.Context, key string) (WrappedToken, error)
+}
+
+// MemoryWrapStore is a WrapStore that keeps tokens in memory. Tokens are lost
+// when the process ends. Use NewMemoryWrapStore to construct.
+type MemoryWrapStore struct {
+	now func() time.Time
+
+	mu         sync.Mutex
+	maxEntries int
+	entries    map[string]WrappedToken
+}
+
+// NewMemoryWrapStore creates a MemoryWrapStore that holds up to maxEntries
+// tokens at the same time.
+func NewMemoryWrapStore(maxEntries int) *MemoryWrapStore {
+	return &MemoryWrapStore{
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    map[string]WrappedToken{},
+	}
+}
+
+// SetMaxEntries changes the number of tokens the store holds at the same time.
+// Tokens already stored are kept.
+func (s *MemoryWrapStore) SetMaxEntries(maxEntries int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxEntries = maxEntries
+}
+
+// Put implements WrapStore. Expired tokens are removed before the store is
+// considered full.
+func (s *MemoryWrapStore) Put(_ context.Context, key string, wrapped WrappedToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) >= s.maxEntries {
+		now := s.now()
+		for k, e := range s.entries {
+			if now.After(e.ExpiresAt) {
+				delete(s.entries, k)
+			}
+		}
+	}
+	if len(s.entries) >= s.maxEntries {
+		return ErrWrapStoreFull
+	}
+
+	s.entries[key] = wrapped
+
+	return nil
+}
+
+// Take implements WrapStore.
+func (s *MemoryWrapStore) Take(_ context.Context, key string) (WrappedToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wrapped, ok := s.entries[key]
+	if !ok {
+		return WrappedToken{}, ErrWrappedTokenNotFound
+	}
+	delete(s.entries, key)
+
+	if s.now().After(wrapped.ExpiresAt) {
+		return WrappedToken{}, ErrWrappedTokenNotFound
+	}
+
+	return wrapped, nil
+}
+
+// TokenWrapping is the representation of a wrapped token handed out to the
+// client. It contains everything needed to unwrap the token but not the token
+// itself.
+type TokenWrapping struct {
+	WrappingID string `json:"wrappingId"`
+
+	// ExpiresAt is the expiry formatted as RFC 3339 timestamp.
+	ExpiresAt string `json:"expiresAt"`
+
+	// ExpiresIn is the number of seconds until the expiry.
+	ExpiresIn int64 `json:"expiresIn"`
+}
+
+// TokenWrapper wraps tokens under random single-use wrapping IDs that expire
+// after a TTL. Use NewTokenWrapper to construct.
+type TokenWrapper struct {
+	store WrapStore
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// NewTokenWrapper creates a TokenWrapper that keeps wrapped tokens in the
+// given store for the given TTL.
+func NewTokenWrapper(store WrapStore, ttl time.Duration) *TokenWrapper {
+	return &TokenWrapper{
+		store: store,
+		ttl:   ttl,
+		now:   time.Now,
+	}
+}
+
+// Wrap stores the given token of the named profile under a new wrapping ID.
+// Returns ErrWrapStoreFull if the store can't take more tokens.
+func (w *TokenWrapper) Wrap(ctx context.Context, profile string, token Token) (TokenWrapping, error) {
+	b, err := GenRandBytes(32)
+	if err != nil {
+		return TokenWrapping{}, err
+	}
+	id := base64.RawURLEncoding.EncodeToString(b)
+
+	expiresAt := w.now().Add(w.ttl)
+	err = w.store.Put(ctx, wrapStoreKey(id), WrappedToken{
+		Profile:   profile,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return TokenWrapping{}, err
+	}
+
+	return TokenWrapping{
+		WrappingID: id,
+		ExpiresAt:  expiresAt.UTC().Format(time.RFC3339),
+		ExpiresIn:  int64(w.ttl.Seconds()),
+	}, nil
+}
+
+// Unwrap returns the token wrapped under the given wrapping ID and deletes it,
+// so every token can be unwrapped only once. Returns ErrWrappedTokenNotFound
+// if the ID is unknown, expired, or already used.
+func (w *TokenWrapper) Unwrap(ctx context.Context, id string) (WrappedToken, error) {
+	if len(id) == 0 {
+		return WrappedToken{}, ErrWrappedTokenNotFound
+	}
+
+	return w.store.Take(ctx, wrapStoreKey(id))
+}
+
+// wrapStoreKey derives the key of a wrapping ID in the WrapStore.
+func wrapStoreKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryWrapStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+
+	store := NewMemoryWrapStore(2)
+	store.now = func() time.Time { return now }
+
+	err := store.Put(ctx, "a", WrappedToken{Token: Token{Secret: "a"}, ExpiresAt: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = store.Put(ctx, "b", WrappedToken{Token: Token{Secret: "b"}, ExpiresAt: now.Add(time.Second)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Full store refuses new tokens.
+	err = store.Put(ctx, "c", WrappedToken{ExpiresAt: now.Add(time.Minute)})
+	if !errors.Is(err, ErrWrapStoreFull) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrWrapStoreFull)
+	}
+
+	// Expired tokens make room.
+	now = now.Add(2 * time.Second)
+	err = store.Put(ctx, "c", WrappedToken{Token: Token{Secret: "c"}, ExpiresAt: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = store.Take(ctx, "b")
+	if !errors.Is(err, ErrWrappedTokenNotFound) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrWrappedTokenNotFound)
+	}
+
+	// Tokens can be taken only once.
+	wrapped, err := store.Take(ctx, "a")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if wrapped.Token.Secret != "a" {
+		t.Errorf("Wrong secret: got %q, want %q", wrapped.Token.Secret, "a")
+	}
+	_, err = store.Take(ctx, "a")
+	if !errors.Is(err, ErrWrappedTokenNotFound) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrWrappedTokenNotFound)
+	}
+
+	// Tokens that expire while stored can't be taken.
+	now = now.Add(2 * time.Minute)
+	_, err = store.Take(ctx, "c")
+	if !errors.Is(err, ErrWrappedTokenNotFound) {
+		t.Errorf("Wrong error: got %v, want %v", err, ErrWrappedTokenNotFound)
+	}
+}
+
+func TestTokenWrapper(t *testing.T) {
+	ctx := context.Background()
+	now := func() time.Time { return time.Unix(1700000000, 0) }
+
+	store := NewMemoryWrapStore(10)
+	store.now = now
+	wrapper := NewTokenWrapper(store, 5*time.Minute)
+	wrapper.now = now
+
+	wrapping, err := wrapper.Wrap(ctx, "id", Token{Secret: "secret"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(wrapping.WrappingID) != 43 {
+		t.Errorf("Wrong wrapping ID length: got %v, want %v", len(wrapping.WrappingID), 43)
+	}
+	if wrapping.ExpiresAt != "2023-11-14T22:18:20Z" {
+		t.Errorf("Wrong expiry: got %q, want %q", wrapping.ExpiresAt, "2023-11-14T22:18:20Z")
+	}
+	if wrapping.ExpiresIn != 300 {
+		t.Errorf("Wrong expires in: got %v, want %v", wrapping.ExpiresIn, 300)
+	}
+	if _, ok := store.entries[wrapping.WrappingID]; ok {
+		t.Error("Wrapping ID used as key in store")
+	}
+
+	for _, tc := range []struct {
+		name          string
+		id            string
+		expectedError error
+	}{
+		{name: "1_empty", id: "", expectedError: ErrWrappedTokenNotFound},
+		{name: "2_unknown", id: "unknown", expectedError: ErrWrappedTokenNotFound},
+		{name: "3_valid", id: wrapping.WrappingID},
+		{name: "4_used", id: wrapping.WrappingID, expectedError: ErrWrappedTokenNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			wrapped, err := wrapper.Unwrap(ctx, tc.id)
+			if !errors.Is(err, tc.expectedError) {
+				t.Fatalf("Wrong error: got %v, want %v", err, tc.expectedError)
+			}
+			if tc.expectedError == nil && (wrapped.Profile != "id" || wrapped.Token.Secret != "secret") {
+				t.Errorf("Wrong wrapped token: got %+v", wrapped)
+			}
+		})
+	}
+}
+
+func TestInitRouter_Wrap(t *testing.T) {
+	router := initRouter(RouterArgs{
+		tokenPipeline: TokenPipeline{profiles: newTestTokenProfiles(t, []string{"Foo"}, "")},
+		tokenWrapper:  NewTokenWrapper(NewMemoryWrapStore(10), time.Minute),
+	})
+
+	// Wrapping requires a token.
+	r := httptest.NewRequest("POST", "/wrap", nil)
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != StatusTokenNotFound {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, StatusTokenNotFound)
+	}
+
+	r = httptest.NewRequest("POST", "/wrap", nil)
+	r.Header.Set("Foo", "secret")
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 200 {
+		t.Fatalf("Wrong code: got %v, want %v", rr.Code, 200)
+	}
+	if strings.Contains(rr.Body.String(), "secret") {
+		t.Error("Found secret in body")
+	}
+	var wrapping TokenWrapping
+	err := json.Unmarshal(rr.Body.Bytes(), &wrapping)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Unwrapping works without token, but only once.
+	body := url.Values{"wrappingId": {wrapping.WrappingID}}.Encode()
+	for i, expectedCode := range []int{200, 404} {
+		r = httptest.NewRequest("POST", "/unwrap?format=text", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr = httptest.NewRecorder()
+		router.ServeHTTP(rr, r)
+
+		if rr.Code != expectedCode {
+			t.Errorf("Wrong code of unwrap %d: got %v, want %v", i+1, rr.Code, expectedCode)
+		}
+		if expectedCode == 200 && rr.Body.String() != "secret" {
+			t.Errorf("Wrong body: got %q, want %q", rr.Body.String(), "secret")
+		}
+	}
+
+	// Unwrapping requires a wrapping ID.
+	r = httptest.NewRequest("POST", "/unwrap", nil)
+	rr = httptest.NewRecorder()
+	router.ServeHTTP(rr, r)
+
+	if rr.Code != 400 {
+		t.Errorf("Wrong code: got %v, want %v", rr.Code, 400)
+	}
+}